@@ -66,6 +66,11 @@ func NewHandler(defaultCfg langserver.Config) *BuildHandler {
 // BuildHandler is a Go build server LSP/JSON-RPC handler that wraps a
 // Go language server handler.
 type BuildHandler struct {
+	// Resolver resolves the import paths of dependencies. If nil, they are
+	// resolved by gosrc.ResolveImportPath, which is configured by
+	// environment variables.
+	Resolver gosrc.Resolver
+
 	lang *langserver.LangHandler
 
 	mu             sync.Mutex
@@ -73,6 +78,7 @@ type BuildHandler struct {
 	gopathDeps     []*gosrc.Directory
 	pinnedDepsOnce sync.Once
 	pinnedDeps     pinnedPkgs
+	goSum          []byte     // the go.sum files of the workspace, set with pinnedDeps
 	findPkgMu      sync.Mutex // guards findPkg
 	findPkg        map[findPkgKey]*findPkgValue
 	langserver.HandlerCommon
@@ -113,6 +119,7 @@ func (h *BuildHandler) reset(init *lspext.InitializeParams, conn *jsonrpc2.Conn,
 	h.gopathDeps = nil
 	h.pinnedDepsOnce = sync.Once{}
 	h.pinnedDeps = nil
+	h.goSum = nil
 	h.findPkg = nil
 	return nil
 }
@@ -259,19 +266,10 @@ func (h *BuildHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jso
 	case req.Method == "workspace/xpackages":
 		return h.handleWorkspacePackages(ctx, conn, req)
 
-	case req.Method == "workspace/xdependencies":
-		// The same as h.fetchAndSendDepsOnce except it operates locally to the
-		// request.
-		fetchAndSendDepsOnces := make(map[string]*sync.Once) // key is file URI
-		localFetchAndSendDepsOnce := func(fileURI string) *sync.Once {
-			once, ok := fetchAndSendDepsOnces[fileURI]
-			if !ok {
-				once = new(sync.Once)
-				fetchAndSendDepsOnces[fileURI] = once
-			}
-			return once
-		}
+	case req.Method == "workspace/xdependencyGraph":
+		return h.handleWorkspaceDependencyGraph(ctx, req)
 
+	case req.Method == "workspace/xdependencies":
 		var (
 			mu              sync.Mutex
 			finalReferences []*lspext.DependencyReference
@@ -322,21 +320,7 @@ func (h *BuildHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jso
 
 		// We need every transitive dependency, for every Go package in the
 		// repository.
-		var (
-			w  = ctxvfs.Walk(ctx, h.RootFSPath, h.FS)
-			dc = newDepCache()
-		)
-		dc.collectReferences = true
-		for w.Step() {
-			if path.Ext(w.Path()) == ".go" {
-				d := path.Dir(w.Path())
-				localFetchAndSendDepsOnce(d).Do(func() {
-					if err := h.fetchTransitiveDepsOfFile(ctx, lsp.DocumentURI("file://"+d), dc); err != nil {
-						log.Printf("Warning: fetching deps for dir %s: %s.", d, err)
-					}
-				})
-			}
-		}
+		dc := h.fetchWorkspaceDeps(ctx)
 		dc.references(emitRef, 1)
 		return finalReferences, nil
 
@@ -0,0 +1,264 @@
+package buildserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-langserver/gosrc"
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-langserver/pkg/lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// dependencyGraphParams are the parameters of a workspace/xdependencyGraph
+// request.
+type dependencyGraphParams struct {
+	// Format is the format of the result: "json" (the default) for a
+	// dependencyGraph, or "dot" for a string with the Graphviz DOT
+	// document of the graph.
+	Format string `json:"format,omitempty"`
+}
+
+// dependencyGraph is the import graph of the packages of the workspace and
+// of their transitive dependencies.
+type dependencyGraph struct {
+	// Packages are sorted by their absolute import paths.
+	Packages []*dependencyGraphPackage `json:"packages"`
+}
+
+// dependencyGraphPackage is a node of a dependencyGraph. Like the
+// attributes of goDependencyReference, it is identified by its absolute
+// import path, which includes the path of the vendor directory of
+// vendored packages.
+type dependencyGraphPackage struct {
+	Package   string `json:"package"`
+	Absolute  string `json:"absolute"`
+	Vendor    bool   `json:"vendor"`
+	Workspace bool   `json:"workspace"` // a (non-vendored) package of the workspace
+	Stdlib    bool   `json:"stdlib"`
+
+	// The repo the package was fetched from, and the revision. Version is
+	// the version it is pinned at, by go.mod or another lock file. They
+	// aren't set for the packages of the workspace, including vendored
+	// ones.
+	Repo    string `json:"repo,omitempty"`
+	Rev     string `json:"rev,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Imports are the absolute import paths of the packages it imports.
+	Imports []string `json:"imports"`
+
+	// TransitiveImports, only set for the packages of the workspace, are
+	// the absolute import paths of the packages it imports indirectly,
+	// but not directly.
+	TransitiveImports []string `json:"transitiveImports,omitempty"`
+}
+
+func (h *BuildHandler) handleWorkspaceDependencyGraph(ctx context.Context, req *jsonrpc2.Request) (interface{}, error) {
+	var params dependencyGraphParams
+	if req.Params != nil {
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	if params.Format != "" && params.Format != "json" && params.Format != "dot" {
+		return nil, fmt.Errorf("unsupported dependency graph format %q (must be json or dot)", params.Format)
+	}
+
+	dc := h.fetchWorkspaceDeps(ctx)
+	g := dc.graph(func(dir string) bool {
+		return util.PathHasPrefix(dir, h.RootFSPath) && !util.IsVendorDir(dir)
+	})
+	h.annotateDependencyGraph(ctx, g)
+
+	if params.Format == "dot" {
+		var buf bytes.Buffer
+		if err := g.writeDOT(&buf); err != nil {
+			return nil, err
+		}
+		return buf.String(), nil
+	}
+	return g, nil
+}
+
+// annotateDependencyGraph sets the repos and revisions the dependencies in
+// g were fetched from.
+func (h *BuildHandler) annotateDependencyGraph(ctx context.Context, g *dependencyGraph) {
+	h.HandlerShared.Mu.Lock()
+	deps := make([]*gosrc.Directory, len(h.gopathDeps))
+	copy(deps, h.gopathDeps)
+	h.HandlerShared.Mu.Unlock()
+
+	for _, p := range g.Packages {
+		if p.Workspace || p.Vendor {
+			continue
+		}
+		if p.Stdlib {
+			if d, err := (gosrc.StdlibResolver{}).Resolve(p.Absolute); err == nil {
+				p.Repo, p.Rev = d.CloneURL, d.Rev
+			}
+			continue
+		}
+
+		// The dependency is in the repo with the longest project root
+		// which contains it.
+		var dep *gosrc.Directory
+		for _, d := range deps {
+			if util.PathHasPrefix(p.Absolute, d.ProjectRoot) && (dep == nil || len(d.ProjectRoot) > len(dep.ProjectRoot)) {
+				dep = d
+			}
+		}
+		if dep != nil {
+			p.Repo, p.Rev = dep.CloneURL, dep.Rev
+		}
+		if pinned := h.pinnedDep(ctx, p.Absolute); pinned != nil {
+			p.Version = pinned.Rev
+			if pinned.Module != "" {
+				p.Version = pinned.Version
+			}
+		}
+	}
+}
+
+// graph returns the import graph of the packages seen by the dependency
+// cache. The packages in the directories for which isWorkspace returns true
+// are the packages of the workspace.
+func (d *depCache) graph(isWorkspace func(dir string) bool) *dependencyGraph {
+	pkgs := map[string]*build.Package{} // by dir
+	imports := map[string][]string{}    // dir -> imported dirs
+	for _, pkg := range d.entryPackages {
+		pkgs[pkg.Dir] = pkg
+	}
+	for dir, records := range d.seen {
+		for _, r := range records {
+			pkgs[r.pkg.Dir] = r.pkg
+			pkgs[r.imports.Dir] = r.imports
+			// A package may import itself, e.g. in its xtest files.
+			if r.imports.Dir != dir {
+				imports[dir] = append(imports[dir], r.imports.Dir)
+			}
+		}
+	}
+
+	absolute := func(dirs []string) []string {
+		paths := make([]string, 0, len(dirs))
+		for _, dir := range dirs {
+			paths = append(paths, pkgs[dir].ImportPath)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	g := &dependencyGraph{Packages: make([]*dependencyGraphPackage, 0, len(pkgs))}
+	for dir, pkg := range pkgs {
+		p := &dependencyGraphPackage{
+			Package:   unvendoredPath(pkg.ImportPath),
+			Absolute:  pkg.ImportPath,
+			Vendor:    util.IsVendorDir(dir),
+			Workspace: isWorkspace(dir),
+			Stdlib:    pkg.Goroot,
+			Imports:   absolute(imports[dir]),
+		}
+		if p.Workspace {
+			// Every package reachable from the direct imports, except
+			// the package itself and the direct imports.
+			seen := map[string]bool{dir: true}
+			for _, imp := range imports[dir] {
+				seen[imp] = true
+			}
+			var transitive []string
+			queue := append([]string(nil), imports[dir]...)
+			for len(queue) > 0 {
+				next := queue[0]
+				queue = queue[1:]
+				for _, imp := range imports[next] {
+					if !seen[imp] {
+						seen[imp] = true
+						transitive = append(transitive, imp)
+						queue = append(queue, imp)
+					}
+				}
+			}
+			if len(transitive) > 0 {
+				p.TransitiveImports = absolute(transitive)
+			}
+		}
+		g.Packages = append(g.Packages, p)
+	}
+	sort.Slice(g.Packages, func(i, j int) bool { return g.Packages[i].Absolute < g.Packages[j].Absolute })
+	return g
+}
+
+// writeDOT writes the Graphviz DOT document of the graph to w. The packages
+// of the workspace are drawn in bold, vendored packages dashed, and the
+// edges are the direct imports.
+func (g *dependencyGraph) writeDOT(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph dependencies {\n")
+	for _, p := range g.Packages {
+		label := p.Package
+		if p.Version != "" {
+			label += "\n" + p.Version
+		} else if p.Rev != "" && !p.Stdlib {
+			label += "\n" + p.Rev
+		}
+		var attrs []string
+		if label != p.Absolute {
+			attrs = append(attrs, "label="+strconv.Quote(label))
+		}
+		switch {
+		case p.Workspace:
+			attrs = append(attrs, "style=bold")
+		case p.Vendor:
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(&buf, "\t%s", strconv.Quote(p.Absolute))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&buf, " [%s]", strings.Join(attrs, ", "))
+		}
+		buf.WriteString(";\n")
+	}
+	for _, p := range g.Packages {
+		for _, imp := range p.Imports {
+			fmt.Fprintf(&buf, "\t%s -> %s;\n", strconv.Quote(p.Absolute), strconv.Quote(imp))
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// fetchWorkspaceDeps fetches every transitive dependency of every Go package
+// in the workspace, and returns the dependency cache which recorded their
+// imports.
+func (h *BuildHandler) fetchWorkspaceDeps(ctx context.Context) *depCache {
+	var (
+		w       = ctxvfs.Walk(ctx, h.RootFSPath, h.FS)
+		dc      = newDepCache()
+		fetched = make(map[string]bool) // key is dir
+	)
+	dc.collectReferences = true
+	for w.Step() {
+		if path.Ext(w.Path()) == ".go" {
+			d := path.Dir(w.Path())
+			if fetched[d] {
+				continue
+			}
+			fetched[d] = true
+			if err := h.fetchTransitiveDepsOfFile(ctx, lsp.DocumentURI("file://"+d), dc); err != nil {
+				log.Printf("Warning: fetching deps for dir %s: %s.", d, err)
+			}
+		}
+	}
+	return dc
+}
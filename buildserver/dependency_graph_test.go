@@ -0,0 +1,82 @@
+package buildserver
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDepCacheGraph(t *testing.T) {
+	pkgs := map[string]*build.Package{
+		"test/ws":       {ImportPath: "test/ws", Imports: []string{"test/ws/sub", "v"}, Dir: "/src/test/ws"},
+		"test/ws/sub":   {ImportPath: "test/ws/sub", Imports: []string{"a"}, XTestImports: []string{"test/ws/sub"}, Dir: "/src/test/ws/sub"},
+		"test/ws/empty": {ImportPath: "test/ws/empty", Dir: "/src/test/ws/empty"},
+		"v":             {ImportPath: "test/ws/vendor/v", Imports: []string{"a"}, Dir: "/src/test/ws/vendor/v"},
+		"a":             {ImportPath: "a", Imports: []string{"b"}, Dir: "/src/a"},
+		"b":             {ImportPath: "b", Imports: []string{"fmt", "a"}, Dir: "/src/b"},
+		"fmt":           {ImportPath: "fmt", Goroot: true, Dir: "/goroot/src/fmt"},
+	}
+	importPackage := func(path, srcDir string, mode build.ImportMode) (*build.Package, error) {
+		if pkg := pkgs[path]; pkg != nil {
+			return pkg, nil
+		}
+		return nil, fmt.Errorf("package not found: %q", path)
+	}
+
+	dc := newDepCache()
+	dc.collectReferences = true
+	for _, p := range []string{"test/ws", "test/ws/sub", "test/ws/empty"} {
+		if err := doDeps(pkgs[p], 0, dc, importPackage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := dc.graph(func(dir string) bool {
+		return strings.HasPrefix(dir, "/src/test/ws") && !strings.Contains(dir, "/vendor/")
+	})
+
+	want := []*dependencyGraphPackage{
+		{Package: "a", Absolute: "a", Imports: []string{"b"}},
+		{Package: "b", Absolute: "b", Imports: []string{"a", "fmt"}},
+		{Package: "fmt", Absolute: "fmt", Stdlib: true, Imports: []string{}},
+		{Package: "test/ws", Absolute: "test/ws", Workspace: true, Imports: []string{"test/ws/sub", "test/ws/vendor/v"}, TransitiveImports: []string{"a", "b", "fmt"}},
+		{Package: "test/ws/empty", Absolute: "test/ws/empty", Workspace: true, Imports: []string{}},
+		{Package: "test/ws/sub", Absolute: "test/ws/sub", Workspace: true, Imports: []string{"a"}, TransitiveImports: []string{"b", "fmt"}},
+		{Package: "v", Absolute: "test/ws/vendor/v", Vendor: true, Imports: []string{"a"}},
+	}
+	if !reflect.DeepEqual(g.Packages, want) {
+		for _, p := range g.Packages {
+			t.Logf("got %+v", p)
+		}
+		t.Fatal("unexpected graph")
+	}
+
+	g.Packages[0].Repo, g.Packages[0].Rev, g.Packages[0].Version = "https://github.com/a/a", "0123456789abcdef", "v1.2.3"
+	g.Packages[1].Repo, g.Packages[1].Rev = "https://github.com/b/b", "master"
+	var buf bytes.Buffer
+	if err := g.writeDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wantDOT := `digraph dependencies {
+	"a" [label="a\nv1.2.3"];
+	"b" [label="b\nmaster"];
+	"fmt";
+	"test/ws" [style=bold];
+	"test/ws/empty" [style=bold];
+	"test/ws/sub" [style=bold];
+	"test/ws/vendor/v" [label="v", style=dashed];
+	"a" -> "b";
+	"b" -> "a";
+	"b" -> "fmt";
+	"test/ws" -> "test/ws/sub";
+	"test/ws" -> "test/ws/vendor/v";
+	"test/ws/sub" -> "a";
+	"test/ws/vendor/v" -> "a";
+}
+`
+	if got := buf.String(); got != wantDOT {
+		t.Errorf("got DOT\n%s\nwant\n%s", got, wantDOT)
+	}
+}
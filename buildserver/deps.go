@@ -67,6 +67,7 @@ type depCache struct {
 	seenMu            sync.Mutex
 	seen              map[string][]importRecord
 	entryPackageDirs  []string
+	entryPackages     []*build.Package // like entryPackageDirs, for the dependency graph
 }
 
 func newDepCache() *depCache {
@@ -115,6 +116,10 @@ type findPkgKey struct {
 	importPath string // e.g. "github.com/gorilla/mux"
 	fromDir    string // e.g. "/gopath/src/github.com/kubernetes/kubernetes"
 	mode       build.ImportMode
+
+	// The build target, since it determines the files of the package.
+	goos, goarch string
+	cgoEnabled   bool
 }
 
 type findPkgValue struct {
@@ -148,7 +153,7 @@ func (h *BuildHandler) findPackageCached(ctx context.Context, bctx *build.Contex
 	// "/gopath/src/gh.com/p/r" because we know the first vendor dir to
 	// check is "/gopath/src/gh.com/p/r/vendor". This also means that
 	// "/gopath/src/gh.com/p/r/bar/baz" and "/gopath/src/gh.com/p/r/foo"
-	// get the same cache key findPkgKey{"gh.com/gorilla/mux", "/gopath/src/gh.com/p/r", 0}.
+	// get the same cache key findPkgKey{"gh.com/gorilla/mux", "/gopath/src/gh.com/p/r", 0, ...}.
 	if !build.IsLocalImport(p) && srcDir != "" {
 		srcDirs := bctx.SrcDirs()
 		isGoPathSrcDir := func(p string) bool {
@@ -166,7 +171,7 @@ func (h *BuildHandler) findPackageCached(ctx context.Context, bctx *build.Contex
 
 	// We do single-flighting as well. conf.Loader does the same, but its
 	// single-flighting is based on srcDir before it is normalised.
-	k := findPkgKey{p, srcDir, mode}
+	k := findPkgKey{p, srcDir, mode, bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled}
 	h.findPkgMu.Lock()
 	if h.findPkg == nil {
 		h.findPkg = make(map[findPkgKey]*findPkgValue)
@@ -184,11 +189,28 @@ func (h *BuildHandler) findPackageCached(ctx context.Context, bctx *build.Contex
 	h.findPkgMu.Unlock()
 
 	v.bp, v.err = h.findPackage(ctx, bctx, p, srcDir, mode)
+	if v.bp != nil {
+		v.bp = withoutCgoFiles(v.bp)
+	}
 
 	close(v.ready)
 	return v.bp, v.err
 }
 
+// withoutCgoFiles returns bp with its CgoFiles moved to its GoFiles. The
+// loader runs cgo on the CgoFiles of a package, which can't work on our
+// VFS, so instead they are typechecked as they are with
+// types.Config.FakeImportC.
+func withoutCgoFiles(bp *build.Package) *build.Package {
+	if len(bp.CgoFiles) == 0 {
+		return bp
+	}
+	cp := *bp
+	cp.GoFiles = append(append([]string{}, bp.GoFiles...), bp.CgoFiles...)
+	cp.CgoFiles = nil
+	return &cp
+}
+
 // findPackage is a langserver.FindPackageFunc which integrates with the build
 // server. It will fetch dependencies just in time.
 func (h *BuildHandler) findPackage(ctx context.Context, bctx *build.Context, path, srcDir string, mode build.ImportMode) (*build.Package, error) {
@@ -248,7 +270,10 @@ func (h *BuildHandler) doFindPackage2(ctx context.Context, bctx *build.Context,
 
 	// Otherwise, it's an external dependency. Fetch the package
 	// and try again.
-	d, err := gosrc.ResolveImportPath(h.cachingClient, path)
+	if Offline != nil {
+		return h.findOfflinePackage(ctx, bctx, path, srcDir, mode)
+	}
+	d, err := h.resolveImportPath(path)
 	if err != nil {
 		return nil, err
 	}
@@ -271,14 +296,39 @@ func (h *BuildHandler) doFindPackage2(ctx context.Context, bctx *build.Context,
 		return pkg, nil
 	}
 
-	// We may have a specific rev to use (from glide.lock)
-	if rev := h.pinnedDep(ctx, d.ImportPath); rev != "" {
-		d.Rev = rev
-	}
+	// We may have a specific rev to use (from go.mod, glide.lock, etc.)
+	pinned := h.pinnedDep(ctx, d.ImportPath)
 
-	// If not, we hold the lock and we will fetch the dep.
-	if err := h.fetchDep(ctx, d); err != nil {
-		return nil, err
+	// If not, we hold the lock and we will fetch the dep. Modules pinned by
+	// a go.mod may be fetched from module proxies rather than their repos.
+	fetched := false
+	if pinned != nil && pinned.Module != "" {
+		err := h.fetchModule(ctx, d, pinned)
+		if err != nil && err != vfsutil.ErrModuleProxyDirect {
+			return nil, err
+		}
+		fetched = err == nil
+	}
+	if !fetched {
+		if pinned != nil {
+			d.Rev = pinned.Rev
+			if pinned.Module != "" {
+				projectRoot := d.ProjectRoot
+				if pinned.Module != strings.TrimSuffix(pinned.Pkg, "/") {
+					// The module is replaced, so we fetch the
+					// replacement in its place.
+					r, err := h.resolveImportPath(pinned.Module)
+					if err != nil {
+						return nil, err
+					}
+					d.CloneURL, d.VCS, projectRoot = r.CloneURL, r.VCS, r.ProjectRoot
+				}
+				d.Rev = moduleRev(pinned.Module, pinned.Version, projectRoot)
+			}
+		}
+		if err := h.fetchDep(ctx, d); err != nil {
+			return nil, err
+		}
 	}
 
 	pkg, err = bctx.Import(path, srcDir, mode)
@@ -288,27 +338,41 @@ func (h *BuildHandler) doFindPackage2(ctx context.Context, bctx *build.Context,
 	return pkg, err
 }
 
+// resolveImportPath resolves importPath with h.Resolver, or else like
+// gosrc.ResolveImportPath.
+func (h *BuildHandler) resolveImportPath(importPath string) (*gosrc.Directory, error) {
+	if h.Resolver != nil {
+		return h.Resolver.Resolve(importPath)
+	}
+	return gosrc.ResolveImportPath(h.cachingClient, importPath)
+}
+
 func (h *BuildHandler) fetchDep(ctx context.Context, d *gosrc.Directory) error {
-	if d.VCS != "git" {
+	if d.VCS != "git" && !vfsutil.SupportedVCS(d.VCS) {
 		return fmt.Errorf("dependency at import path %q has unsupported VCS %q (clone URL is %q)", d.ImportPath, d.VCS, d.CloneURL)
 	}
 
-	rev := d.Rev
-	if rev == "" {
-		rev = "HEAD"
-	}
 	cloneURL, err := url.Parse(d.CloneURL)
 	if err != nil {
 		return err
 	}
 
-	var zipURLTemplate *string
-	if initializationOptions, ok := h.init.InitializationOptions.(map[string]interface{}); ok {
-		if stringValue, ok := initializationOptions["zipURLTemplate"].(string); ok {
-			zipURLTemplate = &stringValue
+	var fs ctxvfs.FileSystem
+	if d.VCS == "git" {
+		rev := d.Rev
+		if rev == "" {
+			rev = "HEAD"
+		}
+		var zipURLTemplate *string
+		if initializationOptions, ok := h.init.InitializationOptions.(map[string]interface{}); ok {
+			if stringValue, ok := initializationOptions["zipURLTemplate"].(string); ok {
+				zipURLTemplate = &stringValue
+			}
 		}
+		fs, err = NewDepRepoVFS(ctx, cloneURL, rev, zipURLTemplate)
+	} else {
+		fs, err = NewVCSRepoVFS(ctx, d.VCS, cloneURL, d.Rev)
 	}
-	fs, err := NewDepRepoVFS(ctx, cloneURL, rev, zipURLTemplate)
 	if err != nil {
 		return err
 	}
@@ -318,6 +382,16 @@ func (h *BuildHandler) fetchDep(ctx context.Context, d *gosrc.Directory) error {
 		fs = addSysZversionFile(fs)
 	}
 
+	// The packages of a module with a major version suffix, such as
+	// github.com/foo/bar/v2, are either in a subdirectory of the repo
+	// named after the suffix, or at the root of the repo on a major version
+	// branch. In the latter case, the repo is mounted at the module path.
+	if major := majorVersionSuffix(d.ImportPath, d.ProjectRoot); major != "" {
+		if _, err := fs.Stat(ctx, "/"+major); err != nil {
+			d.ProjectRoot = path.Join(d.ProjectRoot, major)
+		}
+	}
+
 	var oldPath string
 	if isStdlib {
 		oldPath = goroot // stdlib
@@ -335,13 +409,42 @@ func (h *BuildHandler) fetchDep(ctx context.Context, d *gosrc.Directory) error {
 	return nil
 }
 
-func (h *BuildHandler) pinnedDep(ctx context.Context, pkg string) string {
+// fetchModule fetches the module pinned by a go.mod from module proxies,
+// and mounts it at the module path it is required by. It returns
+// vfsutil.ErrModuleProxyDirect if the module must be fetched from its repo.
+func (h *BuildHandler) fetchModule(ctx context.Context, d *gosrc.Directory, pinned *pinnedPkg) error {
+	fs, err := NewModuleVFS(ctx, pinned.Module, pinned.Version, h.goSum)
+	if err != nil {
+		return err
+	}
+
+	if pinned.Module == strings.TrimSuffix(pinned.Pkg, "/") {
+		d.Rev = moduleRev(pinned.Module, pinned.Version, d.ProjectRoot)
+	}
+
+	h.HandlerShared.Mu.Lock()
+	h.FS.Bind(path.Join(gopath, "src", strings.TrimSuffix(pinned.Pkg, "/")), fs, "/", ctxvfs.BindAfter)
+	h.gopathDeps = append(h.gopathDeps, d)
+	h.HandlerShared.Mu.Unlock()
+
+	return nil
+}
+
+func (h *BuildHandler) pinnedDep(ctx context.Context, pkg string) *pinnedPkg {
 	h.pinnedDepsOnce.Do(func() {
 		h.HandlerShared.Mu.Lock()
 		fs := h.FS
 		root := h.RootFSPath
 		h.HandlerShared.Mu.Unlock()
 
+		// Go modules are the official way to pin dependencies, so if a repo
+		// has go.mod files they are the most up to date.
+		if gomods, gosum := readGoMods(ctx, fs, root); len(gomods) > 0 {
+			h.pinnedDeps = loadGoMod(gomods...)
+			h.goSum = gosum
+			return
+		}
+
 		// github.com/golang/dep is not widely used yet, but likely will in
 		// the future. So we try it first.
 		toml, err := ctxvfs.ReadFile(ctx, fs, path.Join(root, "Gopkg.lock"))
@@ -369,7 +472,44 @@ func (h *BuildHandler) pinnedDep(ctx context.Context, pkg string) string {
 			return
 		}
 	})
-	return h.pinnedDeps.Find(pkg)
+	return h.pinnedDeps.find(pkg)
+}
+
+// readGoMods returns the contents of the go.mod files of the repo at root,
+// the shallowest ones first, and of their go.sum files concatenated. Like
+// the go tool, it ignores the go.mod files in vendor and testdata
+// directories, and in directories whose names begin with "." or "_".
+func readGoMods(ctx context.Context, fs ctxvfs.FileSystem, root string) (gomods [][]byte, gosum []byte) {
+	var paths []string
+	w := ctxvfs.Walk(ctx, root, fs)
+	for w.Step() {
+		if w.Err() != nil {
+			continue
+		}
+		fi := w.Stat()
+		if fi.Mode().IsDir() && w.Path() != root && (fi.Name() == "vendor" || fi.Name() == "testdata" || strings.HasPrefix(fi.Name(), ".") || strings.HasPrefix(fi.Name(), "_")) {
+			w.SkipDir()
+			continue
+		}
+		if fi.Mode().IsRegular() && fi.Name() == "go.mod" {
+			paths = append(paths, w.Path())
+		}
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return strings.Count(paths[i], "/") < strings.Count(paths[j], "/")
+	})
+
+	for _, p := range paths {
+		b, err := ctxvfs.ReadFile(ctx, fs, p)
+		if err == nil && len(b) > 0 {
+			gomods = append(gomods, b)
+		}
+		b, err = ctxvfs.ReadFile(ctx, fs, path.Join(path.Dir(p), "go.sum"))
+		if err == nil {
+			gosum = append(gosum, b...)
+		}
+	}
+	return gomods, gosum
 }
 
 func doDeps(pkg *build.Package, mode build.ImportMode, dc *depCache, importPackage func(path, srcDir string, mode build.ImportMode) (*build.Package, error)) error {
@@ -443,6 +583,7 @@ func doDeps(pkg *build.Package, mode build.ImportMode, dc *depCache, importPacka
 	if dc.collectReferences {
 		dc.seenMu.Lock()
 		dc.entryPackageDirs = append(dc.entryPackageDirs, pkg.Dir)
+		dc.entryPackages = append(dc.entryPackages, pkg)
 		dc.seenMu.Unlock()
 	}
 	wg.Wait()
@@ -477,6 +618,10 @@ func isMultiplePackageError(err error) bool {
 // impacting the first ever typecheck we do in a repo since it will have to
 // fetch the dependency from the internet.
 func FetchCommonDeps() {
+	if Offline != nil {
+		return
+	}
+
 	// github.com/golang/go
 	d, _ := gosrc.ResolveImportPath(http.DefaultClient, "time")
 	u, _ := url.Parse(d.CloneURL)
@@ -528,6 +673,33 @@ var NewDepRepoVFS = func(ctx context.Context, cloneURL *url.URL, rev string, zip
 	}, nil
 }
 
+// NewVCSRepoVFS returns a virtual file system interface for accessing
+// the files of a Mercurial, Subversion or Fossil repo at a revision, or at
+// the default branch if rev is empty.
+var NewVCSRepoVFS = func(ctx context.Context, vcs string, cloneURL *url.URL, rev string) (ctxvfs.FileSystem, error) {
+	return &vfsutil.VCSRepoVFS{
+		VCS:      vcs,
+		CloneURL: cloneURL.String(),
+		Rev:      rev,
+	}, nil
+}
+
+// NewModuleVFS returns a virtual file system interface for accessing the
+// files of the version of a module, fetched from the module proxies
+// configured by the GOPROXY, GONOPROXY and GOPRIVATE environment variables
+// and verified against goSum. Unlike the go tool, modules are only fetched
+// from module proxies if GOPROXY is set; otherwise, and if GOPROXY falls
+// back to "direct", vfsutil.ErrModuleProxyDirect is returned and the module
+// is fetched from its repo by NewDepRepoVFS instead.
+var NewModuleVFS = func(ctx context.Context, module, version string, goSum []byte) (ctxvfs.FileSystem, error) {
+	cfg := vfsutil.ModuleProxyConfigFromEnv()
+	if cfg.GOPROXY == "" {
+		return nil, vfsutil.ErrModuleProxyDirect
+	}
+	cfg.GoSum = goSum
+	return vfsutil.NewModuleProxyVFS(ctx, cfg, module, version)
+}
+
 var depZipFetch = prometheus.NewCounter(prometheus.CounterOpts{
 	Name: "golangserver_vfs_dep_zip_fetch_total",
 	Help: "Total number of zip URL fetches by NewDepRepoVFS.",
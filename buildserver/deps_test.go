@@ -1,11 +1,22 @@
 package buildserver
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go/build"
+	"go/types"
+	"net/url"
 	"reflect"
 	"sync"
 	"testing"
+
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-langserver/gosrc"
+	"github.com/sourcegraph/go-langserver/langserver"
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-lsp/lspext"
+	"golang.org/x/tools/go/loader"
 )
 
 func TestImportDeps(t *testing.T) {
@@ -47,3 +58,111 @@ func TestImportDeps(t *testing.T) {
 		t.Errorf("imported %v, want %v", imported, want)
 	}
 }
+
+func TestFindPackage_resolver(t *testing.T) {
+	var fetched []string
+	orig := NewDepRepoVFS
+	NewDepRepoVFS = func(ctx context.Context, cloneURL *url.URL, rev string, zipURLTemplate *string) (ctxvfs.FileSystem, error) {
+		fetched = append(fetched, cloneURL.String())
+		return mapFS(map[string]string{"sub/sub.go": "package sub"}), nil
+	}
+	defer func() { NewDepRepoVFS = orig }()
+
+	h, bctx := newTestHandler(t, map[string]string{"ws.go": "package ws"})
+	h.Resolver = gosrc.RewriteRules{{Match: `go\.acme\.com/([^/]+)`, CloneURL: "https://git.acme.com/$1.git"}}
+	pkg, err := h.findPackage(context.Background(), bctx, "go.acme.com/log/sub", "/src/test/ws", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/src/go.acme.com/log/sub"; pkg.Dir != want {
+		t.Errorf("got dir %s, want %s", pkg.Dir, want)
+	}
+	if want := []string{"https://git.acme.com/log.git"}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %q, want %q", fetched, want)
+	}
+}
+
+func TestFindPackage_vcs(t *testing.T) {
+	var fetched []string
+	origNewDepRepoVFS, origNewVCSRepoVFS := NewDepRepoVFS, NewVCSRepoVFS
+	NewDepRepoVFS = func(ctx context.Context, cloneURL *url.URL, rev string, zipURLTemplate *string) (ctxvfs.FileSystem, error) {
+		t.Errorf("fetched %s with git", cloneURL)
+		return nil, errors.New("not git")
+	}
+	NewVCSRepoVFS = func(ctx context.Context, vcs string, cloneURL *url.URL, rev string) (ctxvfs.FileSystem, error) {
+		fetched = append(fetched, vcs+" "+cloneURL.String()+"@"+rev)
+		return mapFS(map[string]string{"pkg.go": "package pkg"}), nil
+	}
+	defer func() { NewDepRepoVFS, NewVCSRepoVFS = origNewDepRepoVFS, origNewVCSRepoVFS }()
+
+	h, bctx := newTestHandler(t, map[string]string{"ws.go": "package ws"})
+	h.Resolver = gosrc.RewriteRules{
+		{Match: `hg\.example\.com/[^/]+`, CloneURL: "https://$0", VCS: "hg"},
+		{Match: `svn\.example\.com/[^/]+`, CloneURL: "https://$0/trunk", VCS: "svn"},
+		{Match: `bzr\.example\.com/[^/]+`, CloneURL: "https://$0", VCS: "bzr"},
+	}
+	h.pinnedDepsOnce.Do(func() {
+		h.pinnedDeps = pinnedPkgs{{Pkg: "svn.example.com/b/", Rev: "42"}}
+	})
+	for _, importPath := range []string{"hg.example.com/a", "svn.example.com/b"} {
+		if _, err := h.findPackage(context.Background(), bctx, importPath, "/src/test/ws", 0); err != nil {
+			t.Errorf("%s: %s", importPath, err)
+		}
+	}
+	if want := []string{"hg https://hg.example.com/a@", "svn https://svn.example.com/b/trunk@42"}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %q, want %q", fetched, want)
+	}
+
+	want := `dependency at import path "bzr.example.com/c" has unsupported VCS "bzr" (clone URL is "https://bzr.example.com/c")`
+	if _, err := h.findPackage(context.Background(), bctx, "bzr.example.com/c", "/src/test/ws", 0); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestFindPackage_cgo(t *testing.T) {
+	h, bctx := newTestHandler(t, map[string]string{
+		"ws.go":                         `package ws; import "example.com/c"; var _ = c.Cgo`,
+		"vendor/example.com/c/cgo.go":   `package c; import "C"; func Cgo() {}`,
+		"vendor/example.com/c/nocgo.go": "// +build !cgo\n\npackage c; func NoCgo() {}",
+	})
+	bctx.CgoEnabled = true
+	var typeErrs []error
+	conf := loader.Config{
+		Build: bctx,
+		TypeChecker: types.Config{
+			FakeImportC: true,
+			Error:       func(err error) { typeErrs = append(typeErrs, err) },
+		},
+		AllowErrors: true,
+		FindPackage: func(bctx *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error) {
+			return h.findPackageCached(context.Background(), bctx, importPath, fromDir, "/src/test/ws", mode)
+		},
+	}
+	conf.Import("test/ws")
+	prog, err := conf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(typeErrs) > 0 {
+		t.Fatal(typeErrs)
+	}
+	if c := prog.Package("test/ws/vendor/example.com/c").Pkg; c.Scope().Lookup("NoCgo") != nil {
+		t.Error("got the !cgo files of example.com/c, want only its cgo files")
+	}
+}
+
+// newTestHandler returns a build handler for the workspace test/ws with the
+// files ws, and a build context for it.
+func newTestHandler(t *testing.T, ws map[string]string) (*BuildHandler, *build.Context) {
+	h := NewHandler(langserver.NewDefaultConfig())
+	if err := h.HandlerShared.Reset(false); err != nil {
+		t.Fatal(err)
+	}
+	h.init = &lspext.InitializeParams{}
+	h.depURLMutex = newKeyMutex()
+	h.rootImportPath = "test/ws"
+	h.FS.Bind("/src/test/ws", mapFS(ws), "/", ctxvfs.BindAfter)
+	bctx := &build.Context{GOOS: "linux", GOARCH: "amd64", GOROOT: goroot, GOPATH: gopath, Compiler: "gc"}
+	util.PrepareContext(bctx, context.Background(), h.FS)
+	return h, bctx
+}
@@ -30,10 +30,10 @@ const (
 	goroot     = "/goroot"
 	gocompiler = "gc"
 
-	// TODO(sqs): allow these to be customized. They're
-	// fine for now, though.
-	goos   = "linux"
-	goarch = "amd64"
+	// defaultGOOS and defaultGOARCH are the target of the workspaces which
+	// don't configure one, see determineBuildTarget.
+	defaultGOOS   = "linux"
+	defaultGOARCH = "amd64"
 )
 
 // determineEnvironment will setup the language server InitializeParams based
@@ -78,6 +78,8 @@ func determineEnvironment(ctx context.Context, fs ctxvfs.FileSystem, params lspe
 		GOPATH = strings.Join(customGOPATH, ":")
 	}
 
+	goos, goarch, cgoEnabled := determineBuildTarget(ctx, fs, params)
+
 	// Send "initialize" to the wrapped lang server.
 	langInitParams := &langserver.InitializeParams{
 		InitializeParams:     params.InitializeParams,
@@ -87,7 +89,7 @@ func determineEnvironment(ctx context.Context, fs ctxvfs.FileSystem, params lspe
 			GOARCH:     goarch,
 			GOPATH:     GOPATH,
 			GOROOT:     goroot,
-			CgoEnabled: false,
+			CgoEnabled: cgoEnabled,
 			Compiler:   gocompiler,
 
 			// TODO(sqs): We'd like to set this to true only for
@@ -107,6 +109,39 @@ func determineEnvironment(ctx context.Context, fs ctxvfs.FileSystem, params lspe
 	return langInitParams, nil
 }
 
+// determineBuildTarget determines the GOOS and GOARCH the workspace is built
+// for, and whether cgo is enabled. The goos, goarch and cgoEnabled
+// initializationOptions (the same as the language server's) take precedence
+// over the go section of .sourcegraph/config.json. Cgo is disabled unless
+// enabled by either, since most dependencies build without it.
+func determineBuildTarget(ctx context.Context, fs ctxvfs.FileSystem, params lspext.InitializeParams) (goos, goarch string, cgoEnabled bool) {
+	goos, goarch = defaultGOOS, defaultGOARCH
+
+	cfg := readSourcegraphConfig(ctx, fs)
+	if cfg.Go.GOOS != "" {
+		goos = cfg.Go.GOOS
+	}
+	if cfg.Go.GOARCH != "" {
+		goarch = cfg.Go.GOARCH
+	}
+	if cfg.Go.CgoEnabled != nil {
+		cgoEnabled = *cfg.Go.CgoEnabled
+	}
+
+	if initializationOptions, ok := params.InitializationOptions.(map[string]interface{}); ok {
+		if v, _ := initializationOptions["goos"].(string); v != "" {
+			goos = v
+		}
+		if v, _ := initializationOptions["goarch"].(string); v != "" {
+			goarch = v
+		}
+		if v, ok := initializationOptions["cgoEnabled"].(bool); ok {
+			cgoEnabled = v
+		}
+	}
+	return goos, goarch, cgoEnabled
+}
+
 // detectCustomGOPATH tries to detect monorepos which require their own custom
 // GOPATH.
 //
@@ -291,6 +326,18 @@ type sourcegraphConfig struct {
 		//
 		// See https://github.com/sourcegraph/go-langserver#vanity-import-paths.
 		RootImportPath string
+
+		// GOOS and GOARCH are the operating system and architecture the
+		// repository is built for, such as "windows" and "arm64". They
+		// default to linux and amd64.
+		//
+		// See https://github.com/sourcegraph/go-langserver#target-platforms.
+		GOOS   string
+		GOARCH string
+
+		// CgoEnabled controls whether files which import "C" are part of
+		// their packages. It defaults to false.
+		CgoEnabled *bool
 	} `json:"go"`
 }
 
@@ -157,6 +157,53 @@ GOPATH_add /absolute
 	}
 }
 
+func TestDetermineBuildTarget(t *testing.T) {
+	config := `{"go": {"GOOS": "windows", "GOARCH": "386", "CgoEnabled": true}}`
+	cases := []struct {
+		Name                  string
+		FS                    map[string]string
+		InitializationOptions interface{}
+		WantGOOS, WantGOARCH  string
+		WantCgoEnabled        bool
+	}{
+		{
+			Name:       "default",
+			FS:         map[string]string{"pkg.go": "package pkg"},
+			WantGOOS:   "linux",
+			WantGOARCH: "amd64",
+		},
+		{
+			Name:           "sourcegraph_config",
+			FS:             map[string]string{".sourcegraph/config.json": config},
+			WantGOOS:       "windows",
+			WantGOARCH:     "386",
+			WantCgoEnabled: true,
+		},
+		{
+			Name:                  "initialization_options",
+			FS:                    map[string]string{".sourcegraph/config.json": config},
+			InitializationOptions: map[string]interface{}{"goos": "darwin", "cgoEnabled": false},
+			WantGOOS:              "darwin",
+			WantGOARCH:            "386",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			params := lspext.InitializeParams{
+				InitializeParams: lsp.InitializeParams{InitializationOptions: tc.InitializationOptions},
+				OriginalRootURI:  "git://github.com/alice/pkg",
+			}
+			got, err := determineEnvironment(context.Background(), mapFS(tc.FS), params)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			if bctx := got.BuildContext; bctx.GOOS != tc.WantGOOS || bctx.GOARCH != tc.WantGOARCH || bctx.CgoEnabled != tc.WantCgoEnabled {
+				t.Fatalf("got %s/%s (cgo %t), want %s/%s (cgo %t)", bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled, tc.WantGOOS, tc.WantGOARCH, tc.WantCgoEnabled)
+			}
+		})
+	}
+}
+
 // mapFS lets us easily instantiate a VFS with a map[string]string
 // (which is less noisy than map[string][]byte in test fixtures).
 func mapFS(m map[string]string) ctxvfs.FileSystem {
@@ -0,0 +1,185 @@
+package buildserver
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-langserver/gosrc"
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-langserver/vfsutil"
+)
+
+// OfflineConfig configures the local sources the build server fetches
+// dependencies from in offline mode.
+type OfflineConfig struct {
+	// ModCacheDir is a module cache directory, such as $GOPATH/pkg/mod. The
+	// module versions required by go.mod files are read from the zip
+	// archives the go command downloaded into it.
+	ModCacheDir string
+
+	// MirrorDir is a directory of zip archives, named after the import path
+	// of a repository root and a revision, such as
+	// github.com/gorilla/mux@v1.6.2.zip. The module versions required by
+	// go.mod files are read from the archives named after the module path
+	// and version, the dependencies pinned by other lock files from the
+	// archives of their revisions, and the other dependencies from the
+	// archives of the HEAD revision, such as github.com/gorilla/mux@HEAD.zip.
+	// The standard library is read from github.com/golang/go@go1.x.zip
+	// archives, named after the Go version of the build server.
+	MirrorDir string
+}
+
+// Offline, if set, puts the build server in offline mode, where it never
+// accesses the network to resolve import paths or fetch dependencies.
+// Instead, dependencies are only read from the vendor directories of the
+// workspace and the local sources Offline configures. The standard library
+// is read from the GOROOT of the build server if the mirror has no
+// archive of it.
+var Offline *OfflineConfig
+
+// findOfflinePackage imports the external dependency at importPath, after
+// fetching it from the local sources configured by Offline.
+func (h *BuildHandler) findOfflinePackage(ctx context.Context, bctx *build.Context, importPath, srcDir string, mode build.ImportMode) (*build.Package, error) {
+	d, fs, err := h.resolveOfflineDep(ctx, importPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like for online dependencies, never overlay the workspace's repo
+	// with another version of it.
+	if h.rootImportPath != "" && util.PathHasPrefix(d.ProjectRoot, h.rootImportPath) {
+		return nil, fmt.Errorf("package %q is inside of workspace root, refusing to fetch from offline sources", importPath)
+	}
+
+	isStdlib := gosrc.IsStdlibPkg(importPath)
+	var oldPath string
+	if isStdlib {
+		oldPath = goroot
+		fs = addSysZversionFile(fs)
+	} else {
+		oldPath = path.Join(gopath, "src", d.ProjectRoot)
+	}
+
+	mu := h.depURLMutex.get(oldPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Check again after waiting.
+	pkg, err := bctx.Import(importPath, srcDir, mode)
+	if err == nil {
+		return pkg, nil
+	}
+
+	h.HandlerShared.Mu.Lock()
+	h.FS.Bind(oldPath, fs, "/", ctxvfs.BindAfter)
+	if !isStdlib {
+		h.gopathDeps = append(h.gopathDeps, d)
+	}
+	h.HandlerShared.Mu.Unlock()
+
+	pkg, err = bctx.Import(importPath, srcDir, mode)
+	if isMultiplePackageError(err) {
+		err = nil
+	}
+	return pkg, err
+}
+
+// resolveOfflineDep returns the directory of the package at importPath and
+// the file system of its repo or module, read from the local sources
+// configured by Offline. Since the import path can't be resolved, the
+// project root of a dependency is the path it is pinned at, or else the
+// longest prefix of importPath which the mirror has an archive of.
+func (h *BuildHandler) resolveOfflineDep(ctx context.Context, importPath string) (*gosrc.Directory, ctxvfs.FileSystem, error) {
+	var tried []string
+	openZip := func(name string, open func() (*vfsutil.ArchiveFS, error)) ctxvfs.FileSystem {
+		tried = append(tried, name)
+		fs, err := open()
+		if err != nil {
+			return nil
+		}
+		return fs
+	}
+	openMirror := func(importPath, rev, prefix string) ctxvfs.FileSystem {
+		if Offline.MirrorDir == "" || rev == "" {
+			return nil
+		}
+		name := filepath.Join(Offline.MirrorDir, filepath.FromSlash(importPath+"@"+rev+".zip"))
+		return openZip(name, func() (*vfsutil.ArchiveFS, error) { return vfsutil.NewLocalZipVFS(name, prefix) })
+	}
+
+	if gosrc.IsStdlibPkg(importPath) {
+		d, err := gosrc.StdlibResolver{}.Resolve(importPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if fs := openMirror("github.com/golang/go", d.Rev, ""); fs != nil {
+			return d, fs, nil
+		}
+		localGOROOT := runtime.GOROOT()
+		tried = append(tried, filepath.Join(localGOROOT, "src"))
+		if fi, err := os.Stat(filepath.Join(localGOROOT, "src")); err == nil && fi.IsDir() {
+			return d, ctxvfs.OS(localGOROOT), nil
+		}
+		return nil, nil, offlineNotFoundError(importPath, tried)
+	}
+
+	if pinned := h.pinnedDep(ctx, importPath); pinned != nil {
+		root := strings.TrimSuffix(pinned.Pkg, "/")
+		d := offlineDirectory(importPath, root, pinned.Rev)
+		if pinned.Module != "" {
+			if pinned.Module == root {
+				d.Rev = moduleRev(pinned.Module, pinned.Version, root)
+			}
+			prefix := pinned.Module + "@" + pinned.Version
+			if Offline.ModCacheDir != "" {
+				name := vfsutil.ModuleCacheZipPath(Offline.ModCacheDir, pinned.Module, pinned.Version)
+				if fs := openZip(name, func() (*vfsutil.ArchiveFS, error) {
+					return vfsutil.NewModuleCacheVFS(Offline.ModCacheDir, pinned.Module, pinned.Version)
+				}); fs != nil {
+					return d, fs, nil
+				}
+			}
+			if fs := openMirror(pinned.Module, pinned.Version, prefix); fs != nil {
+				return d, fs, nil
+			}
+		} else if fs := openMirror(root, pinned.Rev, ""); fs != nil {
+			return d, fs, nil
+		}
+		return nil, nil, offlineNotFoundError(importPath, tried)
+	}
+
+	for root := importPath; root != "." && root != "/"; root = path.Dir(root) {
+		if fs := openMirror(root, "HEAD", ""); fs != nil {
+			return offlineDirectory(importPath, root, ""), fs, nil
+		}
+	}
+	return nil, nil, offlineNotFoundError(importPath, tried)
+}
+
+// offlineDirectory returns the directory of the package at importPath in
+// the repo at root. The clone URL is a guess, like for noGoGetDomains,
+// since it can't be resolved offline. It's only used for the locations of
+// the files of the dependency.
+func offlineDirectory(importPath, root, rev string) *gosrc.Directory {
+	return &gosrc.Directory{
+		ImportPath:  importPath,
+		ProjectRoot: root,
+		CloneURL:    "https://" + root,
+		VCS:         "git",
+		Rev:         rev,
+	}
+}
+
+func offlineNotFoundError(importPath string, tried []string) error {
+	if len(tried) == 0 {
+		return fmt.Errorf("package %q is not vendored, and no module cache or mirror directory is configured for offline mode", importPath)
+	}
+	return fmt.Errorf("package %q is not vendored, and not found in offline mode (tried %s)", importPath, strings.Join(tried, ", "))
+}
@@ -0,0 +1,138 @@
+package buildserver
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"go/build"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/ctxvfs"
+)
+
+func TestOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mirrorDir, modCacheDir := filepath.Join(dir, "mirror"), filepath.Join(dir, "mod")
+
+	writeZip(t, filepath.Join(mirrorDir, "github.com/gorilla/mux@HEAD.zip"), map[string]string{
+		"mux-master/mux.go":     "package mux",
+		"mux-master/sub/sub.go": "package sub",
+	})
+	writeZip(t, filepath.Join(mirrorDir, "gopkg.in/yaml.v2@v2.2.1.zip"), map[string]string{
+		"yaml.go": "package yaml",
+		"LICENSE": "",
+	})
+	writeZip(t, filepath.Join(mirrorDir, "example.com/bar@v0.1.0.zip"), map[string]string{
+		"example.com/bar@v0.1.0/bar.go": "package bar",
+	})
+	writeZip(t, filepath.Join(modCacheDir, "cache/download/example.com/!foo/@v/v1.0.0.zip"), map[string]string{
+		"example.com/Foo@v1.0.0/foo.go": "package foo",
+	})
+
+	// Nothing must be fetched from the network.
+	origNewDepRepoVFS, origNewModuleVFS := NewDepRepoVFS, NewModuleVFS
+	NewDepRepoVFS = func(ctx context.Context, cloneURL *url.URL, rev string, zipURLTemplate *string) (ctxvfs.FileSystem, error) {
+		t.Errorf("fetched %s@%s in offline mode", cloneURL, rev)
+		return nil, errors.New("offline")
+	}
+	NewModuleVFS = func(ctx context.Context, module, version string, goSum []byte) (ctxvfs.FileSystem, error) {
+		t.Errorf("fetched module %s@%s in offline mode", module, version)
+		return nil, errors.New("offline")
+	}
+	defer func() {
+		NewDepRepoVFS, NewModuleVFS = origNewDepRepoVFS, origNewModuleVFS
+		Offline = nil
+	}()
+
+	newHandler := func() (*BuildHandler, *build.Context) {
+		h, bctx := newTestHandler(t, map[string]string{
+			"ws.go":                          "package ws",
+			"vendor/github.com/pkg/v/v.go":   "package v",
+			"vendor/example.com/bar/vend.go": "package bar",
+		})
+		h.pinnedDepsOnce.Do(func() {
+			h.pinnedDeps = pinnedPkgs{
+				{Pkg: "example.com/Foo/", Module: "example.com/Foo", Version: "v1.0.0"},
+				{Pkg: "example.com/bar/", Module: "example.com/bar", Version: "v0.1.0"},
+				{Pkg: "gopkg.in/yaml.v2/", Rev: "v2.2.1"},
+			}
+		})
+		return h, bctx
+	}
+
+	Offline = &OfflineConfig{ModCacheDir: modCacheDir, MirrorDir: mirrorDir}
+	h, bctx := newHandler()
+	for importPath, wantDir := range map[string]string{
+		"github.com/pkg/v":           "/src/test/ws/vendor/github.com/pkg/v",
+		"github.com/gorilla/mux/sub": "/src/github.com/gorilla/mux/sub",
+		"github.com/gorilla/mux":     "/src/github.com/gorilla/mux",
+		"gopkg.in/yaml.v2":           "/src/gopkg.in/yaml.v2",
+		"example.com/Foo":            "/src/example.com/Foo",
+		"example.com/bar":            "/src/example.com/bar",
+		"fmt":                        "/goroot/src/fmt",
+	} {
+		srcDir := "/src/test/ws"
+		if importPath == "example.com/bar" {
+			// Not from the workspace, so the vendored one isn't used.
+			srcDir = ""
+		}
+		pkg, err := h.findPackage(context.Background(), bctx, importPath, srcDir, 0)
+		if err != nil {
+			t.Errorf("%s: %s", importPath, err)
+			continue
+		}
+		if pkg.Dir != wantDir {
+			t.Errorf("%s: got dir %s, want %s", importPath, pkg.Dir, wantDir)
+		}
+	}
+
+	want := "not found in offline mode (tried " + filepath.Join(mirrorDir, "github.com/missing/pkg@HEAD.zip")
+	if _, err := h.findPackage(context.Background(), bctx, "github.com/missing/pkg", "/src/test/ws", 0); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	// Without any local sources, only vendored packages are found.
+	Offline = &OfflineConfig{}
+	h, bctx = newHandler()
+	if _, err := h.findPackage(context.Background(), bctx, "github.com/pkg/v", "/src/test/ws", 0); err != nil {
+		t.Error(err)
+	}
+	want = `package "gopkg.in/yaml.v2" is not vendored, and no module cache or mirror directory is configured for offline mode`
+	if _, err := h.findPackage(context.Background(), bctx, "gopkg.in/yaml.v2", "/src/test/ws", 0); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+// writeZip writes a zip archive of files to name.
+func writeZip(t *testing.T, name string, files map[string]string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, contents := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,7 +2,9 @@ package buildserver
 
 import (
 	"encoding/json"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	toml "github.com/pelletier/go-toml"
@@ -13,6 +15,13 @@ import (
 type pinnedPkg struct {
 	Pkg string
 	Rev string
+
+	// Module and Version are set instead of Rev for pkgs pinned by a
+	// go.mod, since the revision of a module version depends on where the
+	// module is in its repository. Module is the path of the module to
+	// fetch, which is not the path of the pkg if the module is replaced.
+	Module  string
+	Version string
 }
 
 // pinnedPkgs is a sorted slice of go pkg names, except always with a `/`
@@ -22,17 +31,31 @@ type pinnedPkgs []pinnedPkg
 
 // Find returns the revision a pkg is pinned at, or the empty string.
 func (p pinnedPkgs) Find(pkg string) string {
-	if len(p) == 0 {
-		return ""
-	}
-	pkg = pkg + "/"
-	i := sort.Search(len(p), func(i int) bool { return p[i].Pkg > pkg })
-	if i > 0 && strings.HasPrefix(pkg, p[i-1].Pkg) {
-		return p[i-1].Rev
+	if pinned := p.find(pkg); pinned != nil {
+		return pinned.Rev
 	}
 	return ""
 }
 
+// find returns the pin of pkg, or nil. Pins can be nested, such as the
+// modules cloud.google.com/go and cloud.google.com/go/bigquery, so the pin
+// of the longest prefix of pkg is returned.
+func (p pinnedPkgs) find(pkg string) *pinnedPkg {
+	for len(p) > 0 {
+		prefix := pkg + "/"
+		i := sort.Search(len(p), func(i int) bool { return p[i].Pkg >= prefix })
+		if i < len(p) && p[i].Pkg == prefix {
+			return &p[i]
+		}
+		j := strings.LastIndex(pkg, "/")
+		if j < 0 {
+			break
+		}
+		pkg = pkg[:j]
+	}
+	return nil
+}
+
 func (p pinnedPkgs) Len() int           { return len(p) }
 func (p pinnedPkgs) Less(i, j int) bool { return p[i].Pkg < p[j].Pkg }
 func (p pinnedPkgs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -107,3 +130,177 @@ func loadGodeps(b []byte) pinnedPkgs {
 	sort.Sort(pkgs)
 	return pkgs
 }
+
+// loadGoMod supports the go.mod files of Go modules. The modules required
+// by the first go.mod take precedence over the ones required by the next,
+// so a repo's root go.mod should be first, followed by the ones of its
+// nested modules.
+func loadGoMod(gomods ...[]byte) pinnedPkgs {
+	var pkgs pinnedPkgs
+	pinned := make(map[string]bool)
+	for _, b := range gomods {
+		f := parseGoMod(b)
+		excluded := make(map[moduleVersion]bool, len(f.Exclude))
+		for _, m := range f.Exclude {
+			excluded[m] = true
+		}
+		replaced := make(map[moduleVersion]moduleVersion, len(f.Replace))
+		for _, r := range f.Replace {
+			replaced[r.Old] = r.New
+		}
+
+		for _, m := range f.Require {
+			if pinned[m.Path] || excluded[m] {
+				continue
+			}
+			// A replacement of a specific version wins over one of all
+			// versions.
+			mod, ok := replaced[m]
+			if !ok {
+				mod, ok = replaced[moduleVersion{Path: m.Path}]
+			}
+			if !ok {
+				mod = m
+			}
+			if mod.Version == "" {
+				// Replaced by a local directory, which we can't fetch.
+				continue
+			}
+			pinned[m.Path] = true
+			pkgs = append(pkgs, pinnedPkg{Pkg: m.Path + "/", Module: mod.Path, Version: mod.Version})
+		}
+	}
+	sort.Sort(pkgs)
+	return pkgs
+}
+
+type moduleVersion struct {
+	Path    string
+	Version string // empty for all versions, or a local directory
+}
+
+// goMod is the part of a go.mod file which pins dependencies.
+type goMod struct {
+	Require []moduleVersion
+	Exclude []moduleVersion
+	Replace []struct{ Old, New moduleVersion }
+}
+
+// parseGoMod parses the require, exclude and replace directives of a go.mod
+// file. Like the other lock files, it is parsed on a best effort basis:
+// other directives and malformed lines are ignored.
+func parseGoMod(b []byte) *goMod {
+	var f goMod
+	var block string // the directive of the block we are in, if any
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		verb := block
+		if block == "" {
+			if len(args) == 2 && args[1] == "(" {
+				block = args[0]
+				continue
+			}
+			verb, args = args[0], args[1:]
+		} else if len(args) == 1 && args[0] == ")" {
+			block = ""
+			continue
+		}
+		for i, arg := range args {
+			if s, err := strconv.Unquote(arg); err == nil {
+				args[i] = s
+			}
+		}
+
+		switch verb {
+		case "require", "exclude":
+			if len(args) != 2 {
+				continue
+			}
+			m := moduleVersion{Path: args[0], Version: args[1]}
+			if verb == "require" {
+				f.Require = append(f.Require, m)
+			} else {
+				f.Exclude = append(f.Exclude, m)
+			}
+
+		case "replace":
+			// old [version] => new [version]
+			i := 0
+			for i < len(args) && args[i] != "=>" {
+				i++
+			}
+			if i == len(args) {
+				continue
+			}
+			old, new := args[:i], args[i+1:]
+			if len(old) < 1 || len(old) > 2 || len(new) < 1 || len(new) > 2 {
+				continue
+			}
+			var r struct{ Old, New moduleVersion }
+			r.Old.Path, r.New.Path = old[0], new[0]
+			if len(old) == 2 {
+				r.Old.Version = old[1]
+			}
+			if len(new) == 2 {
+				r.New.Version = new[1]
+			}
+			f.Replace = append(f.Replace, r)
+		}
+	}
+	return &f
+}
+
+// pseudoVersion matches the pseudo-versions of module versions which aren't
+// tagged, such as v0.0.0-20191109021931-daa7c04131f5, capturing the commit.
+var pseudoVersion = regexp.MustCompile(`^v[0-9]+\.(?:0\.0-|[0-9]+\.[0-9]+-(?:[^+]*\.)?0\.)[0-9]{14}-([A-Za-z0-9]+)$`)
+
+// moduleRev returns the revision of the version of module, which is in the
+// repo of the project root projectRoot.
+func moduleRev(module, version, projectRoot string) string {
+	version = strings.TrimSuffix(version, "+incompatible")
+	if m := pseudoVersion.FindStringSubmatch(version); m != nil {
+		return m[1]
+	}
+
+	// Other versions are tags, which are prefixed by the directory of the
+	// module in its repo, without its major version suffix.
+	dir := strings.TrimPrefix(module, projectRoot)
+	if i := strings.LastIndex(dir, "/"); i >= 0 && isMajorVersion(dir[i+1:]) {
+		dir = dir[:i]
+	}
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		return dir + "/" + version
+	}
+	return version
+}
+
+// majorVersionSuffix returns the major version suffix of the import path
+// pkg, such as "v2" for github.com/foo/bar/v2/baz, or the empty string.
+// Only the first element after the project root can be a major version
+// suffix, since the project root is the path of the repo.
+func majorVersionSuffix(pkg, projectRoot string) string {
+	if projectRoot == "" || !strings.HasPrefix(pkg, projectRoot+"/") {
+		return ""
+	}
+	elem := strings.SplitN(strings.TrimPrefix(pkg, projectRoot+"/"), "/", 2)[0]
+	if !isMajorVersion(elem) {
+		return ""
+	}
+	return elem
+}
+
+// isMajorVersion reports whether the path element elem is a major version
+// suffix, such as v2.
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' || elem[1] < '1' || elem[1] > '9' {
+		return false
+	}
+	n, err := strconv.Atoi(elem[1:])
+	return err == nil && n >= 2
+}
@@ -1,6 +1,9 @@
 package buildserver
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestLoadGopkgLock(t *testing.T) {
 	// example is the Gopkg.lock from the dep project itself.
@@ -234,3 +237,120 @@ func TestLoadGodeps(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadGoMod(t *testing.T) {
+	root := []byte(`module github.com/foo/bar
+
+go 1.14
+
+require (
+	github.com/pkg/errors v0.9.1
+	github.com/gorilla/mux v1.7.4 // indirect
+	golang.org/x/net v0.0.0-20200625001655-4c5254603344
+	"gopkg.in/yaml.v2" v2.3.0
+	github.com/go-redis/redis/v7 v7.2.0
+	github.com/docker/docker v1.13.1
+	github.com/foo/bar/sub v0.0.0
+	cloud.google.com/go v0.60.0
+	cloud.google.com/go/bigquery v1.9.0
+)
+
+require github.com/kr/pretty v0.2.0
+
+exclude github.com/docker/docker v1.13.1
+
+replace github.com/pkg/errors => github.com/fork/errors v0.9.2
+
+replace (
+	github.com/gorilla/mux v1.7.4 => github.com/fork/mux v1.7.5
+	github.com/gorilla/mux v1.7.3 => github.com/other/mux v1.7.3
+	github.com/foo/bar/sub => ./sub
+)
+`)
+	nested := []byte(`module github.com/foo/bar/sub
+
+require (
+	github.com/kr/pretty v0.1.0
+	github.com/kr/text v0.1.0
+)
+`)
+	cases := map[string]*pinnedPkg{
+		// Specified in go.mod
+		"github.com/pkg/errors":        {Pkg: "github.com/pkg/errors/", Module: "github.com/fork/errors", Version: "v0.9.2"},
+		"github.com/gorilla/mux":       {Pkg: "github.com/gorilla/mux/", Module: "github.com/fork/mux", Version: "v1.7.5"},
+		"golang.org/x/net/context":     {Pkg: "golang.org/x/net/", Module: "golang.org/x/net", Version: "v0.0.0-20200625001655-4c5254603344"},
+		"gopkg.in/yaml.v2":             {Pkg: "gopkg.in/yaml.v2/", Module: "gopkg.in/yaml.v2", Version: "v2.3.0"},
+		"github.com/go-redis/redis/v7": {Pkg: "github.com/go-redis/redis/v7/", Module: "github.com/go-redis/redis/v7", Version: "v7.2.0"},
+		"github.com/kr/pretty":         {Pkg: "github.com/kr/pretty/", Module: "github.com/kr/pretty", Version: "v0.2.0"},
+
+		// Nested modules
+		"cloud.google.com/go/bigquery":         {Pkg: "cloud.google.com/go/bigquery/", Module: "cloud.google.com/go/bigquery", Version: "v1.9.0"},
+		"cloud.google.com/go/bigquery/storage": {Pkg: "cloud.google.com/go/bigquery/", Module: "cloud.google.com/go/bigquery", Version: "v1.9.0"},
+		"cloud.google.com/go/civil":            {Pkg: "cloud.google.com/go/", Module: "cloud.google.com/go", Version: "v0.60.0"},
+		"cloud.google.com/go/bigqueryx":        {Pkg: "cloud.google.com/go/", Module: "cloud.google.com/go", Version: "v0.60.0"},
+
+		// Specified in the nested go.mod
+		"github.com/kr/text": {Pkg: "github.com/kr/text/", Module: "github.com/kr/text", Version: "v0.1.0"},
+
+		// Excluded, replaced by a directory or not specified
+		"github.com/docker/docker":  nil,
+		"github.com/foo/bar/sub":    nil,
+		"github.com/go-redis/redis": nil,
+		"golang.org/x/netx":         nil,
+		"fmt":                       nil,
+	}
+	p := loadGoMod(root, nested)
+	for pkg, want := range cases {
+		got := p.find(pkg)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("find(%v) = %+v, want %+v", pkg, got, want)
+		}
+	}
+}
+
+func TestModuleRev(t *testing.T) {
+	cases := []struct {
+		module, version, projectRoot string
+		want                         string
+	}{
+		{"github.com/pkg/errors", "v0.9.1", "github.com/pkg/errors", "v0.9.1"},
+		{"golang.org/x/net", "v0.0.0-20200625001655-4c5254603344", "golang.org/x/net", "4c5254603344"},
+		{"github.com/foo/bar", "v1.2.4-0.20191109021931-daa7c04131f5", "github.com/foo/bar", "daa7c04131f5"},
+		{"github.com/foo/bar", "v2.0.0-rc.1.0.20191109021931-daa7c04131f5+incompatible", "github.com/foo/bar", "daa7c04131f5"},
+		{"github.com/docker/docker", "v17.12.0-ce-rc1.0.20200309214505-aa6a9891b09c+incompatible", "github.com/docker/docker", "aa6a9891b09c"},
+		{"github.com/docker/docker", "v1.13.1+incompatible", "github.com/docker/docker", "v1.13.1"},
+		{"github.com/go-redis/redis/v7", "v7.2.0", "github.com/go-redis/redis", "v7.2.0"},
+		{"gopkg.in/yaml.v2", "v2.3.0", "gopkg.in/yaml.v2", "v2.3.0"},
+
+		// Modules in subdirectories of their repo.
+		{"github.com/foo/bar/sub", "v1.0.0", "github.com/foo/bar", "sub/v1.0.0"},
+		{"github.com/foo/bar/sub/v3", "v3.1.0", "github.com/foo/bar", "sub/v3.1.0"},
+	}
+	for _, c := range cases {
+		if got := moduleRev(c.module, c.version, c.projectRoot); got != c.want {
+			t.Errorf("moduleRev(%q, %q, %q) = %q, want %q", c.module, c.version, c.projectRoot, got, c.want)
+		}
+	}
+}
+
+func TestMajorVersionSuffix(t *testing.T) {
+	cases := []struct {
+		pkg, projectRoot string
+		want             string
+	}{
+		{"github.com/go-redis/redis/v7", "github.com/go-redis/redis", "v7"},
+		{"github.com/go-redis/redis/v7/internal", "github.com/go-redis/redis", "v7"},
+		{"github.com/go-redis/redis", "github.com/go-redis/redis", ""},
+		{"github.com/go-redis/redis/internal/v2", "github.com/go-redis/redis", ""},
+		{"github.com/foo/bar/v1", "github.com/foo/bar", ""},
+		{"github.com/foo/bar/v02", "github.com/foo/bar", ""},
+		{"github.com/foo/bar/vendor", "github.com/foo/bar", ""},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2", ""},
+		{"fmt", "", ""},
+	}
+	for _, c := range cases {
+		if got := majorVersionSuffix(c.pkg, c.projectRoot); got != c.want {
+			t.Errorf("majorVersionSuffix(%q, %q) = %q, want %q", c.pkg, c.projectRoot, got, c.want)
+		}
+	}
+}
@@ -77,10 +77,7 @@ func (s *Store) Open(ctx context.Context, key string, fetcher Fetcher) (file *Fi
 		return nil, errors.New("diskcache.Store.Dir must be set")
 	}
 
-	// path uses a sha256 hash of the key since we want to use it for the
-	// disk name.
-	h := sha256.Sum256([]byte(key))
-	path := filepath.Join(s.Dir, hex.EncodeToString(h[:])) + ".zip"
+	path := s.path(key)
 	span.LogKV("key", key, "path", path)
 
 	// First do a fast-path, assume already on disk
@@ -120,6 +117,28 @@ func (s *Store) Open(ctx context.Context, key string, fetcher Fetcher) (file *Fi
 	}
 }
 
+// path returns the path on disk of the item with key.
+func (s *Store) path(key string) string {
+	// path uses a sha256 hash of the key since we want to use it for the
+	// disk name.
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(s.Dir, hex.EncodeToString(h[:])) + ".zip"
+}
+
+// Remove removes the item with key from the cache, if it is cached. Like
+// eviction, it does not protect against open readers or concurrent
+// fetches.
+func (s *Store) Remove(key string) error {
+	if s.Dir == "" {
+		return errors.New("diskcache.Store.Dir must be set")
+	}
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 func doFetch(ctx context.Context, path string, fetcher Fetcher) (file *File, err error) {
 	// We have to grab the lock for this key, so we can fetch or wait for
 	// someone else to finish fetching.
@@ -60,4 +60,18 @@ func TestOpen(t *testing.T) {
 	if usedCache {
 		t.Fatal("Item was not properly evicted")
 	}
+
+	// Remove, then we should not use the cache
+	if err := store.Remove("key"); err != nil {
+		t.Fatal(err)
+	}
+	_, usedCache = do()
+	if usedCache {
+		t.Fatal("Item was not properly removed")
+	}
+
+	// Removing missing items is fine
+	if err := store.Remove("missing"); err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,154 @@
+package gosrc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sourcegraph/go-langserver/diskcache"
+)
+
+// diskCacheVersion is stored in every entry of a DiskCache. It must be
+// incremented whenever the format of the entries changes, so that entries
+// written by older versions are ignored.
+const diskCacheVersion = 1
+
+// DiskCache is a Resolver which caches the directories resolved by another
+// resolver on disk, so they are shared by all the build servers using the
+// same directory and survive restarts. Resolved directories are cached for
+// TTL, and errors for NegativeTTL, so that import paths which can't be
+// resolved aren't retried by every session either.
+type DiskCache struct {
+	// Resolver is the resolver the directories are resolved by.
+	Resolver Resolver
+
+	// Store is where the directories are cached.
+	Store *diskcache.Store
+
+	// TTL is how long resolved directories are cached for.
+	TTL time.Duration
+
+	// NegativeTTL is how long errors are cached for. If zero, errors
+	// aren't cached.
+	NegativeTTL time.Duration
+
+	now func() time.Time // for tests
+}
+
+// diskCacheEntry is the JSON encoding of a DiskCache entry.
+type diskCacheEntry struct {
+	Version    int
+	ImportPath string
+	Expires    time.Time
+
+	// Exactly one of Dir and Err is set.
+	Dir *Directory `json:",omitempty"`
+	Err string     `json:",omitempty"`
+}
+
+func (c *DiskCache) Resolve(importPath string) (*Directory, error) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+
+	var (
+		filled  bool
+		dir     *Directory
+		err     error
+		ctx     = context.Background()
+		fetcher = func(ctx context.Context) (io.ReadCloser, error) {
+			filled = true
+			dir, err = c.Resolver.Resolve(importPath)
+			entry := diskCacheEntry{Version: diskCacheVersion, ImportPath: importPath, Dir: dir}
+			if err != nil {
+				if c.NegativeTTL <= 0 {
+					return nil, err
+				}
+				entry.Err = err.Error()
+				entry.Expires = now().Add(c.NegativeTTL)
+			} else {
+				entry.Expires = now().Add(c.TTL)
+			}
+			b, err := json.Marshal(entry)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+	)
+
+	// Expired and invalid entries are removed and resolved again.
+	for i := 0; i < 2; i++ {
+		f, openErr := c.Store.Open(ctx, importPath, fetcher)
+		if filled {
+			diskCacheTotal.WithLabelValues("miss").Inc()
+			if f != nil {
+				f.Close()
+			}
+			// Even if we failed to cache the result, we can still
+			// use it.
+			return dir, err
+		}
+		if openErr != nil {
+			// Someone else was resolving concurrently, but they
+			// failed.
+			return c.Resolver.Resolve(importPath)
+		}
+
+		var entry diskCacheEntry
+		decodeErr := json.NewDecoder(f.File).Decode(&entry)
+		f.Close()
+		if decodeErr != nil || entry.Version != diskCacheVersion || entry.ImportPath != importPath || (entry.Dir == nil && entry.Err == "") {
+			diskCacheTotal.WithLabelValues("invalid").Inc()
+			_ = os.Remove(f.Path)
+			continue
+		}
+		if !now().Before(entry.Expires) {
+			diskCacheTotal.WithLabelValues("expired").Inc()
+			_ = os.Remove(f.Path)
+			continue
+		}
+
+		if entry.Err != "" {
+			diskCacheTotal.WithLabelValues("negative_hit").Inc()
+			if entry.Err == ErrNoMatch.Error() {
+				return nil, ErrNoMatch
+			}
+			return nil, errors.New(entry.Err)
+		}
+		diskCacheTotal.WithLabelValues("hit").Inc()
+		return entry.Dir, nil
+	}
+	return c.Resolver.Resolve(importPath)
+}
+
+// Purge removes the cached directories of the import paths, or of all
+// import paths if none are given.
+func (c *DiskCache) Purge(importPaths ...string) error {
+	if len(importPaths) == 0 {
+		_, err := c.Store.EvictMaxSize(0)
+		return err
+	}
+	for _, importPath := range importPaths {
+		if err := c.Store.Remove(importPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var diskCacheTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "golangserver_gosrc_disk_cache_request_total",
+	Help: "Count of import path resolutions by DiskCache.",
+}, []string{"type"})
+
+func init() {
+	prometheus.MustRegister(diskCacheTotal)
+}
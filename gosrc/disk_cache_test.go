@@ -0,0 +1,118 @@
+package gosrc
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/go-langserver/diskcache"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosrc-disk-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	resolved := map[string]int{}
+	c := &DiskCache{
+		Resolver: resolverFunc(func(importPath string) (*Directory, error) {
+			resolved[importPath]++
+			switch importPath {
+			case "example.com/pkg":
+				return &Directory{ImportPath: importPath, ProjectRoot: importPath, CloneURL: "https://example.com/pkg", VCS: "git"}, nil
+			case "example.com/nomatch":
+				return nil, ErrNoMatch
+			}
+			return nil, errors.New("failed")
+		}),
+		Store:       &diskcache.Store{Dir: dir},
+		TTL:         time.Hour,
+		NegativeTTL: time.Minute,
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	want := &Directory{ImportPath: "example.com/pkg", ProjectRoot: "example.com/pkg", CloneURL: "https://example.com/pkg", VCS: "git"}
+	resolve := func(importPath, wantErr string) {
+		t.Helper()
+		d, err := c.Resolve(importPath)
+		if wantErr != "" {
+			if err == nil || err.Error() != wantErr {
+				t.Fatalf("%s: got error %v, want %q", importPath, err, wantErr)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d, want) {
+			t.Fatalf("%s: got %+v, want %+v", importPath, d, want)
+		}
+		// Callers may modify the directory.
+		d.Rev = "v1"
+	}
+	wantResolved := func(importPath string, want int) {
+		t.Helper()
+		if resolved[importPath] != want {
+			t.Errorf("%s: resolved %d times, want %d", importPath, resolved[importPath], want)
+		}
+	}
+
+	// Resolved directories are cached until they expire, even by another
+	// DiskCache using the same directory.
+	resolve("example.com/pkg", "")
+	resolve("example.com/pkg", "")
+	c2 := *c
+	c2.Store = &diskcache.Store{Dir: dir}
+	if d, err := c2.Resolve("example.com/pkg"); err != nil || !reflect.DeepEqual(d, want) {
+		t.Errorf("got %+v, %v from another cache, want %+v", d, err, want)
+	}
+	wantResolved("example.com/pkg", 1)
+	now = now.Add(time.Hour)
+	resolve("example.com/pkg", "")
+	wantResolved("example.com/pkg", 2)
+
+	// Errors are cached for NegativeTTL.
+	resolve("example.com/fail", "failed")
+	now = now.Add(59 * time.Second)
+	resolve("example.com/fail", "failed")
+	wantResolved("example.com/fail", 1)
+	now = now.Add(time.Second)
+	resolve("example.com/fail", "failed")
+	wantResolved("example.com/fail", 2)
+
+	// ErrNoMatch is returned as is, so that chains fall through.
+	for i := 0; i < 2; i++ {
+		if _, err := c.Resolve("example.com/nomatch"); err != ErrNoMatch {
+			t.Errorf("got error %v, want ErrNoMatch", err)
+		}
+	}
+	wantResolved("example.com/nomatch", 1)
+
+	// Unless NegativeTTL is set, errors aren't cached.
+	c.NegativeTTL = 0
+	resolve("example.com/other", "failed")
+	resolve("example.com/other", "failed")
+	wantResolved("example.com/other", 2)
+
+	// Purged import paths are resolved again.
+	if err := c.Purge("example.com/pkg"); err != nil {
+		t.Fatal(err)
+	}
+	resolve("example.com/pkg", "")
+	resolve("example.com/fail", "failed")
+	wantResolved("example.com/pkg", 3)
+	wantResolved("example.com/fail", 2)
+	if err := c.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	resolve("example.com/pkg", "")
+	resolve("example.com/fail", "failed")
+	wantResolved("example.com/pkg", 4)
+	wantResolved("example.com/fail", 3)
+}
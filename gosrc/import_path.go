@@ -49,29 +49,57 @@ type Directory struct {
 	Rev         string // the VCS revision specifier, if any
 }
 
-var errNoMatch = errors.New("no match")
+// ErrNoMatch is returned by a Resolver which doesn't resolve an import
+// path, so that the next resolver of a Chain is tried.
+var ErrNoMatch = errors.New("no match")
 
+// ResolveImportPath resolves importPath with DefaultResolver(client).
 func ResolveImportPath(client *http.Client, importPath string) (*Directory, error) {
-	if d, err := resolveStaticImportPath(importPath); err == nil {
-		return d, nil
-	} else if err != nil && err != errNoMatch {
-		return nil, err
-	}
-	return resolveDynamicImportPath(client, importPath)
+	return DefaultResolver(client).Resolve(importPath)
 }
 
 func resolveStaticImportPath(importPath string) (*Directory, error) {
-	if IsStdlibPkg(importPath) {
-		return &Directory{
-			ImportPath:  importPath,
-			ProjectRoot: "",
-			CloneURL:    "https://github.com/golang/go",
-			RepoPrefix:  "src",
-			VCS:         "git",
-			Rev:         RuntimeVersion,
-		}, nil
+	return Chain{StdlibResolver{}, StaticResolverFromEnv()}.Resolve(importPath)
+}
+
+// StdlibResolver resolves the import paths of the standard library to the
+// golang/go repo at RuntimeVersion.
+type StdlibResolver struct{}
+
+func (StdlibResolver) Resolve(importPath string) (*Directory, error) {
+	if !IsStdlibPkg(importPath) {
+		return nil, ErrNoMatch
 	}
+	return &Directory{
+		ImportPath:  importPath,
+		ProjectRoot: "",
+		CloneURL:    "https://github.com/golang/go",
+		RepoPrefix:  "src",
+		VCS:         "git",
+		Rev:         RuntimeVersion,
+	}, nil
+}
+
+// StaticResolver resolves the import paths of well-known hosts, such as
+// github.com, without fetching them.
+type StaticResolver struct {
+	// NoGoGetDomains are the import path prefixes of non-go-gettable
+	// repos, whose project roots are guessed from the import paths.
+	NoGoGetDomains []string
+
+	// BlacklistGoGet are the import path prefixes which are never
+	// resolved.
+	BlacklistGoGet []string
+}
+
+// StaticResolverFromEnv returns the StaticResolver configured by the JSON
+// arrays of the NO_GO_GET_DOMAINS and BLACKLIST_GO_GET environment
+// variables.
+func StaticResolverFromEnv() StaticResolver {
+	return StaticResolver{NoGoGetDomains: noGoGetDomains, BlacklistGoGet: blacklistGoGet}
+}
 
+func (r StaticResolver) Resolve(importPath string) (*Directory, error) {
 	// This allows users to set a list of domains that we should NEVER perform
 	// go get or git clone against. This is useful when e.g. a user has not
 	// correctly configured a monorepo and we are constantly hitting their
@@ -80,7 +108,7 @@ func resolveStaticImportPath(importPath string) (*Directory, error) {
 	// broken until they do correctly configure their monorepo (so we can
 	// identify its GOPATH), but it gives them a quick escape hatch that is
 	// better than "turn off the Sourcegraph server".
-	for _, domain := range blacklistGoGet {
+	for _, domain := range r.BlacklistGoGet {
 		if strings.HasPrefix(importPath, domain) {
 			return nil, errors.New("import path in blacklistGoGet configuration")
 		}
@@ -94,7 +122,7 @@ func resolveStaticImportPath(importPath string) (*Directory, error) {
 	// non-go-gettable, i.e. standard git repositories. Some on-prem customers
 	// use setups like this, where they directly import non-go-gettable git
 	// repository URLs like "mygitolite.aws.me.org/mux.git/subpkg"
-	for _, domain := range noGoGetDomains {
+	for _, domain := range r.NoGoGetDomains {
 		if !strings.HasPrefix(importPath, domain) {
 			continue
 		}
@@ -119,7 +147,7 @@ func resolveStaticImportPath(importPath string) (*Directory, error) {
 		}, nil
 
 	case strings.HasPrefix(importPath, "golang.org/x/"):
-		d, err := resolveStaticImportPath(strings.Replace(importPath, "golang.org/x/", "github.com/golang/", 1))
+		d, err := r.Resolve(strings.Replace(importPath, "golang.org/x/", "github.com/golang/", 1))
 		if err != nil {
 			return nil, err
 		}
@@ -137,14 +165,14 @@ func resolveStaticImportPath(importPath string) (*Directory, error) {
 	// This is the same as the previous case, except with the `.` replaced with an
 	// `_`.
 	case strings.HasPrefix(importPath, "golang_org/x/"):
-		d, err := resolveStaticImportPath(strings.Replace(importPath, "golang_org/x/", "github.com/golang/", 1))
+		d, err := r.Resolve(strings.Replace(importPath, "golang_org/x/", "github.com/golang/", 1))
 		if err != nil {
 			return nil, err
 		}
 		d.ProjectRoot = strings.Replace(d.ProjectRoot, "github.com/golang/", "golang_org/x/", 1)
 		return d, nil
 	}
-	return nil, errNoMatch
+	return nil, ErrNoMatch
 }
 
 // guessImportPath is used by noGoGetDomains since we can't do the usual
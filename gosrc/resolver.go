@@ -0,0 +1,191 @@
+package gosrc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// A Resolver resolves import paths to the directories of their packages.
+type Resolver interface {
+	// Resolve returns the directory of the package at importPath. It
+	// returns ErrNoMatch if it doesn't resolve importPath.
+	Resolve(importPath string) (*Directory, error)
+}
+
+// DefaultResolver returns the resolver used by ResolveImportPath. It
+// resolves the standard library and well-known hosts statically, and other
+// import paths from their go-import meta tags fetched with client.
+func DefaultResolver(client *http.Client) Chain {
+	return Chain{
+		StdlibResolver{},
+		StaticResolverFromEnv(),
+		MetaResolver{Client: client},
+	}
+}
+
+// Chain is a Resolver which tries its resolvers in order, until one of them
+// doesn't return ErrNoMatch.
+type Chain []Resolver
+
+func (c Chain) Resolve(importPath string) (*Directory, error) {
+	for _, r := range c {
+		d, err := r.Resolve(importPath)
+		if err != ErrNoMatch {
+			return d, err
+		}
+	}
+	return nil, ErrNoMatch
+}
+
+// MetaResolver resolves import paths from their go-import and go-source
+// meta tags, like the go get command.
+//
+// See https://golang.org/cmd/go/#hdr-Remote_import_paths.
+type MetaResolver struct {
+	// Client is the client the meta tags are fetched with. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (r MetaResolver) Resolve(importPath string) (*Directory, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return resolveDynamicImportPath(client, importPath)
+}
+
+// RewriteRule resolves the import paths matching a regular expression to
+// repos without fetching them, e.g. for vanity import paths which map to a
+// Git server deterministically.
+type RewriteRule struct {
+	// Match is the regular expression import paths are matched against.
+	// It's anchored at the start of the import path, and what it matches,
+	// which must end at a path element boundary, is the project root.
+	Match string `json:"match"`
+
+	// CloneURL is the template of the clone URL of the repo, in which
+	// $1 or ${name} is replaced by the submatches of Match, like
+	// regexp.Regexp.Expand.
+	CloneURL string `json:"cloneURL"`
+
+	// VCS is the version control system of the repo. It defaults to
+	// "git".
+	VCS string `json:"vcs,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// RewriteRules is a Resolver which resolves import paths with the first
+// rule matching them.
+type RewriteRules []*RewriteRule
+
+// ParseRewriteRules parses a JSON array of rewrite rules, such as:
+//
+//	[{"match": "go\\.example\\.com/([^/]+)", "cloneURL": "https://git.example.com/go/$1.git"}]
+func ParseRewriteRules(data []byte) (RewriteRules, error) {
+	var rules RewriteRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		re, err := rule.compile()
+		if err != nil {
+			return nil, err
+		}
+		rule.re = re
+	}
+	return rules, nil
+}
+
+func (rule *RewriteRule) compile() (*regexp.Regexp, error) {
+	if rule.CloneURL == "" {
+		return nil, fmt.Errorf("import path rewrite rule %q has no clone URL", rule.Match)
+	}
+	re, err := regexp.Compile("^(?:" + rule.Match + ")")
+	if err != nil {
+		return nil, fmt.Errorf("invalid import path rewrite rule: %s", err)
+	}
+	return re, nil
+}
+
+func (rules RewriteRules) Resolve(importPath string) (*Directory, error) {
+	for _, rule := range rules {
+		re := rule.re
+		if re == nil {
+			// The rule wasn't parsed by ParseRewriteRules.
+			var err error
+			if re, err = rule.compile(); err != nil {
+				return nil, err
+			}
+		}
+		m := re.FindStringSubmatchIndex(importPath)
+		if m == nil || m[1] == 0 || (m[1] < len(importPath) && importPath[m[1]] != '/') {
+			continue
+		}
+		vcs := rule.VCS
+		if vcs == "" {
+			vcs = "git"
+		}
+		return &Directory{
+			ImportPath:  importPath,
+			ProjectRoot: importPath[:m[1]],
+			CloneURL:    string(re.ExpandString(nil, rule.CloneURL, importPath, m)),
+			VCS:         vcs,
+		}, nil
+	}
+	return nil, ErrNoMatch
+}
+
+// CachingResolver is a Resolver which caches the directories resolved by
+// another resolver for a while. Errors aren't cached.
+type CachingResolver struct {
+	// Resolver is the resolver the directories are resolved by.
+	Resolver Resolver
+
+	// TTL is how long resolved directories are cached for.
+	TTL time.Duration
+
+	now func() time.Time // for tests
+
+	mu    sync.Mutex
+	cache map[string]cachedDirectory
+}
+
+type cachedDirectory struct {
+	dir     *Directory
+	expires time.Time
+}
+
+func (r *CachingResolver) Resolve(importPath string) (*Directory, error) {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+
+	r.mu.Lock()
+	c, ok := r.cache[importPath]
+	r.mu.Unlock()
+	if ok && now().Before(c.expires) {
+		// Copy, since callers may modify the directory.
+		d := *c.dir
+		return &d, nil
+	}
+
+	d, err := r.Resolver.Resolve(importPath)
+	if err != nil {
+		return nil, err
+	}
+	cached := *d
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = make(map[string]cachedDirectory)
+	}
+	r.cache[importPath] = cachedDirectory{dir: &cached, expires: now().Add(r.TTL)}
+	r.mu.Unlock()
+	return d, nil
+}
@@ -0,0 +1,176 @@
+package gosrc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewriteRules(t *testing.T) {
+	rules, err := ParseRewriteRules([]byte(`[
+		{"match": "go\\.acme\\.com/(?P<repo>[^/]+)", "cloneURL": "https://git.acme.com/go/${repo}.git"},
+		{"match": "hg\\.acme\\.com/([^/]+)/([^/]+)", "cloneURL": "https://hg.acme.com/$1/$2", "vcs": "hg"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		importPath string
+		dir        *Directory
+	}{
+		{"go.acme.com/log", &Directory{
+			ImportPath:  "go.acme.com/log",
+			ProjectRoot: "go.acme.com/log",
+			CloneURL:    "https://git.acme.com/go/log.git",
+			VCS:         "git",
+		}},
+		{"go.acme.com/log/internal/buf", &Directory{
+			ImportPath:  "go.acme.com/log/internal/buf",
+			ProjectRoot: "go.acme.com/log",
+			CloneURL:    "https://git.acme.com/go/log.git",
+			VCS:         "git",
+		}},
+		{"hg.acme.com/team/repo/pkg", &Directory{
+			ImportPath:  "hg.acme.com/team/repo/pkg",
+			ProjectRoot: "hg.acme.com/team/repo",
+			CloneURL:    "https://hg.acme.com/team/repo",
+			VCS:         "hg",
+		}},
+		{"go.acme.com", nil},
+		{"hg.acme.com/team", nil},
+		{"mirror.go.acme.com/log", nil},
+	}
+	for _, tt := range tests {
+		dir, err := rules.Resolve(tt.importPath)
+		if tt.dir == nil {
+			if err != ErrNoMatch {
+				t.Errorf("Resolve(%q) = %+v, %v, want ErrNoMatch", tt.importPath, dir, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q) returned unexpected error: %v", tt.importPath, err)
+			continue
+		}
+		if !reflect.DeepEqual(dir, tt.dir) {
+			t.Errorf("Resolve(%q) =\n     %+v,\nwant %+v", tt.importPath, dir, tt.dir)
+		}
+	}
+
+	for _, data := range []string{
+		`[{"match": "go.acme.com/("}]`,
+		`[{"match": "go.acme.com/([^/]+)", "cloneURL": ""}]`,
+		`{}`,
+	} {
+		if _, err := ParseRewriteRules([]byte(data)); err == nil {
+			t.Errorf("ParseRewriteRules(%s) did not return expected error", data)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	rules := RewriteRules{{Match: `example\.com/[^/]+`, CloneURL: "https://git.example.com/x"}}
+	failing := resolverFunc(func(importPath string) (*Directory, error) {
+		return nil, errors.New("failed")
+	})
+
+	// Resolvers are tried in order, until one doesn't return ErrNoMatch.
+	for _, tt := range []struct {
+		chain      Chain
+		importPath string
+		want       string
+	}{
+		{Chain{StdlibResolver{}, rules, failing}, "fmt", "https://github.com/golang/go"},
+		{Chain{StdlibResolver{}, rules, failing}, "example.com/x/y", "https://git.example.com/x"},
+		{Chain{StdlibResolver{}, rules, failing}, "other.com/x", "failed"},
+		{Chain{StdlibResolver{}, rules}, "other.com/x", ErrNoMatch.Error()},
+		{Chain{StaticResolver{BlacklistGoGet: []string{"example.com"}}, rules}, "example.com/x", "import path in blacklistGoGet configuration"},
+	} {
+		var got string
+		if d, err := tt.chain.Resolve(tt.importPath); err != nil {
+			got = err.Error()
+		} else {
+			got = d.CloneURL
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.importPath, got, tt.want)
+		}
+	}
+}
+
+func TestMetaResolver(t *testing.T) {
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		if r.URL.Query().Get("go-get") != "1" || !strings.HasPrefix(r.URL.Path, "/pkg") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<head><meta name="go-import" content="%s/pkg git https://git.example.com/pkg"></head>`, r.Host)
+	}))
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	r := &CachingResolver{Resolver: MetaResolver{Client: s.Client()}, TTL: time.Minute}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	want := &Directory{
+		ImportPath:  host + "/pkg/sub",
+		ProjectRoot: host + "/pkg",
+		CloneURL:    "http://git.example.com/pkg.git",
+		VCS:         "git",
+	}
+	resolve := func() {
+		t.Helper()
+		d, err := r.Resolve(host + "/pkg/sub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d, want) {
+			t.Fatalf("got %+v, want %+v", d, want)
+		}
+		// Callers may modify the directory.
+		d.Rev = "v1"
+	}
+
+	// The package and the project root are fetched over http, after
+	// https fails.
+	resolve()
+	if got, want := requests, []string{"/pkg/sub?go-get=1", "/pkg?go-get=1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+
+	// Until they expire, resolved directories are cached.
+	resolve()
+	now = now.Add(59 * time.Second)
+	resolve()
+	if len(requests) != 2 {
+		t.Errorf("got %d requests, want 2", len(requests))
+	}
+	now = now.Add(time.Second)
+	resolve()
+	if len(requests) != 4 {
+		t.Errorf("got %d requests, want 4", len(requests))
+	}
+
+	// Errors aren't cached.
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(host + "/other"); err == nil {
+			t.Error("got no error resolving a package without meta tags")
+		}
+	}
+	if len(requests) != 6 {
+		t.Errorf("got %d requests, want 6", len(requests))
+	}
+}
+
+type resolverFunc func(importPath string) (*Directory, error)
+
+func (f resolverFunc) Resolve(importPath string) (*Directory, error) { return f(importPath) }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sourcegraph/go-langserver/langserver"
+)
+
+// runIndex runs the index subcommand, which writes a code intelligence
+// index of the packages matching the patterns in args, eg
+//
+//	go-langserver index -format scip ./...
+func runIndex(cfg langserver.Config, args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	format := fs.String("format", langserver.IndexFormatLSIF, "index format (lsif|scip)")
+	out := fs.String("o", "", "file to write the index to (defaults to dump.lsif or index.scip)")
+	root := fs.String("root", ".", "root directory of the workspace, which the package patterns are relative to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: go-langserver index [flags] [packages]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	rootPath, err := filepath.Abs(*root)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = "dump.lsif"
+		if *format == langserver.IndexFormatSCIP {
+			*out = "index.scip"
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = langserver.WriteIndex(context.Background(), cfg, filepath.ToSlash(rootPath), f, langserver.IndexOptions{
+		Format:        *format,
+		Patterns:      fs.Args(),
+		ToolVersion:   version,
+		ToolArguments: os.Args[1:],
+	})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+	}
+	return err
+}
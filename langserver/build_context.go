@@ -14,7 +14,8 @@ import (
 	"github.com/sourcegraph/go-langserver/langserver/util"
 )
 
-// BuildContext creates a build.Context which uses the overlay FS and the InitializeParams.BuildContext overrides.
+// BuildContext creates a build.Context which uses the overlay FS, the InitializeParams.BuildContext overrides
+// and the active BuildConfiguration.
 func (h *LangHandler) BuildContext(ctx context.Context) *build.Context {
 	var bctx *build.Context
 	if override := h.init.BuildContext; override != nil {
@@ -38,6 +39,11 @@ func (h *LangHandler) BuildContext(ctx context.Context) *build.Context {
 		bctx = &copy
 	}
 
+	h.mu.Lock()
+	buildConfig := h.buildConfig
+	h.mu.Unlock()
+	buildConfig.apply(bctx)
+
 	h.Mu.Lock()
 	fs := h.FS
 	h.Mu.Unlock()
@@ -46,6 +52,30 @@ func (h *LangHandler) BuildContext(ctx context.Context) *build.Context {
 	return bctx
 }
 
+// apply sets the fields of bctx which are selected by c.
+func (c BuildConfiguration) apply(bctx *build.Context) {
+	crossCompiling := false
+	if c.GOOS != "" && c.GOOS != bctx.GOOS {
+		bctx.GOOS = c.GOOS
+		crossCompiling = true
+	}
+	if c.GOARCH != "" && c.GOARCH != bctx.GOARCH {
+		bctx.GOARCH = c.GOARCH
+		crossCompiling = true
+	}
+	if c.CgoEnabled != nil {
+		bctx.CgoEnabled = *c.CgoEnabled
+	} else if crossCompiling {
+		// The go tool disables cgo by default when cross-compiling.
+		bctx.CgoEnabled = false
+	}
+	if len(c.BuildTags) > 0 {
+		// Copy, since bctx.BuildTags may be shared with the
+		// InitializeParams.
+		bctx.BuildTags = append(append([]string(nil), bctx.BuildTags...), c.BuildTags...)
+	}
+}
+
 // ContainingPackage returns the package that contains the given
 // filename. It is like buildutil.ContainingPackage, except that:
 //
@@ -1,8 +1,14 @@
 package langserver
 
 import (
+	"context"
+	"encoding/json"
+	"go/build"
+	"reflect"
 	"testing"
 
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-lsp"
 	"golang.org/x/tools/go/buildutil"
 )
 
@@ -53,3 +59,107 @@ func TestContainingPackageOutGOPATH(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildConfigurationApply(t *testing.T) {
+	yes := true
+	base := build.Context{GOOS: "linux", GOARCH: "amd64", CgoEnabled: true, BuildTags: []string{"base"}}
+	tests := map[string]struct {
+		c    BuildConfiguration
+		want build.Context
+	}{
+		"empty": {
+			want: base,
+		},
+		"tags": {
+			c:    BuildConfiguration{BuildTags: []string{"integration"}},
+			want: build.Context{GOOS: "linux", GOARCH: "amd64", CgoEnabled: true, BuildTags: []string{"base", "integration"}},
+		},
+		"same GOOS keeps cgo": {
+			c:    BuildConfiguration{GOOS: "linux"},
+			want: base,
+		},
+		"cross-compiling disables cgo": {
+			c:    BuildConfiguration{GOOS: "windows", GOARCH: "arm64"},
+			want: build.Context{GOOS: "windows", GOARCH: "arm64", CgoEnabled: false, BuildTags: []string{"base"}},
+		},
+		"cross-compiling with cgo": {
+			c:    BuildConfiguration{GOARCH: "arm64", CgoEnabled: &yes},
+			want: build.Context{GOOS: "linux", GOARCH: "arm64", CgoEnabled: true, BuildTags: []string{"base"}},
+		},
+	}
+	for label, test := range tests {
+		bctx := base
+		test.c.apply(&bctx)
+		if !reflect.DeepEqual(bctx, test.want) {
+			t.Errorf("%s: got %+v, want %+v", label, bctx, test.want)
+		}
+	}
+	if !reflect.DeepEqual(base.BuildTags, []string{"base"}) {
+		t.Errorf("apply modified the build tags of the original context: %v", base.BuildTags)
+	}
+}
+
+func TestSetBuildConfiguration(t *testing.T) {
+	fs := NewAtomicFS()
+	fs.Bind("/src/p", ctxvfs.Map(map[string][]byte{
+		"a.go":             []byte("package p"),
+		"b_integration.go": []byte("//go:build integration\n// +build integration\n\npackage p"),
+		"c_windows.go":     []byte("package p"),
+	}), "/", ctxvfs.BindReplace)
+	cfg := NewDefaultConfig()
+	cfg.BuildTags = []string{"integration"}
+	h := &LangHandler{
+		HandlerShared: &HandlerShared{FS: fs},
+		init:          &InitializeParams{BuildContext: &InitializeBuildContextParams{GOOS: "linux", GOARCH: "amd64", GOPATH: "/", Compiler: "gc"}},
+		config:        &cfg,
+		buildConfig:   cfg.buildConfiguration(),
+	}
+	h.resetCaches(false)
+
+	goFiles := func() []string {
+		bctx := h.BuildContext(context.Background())
+		bpkg, err := bctx.ImportDir("/src/p", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bpkg.GoFiles
+	}
+	execute := func(args ...interface{}) BuildConfiguration {
+		res, err := h.handleExecuteCommand(context.Background(), nil, nil, lsp.ExecuteCommandParams{
+			Command:   commandSetBuildConfiguration,
+			Arguments: args,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.(BuildConfiguration)
+	}
+
+	if got, want := goFiles(), []string{"a.go", "b_integration.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v with the initial configuration, want %v", got, want)
+	}
+
+	// Arguments are decoded from JSON, so pass the configuration as such.
+	var arg interface{}
+	if err := json.Unmarshal([]byte(`{"goos":"windows"}`), &arg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := execute(arg), (BuildConfiguration{GOOS: "windows"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got build configuration %+v, want %+v", got, want)
+	}
+	if got, want := goFiles(), []string{"a.go", "c_windows.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v after switching to windows, want %v", got, want)
+	}
+
+	// Without an argument we go back to the configuration from the Config.
+	if got, want := execute(), cfg.buildConfiguration(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got build configuration %+v after reset, want %+v", got, want)
+	}
+	if got, want := goFiles(), []string{"a.go", "b_integration.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v after reset, want %v", got, want)
+	}
+
+	if _, err := h.handleExecuteCommand(context.Background(), nil, nil, lsp.ExecuteCommandParams{Command: "go.unknown"}); err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+}
@@ -50,6 +50,7 @@ func init() {
 
 type cache interface {
 	Get(key interface{}, fill func() interface{}) interface{}
+	Remove(key interface{})
 	Purge()
 }
 
@@ -116,6 +117,15 @@ func (c *boundedCache) Get(k interface{}, fill func() interface{}) interface{} {
 	return v.value
 }
 
+// Remove removes k from the cache. Callers waiting on a fill of k still
+// receive its value, but later calls to Get fill again.
+func (c *boundedCache) Remove(k interface{}) {
+	c.mu.Lock()
+	c.c.Remove(cacheKey{c.id, k})
+	c.size.Set(float64(c.c.Len()))
+	c.mu.Unlock()
+}
+
 func (c *boundedCache) Purge() {
 	// c.c is a process level cache. We could increment c.id to make it seem
 	// like we've purged the cache, but that would leave the objects in memory
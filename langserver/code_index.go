@@ -0,0 +1,400 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+
+	"github.com/sourcegraph/go-langserver/langserver/internal/refs"
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-langserver/pkg/tools"
+	"github.com/sourcegraph/go-lsp"
+)
+
+// The formats of the code intelligence indexes written by WriteIndex.
+const (
+	// IndexFormatLSIF is the Language Server Index Format, as JSON lines.
+	IndexFormatLSIF = "lsif"
+
+	// IndexFormatSCIP is the protobuf encoding of a SCIP index.
+	IndexFormatSCIP = "scip"
+)
+
+// IndexOptions configures WriteIndex.
+type IndexOptions struct {
+	// Format is IndexFormatLSIF or IndexFormatSCIP.
+	Format string
+
+	// Patterns select the packages to index, eg "./..." for every package
+	// under the root or "./cmd/foo" for a single one. They are relative
+	// to the root. Vendored packages are never indexed.
+	Patterns []string
+
+	// ToolVersion and ToolArguments are recorded in the metadata of the
+	// index.
+	ToolVersion   string
+	ToolArguments []string
+}
+
+// WriteIndex type checks the Go packages under rootPath and writes a precise
+// code intelligence index of them to w. The index has the definitions,
+// references and hovers of the symbols the packages declare or use, and
+// monikers which link the symbols to the indexes of other repositories.
+func WriteIndex(ctx context.Context, cfg Config, rootPath string, w io.Writer, opts IndexOptions) error {
+	// There is no client to publish diagnostics to.
+	cfg.DiagnosticsEnabled = false
+
+	h := &LangHandler{DefaultConfig: cfg, HandlerShared: &HandlerShared{}}
+	if err := h.reset(&InitializeParams{InitializeParams: lsp.InitializeParams{RootURI: util.PathToURI(rootPath)}}); err != nil {
+		return err
+	}
+	return h.writeIndex(ctx, w, opts)
+}
+
+func (h *LangHandler) writeIndex(ctx context.Context, w io.Writer, opts IndexOptions) error {
+	if opts.Format != IndexFormatLSIF && opts.Format != IndexFormatSCIP {
+		return fmt.Errorf("unsupported index format %q (must be %s or %s)", opts.Format, IndexFormatLSIF, IndexFormatSCIP)
+	}
+	ix, err := h.buildCodeIndex(ctx, opts.Patterns)
+	if err != nil {
+		return err
+	}
+	if opts.Format == IndexFormatSCIP {
+		return ix.writeSCIP(w, opts)
+	}
+	return ix.writeLSIF(w, opts)
+}
+
+// codeIndex is a code intelligence index of the packages of a workspace. It
+// is independent of the format it is written in.
+type codeIndex struct {
+	rootPath  string
+	documents []*indexDocument // sorted by path
+	symbols   []*indexSymbol   // in the order they were first seen
+}
+
+// indexDocument is a Go file of the workspace.
+type indexDocument struct {
+	path        string             // absolute
+	occurrences []*indexOccurrence // sorted by range
+}
+
+// indexOccurrence is where a document defines or references a symbol.
+type indexOccurrence struct {
+	rng        lsp.Range
+	symbol     *indexSymbol
+	definition bool
+}
+
+// indexSymbol is a package, or an object declared by a package.
+type indexSymbol struct {
+	id int // the index in codeIndex.symbols
+
+	// obj is nil for packages, in which case pkg is the package.
+	obj types.Object
+	pkg *types.Package
+
+	// desc identifies symbols across repositories, like the symbol of
+	// textDocument/xdefinition. It is nil for the symbols which can only
+	// be referenced from the package declaring them, eg local variables.
+	desc *symbolDescriptor
+
+	// defined is true if the symbol is defined in the index.
+	defined bool
+
+	// hover is the Markdown hover of the symbol.
+	hover string
+
+	// hoverPkg and hoverIdent are the identifier the hover is computed
+	// for, which is preferably the definition of the symbol.
+	hoverPkg   *loader.PackageInfo
+	hoverIdent *ast.Ident
+	hoverIsDef bool
+}
+
+// exported reports whether the symbol can be referenced by other packages.
+func (s *indexSymbol) exported() bool {
+	if s.desc == nil || s.pkg.Name() == "main" {
+		return false
+	}
+	if s.obj == nil {
+		return true
+	}
+	return ast.IsExported(s.desc.Name) && (s.desc.Recv == "" || ast.IsExported(s.desc.Recv))
+}
+
+// monikerKind returns "export" if the symbol is defined in the index and
+// other repositories can reference it, "import" if it is defined in
+// another repository, or "" if it can't be referenced across repositories.
+func (s *indexSymbol) monikerKind() string {
+	switch {
+	case s.desc == nil:
+		return ""
+	case !s.defined:
+		return "import"
+	case s.exported():
+		return "export"
+	}
+	return ""
+}
+
+// buildCodeIndex type checks the packages of the workspace matching patterns
+// and indexes their files.
+func (h *LangHandler) buildCodeIndex(ctx context.Context, patterns []string) (*codeIndex, error) {
+	rootPath := h.RootFSPath
+	bctx := h.BuildContext(ctx)
+	findPackage := h.getFindPackageFunc()
+
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	match, err := indexPatternMatcher(rootPath, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []string
+	for _, pkg := range tools.ListPkgsUnderDir(bctx, rootPath) {
+		bpkg, err := findPackage(ctx, bctx, pkg, rootPath, rootPath, build.FindOnly)
+		if err != nil && !isMultiplePackageError(err) {
+			continue
+		}
+		if util.IsVendorDir(bpkg.Dir) || !match(bpkg.Dir) {
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no Go packages match %s under %s", strings.Join(patterns, " "), rootPath)
+	}
+
+	fset := token.NewFileSet()
+	prog, err := h.workspaceRefsTypecheck(ctx, bctx, nil, fset, pkgs, func(*loader.PackageInfo, []*ast.File) {})
+	if err != nil {
+		return nil, err
+	}
+
+	ix := &codeIndex{rootPath: rootPath}
+	symbols := map[interface{}]*indexSymbol{} // by object or package path
+	symbol := func(obj types.Object, pkg *types.Package) *indexSymbol {
+		var key interface{} = obj
+		if obj == nil {
+			key = pkg.Path()
+		}
+		if s, ok := symbols[key]; ok {
+			return s
+		}
+		s := &indexSymbol{id: len(ix.symbols), obj: obj, pkg: pkg}
+		def := &refs.Def{ImportPath: pkg.Path(), PackageName: pkg.Name()}
+		if obj != nil {
+			def = objectDef(obj)
+		}
+		if def != nil {
+			s.desc, _ = defSymbolDescriptor(ctx, bctx, rootPath, *def, findPackage)
+		}
+		symbols[key] = s
+		ix.symbols = append(ix.symbols, s)
+		return s
+	}
+
+	for _, info := range prog.InitialPackages() {
+		for _, f := range info.Files {
+			filename := fset.Position(f.Pos()).Filename
+			// Skip the files generated by cgo.
+			if !util.PathHasPrefix(filename, rootPath) {
+				continue
+			}
+			doc := &indexDocument{path: filename}
+			ix.documents = append(ix.documents, doc)
+			src, _ := readFile(bctx, filename, nil)
+			lines := bytes.Split(src, []byte("\n"))
+
+			add := func(node ast.Node, s *indexSymbol, definition bool) {
+				doc.occurrences = append(doc.occurrences, &indexOccurrence{
+					rng:        utf16Range(lines, rangeForNode(fset, node)),
+					symbol:     s,
+					definition: definition,
+				})
+				if definition {
+					s.defined = true
+				}
+				if ident, ok := node.(*ast.Ident); ok && (s.hoverIdent == nil || definition && !s.hoverIsDef) {
+					s.hoverPkg, s.hoverIdent, s.hoverIsDef = info, ident, definition
+				}
+			}
+
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.ImportSpec:
+					obj := info.Implicits[n]
+					if n.Name != nil && info.Defs[n.Name] != nil {
+						obj = info.Defs[n.Name]
+					}
+					if pkgName, ok := obj.(*types.PkgName); ok {
+						add(n.Path, symbol(nil, pkgName.Imported()), false)
+					}
+
+				case *ast.Ident:
+					if n == f.Name {
+						add(n, symbol(nil, info.Pkg), true)
+						return false
+					}
+					obj, definition := info.Defs[n], true
+					if obj == nil {
+						obj, definition = info.Uses[n], false
+					}
+					if obj == nil {
+						return false
+					}
+					if pkgName, ok := obj.(*types.PkgName); ok {
+						add(n, symbol(nil, pkgName.Imported()), false)
+						return false
+					}
+					// Skip builtins.
+					if obj.Pkg() == nil || !obj.Pos().IsValid() {
+						return false
+					}
+					add(n, symbol(originObject(obj), obj.Pkg()), definition)
+				}
+				return true
+			})
+
+			sort.SliceStable(doc.occurrences, func(i, j int) bool {
+				a, b := doc.occurrences[i].rng.Start, doc.occurrences[j].rng.Start
+				return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+			})
+		}
+	}
+	sort.Slice(ix.documents, func(i, j int) bool { return ix.documents[i].path < ix.documents[j].path })
+
+	for _, s := range ix.symbols {
+		if s.hoverIdent == nil {
+			continue
+		}
+		doc, err := h.identHoverDoc(ctx, fset, prog, s.hoverPkg, s.hoverIdent)
+		if err == nil && doc != nil {
+			s.hover = doc.markdown(h.config.DocumentationURLTemplate)
+		}
+		s.hoverPkg, s.hoverIdent = nil, nil
+	}
+	return ix, nil
+}
+
+// utf16Range converts rng, whose characters are byte offsets in lines, to
+// the UTF-16 code units LSIF and SCIP positions are in.
+func utf16Range(lines [][]byte, rng lsp.Range) lsp.Range {
+	return lsp.Range{Start: utf16Position(lines, rng.Start), End: utf16Position(lines, rng.End)}
+}
+
+func utf16Position(lines [][]byte, pos lsp.Position) lsp.Position {
+	if pos.Line >= len(lines) || pos.Character > len(lines[pos.Line]) {
+		return pos
+	}
+	n := 0
+	for _, r := range string(lines[pos.Line][:pos.Character]) {
+		if r >= 0x10000 {
+			n += 2 // a surrogate pair
+		} else {
+			n++
+		}
+	}
+	pos.Character = n
+	return pos
+}
+
+// relativePath returns the path of doc relative to the root of the index.
+func (ix *codeIndex) relativePath(doc *indexDocument) string {
+	return strings.TrimPrefix(util.PathTrimPrefix(doc.path, ix.rootPath), "/")
+}
+
+// objectDef returns the definition of obj in the form of the definitions of
+// textDocument/xdefinition, or nil if obj can only be referenced from the
+// package declaring it.
+func objectDef(obj types.Object) *refs.Def {
+	pkg := obj.Pkg()
+	def := &refs.Def{ImportPath: pkg.Path(), PackageName: pkg.Name()}
+	if obj.Parent() == pkg.Scope() {
+		def.Path = obj.Name()
+		return def
+	}
+
+	var recv types.Type
+	switch obj := obj.(type) {
+	case *types.Func:
+		if r := obj.Type().(*types.Signature).Recv(); r != nil {
+			recv = r.Type()
+		}
+	case *types.Var:
+		if obj.IsField() {
+			recv = fieldOwner(obj)
+		}
+	}
+	if recv == nil {
+		return nil
+	}
+	named, ok := derefType(recv).(*types.Named)
+	if !ok || named.Obj().Parent() != pkg.Scope() {
+		return nil
+	}
+	def.Path = named.Obj().Name() + " " + obj.Name()
+	return def
+}
+
+// fieldOwner returns the package level named type whose struct declares the
+// field, or nil if there is none.
+func fieldOwner(field *types.Var) types.Type {
+	scope := field.Pkg().Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		s, ok := tn.Type().Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for i := 0; i < s.NumFields(); i++ {
+			if s.Field(i) == field {
+				return tn.Type()
+			}
+		}
+	}
+	return nil
+}
+
+// indexPatternMatcher returns a function which reports whether a package
+// directory matches any of patterns, which are relative to rootPath. As for
+// the go command, "dir/..." matches dir and every directory under it.
+func indexPatternMatcher(rootPath string, patterns []string) (func(dir string) bool, error) {
+	type dirPattern struct {
+		dir       string
+		recursive bool
+	}
+	var dirs []dirPattern
+	for _, p := range patterns {
+		if p != "." && p != "..." && !strings.HasPrefix(p, "./") {
+			return nil, fmt.Errorf("invalid package pattern %q: must be relative to the root, eg ./...", p)
+		}
+		recursive := p == "..." || strings.HasSuffix(p, "/...")
+		p = strings.TrimSuffix(strings.TrimSuffix(p, "..."), "/")
+		dirs = append(dirs, dirPattern{dir: path.Join(rootPath, p), recursive: recursive})
+	}
+	return func(dir string) bool {
+		for _, p := range dirs {
+			if util.PathEqual(dir, p.dir) || p.recursive && util.PathHasPrefix(dir, p.dir) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,437 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-lsp"
+)
+
+// newTestIndexHandler returns a handler for the workspace test/p, which
+// imports the package github.com/dep/d.
+func newTestIndexHandler(t *testing.T) *LangHandler {
+	h := &LangHandler{DefaultConfig: NewDefaultConfig(), HandlerShared: &HandlerShared{}}
+	if err := h.reset(&InitializeParams{
+		InitializeParams:     lsp.InitializeParams{RootURI: "file:///src/test/p"},
+		NoOSFileSystemAccess: true,
+		RootImportPath:       "test/p",
+		BuildContext: &InitializeBuildContextParams{
+			GOOS:     runtime.GOOS,
+			GOARCH:   runtime.GOARCH,
+			GOPATH:   "/",
+			GOROOT:   "/goroot",
+			Compiler: runtime.Compiler,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The files are bound at the root, so that the GOPATH directories exist.
+	h.FS.Bind("/", mapFS(map[string]string{
+		"src/test/p/a.go": `package p
+
+import "github.com/dep/d"
+
+// Foo returns a thing.
+func Foo() d.Thing { return d.New() }
+
+type T struct{ F int }
+
+func (t T) M() int { return t.F }
+`,
+		"src/test/p/b.go": `package p
+
+func bar() int {
+	x := Foo()
+	_ = x
+	return T{F: 1}.M()
+}
+`,
+		"src/test/p/c.go": `package p
+
+var s, Héllo = "ééé", 1
+
+var t = "𝒳" + s
+`,
+		"src/test/p/cmd/main.go": `package main
+
+func Run() {}
+`,
+		"src/test/p/vendor/v/v.go": "package v\n",
+		"src/github.com/dep/d/d.go": `package d
+
+// Thing is a thing.
+type Thing struct{}
+
+func New() Thing { return Thing{} }
+`,
+	}), "/", ctxvfs.BindAfter)
+	return h
+}
+
+func TestBuildCodeIndex(t *testing.T) {
+	h := newTestIndexHandler(t)
+	ix, err := h.buildCodeIndex(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, doc := range ix.documents {
+		for _, o := range doc.occurrences {
+			role := "ref"
+			if o.definition {
+				role = "def"
+			}
+			id := "local"
+			if o.symbol.desc != nil {
+				id = o.symbol.desc.ID
+			}
+			got = append(got, fmt.Sprintf("%s:%d:%d %s %s %s", ix.relativePath(doc), o.rng.Start.Line+1, o.rng.Start.Character+1, role, id, o.symbol.monikerKind()))
+		}
+	}
+	want := []string{
+		"a.go:1:9 def test/p export",
+		"a.go:3:8 ref github.com/dep/d import",
+		"a.go:6:6 def test/p/-/Foo export",
+		"a.go:6:12 ref github.com/dep/d import",
+		"a.go:6:14 ref github.com/dep/d/-/Thing import",
+		"a.go:6:29 ref github.com/dep/d import",
+		"a.go:6:31 ref github.com/dep/d/-/New import",
+		"a.go:8:6 def test/p/-/T export",
+		"a.go:8:16 def test/p/-/T/F export",
+		"a.go:10:7 def local ",
+		"a.go:10:9 ref test/p/-/T export",
+		"a.go:10:12 def test/p/-/T/M export",
+		"a.go:10:29 ref local ",
+		"a.go:10:31 ref test/p/-/T/F export",
+		"b.go:1:9 def test/p export",
+		"b.go:3:6 def test/p/-/bar ",
+		"b.go:4:2 def local ",
+		"b.go:4:7 ref test/p/-/Foo export",
+		"b.go:5:6 ref local ",
+		"b.go:6:9 ref test/p/-/T export",
+		"b.go:6:11 ref test/p/-/T/F export",
+		"b.go:6:17 ref test/p/-/T/M export",
+		"c.go:1:9 def test/p export",
+		"c.go:3:5 def test/p/-/s ",
+		"c.go:3:8 def test/p/-/Héllo export",
+		"c.go:5:5 def test/p/-/t ",
+		"c.go:5:16 ref test/p/-/s ",
+		"cmd/main.go:1:9 def test/p/cmd ",
+		"cmd/main.go:3:6 def test/p/cmd/-/Run ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got occurrences\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, s := range ix.symbols {
+		if s.desc != nil && s.desc.ID == "test/p/-/Foo" {
+			if !strings.Contains(s.hover, "func Foo() Thing") || !strings.Contains(s.hover, "Foo returns a thing.") {
+				t.Errorf("got hover %q for Foo", s.hover)
+			}
+		}
+	}
+}
+
+func TestWriteIndex_lsif(t *testing.T) {
+	h := newTestIndexHandler(t)
+	var buf bytes.Buffer
+	if err := h.writeIndex(context.Background(), &buf, IndexOptions{Format: IndexFormatLSIF, ToolVersion: "v0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the graph is well formed: every edge connects vertices
+	// which were emitted before it, and every range has a result set.
+	type element struct {
+		ID       int             `json:"id"`
+		Type     string          `json:"type"`
+		Label    string          `json:"label"`
+		OutV     int             `json:"outV"`
+		InV      int             `json:"inV"`
+		InVs     []int           `json:"inVs"`
+		Document int             `json:"document"`
+		Property string          `json:"property"`
+		URI      string          `json:"uri"`
+		Start    lsp.Position    `json:"start"`
+		End      lsp.Position    `json:"end"`
+		Kind     string          `json:"kind"`
+		Name     string          `json:"name"`
+		Ident    string          `json:"identifier"`
+		Result   json.RawMessage `json:"result"`
+	}
+	vertices := map[int]*element{}
+	edges := map[int][]*element{} // by outV
+	for i, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e element
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != i+1 {
+			t.Fatalf("got id %d on line %d", e.ID, i+1)
+		}
+		if e.Type == "vertex" {
+			vertices[e.ID] = &e
+			continue
+		}
+		for _, v := range append([]int{e.OutV, e.InV}, e.InVs...) {
+			if v != 0 && vertices[v] == nil {
+				t.Fatalf("edge %d references vertex %d which wasn't emitted before it", e.ID, v)
+			}
+		}
+		edges[e.OutV] = append(edges[e.OutV], &e)
+	}
+	if v := vertices[1]; v == nil || v.Label != "metaData" {
+		t.Fatalf("got first vertex %+v, want metaData", v)
+	}
+	next := func(outV int, label string) []*element {
+		var out []*element
+		for _, e := range edges[outV] {
+			if e.Label == label {
+				out = append(out, e)
+			}
+		}
+		return out
+	}
+	for id, v := range vertices {
+		if v.Label == "range" && len(next(id, "next")) != 1 {
+			t.Errorf("range %d has %d next edges, want 1", id, len(next(id, "next")))
+		}
+	}
+
+	rangeAt := func(file string, line, character int) int {
+		for id, v := range vertices {
+			if v.Label != "range" || v.Start.Line != line || v.Start.Character != character {
+				continue
+			}
+			for _, e := range edges {
+				for _, e := range e {
+					if e.Label == "contains" && vertices[e.OutV].URI == "file:///src/test/p/"+file {
+						for _, inV := range e.InVs {
+							if inV == id {
+								return id
+							}
+						}
+					}
+				}
+			}
+		}
+		t.Fatalf("no range at %s:%d:%d", file, line, character)
+		return 0
+	}
+
+	// Go to the definition of the reference to Foo in b.go.
+	fooRef := rangeAt("b.go", 3, 6)
+	resultSet := next(fooRef, "next")[0].InV
+	defs := next(resultSet, "textDocument/definition")
+	if len(defs) != 1 {
+		t.Fatalf("got %d definition results", len(defs))
+	}
+	items := next(defs[0].InV, "item")
+	if len(items) != 1 || vertices[items[0].Document].URI != "file:///src/test/p/a.go" || !reflect.DeepEqual(items[0].InVs, []int{rangeAt("a.go", 5, 5)}) {
+		t.Errorf("got definition items %+v, want the range of Foo in a.go", items)
+	}
+
+	refs := next(next(resultSet, "textDocument/references")[0].InV, "item")
+	var props []string
+	for _, e := range refs {
+		props = append(props, fmt.Sprintf("%s %s %d", e.Property, vertices[e.Document].URI, len(e.InVs)))
+	}
+	if want := []string{"definitions file:///src/test/p/a.go 1", "references file:///src/test/p/b.go 1"}; !reflect.DeepEqual(props, want) {
+		t.Errorf("got reference items %q, want %q", props, want)
+	}
+
+	hovers := next(resultSet, "textDocument/hover")
+	if len(hovers) != 1 || !strings.Contains(string(vertices[hovers[0].InV].Result), "func Foo() Thing") {
+		t.Errorf("got hover edges %+v", hovers)
+	}
+
+	moniker := func(resultSet int) string {
+		m := next(resultSet, "moniker")
+		if len(m) == 0 {
+			return ""
+		}
+		v := vertices[m[0].InV]
+		info := vertices[next(v.ID, "packageInformation")[0].InV]
+		return fmt.Sprintf("%s %s %s", v.Kind, v.Ident, info.Name)
+	}
+	if got, want := moniker(resultSet), "export test/p/-/Foo test/p"; got != want {
+		t.Errorf("got moniker %q, want %q", got, want)
+	}
+	thing := next(rangeAt("a.go", 5, 13), "next")[0].InV
+	if got, want := moniker(thing), "import github.com/dep/d/-/Thing github.com/dep/d"; got != want {
+		t.Errorf("got moniker %q, want %q", got, want)
+	}
+	if defs := next(thing, "textDocument/definition"); len(defs) != 0 {
+		t.Errorf("got definition of imported symbol: %+v", defs)
+	}
+	if x := next(rangeAt("b.go", 3, 1), "next")[0].InV; moniker(x) != "" {
+		t.Errorf("got moniker %q for local variable", moniker(x))
+	}
+
+	// Positions are in UTF-16 code units, not bytes.
+	if got, want := vertices[rangeAt("c.go", 2, 7)].End, (lsp.Position{Line: 2, Character: 12}); got != want {
+		t.Errorf("got end %+v for Héllo, want %+v", got, want)
+	}
+	rangeAt("c.go", 4, 15) // s, after a surrogate pair
+}
+
+func TestWriteIndex_scip(t *testing.T) {
+	h := newTestIndexHandler(t)
+	var buf bytes.Buffer
+	if err := h.writeIndex(context.Background(), &buf, IndexOptions{Format: IndexFormatSCIP, Patterns: []string{"./..."}}); err != nil {
+		t.Fatal(err)
+	}
+
+	index := decodeProto(t, buf.Bytes())
+	metadata := decodeProto(t, index[scipIndexMetadata][0].([]byte))
+	if got := string(metadata[scipMetadataProjectRoot][0].([]byte)); got != "file:///src/test/p" {
+		t.Errorf("got project root %q", got)
+	}
+
+	var got []string
+	symbols := map[string]string{}
+	for _, d := range index[scipIndexDocuments] {
+		doc := decodeProto(t, d.([]byte))
+		path := string(doc[scipDocumentRelativePath][0].([]byte))
+		if enc := doc[scipDocumentPositionEncoding]; len(enc) != 1 || enc[0].(uint64) != scipPositionEncodingUTF16 {
+			t.Errorf("got position encoding %v for %s, want UTF-16", enc, path)
+		}
+		for _, o := range doc[scipDocumentOccurrences] {
+			occ := decodeProto(t, o.([]byte))
+			symbol := string(occ[scipOccurrenceSymbol][0].([]byte))
+			if strings.HasPrefix(symbol, "local ") {
+				continue
+			}
+			role := "ref"
+			if len(occ[scipOccurrenceSymbolRoles]) > 0 && occ[scipOccurrenceSymbolRoles][0].(uint64)&scipSymbolRoleDefinition != 0 {
+				role = "def"
+			}
+			got = append(got, fmt.Sprintf("%s:%v %s %s", path, decodePacked(t, occ[scipOccurrenceRange][0].([]byte)), role, symbol))
+		}
+		for _, s := range doc[scipDocumentSymbols] {
+			info := decodeProto(t, s.([]byte))
+			if docs := info[scipSymbolInformationDocumentation]; len(docs) > 0 {
+				symbols[string(info[scipSymbolInformationSymbol][0].([]byte))] = string(docs[0].([]byte))
+			}
+		}
+	}
+	want := []string{
+		"a.go:[0 8 9] def go go test/p . p/",
+		"a.go:[2 7 25] ref go go github.com/dep/d . d/",
+		"a.go:[5 5 8] def go go test/p . p/Foo().",
+		"a.go:[5 11 12] ref go go github.com/dep/d . d/",
+		"a.go:[5 13 18] ref go go github.com/dep/d . d/Thing#",
+		"a.go:[5 28 29] ref go go github.com/dep/d . d/",
+		"a.go:[5 30 33] ref go go github.com/dep/d . d/New().",
+		"a.go:[7 5 6] def go go test/p . p/T#",
+		"a.go:[7 15 16] def go go test/p . p/T#F.",
+		"a.go:[9 8 9] ref go go test/p . p/T#",
+		"a.go:[9 11 12] def go go test/p . p/T#M().",
+		"a.go:[9 30 31] ref go go test/p . p/T#F.",
+		"b.go:[0 8 9] def go go test/p . p/",
+		"b.go:[2 5 8] def go go test/p . p/bar().",
+		"b.go:[3 6 9] ref go go test/p . p/Foo().",
+		"b.go:[5 8 9] ref go go test/p . p/T#",
+		"b.go:[5 10 11] ref go go test/p . p/T#F.",
+		"b.go:[5 16 17] ref go go test/p . p/T#M().",
+		"c.go:[0 8 9] def go go test/p . p/",
+		"c.go:[2 4 5] def go go test/p . p/s.",
+		"c.go:[2 7 12] def go go test/p . p/`Héllo`.",
+		"c.go:[4 4 5] def go go test/p . p/t.",
+		"c.go:[4 15 16] ref go go test/p . p/s.",
+		"cmd/main.go:[0 8 12] def go go test/p/cmd . main/",
+		"cmd/main.go:[2 5 8] def go go test/p/cmd . main/Run().",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got occurrences\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if doc := symbols["go go test/p . p/Foo()."]; !strings.Contains(doc, "Foo returns a thing.") {
+		t.Errorf("got documentation %q for Foo", doc)
+	}
+
+	var external []string
+	for _, s := range index[scipIndexExternalSymbols] {
+		external = append(external, string(decodeProto(t, s.([]byte))[scipSymbolInformationSymbol][0].([]byte)))
+	}
+	if want := []string{"go go github.com/dep/d . d/", "go go github.com/dep/d . d/Thing#", "go go github.com/dep/d . d/New()."}; !reflect.DeepEqual(external, want) {
+		t.Errorf("got external symbols %q, want %q", external, want)
+	}
+}
+
+func TestIndexPatternMatcher(t *testing.T) {
+	match, err := indexPatternMatcher("/src/p", []string{"./a/...", "./b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]bool{
+		"/src/p":     false,
+		"/src/p/a":   true,
+		"/src/p/a/x": true,
+		"/src/p/ab":  false,
+		"/src/p/b":   true,
+		"/src/p/b/x": false,
+	} {
+		if got := match(dir); got != want {
+			t.Errorf("match(%q) = %v, want %v", dir, got, want)
+		}
+	}
+	if _, err := indexPatternMatcher("/src/p", []string{"github.com/p/..."}); err == nil {
+		t.Error("got no error for an absolute pattern")
+	}
+}
+
+// decodeProto decodes the fields of a protobuf message, which are uint64s
+// for varints and []byte for length delimited fields.
+func decodeProto(t *testing.T, b []byte) map[int][]interface{} {
+	t.Helper()
+	fields := map[int][]interface{}{}
+	for len(b) > 0 {
+		tag, n := decodeUvarint(t, b)
+		b = b[n:]
+		switch tag & 7 {
+		case protoWireVarint:
+			v, n := decodeUvarint(t, b)
+			b = b[n:]
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], v)
+		case protoWireBytes:
+			l, n := decodeUvarint(t, b)
+			b = b[n:]
+			fields[int(tag>>3)] = append(fields[int(tag>>3)], b[:l])
+			b = b[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return fields
+}
+
+func decodePacked(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	var vs []uint64
+	for len(b) > 0 {
+		v, n := decodeUvarint(t, b)
+		b = b[n:]
+		vs = append(vs, v)
+	}
+	return vs
+}
+
+func decodeUvarint(t *testing.T, b []byte) (uint64, int) {
+	t.Helper()
+	var v uint64
+	for i, c := range b {
+		v |= uint64(c&0x7f) << (7 * uint(i))
+		if c < 0x80 {
+			return v, i + 1
+		}
+	}
+	t.Fatal("truncated varint")
+	return 0, 0
+}
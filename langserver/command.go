@@ -0,0 +1,89 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+const (
+	// commandSetBuildConfiguration switches the active build
+	// configuration. Its only argument is the new BuildConfiguration,
+	// which replaces the active one. It returns the new active
+	// BuildConfiguration. If there is no argument, the build configuration
+	// is reset to the one selected by the Config.
+	commandSetBuildConfiguration = "go.setBuildConfiguration"
+
+	// commandImportPath returns the shortest path of imports from the
+	// package at the import path of its first argument to the package at
+	// the import path of its second argument, e.g. to explain an import
+	// rule violation. Import paths starting with "./" are relative to the
+	// root import path of the workspace. It returns null if there is no
+	// such path.
+	commandImportPath = "go.importPath"
+)
+
+// commands are the commands supported by workspace/executeCommand.
+var commands = []string{
+	commandSetBuildConfiguration,
+	commandImportPath,
+}
+
+func (h *LangHandler) handleExecuteCommand(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.ExecuteCommandParams) (interface{}, error) {
+	switch params.Command {
+	case commandSetBuildConfiguration:
+		if len(params.Arguments) > 1 {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("%s takes at most 1 argument, got %d", params.Command, len(params.Arguments))}
+		}
+		h.mu.Lock()
+		buildConfig := h.config.buildConfiguration()
+		h.mu.Unlock()
+		if len(params.Arguments) == 1 {
+			buildConfig = BuildConfiguration{}
+			if err := unmarshalCommandArgument(params.Arguments[0], &buildConfig); err != nil {
+				return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("invalid argument to %s: %s", params.Command, err)}
+			}
+		}
+		return h.setBuildConfiguration(buildConfig), nil
+
+	case commandImportPath:
+		if len(params.Arguments) != 2 {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("%s takes 2 arguments, got %d", params.Command, len(params.Arguments))}
+		}
+		var from, to string
+		if err := unmarshalCommandArgument(params.Arguments[0], &from); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("invalid argument to %s: %s", params.Command, err)}
+		}
+		if err := unmarshalCommandArgument(params.Arguments[1], &to); err != nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("invalid argument to %s: %s", params.Command, err)}
+		}
+		return h.handleImportPathCommand(ctx, from, to), nil
+
+	default:
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("command not supported: %s", params.Command)}
+	}
+}
+
+// setBuildConfiguration makes c the active build configuration. Everything
+// we have cached was computed with the previous build configuration, so the
+// caches are reset.
+func (h *LangHandler) setBuildConfiguration(c BuildConfiguration) BuildConfiguration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buildConfig = c
+	h.resetCaches(false)
+	return c
+}
+
+// unmarshalCommandArgument decodes arg, which was decoded from JSON into an
+// interface{}, into v.
+func unmarshalCommandArgument(arg interface{}, v interface{}) error {
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
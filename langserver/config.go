@@ -1,6 +1,7 @@
 package langserver
 
 import (
+	"log"
 	"os"
 	"runtime"
 )
@@ -29,7 +30,9 @@ type Config struct {
 	// Defaults to false if not specified.
 	GocodeCompletionEnabled bool
 
-	// FormatTool decides which tool is used to format documents. Supported: goimports and gofmt
+	// FormatTool decides which tool is used to format documents. Supported: goimports, gofmt and gofumpt
+	//
+	// gofumpt formats like goimports followed by gofumpt, a stricter gofmt.
 	//
 	// Defaults to goimports if not specified.
 	FormatTool string
@@ -46,6 +49,17 @@ type Config struct {
 	// Defaults to empty string if not specified.
 	GoimportsLocalPrefix string
 
+	// ImportGroups is the order of the groups, separated by blank lines,
+	// which formatting and the source.organizeImports code action sort
+	// imports into. A group is "std" for the standard library, "local"
+	// for the packages of GoimportsLocalPrefix, "default" for the packages
+	// no other group is for, or a comma-separated list of import path
+	// prefixes. The group with the longest matching prefix wins.
+	//
+	// Defaults to none if not specified, which leaves grouping to the
+	// format tool.
+	ImportGroups []string
+
 	// DiagnosticsEnabled enables handling of diagnostics
 	//
 	// Defaults to false if not specified.
@@ -64,6 +78,97 @@ type Config struct {
 	//
 	// Defaults to true if not specified.
 	UseBinaryPkgCache bool
+
+	// UseExportData controls whether only the packages in the workspace are
+	// typechecked from source. Dependencies are instead imported from
+	// export data which the server produces and caches itself. This
+	// greatly reduces the memory and latency of typechecking packages with
+	// large dependency graphs. The export data is only kept across restarts
+	// if the persistent index is enabled (-indexdir). Hover and definition
+	// don't typecheck when UseBinaryPkgCache is true, so they are only
+	// affected if it is false.
+	//
+	// Defaults to false if not specified.
+	UseExportData bool
+
+	// BuildTags are additional build tags to consider satisfied when
+	// deciding which files are part of a package, eg "integration". They
+	// are added to the build tags of the InitializeParams.BuildContext.
+	//
+	// Defaults to no additional build tags if not specified.
+	BuildTags []string
+
+	// GOOS is the target operating system, eg "windows".
+	//
+	// Defaults to the GOOS of the language server if not specified.
+	GOOS string
+
+	// GOARCH is the target architecture, eg "arm64".
+	//
+	// Defaults to the GOARCH of the language server if not specified.
+	GOARCH string
+
+	// CgoEnabled controls whether files which import "C" are part of a
+	// package.
+	//
+	// Defaults to the CGO_ENABLED of the language server if not specified,
+	// unless GOOS or GOARCH differ from it, in which case it defaults to
+	// false (like the go tool does when cross-compiling).
+	CgoEnabled *bool
+
+	// WorkspaceSymbolScope decides where workspace/symbol searches for
+	// symbols if the query has no scope: filter. Supported: workspace, std
+	// (the workspace and GOROOT), deps (the workspace and its dependencies)
+	// and all. Symbols outside of the workspace rank below the ones in it,
+	// and are served from an index which is built in the background.
+	//
+	// Defaults to workspace if not specified.
+	WorkspaceSymbolScope string
+
+	// DocumentationURLTemplate is the URL of the documentation of a
+	// symbol, which Markdown hovers link to for exported symbols. The
+	// placeholders {importPath} and {symbol} are replaced by the import
+	// path of the package and the name of the symbol, eg Reader or
+	// Reader.Read. An empty template disables the links.
+	//
+	// Defaults to "https://pkg.go.dev/{importPath}#{symbol}" if not
+	// specified.
+	DocumentationURLTemplate string
+
+	// HoverConstantValues enables showing the values of constants in
+	// hovers, eg of constants declared with iota, and of integers also in
+	// hex.
+	//
+	// Defaults to true if not specified.
+	HoverConstantValues bool
+
+	// HoverStructLayout enables showing the size and alignment of structs
+	// and the offsets and sizes of their fields in hovers, for the GOARCH
+	// of the workspace.
+	//
+	// Defaults to false if not specified.
+	HoverStructLayout bool
+
+	// HoverStructTags enables showing the tags of struct fields in hovers.
+	//
+	// Defaults to true if not specified.
+	HoverStructTags bool
+
+	// HoverMethodSets enables showing the methods of named types in
+	// hovers, with a pointer receiver if they can only be called on a
+	// pointer.
+	//
+	// Defaults to true if not specified.
+	HoverMethodSets bool
+
+	// GoSrcURIsEnabled enables referring to files outside of the
+	// workspace, in GOROOT, the module cache or GOPATH, by go-src: URIs,
+	// eg go-src://goroot/src/net/http/server.go. Clients fetch their
+	// contents with textDocument/content. go-src: URIs are accepted in
+	// requests even if this is false.
+	//
+	// Defaults to false if not specified.
+	GoSrcURIsEnabled bool
 }
 
 // Apply sets the corresponding field in c for each non-nil field in o.
@@ -86,18 +191,82 @@ func (c Config) Apply(o *InitializationOptions) Config {
 	if o.GoimportsLocalPrefix != nil {
 		c.GoimportsLocalPrefix = *o.GoimportsLocalPrefix
 	}
+	if o.ImportGroups != nil {
+		c.ImportGroups = *o.ImportGroups
+	}
 	if o.MaxParallelism != nil {
 		c.MaxParallelism = *o.MaxParallelism
 	}
 	if o.UseBinaryPkgCache != nil {
 		c.UseBinaryPkgCache = *o.UseBinaryPkgCache
 	}
+	if o.UseExportData != nil {
+		c.UseExportData = *o.UseExportData
+	}
 	if o.DiagnosticsEnabled != nil {
 		c.DiagnosticsEnabled = *o.DiagnosticsEnabled
 	}
+	if o.BuildTags != nil {
+		c.BuildTags = *o.BuildTags
+	}
+	if o.GOOS != nil {
+		c.GOOS = *o.GOOS
+	}
+	if o.GOARCH != nil {
+		c.GOARCH = *o.GOARCH
+	}
+	if o.CgoEnabled != nil {
+		c.CgoEnabled = o.CgoEnabled
+	}
+	if o.WorkspaceSymbolScope != nil {
+		c.WorkspaceSymbolScope = *o.WorkspaceSymbolScope
+	}
+	if o.DocumentationURLTemplate != nil {
+		c.DocumentationURLTemplate = *o.DocumentationURLTemplate
+	}
+	if o.HoverConstantValues != nil {
+		c.HoverConstantValues = *o.HoverConstantValues
+	}
+	if o.HoverStructLayout != nil {
+		c.HoverStructLayout = *o.HoverStructLayout
+	}
+	if o.HoverStructTags != nil {
+		c.HoverStructTags = *o.HoverStructTags
+	}
+	if o.HoverMethodSets != nil {
+		c.HoverMethodSets = *o.HoverMethodSets
+	}
+	if o.GoSrcURIsEnabled != nil {
+		c.GoSrcURIsEnabled = *o.GoSrcURIsEnabled
+	}
 	return c
 }
 
+// buildConfiguration returns the build configuration selected by c.
+func (c Config) buildConfiguration() BuildConfiguration {
+	return BuildConfiguration{
+		BuildTags:  c.BuildTags,
+		GOOS:       c.GOOS,
+		GOARCH:     c.GOARCH,
+		CgoEnabled: c.CgoEnabled,
+	}
+}
+
+// workspaceSymbolScope returns the scope selected by
+// c.WorkspaceSymbolScope. Unsupported scopes are logged and treated as
+// workspace.
+func (c Config) workspaceSymbolScope() SymbolScope {
+	if c.WorkspaceSymbolScope == "" {
+		return ScopeWorkspace
+	}
+	scope, err := ParseSymbolScope(c.WorkspaceSymbolScope)
+	if err != nil {
+		log.Printf("warning: %s, searching workspace symbols in the workspace only", err)
+		return ScopeWorkspace
+	}
+	return scope
+}
+
 // NewDefaultConfig returns the default config. See the field comments for the
 // defaults.
 func NewDefaultConfig() Config {
@@ -108,12 +277,20 @@ func NewDefaultConfig() Config {
 	}
 
 	return Config{
-		FuncSnippetEnabled:      true,
-		GocodeCompletionEnabled: false,
-		FormatTool:              formatToolGoimports,
-		LintTool:                lintToolNone,
-		DiagnosticsEnabled:      false,
-		MaxParallelism:          maxparallelism,
-		UseBinaryPkgCache:       true,
+		FuncSnippetEnabled:       true,
+		GocodeCompletionEnabled:  false,
+		FormatTool:               formatToolGoimports,
+		LintTool:                 lintToolNone,
+		DiagnosticsEnabled:       false,
+		MaxParallelism:           maxparallelism,
+		UseBinaryPkgCache:        true,
+		UseExportData:            false,
+		WorkspaceSymbolScope:     "workspace",
+		DocumentationURLTemplate: "https://pkg.go.dev/{importPath}#{symbol}",
+		HoverConstantValues:      true,
+		HoverStructLayout:        false,
+		HoverStructTags:          true,
+		HoverMethodSets:          true,
+		GoSrcURIsEnabled:         false,
 	}
 }
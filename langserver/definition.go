@@ -28,7 +28,13 @@ func (h *LangHandler) handleDefinition(ctx context.Context, conn jsonrpc2.JSONRP
 			// comments/strings/whitespace/etc), just return no info.
 			return []lsp.Location{}, nil
 		}
-		return locs, err
+		if err == nil {
+			return locs, nil
+		}
+		// godef can't resolve uses of generic types declared in other
+		// files either, so fall back to typechecking on any error. It
+		// works against our VFS.
+		params.TextDocument.URI = vfsURI(params.TextDocument.URI)
 	}
 
 	res, err := h.handleXDefinition(ctx, conn, req, params)
@@ -59,17 +65,25 @@ func (h *LangHandler) handleTypeDefinition(ctx context.Context, conn jsonrpc2.JS
 
 var testOSToVFSPath func(osPath string) string
 
-func (h *LangHandler) definitionGodef(ctx context.Context, params lsp.TextDocumentPositionParams) (*token.FileSet, *godef.Result, []lsp.Location, error) {
+// vfsURI returns the URI of the OS file uri in our VFS.
+func vfsURI(uri lsp.DocumentURI) lsp.DocumentURI {
 	// In the case of testing, our OS paths and VFS paths do not match. In the
 	// real world, this is never the case. Give the test suite the opportunity
 	// to correct the path now.
-	vfsURI := params.TextDocument.URI
 	if testOSToVFSPath != nil {
-		vfsURI = util.PathToURI(testOSToVFSPath(util.UriToPath(vfsURI)))
+		return util.PathToURI(testOSToVFSPath(util.UriToPath(uri)))
 	}
+	return uri
+}
+
+// errGodefUnsupported is returned by definitionGodef for code which godef
+// can't handle. godef predates type parameters, so it can't parse generic
+// code. Callers should fall back to typechecking.
+var errGodefUnsupported = errors.New("godef does not support type parameters")
 
+func (h *LangHandler) definitionGodef(ctx context.Context, params lsp.TextDocumentPositionParams) (*token.FileSet, *godef.Result, []lsp.Location, error) {
 	// Read file contents and calculate byte offset.
-	contents, err := h.readFile(ctx, vfsURI)
+	contents, err := h.readFile(ctx, vfsURI(params.TextDocument.URI))
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -81,6 +95,10 @@ func (h *LangHandler) definitionGodef(ctx context.Context, params lsp.TextDocume
 		return nil, nil, nil, fmt.Errorf("invalid position: %s:%d:%d (%s)", filename, params.Position.Line, params.Position.Character, why)
 	}
 
+	if usesTypeParams(filename, contents) {
+		return nil, nil, nil, errGodefUnsupported
+	}
+
 	// Invoke godef to determine the position of the definition.
 	fset := token.NewFileSet()
 	res, err := godef.Godef(fset, offset, filename, contents)
@@ -126,6 +144,9 @@ func typeLookup(prog *loader.Program, typ types.Type) *types.TypeName {
 	for {
 		switch t := typ.(type) {
 		case *types.Named:
+			// For instantiated types this is the generic type.
+			return t.Obj()
+		case *types.TypeParam:
 			return t.Obj()
 		case *types.Map:
 			return nil
@@ -164,6 +185,9 @@ func (h *LangHandler) handleXDefinition(ctx context.Context, conn jsonrpc2.JSONR
 		obj, ok = pkg.Defs[node]
 	}
 	if ok && obj != nil {
+		// The methods and fields of instantiated types are declared by
+		// the generic type.
+		obj = originObject(obj)
 		if p := obj.Pos(); p.IsValid() {
 			nodes = append(nodes, foundNode{
 				ident: &ast.Ident{NamePos: p, Name: obj.Name()},
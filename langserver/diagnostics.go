@@ -53,6 +53,23 @@ func (p *diagnosticsCache) sync(update func(diagnostics) diagnostics, compare fu
 	return publish
 }
 
+// files returns the files which have cached diagnostics from source.
+func (p *diagnosticsCache) files(source string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var files []string
+	for file, diags := range p.cache {
+		for _, diag := range diags {
+			if diag.Source == source {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	return files
+}
+
 // publishDiagnostics sends diagnostic information (such as compile
 // errors) to the client.
 func (h *LangHandler) publishDiagnostics(ctx context.Context, conn jsonrpc2.JSONRPC2, diags diagnostics, source string, files []string) error {
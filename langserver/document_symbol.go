@@ -0,0 +1,285 @@
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/buildutil"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// handleTextDocumentSymbolHierarchical handles `textDocument/documentSymbol`
+// requests from clients which support hierarchicalDocumentSymbolSupport.
+func (h *LangHandler) handleTextDocumentSymbolHierarchical(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.DocumentSymbolParams) ([]DocumentSymbol, error) {
+	if !util.IsURI(params.TextDocument.URI) {
+		return nil, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeInvalidParams,
+			Message: fmt.Sprintf("textDocument/documentSymbol not yet supported for out-of-workspace URI (%q)", params.TextDocument.URI),
+		}
+	}
+	path := util.UriToPath(params.TextDocument.URI)
+
+	fset := token.NewFileSet()
+	bctx := h.BuildContext(ctx)
+	src, err := buildutil.ParseFile(fset, bctx, nil, filepath.Dir(path), filepath.Base(path), 0)
+	if err != nil {
+		return nil, err
+	}
+	return documentSymbols(fset, src), nil
+}
+
+// documentSymbols returns the symbols declared in f as a hierarchy. Methods
+// are children of their receiver type, if it is declared in f.
+func documentSymbols(fset *token.FileSet, f *ast.File) []DocumentSymbol {
+	typeNames := map[string]bool{}
+	for _, decl := range f.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.TYPE {
+			for _, spec := range decl.Specs {
+				typeNames[spec.(*ast.TypeSpec).Name.Name] = true
+			}
+		}
+	}
+
+	var (
+		syms    []DocumentSymbol
+		methods = map[string][]DocumentSymbol{} // receiver type name -> methods
+	)
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			sym := funcSymbol(fset, decl)
+			if decl.Recv == nil || len(decl.Recv.List) != 1 {
+				syms = append(syms, sym)
+				continue
+			}
+			recv := decl.Recv.List[0].Type
+			if name := recvTypeName(recv); typeNames[name] {
+				methods[name] = append(methods[name], sym)
+				continue
+			}
+			// The receiver type is declared in another file, so
+			// qualify the method like a method expression.
+			sym.Name = fmt.Sprintf("(%s).%s", types.ExprString(recv), sym.Name)
+			syms = append(syms, sym)
+
+		case *ast.GenDecl:
+			switch decl.Tok {
+			case token.TYPE:
+				for _, spec := range decl.Specs {
+					if spec := spec.(*ast.TypeSpec); spec.Name.Name != "_" {
+						syms = append(syms, typeSymbol(fset, decl, spec))
+					}
+				}
+			case token.CONST, token.VAR:
+				syms = append(syms, valueSymbols(fset, decl)...)
+			}
+		}
+	}
+
+	for i, sym := range syms {
+		if sym.Kind == lsp.SKClass || sym.Kind == lsp.SKInterface {
+			syms[i].Children = append(syms[i].Children, methods[sym.Name]...)
+		}
+	}
+	return syms
+}
+
+// funcSymbol returns the symbol of the function or method fun. Types
+// declared in its body are its children.
+func funcSymbol(fset *token.FileSet, fun *ast.FuncDecl) DocumentSymbol {
+	sym := DocumentSymbol{
+		Name:           fun.Name.Name,
+		Detail:         funcDetail(fun.Type),
+		Kind:           lsp.SKFunction,
+		Range:          rangeForNode(fset, fun),
+		SelectionRange: rangeForNode(fset, fun.Name),
+	}
+	if fun.Recv != nil {
+		sym.Kind = lsp.SKMethod
+	}
+	if fun.Body != nil {
+		ast.Inspect(fun.Body, func(n ast.Node) bool {
+			decl, ok := n.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				return true
+			}
+			for _, spec := range decl.Specs {
+				if spec := spec.(*ast.TypeSpec); spec.Name.Name != "_" {
+					sym.Children = append(sym.Children, typeSymbol(fset, decl, spec))
+				}
+			}
+			return false
+		})
+	}
+	return sym
+}
+
+// typeSymbol returns the symbol of the type spec in decl. The fields and
+// embedded types of structs and the methods and embedded types of
+// interfaces are its children.
+func typeSymbol(fset *token.FileSet, decl *ast.GenDecl, spec *ast.TypeSpec) DocumentSymbol {
+	sym := DocumentSymbol{
+		Name:           spec.Name.Name,
+		Kind:           lsp.SKClass,
+		Range:          specRange(fset, decl, spec),
+		SelectionRange: rangeForNode(fset, spec.Name),
+	}
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		sym.Detail = "struct{...}"
+		sym.Children = fieldSymbols(fset, t.Fields)
+	case *ast.InterfaceType:
+		sym.Kind = lsp.SKInterface
+		sym.Detail = "interface{...}"
+		for _, field := range t.Methods.List {
+			if len(field.Names) == 0 {
+				// Embedded interface or type set, eg ~int | ~string.
+				sym.Children = append(sym.Children, DocumentSymbol{
+					Name:           types.ExprString(field.Type),
+					Kind:           lsp.SKInterface,
+					Range:          rangeForNode(fset, field),
+					SelectionRange: rangeForNode(fset, field.Type),
+				})
+				continue
+			}
+			for _, name := range field.Names {
+				sym.Children = append(sym.Children, DocumentSymbol{
+					Name:           name.Name,
+					Detail:         funcDetail(field.Type.(*ast.FuncType)),
+					Kind:           lsp.SKMethod,
+					Range:          rangeForNode(fset, field),
+					SelectionRange: rangeForNode(fset, name),
+				})
+			}
+		}
+	default:
+		sym.Detail = types.ExprString(spec.Type)
+	}
+	return sym
+}
+
+// fieldSymbols returns the symbols of the fields of a struct. The fields of
+// anonymous struct types are the children of their field.
+func fieldSymbols(fset *token.FileSet, fields *ast.FieldList) []DocumentSymbol {
+	var syms []DocumentSymbol
+	for _, field := range fields.List {
+		var children []DocumentSymbol
+		if st, ok := field.Type.(*ast.StructType); ok {
+			children = fieldSymbols(fset, st.Fields)
+		}
+		if len(field.Names) == 0 {
+			// Embedded field, which is named after its type.
+			syms = append(syms, DocumentSymbol{
+				Name:           recvTypeName(field.Type),
+				Detail:         types.ExprString(field.Type),
+				Kind:           lsp.SKField,
+				Range:          rangeForNode(fset, field),
+				SelectionRange: rangeForNode(fset, field.Type),
+			})
+			continue
+		}
+		for _, name := range field.Names {
+			syms = append(syms, DocumentSymbol{
+				Name:           name.Name,
+				Detail:         types.ExprString(field.Type),
+				Kind:           lsp.SKField,
+				Range:          rangeForNode(fset, field),
+				SelectionRange: rangeForNode(fset, name),
+				Children:       children,
+			})
+		}
+	}
+	return syms
+}
+
+// valueSymbols returns the symbols of the const or var declaration decl. If
+// decl is a group declaring more than one name, we return a single symbol
+// for the group with the declared names as its children.
+func valueSymbols(fset *token.FileSet, decl *ast.GenDecl) []DocumentSymbol {
+	kind := lsp.SKVariable
+	if decl.Tok == token.CONST {
+		kind = lsp.SKConstant
+	}
+	var syms []DocumentSymbol
+	for _, spec := range decl.Specs {
+		spec := spec.(*ast.ValueSpec)
+		for i, name := range spec.Names {
+			if name.Name == "_" {
+				continue
+			}
+			var detail string
+			if spec.Type != nil {
+				detail = types.ExprString(spec.Type)
+			} else if i < len(spec.Values) {
+				detail = "= " + types.ExprString(spec.Values[i])
+			}
+			syms = append(syms, DocumentSymbol{
+				Name:           name.Name,
+				Detail:         detail,
+				Kind:           kind,
+				Range:          specRange(fset, decl, spec),
+				SelectionRange: rangeForNode(fset, name),
+			})
+		}
+	}
+	if !decl.Lparen.IsValid() || len(syms) < 2 {
+		return syms
+	}
+	return []DocumentSymbol{{
+		Name:           decl.Tok.String(),
+		Kind:           kind,
+		Range:          rangeForNode(fset, decl),
+		SelectionRange: rangeForNode(fset, fakeNode{p: decl.TokPos, e: decl.TokPos + token.Pos(len(decl.Tok.String()))}),
+		Children:       syms,
+	}}
+}
+
+// specRange returns the range of spec. If spec is the only spec of an
+// ungrouped declaration, the range includes the keyword of decl.
+func specRange(fset *token.FileSet, decl *ast.GenDecl, spec ast.Spec) lsp.Range {
+	if !decl.Lparen.IsValid() {
+		return rangeForNode(fset, decl)
+	}
+	return rangeForNode(fset, spec)
+}
+
+// funcDetail returns the signature of a function type, including its type
+// parameters, eg "func[T any](x T) error".
+func funcDetail(ft *ast.FuncType) string {
+	s := types.ExprString(ft)
+	if ft.TypeParams == nil {
+		return s
+	}
+	// Format the type parameters like parameters and replace the
+	// parentheses.
+	tparams := strings.TrimPrefix(types.ExprString(&ast.FuncType{Params: ft.TypeParams}), "func")
+	return "func[" + tparams[1:len(tparams)-1] + "]" + strings.TrimPrefix(s, "func")
+}
+
+// recvTypeName returns the name of the type in a receiver or embedded field
+// type expression, eg T for *T or *pkg.T[int].
+func recvTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return recvTypeName(t.X)
+	case *ast.ParenExpr:
+		return recvTypeName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.IndexExpr:
+		return recvTypeName(t.X)
+	case *ast.IndexListExpr:
+		return recvTypeName(t.X)
+	}
+	return types.ExprString(expr)
+}
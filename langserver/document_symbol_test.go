@@ -0,0 +1,83 @@
+package langserver
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestDocumentSymbols(t *testing.T) {
+	const src = `package p
+
+import "io"
+
+type T struct {
+	A, B int
+	*io.Reader
+	Nested struct{ C string }
+}
+
+func (t *T) M(x int) error { return nil }
+
+type I interface {
+	io.Closer
+	N() string
+}
+
+const (
+	X = iota
+	Y
+	_
+)
+
+var Z int
+
+func F[K comparable](k K) {
+	type local struct{ D bool }
+}
+
+func (e *external) O() {}
+`
+	want := `
+Class T struct{...} 5:1-9:2 5:6
+  Field A int 6:2-6:10 6:2
+  Field B int 6:2-6:10 6:5
+  Field Reader *io.Reader 7:2-7:12 7:2
+  Field Nested struct{C string} 8:2-8:27 8:2
+    Field C string 8:17-8:25 8:17
+  Method M func(x int) error 11:1-11:42 11:13
+Interface I interface{...} 13:1-16:2 13:6
+  Interface io.Closer  14:2-14:11 14:2
+  Method N func() string 15:2-15:12 15:2
+Constant const  18:1-22:2 18:1
+  Constant X = iota 19:2-19:10 19:2
+  Constant Y  20:2-20:3 20:2
+Variable Z int 24:1-24:10 24:5
+Function F func[K comparable](k K) 26:1-28:2 26:6
+  Class local struct{...} 27:2-27:29 27:7
+    Field D bool 27:21-27:27 27:21
+Method (*external).O func() 30:1-30:26 30:20
+`
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "a.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	var write func(syms []DocumentSymbol, indent string)
+	write = func(syms []DocumentSymbol, indent string) {
+		for _, s := range syms {
+			fmt.Fprintf(&b, "%s%s %s %s %d:%d-%d:%d %d:%d\n", indent, s.Kind, s.Name, s.Detail,
+				s.Range.Start.Line+1, s.Range.Start.Character+1, s.Range.End.Line+1, s.Range.End.Character+1,
+				s.SelectionRange.Start.Line+1, s.SelectionRange.Start.Character+1)
+			write(s.Children, indent+"  ")
+		}
+	}
+	write(documentSymbols(fset, f), "")
+	if got := b.String(); got != strings.TrimPrefix(want, "\n") {
+		t.Errorf("got symbols\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,332 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/loader"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+)
+
+// typecheckWithExportData is like typecheck, except that only packages in
+// the workspace are typechecked from source. Dependencies are imported from
+// export data stored in the persistent index. If the index has no export
+// data for the current contents of a dependency, it is typechecked from
+// source once and its export data is stored for next time.
+//
+// The returned program only contains the packages typechecked from source,
+// so callers must not rely on the ASTs of dependencies being available.
+func typecheckWithExportData(ctx context.Context, fset *token.FileSet, bctx *build.Context, bpkg *build.Package, findPackage FindPackageFunc, rootPath string, ix *diskIndex) (*loader.Program, diagnostics, error) {
+	progress := workDoneProgressFromContext(ctx)
+	progress.Expect(bpkg.ImportPath)
+
+	imp := &exportDataImporter{
+		ctx:         ctx,
+		fset:        fset,
+		bctx:        bctx,
+		findPackage: findPackage,
+		rootPath:    rootPath,
+		ix:          ix,
+		hasher:      newPkgHasher(ctx, bctx, findPackage, rootPath),
+		progress:    progress,
+		packages:    map[string]*types.Package{},
+		byDir:       map[string]*types.Package{},
+		importing:   map[string]bool{},
+		prog: &loader.Program{
+			Fset:        fset,
+			Imported:    map[string]*loader.PackageInfo{},
+			AllPackages: map[*types.Package]*loader.PackageInfo{},
+		},
+	}
+
+	goFiles := packageFiles(bpkg)
+	goFiles = append(goFiles, bpkg.TestGoFiles...)
+	if strings.HasSuffix(bpkg.Name, "_test") {
+		goFiles = append(goFiles, bpkg.XTestGoFiles...)
+	}
+	info, _, err := imp.checkSource(bpkg, goFiles, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	imp.prog.Created = append(imp.prog.Created, info)
+	progress.Step(bpkg.ImportPath)
+
+	diags, err := errsToDiagnostics(imp.typeErrs, imp.prog)
+	if err != nil {
+		return nil, nil, err
+	}
+	return imp.prog, diags, nil
+}
+
+// exportDataImporter is the types.ImporterFrom used by
+// typecheckWithExportData. It is not safe for concurrent use, which is fine
+// since go/types calls it sequentially.
+type exportDataImporter struct {
+	ctx         context.Context
+	fset        *token.FileSet
+	bctx        *build.Context
+	findPackage FindPackageFunc
+	rootPath    string
+	ix          *diskIndex
+	hasher      *pkgHasher
+	progress    *workDoneProgress
+
+	packages  map[string]*types.Package // import path -> package, passed to gcexportdata.Read
+	byDir     map[string]*types.Package // package dir -> package
+	importing map[string]bool           // package dirs currently being imported
+	typeErrs  []error
+
+	prog *loader.Program
+}
+
+func (imp *exportDataImporter) Import(path string) (*types.Package, error) {
+	return imp.ImportFrom(path, "", 0)
+}
+
+func (imp *exportDataImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	if err := imp.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bpkg, err := imp.findPackage(imp.ctx, imp.bctx, path, dir, imp.rootPath, 0)
+	if err != nil && !isMultiplePackageError(err) {
+		return nil, err
+	}
+	if pkg, ok := imp.byDir[bpkg.Dir]; ok {
+		return pkg, nil
+	}
+	if imp.importing[bpkg.Dir] {
+		return nil, fmt.Errorf("import cycle via %s", bpkg.ImportPath)
+	}
+	imp.importing[bpkg.Dir] = true
+	defer delete(imp.importing, bpkg.Dir)
+	imp.progress.Expect(bpkg.ImportPath)
+
+	var pkg *types.Package
+	if imp.inWorkspace(bpkg) {
+		var info *loader.PackageInfo
+		info, _, err = imp.checkSource(bpkg, packageFiles(bpkg), false)
+		if info != nil {
+			pkg = info.Pkg
+		}
+	} else {
+		pkg, err = imp.importDependency(bpkg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	imp.byDir[bpkg.Dir] = pkg
+	imp.packages[bpkg.ImportPath] = pkg
+	imp.progress.Step(bpkg.ImportPath)
+	return pkg, nil
+}
+
+// inWorkspace reports whether bpkg should be typechecked from source.
+func (imp *exportDataImporter) inWorkspace(bpkg *build.Package) bool {
+	if bpkg.Goroot || !util.PathHasPrefix(bpkg.Dir, imp.rootPath) {
+		return false
+	}
+	return !util.IsVendorDir(util.PathTrimPrefix(bpkg.Dir, imp.rootPath))
+}
+
+// importDependency imports bpkg from its export data, producing the export
+// data first if it is not yet in the index.
+func (imp *exportDataImporter) importDependency(bpkg *build.Package) (*types.Package, error) {
+	// Import all dependencies of bpkg first. This ensures that the packages
+	// referenced by the export data of bpkg are complete and shared with
+	// the rest of the program.
+	for _, path := range bpkg.Imports {
+		if path == "C" {
+			continue
+		}
+		if _, err := imp.ImportFrom(path, bpkg.Dir, 0); err != nil {
+			// The error is reported when typechecking the importer.
+			continue
+		}
+	}
+
+	key, err := exportDataKey(imp.bctx, bpkg, imp.hasher)
+	if err != nil {
+		// We can't safely use the index without a key, but we can
+		// still typecheck from source.
+		info, _, err := imp.checkSource(bpkg, packageFiles(bpkg), false)
+		if info == nil {
+			return nil, err
+		}
+		return info.Pkg, nil
+	}
+
+	// We intentionally do not pass imp.ctx, since the index fills in
+	// another goroutine which must finish before we continue using imp.
+	var filled *types.Package
+	data, err := imp.ix.get(context.Background(), key, func() ([]byte, error) {
+		info, ok, err := imp.checkSource(bpkg, packageFiles(bpkg), false)
+		if info == nil {
+			return nil, err
+		}
+		filled = info.Pkg
+		if !ok {
+			return nil, errors.Errorf("not storing export data for %s since it has errors", bpkg.ImportPath)
+		}
+		var buf bytes.Buffer
+		if err := gcexportdata.Write(&buf, imp.fset, filled); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if filled != nil {
+		return filled, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gcexportdata.Read(bytes.NewReader(data), imp.fset, imp.packages, bpkg.ImportPath)
+}
+
+// packageFiles returns the Go files of bpkg, including its cgo files. cgo
+// is not run on them, instead they are typechecked with FakeImportC.
+func packageFiles(bpkg *build.Package) []string {
+	return append(append([]string{}, bpkg.GoFiles...), bpkg.CgoFiles...)
+}
+
+// checkSource typechecks the files of bpkg from source. Function bodies are
+// only typechecked if root is true. Packages in the workspace are added to
+// the program. ok is false if the package has errors.
+func (imp *exportDataImporter) checkSource(bpkg *build.Package, goFiles []string, root bool) (info *loader.PackageInfo, ok bool, err error) {
+	ok = true
+	var files []*ast.File
+	for _, name := range goFiles {
+		filename := buildutil.JoinPath(imp.bctx, bpkg.Dir, name)
+		f, err := buildutil.ParseFile(imp.fset, imp.bctx, nil, bpkg.Dir, name, parser.AllErrors|parser.ParseComments)
+		if f == nil {
+			return nil, false, errors.Wrapf(err, "parsing %s", filename)
+		}
+		if err != nil {
+			ok = false
+			imp.typeErrs = append(imp.typeErrs, err)
+		}
+		files = append(files, f)
+	}
+
+	info = &loader.PackageInfo{
+		Files:                 files,
+		TransitivelyErrorFree: true,
+		Info: types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Implicits:  make(map[ast.Node]types.Object),
+			Scopes:     make(map[ast.Node]*types.Scope),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+			Instances:  make(map[*ast.Ident]types.Instance),
+		},
+	}
+	conf := types.Config{
+		Importer:                 imp,
+		IgnoreFuncBodies:         !root,
+		DisableUnusedImportCheck: true,
+		FakeImportC:              true,
+		Error: func(err error) {
+			ok = false
+			info.Errors = append(info.Errors, err)
+			imp.typeErrs = append(imp.typeErrs, err)
+		},
+	}
+	info.Pkg, _ = conf.Check(bpkg.ImportPath, imp.fset, files, &info.Info)
+	info.TransitivelyErrorFree = ok
+
+	if root || imp.inWorkspace(bpkg) {
+		imp.prog.AllPackages[info.Pkg] = info
+	}
+	return info, ok, nil
+}
+
+// programPackage is like prog.Package, except it also finds the packages of
+// programs created by typecheckWithExportData.
+func programPackage(prog *loader.Program, path string) *loader.PackageInfo {
+	if info := prog.Package(path); info != nil {
+		return info
+	}
+	for pkg, info := range prog.AllPackages {
+		if pkg.Path() == path {
+			return info
+		}
+	}
+	return nil
+}
+
+// exportDataObjectPath returns the path to the identifier declaring o, for
+// objects imported from export data (and so have no AST in the program). It
+// parses the file o is declared in. Export data only records the line of a
+// declaration, so we find it by name on that line.
+func exportDataObjectPath(bctx *build.Context, fset *token.FileSet, o types.Object) []ast.Node {
+	pos := fset.Position(o.Pos())
+	if !pos.IsValid() {
+		return nil
+	}
+	fset = token.NewFileSet()
+	f, err := buildutil.ParseFile(fset, bctx, nil, "", pos.Filename, parser.ParseComments)
+	if f == nil {
+		return nil
+	}
+	_ = err // we can still look for o in a partially parsed file
+
+	var ident *ast.Ident
+	ast.Inspect(f, func(n ast.Node) bool {
+		if ident != nil {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok && id.Name == o.Name() && fset.Position(id.Pos()).Line == pos.Line {
+			ident = id
+		}
+		return true
+	})
+	if ident == nil {
+		return nil
+	}
+	path, _ := astutil.PathEnclosingInterval(f, ident.Pos(), ident.Pos())
+	return path
+}
+
+// exportDataPackageDoc returns the package documentation of pkg, for
+// packages imported from export data.
+func exportDataPackageDoc(bctx *build.Context, fset *token.FileSet, pkg *types.Package) string {
+	// Find the directory of pkg via the position of any of its objects.
+	var dir string
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		if pos := fset.Position(scope.Lookup(name).Pos()); pos.IsValid() {
+			dir = filepath.Dir(pos.Filename)
+			break
+		}
+	}
+	if dir == "" {
+		return ""
+	}
+	astPkgs, _ := parseDir(token.NewFileSet(), bctx, dir, nil, parser.PackageClauseOnly|parser.ParseComments)
+	astPkg := astPkgs[pkg.Name()]
+	if astPkg == nil {
+		return ""
+	}
+	var files []*ast.File
+	for _, f := range astPkg.Files {
+		files = append(files, f)
+	}
+	return packageDoc(files, pkg.Name())
+}
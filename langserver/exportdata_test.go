@@ -0,0 +1,179 @@
+package langserver
+
+import (
+	"context"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestTypecheckWithExportData_loaderCases(t *testing.T) {
+	ctx := context.Background()
+	for label, tc := range loaderCases {
+		t.Run(label, func(t *testing.T) {
+			fset, bctx, bpkg := setUpLoaderTest(tc.fs)
+			p, _, err := typecheckWithExportData(ctx, fset, bctx, bpkg, defaultFindPackageFunc, "/src/p", nil)
+			if err != nil {
+				t.Error(err)
+			} else if len(p.Created) == 0 {
+				t.Error("Expected to create a package")
+			} else if len(p.Created[0].Files) == 0 {
+				t.Error("did not load any files")
+			}
+		})
+	}
+}
+
+func TestTypecheckWithExportData(t *testing.T) {
+	ix, cleanup := newTestDiskIndex(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fs := map[string]string{
+		"/src/p/p.go":     `package p; import ("p/sub"; "q"); const X = q.Q + sub.S`,
+		"/src/p/sub/s.go": `package sub; const S = 1`,
+		"/src/q/q.go": `package q; import "r"
+
+// Q is documented.
+const Q = r.R`,
+		"/src/r/r.go": `package r; const R = 2`,
+	}
+	load := func(fs map[string]string) (*token.FileSet, *types.Package, map[string]bool) {
+		t.Helper()
+		fset, bctx, _ := setUpLoaderTest(fs)
+		bctx.Compiler = "gc"
+		bpkg, err := defaultFindPackageFunc(ctx, bctx, "p", "/src/p", "/src/p", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, diags, err := typecheckWithExportData(ctx, fset, bctx, bpkg, defaultFindPackageFunc, "/src/p", ix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diags) != 0 {
+			t.Fatalf("unexpected diagnostics: %v", diags)
+		}
+		fromSource := map[string]bool{}
+		for pkg := range prog.AllPackages {
+			fromSource[pkg.Path()] = true
+		}
+		return fset, prog.Created[0].Pkg, fromSource
+	}
+	entries := func() int {
+		t.Helper()
+		files, err := filepath.Glob(filepath.Join(ix.store.Dir, "*.zip"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(files)
+	}
+	xValue := func(pkg *types.Package) int64 {
+		t.Helper()
+		v, _ := constant.Int64Val(pkg.Scope().Lookup("X").(*types.Const).Val())
+		return v
+	}
+
+	// The first load typechecks q and r from source and stores their
+	// export data.
+	_, pkg, fromSource := load(fs)
+	if want := map[string]bool{"p": true, "p/sub": true}; !equalSets(fromSource, want) {
+		t.Errorf("got packages from source %v, want %v", fromSource, want)
+	}
+	if got := xValue(pkg); got != 3 {
+		t.Errorf("got X = %d, want 3", got)
+	}
+	if got := entries(); got != 2 {
+		t.Fatalf("got %d index entries, want 2", got)
+	}
+
+	// The second load imports q and r from the export data.
+	fset, pkg, _ := load(fs)
+	if got := xValue(pkg); got != 3 {
+		t.Errorf("got X = %d, want 3", got)
+	}
+	if got := entries(); got != 2 {
+		t.Fatalf("got %d index entries, want 2", got)
+	}
+
+	// We can still find the documentation of objects imported from
+	// export data.
+	q := pkg.Imports()[0]
+	if q.Path() != "q" {
+		q = pkg.Imports()[1]
+	}
+	_, bctx, _ := setUpLoaderTest(fs)
+	path := exportDataObjectPath(bctx, fset, q.Scope().Lookup("Q"))
+	var doc string
+	for _, n := range path {
+		if decl, ok := n.(*ast.GenDecl); ok {
+			doc = decl.Doc.Text()
+		}
+	}
+	if doc != "Q is documented.\n" {
+		t.Errorf("got doc %q, want %q", doc, "Q is documented.\n")
+	}
+
+	// Changing r invalidates the export data of both r and q.
+	fs["/src/r/r.go"] = `package r; const R = 5`
+	_, pkg, _ = load(fs)
+	if got := xValue(pkg); got != 6 {
+		t.Errorf("got X = %d, want 6", got)
+	}
+	if got := entries(); got != 4 {
+		t.Fatalf("got %d index entries, want 4", got)
+	}
+}
+
+func TestTypecheckWithExportData_cgo(t *testing.T) {
+	ix, cleanup := newTestDiskIndex(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fset, bctx, _ := setUpLoaderTest(map[string]string{
+		"/src/p/p.go":     `package p; import "c"; const X = c.V`,
+		"/src/c/cgo.go":   `package c; import "C"; const V = 1; var _ C.int`,
+		"/src/c/nocgo.go": "// +build !cgo\n\npackage c; const V = 2",
+	})
+	bctx.Compiler = "gc"
+	bctx.CgoEnabled = true
+	bpkg, err := defaultFindPackageFunc(ctx, bctx, "p", "/src/p", "/src/p", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		prog, diags, err := typecheckWithExportData(ctx, fset, bctx, bpkg, defaultFindPackageFunc, "/src/p", ix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diags) != 0 {
+			t.Fatalf("unexpected diagnostics: %v", diags)
+		}
+		x := prog.Created[0].Pkg.Scope().Lookup("X").(*types.Const)
+		if v, _ := constant.Int64Val(x.Val()); v != 1 {
+			t.Errorf("got X = %d, want the constant of the cgo file", v)
+		}
+	}
+	// The export data of c was stored, so it was imported the second time.
+	files, err := filepath.Glob(filepath.Join(ix.store.Dir, "*.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d index entries, want 1", len(files))
+	}
+}
+
+func equalSets(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+package langserver
+
+import (
+	"context"
+	"go/build"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/neelance/parallel"
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-langserver/pkg/tools"
+)
+
+// externalSymbols is an index of the symbols of the packages in a scope
+// outside of the workspace (ScopeStd or ScopeDeps). It is built in the
+// background, so workspace/symbol never has to parse GOROOT or the
+// dependencies while the user waits.
+type externalSymbols struct {
+	ready   chan struct{} // closed once symbols is set
+	symbols []symbolPair
+}
+
+// externalSymbolIndex returns the index of the symbols of scope, which must
+// be ScopeStd or ScopeDeps. If it does not exist yet, building it is started
+// in the background.
+func (h *LangHandler) externalSymbolIndex(scope SymbolScope) *externalSymbols {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if idx, ok := h.externalSymbols[scope]; ok {
+		return idx
+	}
+	idx := &externalSymbols{ready: make(chan struct{})}
+	if h.externalSymbols == nil {
+		h.externalSymbols = map[SymbolScope]*externalSymbols{}
+	}
+	h.externalSymbols[scope] = idx
+	go h.buildExternalSymbols(scope, idx)
+	return idx
+}
+
+// warmExternalSymbols starts building the indexes of the scopes outside of
+// the workspace which are part of scope.
+func (h *LangHandler) warmExternalSymbols(scope SymbolScope) {
+	for _, s := range []SymbolScope{ScopeStd, ScopeDeps} {
+		if scope&s != 0 {
+			h.externalSymbolIndex(s)
+		}
+	}
+}
+
+// collectExternalSymbols collects the symbols of the scopes outside of the
+// workspace which are part of the query's scope into the results. Indexes
+// which are still being built are skipped.
+func (h *LangHandler) collectExternalSymbols(results *resultSorter) {
+	for _, scope := range []SymbolScope{ScopeStd, ScopeDeps} {
+		if results.Query.Scope&scope == 0 {
+			continue
+		}
+		idx := h.externalSymbolIndex(scope)
+		select {
+		case <-idx.ready:
+		default:
+			continue
+		}
+		for _, sym := range idx.symbols {
+			if results.Query.Filter == FilterExported && !isExported(&sym) {
+				continue
+			}
+			results.collect(sym, true)
+		}
+	}
+}
+
+// buildExternalSymbols sets the symbols of idx to the ones of the packages
+// in scope.
+func (h *LangHandler) buildExternalSymbols(scope SymbolScope, idx *externalSymbols) {
+	defer close(idx.ready)
+	defer func() {
+		_ = util.Panicf(recover(), "building symbol index for scope %v", scope.names())
+	}()
+
+	// Nothing is waiting for the index, so it must not be derived from
+	// the context of a request.
+	ctx := context.Background()
+	bctx := h.BuildContext(ctx)
+	h.mu.Lock()
+	rootPath := h.FilePath(h.init.Root())
+	h.mu.Unlock()
+
+	var pkgs []*build.Package
+	switch scope {
+	case ScopeStd:
+		pkgs = h.stdPackages(ctx, bctx, rootPath)
+	case ScopeDeps:
+		pkgs = h.workspaceDeps(ctx, bctx, rootPath)
+	}
+
+	var (
+		mu      sync.Mutex
+		symbols []symbolPair
+	)
+	par := parallel.NewRun(h.config.MaxParallelism)
+	for _, pkg := range pkgs {
+		par.Acquire()
+		go func(pkg *build.Package) {
+			defer func() {
+				par.Release()
+				_ = util.Panicf(recover(), "collecting symbols of %v", pkg.ImportPath)
+			}()
+			pkgSyms, err := pkgSymbols(ctx, bctx, pkg)
+			if err != nil {
+				log.Printf("failed to collect symbols of %s: %s", pkg.Dir, err)
+				return
+			}
+			mu.Lock()
+			symbols = append(symbols, pkgSyms...)
+			mu.Unlock()
+		}(pkg)
+	}
+	_ = par.Wait()
+
+	// Collecting the symbols in parallel shuffled them.
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].desc.Package != symbols[j].desc.Package {
+			return symbols[i].desc.Package < symbols[j].desc.Package
+		}
+		return symbols[i].desc.ID < symbols[j].desc.ID
+	})
+	idx.symbols = symbols
+}
+
+// stdPackages returns the packages in GOROOT, except for the ones of the go
+// tool and the ones vendored by the standard library.
+func (h *LangHandler) stdPackages(ctx context.Context, bctx *build.Context, rootPath string) []*build.Package {
+	findPackage := h.getFindPackageFunc()
+	var pkgs []*build.Package
+	for _, importPath := range tools.ListPkgsUnderDir(bctx, path.Join(bctx.GOROOT, "src")) {
+		if importPath == "cmd" || strings.HasPrefix(importPath, "cmd/") || importPath == "builtin" || util.IsVendorDir(importPath) {
+			continue
+		}
+		pkg, err := findPackage(ctx, bctx, importPath, rootPath, rootPath, 0)
+		if err != nil {
+			maybeLogImportError(importPath, err)
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// workspaceDeps returns the packages outside of the workspace and GOROOT
+// which the packages in the workspace import, directly or indirectly.
+func (h *LangHandler) workspaceDeps(ctx context.Context, bctx *build.Context, rootPath string) []*build.Package {
+	findPackage := h.getFindPackageFunc()
+
+	type importSpec struct{ importPath, fromDir string }
+	var queue []importSpec
+	for _, importPath := range tools.ListPkgsUnderDir(bctx, rootPath) {
+		queue = append(queue, importSpec{importPath, rootPath})
+	}
+
+	var (
+		deps []*build.Package
+		seen = map[string]bool{} // package dirs
+	)
+	for len(queue) > 0 {
+		imp := queue[0]
+		queue = queue[1:]
+		if imp.importPath == "C" || imp.importPath == "unsafe" {
+			continue
+		}
+		pkg, err := findPackage(ctx, bctx, imp.importPath, imp.fromDir, rootPath, 0)
+		if err != nil {
+			maybeLogImportError(imp.importPath, err)
+			continue
+		}
+		if pkg.Goroot || seen[pkg.Dir] {
+			continue
+		}
+		seen[pkg.Dir] = true
+		if !util.PathHasPrefix(pkg.Dir, rootPath) {
+			deps = append(deps, pkg)
+		}
+		for _, importPath := range pkg.Imports {
+			queue = append(queue, importSpec{importPath, pkg.Dir})
+		}
+	}
+	return deps
+}
@@ -8,12 +8,13 @@ import (
 	"go/parser"
 	"go/printer"
 	"go/token"
-	"path"
 	"strings"
+	"sync"
 
 	"github.com/pmezard/go-difflib/difflib"
-	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/imports"
+	gofumptformat "mvdan.cc/gofumpt/format"
 
 	"github.com/sourcegraph/go-langserver/langserver/util"
 	"github.com/sourcegraph/go-lsp"
@@ -23,6 +24,7 @@ import (
 const (
 	formatToolGoimports string = "goimports"
 	formatToolGofmt     string = "gofmt"
+	formatToolGofumpt   string = "gofumpt"
 )
 
 func (h *LangHandler) handleTextDocumentFormatting(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.DocumentFormattingParams) ([]lsp.TextEdit, error) {
@@ -33,45 +35,330 @@ func (h *LangHandler) handleTextDocumentFormatting(ctx context.Context, conn jso
 		}
 	}
 
-	filename := h.FilePath(params.TextDocument.URI)
-	unformatted, err := h.readFile(ctx, params.TextDocument.URI)
+	unformatted, formatted, err := h.format(ctx, params.TextDocument.URI)
 	if err != nil {
 		return nil, err
 	}
 
-	var formatted []byte
-	switch h.config.FormatTool {
-	case formatToolGofmt:
-		bctx := h.BuildContext(ctx)
-		fset := token.NewFileSet()
-		file, err := buildutil.ParseFile(fset, bctx, nil, path.Dir(filename), path.Base(filename), parser.ParseComments)
-		if err != nil {
-			return nil, err
+	if bytes.Equal(formatted, unformatted) {
+		return nil, nil
+	}
+
+	return ComputeTextEdits(string(unformatted), string(formatted)), nil
+}
+
+func (h *LangHandler) handleTextDocumentRangeFormatting(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.DocumentRangeFormattingParams) ([]lsp.TextEdit, error) {
+	if !util.IsURI(params.TextDocument.URI) {
+		return nil, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeInvalidParams,
+			Message: fmt.Sprintf("%s not yet supported for out-of-workspace URI (%q)", req.Method, params.TextDocument.URI),
 		}
+	}
 
-		ast.SortImports(fset, file)
+	unformatted, formatted, err := h.format(ctx, params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(formatted, unformatted) {
+		return nil, nil
+	}
 
-		var buf bytes.Buffer
-		cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
-		err = cfg.Fprint(&buf, fset, file)
-		if err != nil {
-			return nil, err
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, h.FilePath(params.TextDocument.URI), unformatted, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	start, valid, why := offsetForPosition(unformatted, params.Range.Start)
+	if !valid {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("invalid range start: %s", why)}
+	}
+	end, valid, why := offsetForPosition(unformatted, params.Range.End)
+	if !valid {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("invalid range end: %s", why)}
+	}
+
+	tf := fset.File(file.Pos())
+	first, last := enclosingLines(fset, file, tf.Pos(start), tf.Pos(end))
+	return editsInLines(ComputeTextEdits(string(unformatted), string(formatted)), first, last), nil
+}
+
+func (h *LangHandler) handleTextDocumentOnTypeFormatting(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.DocumentOnTypeFormattingParams) ([]lsp.TextEdit, error) {
+	if !util.IsURI(params.TextDocument.URI) {
+		return nil, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeInvalidParams,
+			Message: fmt.Sprintf("%s not yet supported for out-of-workspace URI (%q)", req.Method, params.TextDocument.URI),
+		}
+	}
+
+	unformatted, formatted, err := h.format(ctx, params.TextDocument.URI)
+	if err != nil {
+		// The document is often incomplete while it is being typed, so
+		// there is nothing to format yet.
+		return nil, nil
+	}
+	if bytes.Equal(formatted, unformatted) {
+		return nil, nil
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, h.FilePath(params.TextDocument.URI), unformatted, parser.ParseComments)
+	if err != nil {
+		return nil, nil
+	}
+	offset, valid, why := offsetForPosition(unformatted, params.Position)
+	if !valid {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("invalid position: %s", why)}
+	}
+	tf := fset.File(file.Pos())
+
+	var first, last int
+	switch params.Ch {
+	case "}":
+		// Format the statement or declaration of the block which was
+		// just closed.
+		if offset == 0 || unformatted[offset-1] != '}' {
+			return nil, nil
+		}
+		node := closedNode(file, tf.Pos(offset-1))
+		if node == nil {
+			return nil, nil
+		}
+		first, last = fset.Position(node.Pos()).Line-1, fset.Position(node.End()).Line-1
+
+	case "\n":
+		// Format the statement or declaration of the line which was just
+		// ended, but leave the new line alone: formatting its indentation
+		// away would move the cursor.
+		if params.Position.Line == 0 {
+			return nil, nil
+		}
+		lineStart, _, _ := offsetForPosition(unformatted, lsp.Position{Line: params.Position.Line - 1})
+		if len(bytes.TrimSpace(unformatted[lineStart:offset])) == 0 {
+			return nil, nil
+		}
+		first, last = enclosingLines(fset, file, tf.Pos(lineStart), tf.Pos(offset-1))
+		if last >= params.Position.Line {
+			last = params.Position.Line - 1
+		}
+
+		var edits []lsp.TextEdit
+		for _, edit := range editsInLines(ComputeTextEdits(string(unformatted), string(formatted)), first, last) {
+			if edit.Range.End.Line <= params.Position.Line {
+				edits = append(edits, edit)
+			}
 		}
-		formatted = buf.Bytes()
+		return edits, nil
+
+	default:
+		return nil, nil
+	}
+
+	return editsInLines(ComputeTextEdits(string(unformatted), string(formatted)), first, last), nil
+}
+
+// format returns the contents of the file at uri, and the contents
+// formatted by the configured format tool.
+func (h *LangHandler) format(ctx context.Context, uri lsp.DocumentURI) (unformatted, formatted []byte, err error) {
+	unformatted, err = h.readFile(ctx, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	formatted, err = h.formatter().Format(ctx, h.FilePath(uri), unformatted)
+	if err != nil {
+		return nil, nil, err
+	}
+	return unformatted, formatted, nil
+}
+
+// formatter returns the formatter of the configured format tool, followed
+// by the import organizer if import groups are configured.
+func (h *LangHandler) formatter() Formatter {
+	var f formatters
+	switch h.config.FormatTool {
+	case formatToolGofmt:
+		f = formatters{gofmt{}}
+	case formatToolGofumpt:
+		f = formatters{goimports{localPrefix: h.config.GoimportsLocalPrefix}, gofumpt{}}
 	default: // goimports
-		imports.LocalPrefix = h.config.GoimportsLocalPrefix
+		f = formatters{goimports{localPrefix: h.config.GoimportsLocalPrefix}}
+	}
+	if len(h.config.ImportGroups) > 0 {
+		f = append(f, importOrganizer{groups: h.config.ImportGroups, localPrefix: h.config.GoimportsLocalPrefix})
+	}
+	return f
+}
+
+// Formatter defines an interface for formatting
+type Formatter interface {
+	// Format returns src, the contents of the file filename, formatted.
+	Format(ctx context.Context, filename string, src []byte) ([]byte, error)
+}
+
+// formatters is a Formatter which runs each of its formatters on the
+// output of the previous one.
+type formatters []Formatter
+
+func (f formatters) Format(ctx context.Context, filename string, src []byte) ([]byte, error) {
+	for _, formatter := range f {
 		var err error
-		formatted, err = imports.Process(filename, unformatted, nil)
+		src, err = formatter.Format(ctx, filename, src)
 		if err != nil {
 			return nil, err
 		}
 	}
+	return src, nil
+}
 
-	if bytes.Equal(formatted, unformatted) {
-		return nil, nil
+// gofmt formats like the gofmt command, but without simplifying code.
+type gofmt struct{}
+
+func (gofmt) Format(ctx context.Context, filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
 	}
 
-	return ComputeTextEdits(string(unformatted), string(formatted)), nil
+	ast.SortImports(fset, file)
+
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	err = cfg.Fprint(&buf, fset, file)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// goimports formats like the goimports command, which also adds missing
+// imports and removes unused ones.
+type goimports struct {
+	// localPrefix is the comma-separated list of import path prefixes
+	// whose imports are grouped after the third-party imports.
+	localPrefix string
+}
+
+// goimportsMu guards imports.LocalPrefix, which is global, so that
+// concurrent requests with different local prefixes don't race.
+var goimportsMu sync.Mutex
+
+func (f goimports) Format(ctx context.Context, filename string, src []byte) ([]byte, error) {
+	goimportsMu.Lock()
+	defer goimportsMu.Unlock()
+	imports.LocalPrefix = f.localPrefix
+	return imports.Process(filename, src, nil)
+}
+
+// gofumpt formats like the gofumpt command, a stricter gofmt.
+type gofumpt struct{}
+
+func (gofumpt) Format(ctx context.Context, filename string, src []byte) ([]byte, error) {
+	return gofumptformat.Source(src, gofumptformat.Options{})
+}
+
+// enclosingLines returns the first and last lines (zero-based) of the
+// complete statements or declarations which enclose the source from start
+// to end.
+func enclosingLines(fset *token.FileSet, file *ast.File, start, end token.Pos) (first, last int) {
+	path, _ := astutil.PathEnclosingInterval(file, start, end)
+	for _, node := range path {
+		var children []ast.Node
+		switch node := node.(type) {
+		case *ast.File:
+			for _, decl := range node.Decls {
+				children = append(children, decl)
+			}
+		case *ast.BlockStmt:
+			for _, stmt := range node.List {
+				children = append(children, stmt)
+			}
+		case *ast.CaseClause:
+			for _, stmt := range node.Body {
+				children = append(children, stmt)
+			}
+		case *ast.CommClause:
+			for _, stmt := range node.Body {
+				children = append(children, stmt)
+			}
+		case ast.Stmt, ast.Decl:
+			return fset.Position(node.Pos()).Line - 1, fset.Position(node.End()).Line - 1
+		default:
+			continue
+		}
+
+		// A selection of several statements or declarations of a block
+		// only extends to the ones it overlaps.
+		var from, to ast.Node
+		for _, child := range children {
+			if child.Pos() <= end && child.End() >= start {
+				if from == nil {
+					from = child
+				}
+				to = child
+			}
+		}
+		if from != nil {
+			return fset.Position(from.Pos()).Line - 1, fset.Position(to.End()).Line - 1
+		}
+	}
+	return fset.Position(start).Line - 1, fset.Position(end).Line - 1
+}
+
+// closedNode returns the statement or declaration closed by the closing
+// brace at rbrace, or nil if there is none.
+func closedNode(file *ast.File, rbrace token.Pos) ast.Node {
+	path, _ := astutil.PathEnclosingInterval(file, rbrace, rbrace+1)
+	for i, node := range path {
+		if node.End() != rbrace+1 {
+			continue
+		}
+		// node is closed by the brace, such as a block, a composite
+		// literal or a struct type. A block is only a statement of its
+		// own in a list of statements; otherwise it is the body of the
+		// statement or declaration to format.
+		for j := i; j < len(path); j++ {
+			switch n := path[j].(type) {
+			case *ast.BlockStmt:
+				if j+1 < len(path) {
+					switch path[j+1].(type) {
+					case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+						return n
+					}
+				}
+			case ast.Stmt, ast.Decl:
+				return n
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// editsInLines returns the edits of the lines from first to last
+// (zero-based). Edits replacing lines one for one are split into an edit
+// per line, so lines outside of the range are left alone.
+func editsInLines(edits []lsp.TextEdit, first, last int) []lsp.TextEdit {
+	var inLines []lsp.TextEdit
+	for _, edit := range edits {
+		lines := strings.SplitAfter(edit.NewText, "\n")
+		lines = lines[:len(lines)-1]
+		if edit.Range.End.Line-edit.Range.Start.Line == len(lines) && len(lines) > 1 {
+			for i, line := range lines {
+				l := edit.Range.Start.Line + i
+				if l >= first && l <= last {
+					inLines = append(inLines, lsp.TextEdit{
+						Range:   lsp.Range{Start: lsp.Position{Line: l}, End: lsp.Position{Line: l + 1}},
+						NewText: line,
+					})
+				}
+			}
+			continue
+		}
+		if edit.Range.Start.Line <= last && (edit.Range.End.Line > first || edit.Range.Start.Line >= first) {
+			inLines = append(inLines, edit)
+		}
+	}
+	return inLines
 }
 
 // ComputeTextEdits computes text edits that are required to
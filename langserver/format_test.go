@@ -1,10 +1,12 @@
 package langserver
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
 	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
 )
 
 type computeTextEditsTestCase struct {
@@ -52,3 +54,130 @@ func TestComputeEdits(t *testing.T) {
 func toTextEdit(r lsp.Range, t string) lsp.TextEdit {
 	return lsp.TextEdit{Range: r, NewText: t}
 }
+
+const formatRangeSrc = `package p
+
+import "fmt"
+
+func f() {
+x:=1
+	if x>0 {
+	fmt.Println( x )
+	}
+	y  :=  2
+	_ = y
+}
+
+var  v  =  1
+`
+
+func TestRangeFormatting(t *testing.T) {
+	h, _ := newHoverTestHandler(t, formatRangeSrc)
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		rng  lsp.Range
+		want []lsp.TextEdit
+	}{
+		"statement": {
+			rng:  toRange(9, 2, 9, 3),
+			want: []lsp.TextEdit{toTextEdit(toRange(9, 0, 10, 0), "\ty := 2\n")},
+		},
+		"statement in block": {
+			rng:  toRange(7, 3, 7, 3),
+			want: []lsp.TextEdit{toTextEdit(toRange(7, 0, 8, 0), "\t\tfmt.Println(x)\n")},
+		},
+		"statements": {
+			rng: toRange(5, 1, 6, 2),
+			want: []lsp.TextEdit{
+				toTextEdit(toRange(5, 0, 6, 0), "\tx := 1\n"),
+				toTextEdit(toRange(6, 0, 7, 0), "\tif x > 0 {\n"),
+				toTextEdit(toRange(7, 0, 8, 0), "\t\tfmt.Println(x)\n"),
+			},
+		},
+		"declaration": {
+			rng:  toRange(13, 0, 13, 1),
+			want: []lsp.TextEdit{toTextEdit(toRange(13, 0, 14, 0), "var v = 1\n")},
+		},
+		"formatted": {
+			rng: toRange(10, 1, 10, 6),
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			edits, err := h.handleTextDocumentRangeFormatting(ctx, nil, &jsonrpc2.Request{Method: "textDocument/rangeFormatting"}, lsp.DocumentRangeFormattingParams{
+				TextDocument: lsp.TextDocumentIdentifier{URI: "file:///src/p/a.go"},
+				Range:        test.rng,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(edits, test.want) {
+				t.Errorf("got edits %q, want %q", edits, test.want)
+			}
+		})
+	}
+}
+
+func TestOnTypeFormatting(t *testing.T) {
+	tests := map[string]struct {
+		src  string
+		pos  lsp.Position
+		ch   string
+		want []lsp.TextEdit
+	}{
+		"closed block": {
+			src: formatRangeSrc,
+			pos: lsp.Position{Line: 8, Character: 2},
+			ch:  "}",
+			want: []lsp.TextEdit{
+				toTextEdit(toRange(6, 0, 7, 0), "\tif x > 0 {\n"),
+				toTextEdit(toRange(7, 0, 8, 0), "\t\tfmt.Println(x)\n"),
+			},
+		},
+		"closed func": {
+			src: formatRangeSrc,
+			pos: lsp.Position{Line: 11, Character: 1},
+			ch:  "}",
+			want: []lsp.TextEdit{
+				toTextEdit(toRange(5, 0, 6, 0), "\tx := 1\n"),
+				toTextEdit(toRange(6, 0, 7, 0), "\tif x > 0 {\n"),
+				toTextEdit(toRange(7, 0, 8, 0), "\t\tfmt.Println(x)\n"),
+				toTextEdit(toRange(9, 0, 10, 0), "\ty := 2\n"),
+			},
+		},
+		"not a brace": {
+			src: formatRangeSrc,
+			pos: lsp.Position{Line: 9, Character: 2},
+			ch:  "}",
+		},
+		// The indentation of the new line stays.
+		"newline": {
+			src:  "package p\n\nfunc f() {\n\ty  :=  2\n\t\n\t_ = y\n}\n",
+			pos:  lsp.Position{Line: 4, Character: 1},
+			ch:   "\n",
+			want: []lsp.TextEdit{toTextEdit(toRange(3, 0, 4, 0), "\ty := 2\n")},
+		},
+		"incomplete": {
+			src: "package p\n\nfunc f() {\n\ty  :=  2\n\t\n",
+			pos: lsp.Position{Line: 4, Character: 1},
+			ch:  "\n",
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			h, _ := newHoverTestHandler(t, test.src)
+			edits, err := h.handleTextDocumentOnTypeFormatting(context.Background(), nil, &jsonrpc2.Request{Method: "textDocument/onTypeFormatting"}, lsp.DocumentOnTypeFormattingParams{
+				TextDocument: lsp.TextDocumentIdentifier{URI: "file:///src/p/a.go"},
+				Position:     test.pos,
+				Ch:           test.ch,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(edits, test.want) {
+				t.Errorf("got edits %q, want %q", edits, test.want)
+			}
+		})
+	}
+}
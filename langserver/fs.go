@@ -118,6 +118,20 @@ func (h *overlay) FS() ctxvfs.FileSystem {
 	return ctxvfs.Sync(&h.mu, ctxvfs.Map(h.m))
 }
 
+// files returns a copy of the overlay contents keyed by absolute file path.
+func (h *overlay) files() map[string][]byte {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	files := make(map[string][]byte, len(h.m))
+	for path, contents := range h.m {
+		files["/"+path] = contents
+	}
+	return files
+}
+
 func (h *overlay) didOpen(params *lsp.DidOpenTextDocumentParams) {
 	h.set(params.TextDocument.URI, []byte(params.TextDocument.Text))
 }
@@ -0,0 +1,158 @@
+package langserver
+
+import "math"
+
+// Scores used by fuzzyMatch, which are modelled after fzf's. A matched
+// character is worth fuzzyMatchScore plus a bonus if it starts a word in
+// the candidate or continues the previous match. The bonus of the first
+// character of the pattern counts double. A gap between matched characters
+// costs fuzzyGapOpenPenalty plus fuzzyGapExtendPenalty for every skipped
+// character after the first.
+const (
+	fuzzyMatchScore          = 16
+	fuzzyBoundaryBonus       = 8
+	fuzzyCamelBonus          = 7
+	fuzzyContiguousBonus     = 4
+	fuzzyFirstCharMultiplier = 2
+	fuzzyGapOpenPenalty      = 3
+	fuzzyGapExtendPenalty    = 1
+)
+
+// fuzzyMaxInline is the longest candidate fuzzyMatch scores without
+// allocating.
+const fuzzyMaxInline = 64
+
+// fuzzyNoMatch marks positions which can't be part of a match.
+const fuzzyNoMatch = math.MinInt32
+
+// fuzzyMatch reports whether the characters of pattern, which must be
+// lower case, occur in order in candidate, ignoring case. If so, it
+// returns a positive score which is higher the better the match is. Matches
+// at the start of words, eg the S in HTTPServer or the r in new_reader, and
+// runs of contiguous characters score higher, so "hsrv" matches
+// HTTPServer better than HandlerSetReverse.
+func fuzzyMatch(pattern, candidate string) (int, bool) {
+	if len(pattern) == 0 || len(pattern) > len(candidate) {
+		return 0, false
+	}
+
+	// Every match lies between the first occurrence of the first
+	// character of pattern and the last occurrence of its last character.
+	// Finding them also quickly rejects most candidates which don't match.
+	start, end := -1, len(candidate)
+	for i, j := 0, 0; i < len(pattern); i, j = i+1, j+1 {
+		for j < len(candidate) && lower(candidate[j]) != pattern[i] {
+			j++
+		}
+		if j == len(candidate) {
+			return 0, false
+		}
+		if start == -1 {
+			start = j
+		}
+	}
+	for end > start && lower(candidate[end-1]) != pattern[len(pattern)-1] {
+		end--
+	}
+
+	// match[j] is the best score of pattern[:i+1] where pattern[i]
+	// matches candidate[j], or fuzzyNoMatch if there is no such match.
+	// prev is the same for pattern[:i].
+	n := len(candidate)
+	var buf [2 * fuzzyMaxInline]int
+	rows := buf[:]
+	if n > fuzzyMaxInline {
+		rows = make([]int, 2*n)
+	}
+	prev, match := rows[:n], rows[n:2*n]
+
+	for i := 0; i < len(pattern); i++ {
+		// gap is the best score of pattern[:i] followed by a gap
+		// which ends just before candidate[j].
+		gap := fuzzyNoMatch
+		for j := start; j < end; j++ {
+			if i > 0 && j-2 >= start {
+				if gap != fuzzyNoMatch {
+					gap -= fuzzyGapExtendPenalty
+				}
+				if prev[j-2] != fuzzyNoMatch && prev[j-2]-fuzzyGapOpenPenalty > gap {
+					gap = prev[j-2] - fuzzyGapOpenPenalty
+				}
+			}
+
+			match[j] = fuzzyNoMatch
+			if j < start+i || lower(candidate[j]) != pattern[i] {
+				continue
+			}
+			bonus := fuzzyBonus(candidate, j)
+			if i == 0 {
+				match[j] = fuzzyMatchScore + fuzzyFirstCharMultiplier*bonus
+				continue
+			}
+			best := fuzzyNoMatch
+			if gap != fuzzyNoMatch {
+				best = gap + fuzzyMatchScore + bonus
+			}
+			if prev[j-1] != fuzzyNoMatch {
+				if bonus < fuzzyContiguousBonus {
+					bonus = fuzzyContiguousBonus
+				}
+				if s := prev[j-1] + fuzzyMatchScore + bonus; s > best {
+					best = s
+				}
+			}
+			match[j] = best
+		}
+		prev, match = match, prev
+	}
+
+	best := fuzzyNoMatch
+	for _, s := range prev[start:end] {
+		if s > best {
+			best = s
+		}
+	}
+	if best == fuzzyNoMatch {
+		return 0, false
+	}
+	if best < 1 {
+		// A match must be worth something, even if it has many gaps.
+		best = 1
+	}
+	return best, true
+}
+
+// fuzzyBonus returns the bonus for matching candidate[j], which depends on
+// whether it starts a word.
+func fuzzyBonus(candidate string, j int) int {
+	if j == 0 {
+		return fuzzyBoundaryBonus
+	}
+	c, prev := candidate[j], candidate[j-1]
+	switch {
+	case prev == '_' && c != '_':
+		// new_reader
+		return fuzzyBoundaryBonus
+	case isUpper(c) && !isUpper(prev):
+		// NewReader
+		return fuzzyCamelBonus
+	case isUpper(c) && j+1 < len(candidate) && isLower(candidate[j+1]) && isUpper(prev):
+		// HTTPServer
+		return fuzzyCamelBonus
+	case isDigit(c) && !isDigit(prev):
+		// Base64
+		return fuzzyCamelBonus
+	}
+	return 0
+}
+
+func lower(c byte) byte {
+	if isUpper(c) {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func isUpper(c byte) bool { return 'A' <= c && c <= 'Z' }
+func isLower(c byte) bool { return 'a' <= c && c <= 'z' }
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
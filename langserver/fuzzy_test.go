@@ -0,0 +1,116 @@
+package langserver
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		pattern, candidate string
+		match              bool
+	}{
+		{"hsrv", "HTTPServer", true},
+		{"nwrdr", "NewReader", true},
+		{"newreader", "NewReader", true},
+		{"nr", "new_reader", true},
+		{"b64", "Base64Encoding", true},
+		{"reader", "NewReader", true},
+		{"rn", "NewReader", false},
+		{"readers", "Reader", false},
+		{"", "Reader", false},
+	}
+	for _, test := range tests {
+		_, match := fuzzyMatch(test.pattern, test.candidate)
+		if match != test.match {
+			t.Errorf("fuzzyMatch(%q, %q) matched %v, want %v", test.pattern, test.candidate, match, test.match)
+		}
+	}
+
+	long := fmt.Sprintf("%0100d", 0) + "NewReader"
+	if _, match := fuzzyMatch("nwrdr", long); !match {
+		t.Errorf("fuzzyMatch(%q, %q) did not match", "nwrdr", long)
+	}
+}
+
+func TestFuzzyMatch_ranking(t *testing.T) {
+	// Each pattern should match the candidates strictly better in the
+	// order they are listed.
+	tests := []struct {
+		pattern    string
+		candidates []string
+	}{
+		{"hsrv", []string{"HTTPServer", "HandlerSetReverse"}},
+		{"nwrdr", []string{"NewReader", "NewWriterReader", "unwrappedReader"}},
+		{"rdr", []string{"Reader", "ForwardedRequest"}},
+		{"srv", []string{"Serve", "observeRaw"}},
+		{"reader", []string{"Reader", "NewReader", "ReadHeader"}},
+	}
+	for _, test := range tests {
+		scores := make([]int, len(test.candidates))
+		for i, c := range test.candidates {
+			s, ok := fuzzyMatch(test.pattern, c)
+			if !ok {
+				t.Errorf("fuzzyMatch(%q, %q) did not match", test.pattern, c)
+			}
+			scores[i] = s
+		}
+		for i := 1; i < len(scores); i++ {
+			if scores[i] >= scores[i-1] {
+				t.Errorf("fuzzyMatch(%q, ...) ranked %v with scores %v", test.pattern, test.candidates, scores)
+				break
+			}
+		}
+	}
+}
+
+// benchmarkSymbols returns n symbols with names like the ones found in real
+// code.
+func benchmarkSymbols(n int) []symbolPair {
+	words := []string{"New", "Reader", "Writer", "HTTP", "Server", "Conn", "Handler", "Request", "Response", "Buffer", "Get", "Set", "Close", "Open", "File", "Path", "URL", "Context", "Error", "String"}
+	syms := make([]symbolPair, n)
+	for i := range syms {
+		name := words[i%len(words)] + words[(i/len(words))%len(words)] + words[(i/7)%len(words)]
+		syms[i] = symbolPair{SymbolInformation: lsp.SymbolInformation{
+			Name:          name,
+			ContainerName: words[(i/3)%len(words)],
+			Kind:          lsp.SKFunction,
+			Location:      lsp.Location{URI: lsp.DocumentURI(fmt.Sprintf("file:///src/pkg%d/file.go", i%50))},
+		}}
+	}
+	return syms
+}
+
+func BenchmarkFuzzyMatch(b *testing.B) {
+	syms := benchmarkSymbols(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, s := range syms {
+			fuzzyMatch("nwrdr", s.Name)
+		}
+	}
+}
+
+// BenchmarkResultSorter measures ranking 5000 symbols, which should take at
+// most a few milliseconds.
+func BenchmarkResultSorter(b *testing.B) {
+	syms := benchmarkSymbols(5000)
+	for _, query := range []string{"hsrv", "reader", "nwrdr.conn", "func srvh"} {
+		b.Run(query, func(b *testing.B) {
+			q := ParseQuery(query)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				results := resultSorter{Query: q}
+				for _, s := range syms {
+					results.Collect(s)
+				}
+				sort.Sort(&results)
+			}
+		})
+	}
+}
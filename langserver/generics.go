@@ -0,0 +1,79 @@
+package langserver
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+)
+
+// originObject returns the generic object obj was instantiated from, or obj
+// itself if it is not part of an instantiation. The methods and fields of an
+// instantiated type are distinct objects from those of the generic type, but
+// they share its declaration.
+func originObject(obj types.Object) types.Object {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return obj.Origin()
+	case *types.Var:
+		return obj.Origin()
+	}
+	return obj
+}
+
+// usesTypeParams reports whether the Go source src declares type parameters
+// or explicitly instantiates a generic function or type with more than one
+// type argument. Instantiations with a single type argument look like index
+// expressions, so they can't be detected without typechecking.
+func usesTypeParams(filename string, src []byte) bool {
+	// Best effort: on syntax errors f still contains what could be parsed.
+	f, _ := parser.ParseFile(token.NewFileSet(), filename, src, parser.SkipObjectResolution)
+	if f == nil {
+		return false
+	}
+
+	found := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncType:
+			found = found || n.TypeParams != nil
+		case *ast.TypeSpec:
+			found = found || n.TypeParams != nil
+		case *ast.IndexListExpr:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// instanceString returns the declaration of the instantiation of the generic
+// function or type obj described by inst, eg "func Map[int, string](xs
+// []int, f func(int) string) []string" or "List[int]".
+func instanceString(obj types.Object, inst types.Instance, qf types.Qualifier) string {
+	var b bytes.Buffer
+	switch obj.(type) {
+	case *types.Func:
+		b.WriteString("func ")
+		b.WriteString(obj.Name())
+		writeTypeArgs(&b, inst.TypeArgs, qf)
+		if sig, ok := inst.Type.(*types.Signature); ok {
+			types.WriteSignature(&b, sig, qf)
+		}
+	default:
+		types.WriteType(&b, inst.Type, qf)
+	}
+	return b.String()
+}
+
+func writeTypeArgs(b *bytes.Buffer, args *types.TypeList, qf types.Qualifier) {
+	b.WriteByte('[')
+	for i := 0; i < args.Len(); i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		types.WriteType(b, args.At(i), qf)
+	}
+	b.WriteByte(']')
+}
@@ -0,0 +1,207 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/go-lsp/lspext"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// goSrcScheme is the scheme of the URIs of read-only files outside of the
+// workspace, which clients can't open by their file URI (eg because the
+// files are in our VFS). The host of a go-src URI names the directory the
+// path is relative to:
+//
+//	go-src://goroot/src/net/http/server.go     in GOROOT
+//	go-src://modcache/golang.org/x/net@v0.1.0/html/node.go  in the module cache
+//	go-src://gopath/src/github.com/a/b/b.go    in the first GOPATH entry
+//
+// The contents of the files are fetched with textDocument/content.
+const goSrcScheme = "go-src"
+
+// goSrcRoot is a directory which go-src URIs refer to files in.
+type goSrcRoot struct {
+	host, dir string
+}
+
+// goSrcRoots returns the directories go-src URIs refer to files in, in the
+// order a path is matched against them.
+func (h *LangHandler) goSrcRoots(ctx context.Context) []goSrcRoot {
+	bctx := h.BuildContext(ctx)
+	roots := []goSrcRoot{{host: "goroot", dir: filepath.ToSlash(bctx.GOROOT)}}
+	gopath := filepath.SplitList(bctx.GOPATH)
+	if modcache := os.Getenv("GOMODCACHE"); modcache != "" {
+		roots = append(roots, goSrcRoot{host: "modcache", dir: filepath.ToSlash(modcache)})
+	} else if len(gopath) > 0 {
+		roots = append(roots, goSrcRoot{host: "modcache", dir: path.Join(filepath.ToSlash(gopath[0]), "pkg/mod")})
+	}
+	if len(gopath) > 0 {
+		roots = append(roots, goSrcRoot{host: "gopath", dir: filepath.ToSlash(gopath[0])})
+	}
+	return roots
+}
+
+func isGoSrcURI(uri lsp.DocumentURI) bool {
+	return strings.HasPrefix(string(uri), goSrcScheme+"://")
+}
+
+// fileURIForGoSrc returns the file URI of the go-src URI uri.
+func (h *LangHandler) fileURIForGoSrc(ctx context.Context, uri lsp.DocumentURI) (lsp.DocumentURI, error) {
+	u, err := url.Parse(string(uri))
+	if err != nil {
+		return "", err
+	}
+	for _, root := range h.goSrcRoots(ctx) {
+		if root.host != u.Host || root.dir == "" {
+			continue
+		}
+		p := path.Join(root.dir, u.Path)
+		if !util.PathHasPrefix(p, root.dir) {
+			return "", fmt.Errorf("invalid %s URI %q: path is outside of %s", goSrcScheme, uri, root.host)
+		}
+		return util.PathToURI(p), nil
+	}
+	return "", fmt.Errorf("invalid %s URI %q: unknown root %q", goSrcScheme, uri, u.Host)
+}
+
+// goSrcURIForFile returns the go-src URI of the file URI uri if it refers to
+// a file outside of the workspace which a go-src URI can refer to.
+// Otherwise it returns uri.
+func (h *LangHandler) goSrcURIForFile(ctx context.Context, uri lsp.DocumentURI) lsp.DocumentURI {
+	if !util.IsURI(uri) {
+		return uri
+	}
+	p := util.UriToPath(uri)
+	if util.PathHasPrefix(p, h.FilePath(h.init.Root())) {
+		return uri
+	}
+	for _, root := range h.goSrcRoots(ctx) {
+		if root.dir == "" || !util.PathHasPrefix(p, root.dir) {
+			continue
+		}
+		u := url.URL{Scheme: goSrcScheme, Host: root.host, Path: "/" + util.PathTrimPrefix(p, root.dir)}
+		return lsp.DocumentURI(u.String())
+	}
+	return uri
+}
+
+// rewriteGoSrcParams replaces the go-src URIs in the params of req by file
+// URIs, so handlers only ever deal with file URIs.
+func (h *LangHandler) rewriteGoSrcParams(ctx context.Context, req *jsonrpc2.Request) error {
+	if req.Params == nil || !bytes.Contains(*req.Params, []byte(goSrcScheme+"://")) {
+		return nil
+	}
+	var params interface{}
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return err
+	}
+	var walkErr error
+	lspext.WalkURIFields(params, nil, func(uri lsp.DocumentURI) lsp.DocumentURI {
+		if !isGoSrcURI(uri) {
+			return uri
+		}
+		fileURI, err := h.fileURIForGoSrc(ctx, uri)
+		if err != nil {
+			walkErr = err
+			return uri
+		}
+		return fileURI
+	})
+	if walkErr != nil {
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: walkErr.Error()}
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req.Params = (*json.RawMessage)(&b)
+	return nil
+}
+
+// rewriteGoSrcResult replaces the file URIs of files outside of the
+// workspace in result by go-src URIs.
+func (h *LangHandler) rewriteGoSrcResult(ctx context.Context, result interface{}) (interface{}, error) {
+	// WalkURIFields can only update the URIs of JSON values.
+	b, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	update := func(uri lsp.DocumentURI) lsp.DocumentURI {
+		return h.goSrcURIForFile(ctx, uri)
+	}
+	lspext.WalkURIFields(v, nil, update)
+	walkChangesURIs(v, update)
+	return v, nil
+}
+
+// walkChangesURIs replaces the keys of the changes of the workspace edits in
+// the JSON value v, which are document URIs that WalkURIFields doesn't
+// visit, by the result of update.
+func walkChangesURIs(v interface{}, update func(lsp.DocumentURI) lsp.DocumentURI) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if changes, ok := e.(map[string]interface{}); ok && k == "changes" {
+				updated := make(map[string]interface{}, len(changes))
+				for uri, edits := range changes {
+					updated[string(update(lsp.DocumentURI(uri)))] = edits
+				}
+				v[k] = updated
+				continue
+			}
+			walkChangesURIs(e, update)
+		}
+	case []interface{}:
+		for _, e := range v {
+			walkChangesURIs(e, update)
+		}
+	}
+}
+
+// handleTextDocumentContent returns the contents of a document, which is
+// how clients read the documents of go-src URIs.
+func (h *LangHandler) handleTextDocumentContent(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lspext.ContentParams) (*lsp.TextDocumentItem, error) {
+	if !util.IsURI(params.TextDocument.URI) {
+		return nil, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeInvalidParams,
+			Message: fmt.Sprintf("textDocument/content not supported for URI (%q)", params.TextDocument.URI),
+		}
+	}
+
+	// Only serve the files a client could have gotten a URI of.
+	p := h.FilePath(params.TextDocument.URI)
+	readable := util.PathHasPrefix(p, h.FilePath(h.init.Root()))
+	for _, root := range h.goSrcRoots(ctx) {
+		readable = readable || root.dir != "" && util.PathHasPrefix(p, root.dir)
+	}
+	if !readable {
+		return nil, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeInvalidParams,
+			Message: fmt.Sprintf("textDocument/content not supported for file outside of the workspace, GOROOT and GOPATH (%q)", params.TextDocument.URI),
+		}
+	}
+
+	contents, err := h.readFile(ctx, params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return &lsp.TextDocumentItem{
+		URI:        params.TextDocument.URI,
+		LanguageID: "go",
+		Text:       string(contents),
+	}, nil
+}
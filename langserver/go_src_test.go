@@ -0,0 +1,172 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/go-lsp/lspext"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestGoSrcURIs(t *testing.T) {
+	t.Setenv("GOMODCACHE", "")
+	h := newGoSrcTestHandler()
+	ctx := context.Background()
+
+	tests := []struct {
+		goSrc, file lsp.DocumentURI
+	}{
+		{"go-src://goroot/src/net/http/server.go", "file:///goroot/src/net/http/server.go"},
+		{"go-src://modcache/golang.org/x/net@v0.1.0/html/node.go", "file:///gopath/pkg/mod/golang.org/x/net@v0.1.0/html/node.go"},
+		{"go-src://gopath/src/github.com/a/b/b.go", "file:///gopath/src/github.com/a/b/b.go"},
+	}
+	for _, test := range tests {
+		file, err := h.fileURIForGoSrc(ctx, test.goSrc)
+		if err != nil {
+			t.Errorf("fileURIForGoSrc(%q): %s", test.goSrc, err)
+		} else if file != test.file {
+			t.Errorf("fileURIForGoSrc(%q) = %q, want %q", test.goSrc, file, test.file)
+		}
+		if goSrc := h.goSrcURIForFile(ctx, test.file); goSrc != test.goSrc {
+			t.Errorf("goSrcURIForFile(%q) = %q, want %q", test.file, goSrc, test.goSrc)
+		}
+	}
+
+	// Workspace files keep their file URIs, even though the workspace is
+	// in GOPATH.
+	if uri := h.goSrcURIForFile(ctx, "file:///gopath/src/p/a.go"); uri != "file:///gopath/src/p/a.go" {
+		t.Errorf("got URI %q for a workspace file, want its file URI", uri)
+	}
+
+	// The changes of workspace edits are keyed by URI.
+	result, err := h.rewriteGoSrcResult(ctx, &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
+		"file:///goroot/src/net/http/server.go": {{NewText: "x"}},
+		"file:///gopath/src/p/a.go":             {{NewText: "y"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(result)
+	var edit lsp.WorkspaceEdit
+	if err := json.Unmarshal(b, &edit); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]lsp.TextEdit{
+		"go-src://goroot/src/net/http/server.go": {{NewText: "x"}},
+		"file:///gopath/src/p/a.go":              {{NewText: "y"}},
+	}; !reflect.DeepEqual(edit.Changes, want) {
+		t.Errorf("got changes %v, want %v", edit.Changes, want)
+	}
+
+	for _, uri := range []lsp.DocumentURI{"go-src://goroot/../etc/passwd", "go-src://goroot/src/../../etc/passwd", "go-src://unknown/a.go"} {
+		if file, err := h.fileURIForGoSrc(ctx, uri); err == nil {
+			t.Errorf("fileURIForGoSrc(%q) = %q, want error", uri, file)
+		}
+	}
+}
+
+func TestHandle_goSrcURIs(t *testing.T) {
+	h := newGoSrcTestHandler()
+	h.config.GoSrcURIsEnabled = true
+	ctx := context.Background()
+
+	call := func(method string, params interface{}, result interface{}) error {
+		b, err := json.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := h.Handle(ctx, nil, &jsonrpc2.Request{Method: method, Params: (*json.RawMessage)(&b)})
+		if err != nil {
+			return err
+		}
+		b, err = json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return json.Unmarshal(b, result)
+	}
+	definition := func(uri lsp.DocumentURI, line, character int) []lsp.Location {
+		var locs []lsp.Location
+		if err := call("textDocument/definition", lsp.TextDocumentPositionParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+			Position:     lsp.Position{Line: line, Character: character},
+		}, &locs); err != nil {
+			t.Fatal(err)
+		}
+		return locs
+	}
+
+	// A definition in GOROOT is returned by its go-src URI.
+	want := []lsp.Location{{
+		URI:   "go-src://goroot/src/net/http/server.go",
+		Range: lsp.Range{Start: lsp.Position{Line: 3, Character: 5}, End: lsp.Position{Line: 3, Character: 11}},
+	}}
+	if got := definition("file:///gopath/src/p/a.go", 4, 12); !reflect.DeepEqual(got, want) {
+		t.Errorf("got definition %+v, want %+v", got, want)
+	}
+
+	// Requests on the go-src document work too, so navigation can go on
+	// from there.
+	want = []lsp.Location{{
+		URI:   "go-src://goroot/src/net/http/server.go",
+		Range: lsp.Range{Start: lsp.Position{Line: 7, Character: 5}, End: lsp.Position{Line: 7, Character: 12}},
+	}}
+	if got := definition("go-src://goroot/src/net/http/server.go", 4, 9); !reflect.DeepEqual(got, want) {
+		t.Errorf("got definition %+v, want %+v", got, want)
+	}
+
+	var item lsp.TextDocumentItem
+	if err := call("textDocument/content", lspext.ContentParams{TextDocument: lsp.TextDocumentIdentifier{URI: "go-src://goroot/src/net/http/server.go"}}, &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.URI != "go-src://goroot/src/net/http/server.go" || item.LanguageID != "go" || item.Text != goSrcTestServer {
+		t.Errorf("got content %+v, want the text of server.go", item)
+	}
+
+	// Only the files of the workspace and the go-src roots can be read.
+	if err := call("textDocument/content", lspext.ContentParams{TextDocument: lsp.TextDocumentIdentifier{URI: "file:///etc/passwd"}}, &item); err == nil {
+		t.Error("got content of a file outside of the workspace and go-src roots, want error")
+	}
+	if err := call("textDocument/content", lspext.ContentParams{TextDocument: lsp.TextDocumentIdentifier{URI: "go-src://goroot/../etc/passwd"}}, &item); err == nil {
+		t.Error("got content of an invalid go-src URI, want error")
+	}
+}
+
+const goSrcTestServer = `package http
+
+// A Server serves HTTP.
+type Server struct {
+	Handler Handler
+}
+
+type Handler interface{}
+`
+
+// newGoSrcTestHandler returns a handler for a workspace with the package p
+// in GOPATH, which imports net/http from GOROOT.
+func newGoSrcTestHandler() *LangHandler {
+	fs := NewAtomicFS()
+	fs.Bind("/goroot/src", ctxvfs.Map(map[string][]byte{
+		"net/http/server.go": []byte(goSrcTestServer),
+	}), "/", ctxvfs.BindReplace)
+	fs.Bind("/gopath/src", ctxvfs.Map(map[string][]byte{
+		"p/a.go": []byte("package p\n\nimport \"net/http\"\n\nvar s http.Server\n"),
+	}), "/", ctxvfs.BindAfter)
+	cfg := NewDefaultConfig()
+	cfg.UseBinaryPkgCache = false
+	h := &LangHandler{
+		HandlerShared: &HandlerShared{FS: fs},
+		init: &InitializeParams{
+			InitializeParams: lsp.InitializeParams{RootURI: "file:///gopath/src/p"},
+			RootImportPath:   "p",
+			BuildContext:     &InitializeBuildContextParams{GOOS: "linux", GOARCH: "amd64", GOPATH: "/gopath", GOROOT: "/goroot", Compiler: "gc"},
+		},
+		config: &cfg,
+	}
+	h.resetCaches(false)
+	return h
+}
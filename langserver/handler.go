@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -65,6 +66,10 @@ type LangHandler struct {
 	symbolCache      cache
 	diagnosticsCache *diagnosticsCache
 
+	// externalSymbols are the indexes of the symbols outside of the
+	// workspace, keyed by ScopeStd or ScopeDeps.
+	externalSymbols map[SymbolScope]*externalSymbols
+
 	// linter is the linter implementation that will be used.
 	// linter can be nil.
 	linter Linter
@@ -77,6 +82,23 @@ type LangHandler struct {
 
 	cancel *cancel
 
+	// onOSFileSystem is true if the workspace is on the OS file system,
+	// which go/packages needs to load its packages. See packageLoader.
+	onOSFileSystem bool
+
+	// packagesFallbackOnce logs the first time go/packages fails to load
+	// packages and we fall back to go/loader. It is a pointer for the
+	// same reason as importGraphOnce.
+	packagesFallbackOnce *sync.Once
+
+	// progress tracks the work done progresses we reported to the client.
+	progress *progress
+
+	// typecheckStarted is true once the first typecheck after initialize
+	// has started. We only report progress for the first typecheck, since
+	// it is usually the only one which is slow.
+	typecheckStarted bool
+
 	// DefaultConfig is the default values used for configuration. It is
 	// combined with InitializationOptions after initialize. This should be
 	// set by LangHandler creators. Please read config instead.
@@ -85,6 +107,11 @@ type LangHandler struct {
 	// config is the language handler configuration. It is a combination of
 	// DefaultConfig and InitializationOptions.
 	config *Config // pointer so we panic if someone reads before we set it.
+
+	// buildConfig is the active build configuration. It starts out as the
+	// one selected by config, but can be switched at runtime with the
+	// go.setBuildConfiguration command.
+	buildConfig BuildConfiguration
 }
 
 // reset clears all internal state in h.
@@ -114,8 +141,20 @@ func (h *LangHandler) reset(init *InitializeParams) error {
 	}
 	config := h.DefaultConfig.Apply(init.InitializationOptions)
 	h.config = &config
+	h.buildConfig = config.buildConfiguration()
 	h.init = init
+	h.onOSFileSystem = false
+	if !init.NoOSFileSystemAccess {
+		if fi, err := os.Stat(h.RootFSPath); err == nil && fi.IsDir() {
+			h.onOSFileSystem = true
+		} else {
+			log.Printf("%s is not on the file system, loading packages with go/loader instead of go/packages", h.RootFSPath)
+		}
+	}
+	h.packagesFallbackOnce = &sync.Once{}
 	h.cancel = &cancel{}
+	h.progress = &progress{}
+	h.typecheckStarted = false
 	h.resetCaches(false)
 	return nil
 }
@@ -144,6 +183,9 @@ func (h *LangHandler) resetCaches(lock bool) {
 		h.diagnosticsCache = newDiagnosticsCache()
 	}
 
+	// Indexes which are still being built are abandoned.
+	h.externalSymbols = nil
+
 	if lock {
 		h.mu.Unlock()
 	}
@@ -203,6 +245,21 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 		defer cancel()
 	}
 
+	// Handlers only deal with file URIs, so go-src URIs are translated
+	// here, on their way in and out.
+	if req.Method != "initialize" {
+		if err := h.rewriteGoSrcParams(ctx, req); err != nil {
+			return nil, err
+		}
+		if h.config.GoSrcURIsEnabled {
+			defer func() {
+				if err == nil && result != nil {
+					result, err = h.rewriteGoSrcResult(ctx, result)
+				}
+			}()
+		}
+	}
+
 	switch req.Method {
 	case "initialize":
 		if h.init != nil {
@@ -227,17 +284,26 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 		}
 
 		// PERF: Kick off a workspace/symbol in the background to warm up the server
+		//
+		// Note: ctx is cancelled once we have responded to initialize,
+		// so background work must not be derived from it.
 		if yes, _ := strconv.ParseBool(envWarmupOnInitialize); yes {
 			go func() {
-				ctx, cancel := context.WithDeadline(ctx, time.Now().Add(30*time.Second))
+				ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
 				defer cancel()
+				ctx, progress := h.startWorkDoneProgress(ctx, conn, "Warming up")
 				_, _ = h.handleWorkspaceSymbol(ctx, conn, req, lspext.WorkspaceSymbolParams{
 					Query: "",
 					Limit: 100,
 				})
+				progress.End("")
 			}()
 		}
 
+		// Build the indexes of the symbols outside of the workspace in
+		// the background if workspace/symbol searches them by default.
+		h.warmExternalSymbols(h.config.workspaceSymbolScope())
+
 		// set the configured linter
 		if h.config.DiagnosticsEnabled && h.config.LintTool != lintToolNone {
 			switch h.config.LintTool {
@@ -253,7 +319,7 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 			} else {
 				// kick off a lint of the entire workspace
 				go func() {
-					ctx, cancel := context.WithDeadline(ctx, time.Now().Add(30*time.Second))
+					ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
 					defer cancel()
 					err := h.lintWorkspace(ctx, h.BuildContext(ctx), conn)
 					if err != nil {
@@ -263,6 +329,17 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 			}
 		}
 
+		// kick off a check of the import rules of the entire workspace
+		if h.config.DiagnosticsEnabled {
+			go func() {
+				ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
+				defer cancel()
+				if err := h.checkImportRules(ctx, h.BuildContext(ctx), conn); err != nil {
+					log.Printf("warning: failed to check import rules: %s", err)
+				}
+			}()
+		}
+
 		kind := lsp.TDSKIncremental
 		var completionOp *lsp.CompletionOptions
 		if h.config.GocodeCompletionEnabled {
@@ -273,20 +350,24 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 				TextDocumentSync: &lsp.TextDocumentSyncOptionsOrKind{
 					Kind: &kind,
 				},
-				CompletionProvider:           completionOp,
-				DefinitionProvider:           true,
-				TypeDefinitionProvider:       true,
-				DocumentFormattingProvider:   true,
-				DocumentSymbolProvider:       true,
-				HoverProvider:                true,
-				ReferencesProvider:           true,
-				RenameProvider:               true,
-				WorkspaceSymbolProvider:      true,
-				ImplementationProvider:       true,
-				XWorkspaceReferencesProvider: true,
-				XDefinitionProvider:          true,
-				XWorkspaceSymbolByProperties: true,
-				SignatureHelpProvider:        &lsp.SignatureHelpOptions{TriggerCharacters: []string{"(", ","}},
+				CompletionProvider:               completionOp,
+				DefinitionProvider:               true,
+				TypeDefinitionProvider:           true,
+				DocumentFormattingProvider:       true,
+				DocumentRangeFormattingProvider:  true,
+				DocumentOnTypeFormattingProvider: &lsp.DocumentOnTypeFormattingOptions{FirstTriggerCharacter: "}", MoreTriggerCharacter: []string{"\n"}},
+				CodeActionProvider:               true,
+				DocumentSymbolProvider:           true,
+				HoverProvider:                    true,
+				ReferencesProvider:               true,
+				RenameProvider:                   true,
+				WorkspaceSymbolProvider:          true,
+				ImplementationProvider:           true,
+				XWorkspaceReferencesProvider:     true,
+				XDefinitionProvider:              true,
+				XWorkspaceSymbolByProperties:     true,
+				SignatureHelpProvider:            &lsp.SignatureHelpOptions{TriggerCharacters: []string{"(", ","}},
+				ExecuteCommandProvider:           &lsp.ExecuteCommandOptions{Commands: commands},
 			},
 		}, nil
 
@@ -323,6 +404,24 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 		})
 		return nil, nil
 
+	case "window/workDoneProgress/cancel":
+		// notification, don't send back results/errors
+		if req.Params == nil {
+			return nil, nil
+		}
+		var params workDoneProgressCancelParams
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, nil
+		}
+		h.mu.Lock()
+		progressManager := h.progress
+		h.mu.Unlock()
+		if progressManager == nil {
+			return nil, nil
+		}
+		progressManager.Cancel(params.Token)
+		return nil, nil
+
 	case "textDocument/hover":
 		if req.Params == nil {
 			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
@@ -405,6 +504,9 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 		if err := json.Unmarshal(*req.Params, &params); err != nil {
 			return nil, err
 		}
+		if h.init.Capabilities.TextDocument.DocumentSymbol.HierarchicalDocumentSymbolSupport {
+			return h.handleTextDocumentSymbolHierarchical(ctx, conn, req, params)
+		}
 		return h.handleTextDocumentSymbol(ctx, conn, req, params)
 
 	case "textDocument/signatureHelp":
@@ -427,6 +529,46 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 		}
 		return h.handleTextDocumentFormatting(ctx, conn, req, params)
 
+	case "textDocument/rangeFormatting":
+		if req.Params == nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		var params lsp.DocumentRangeFormattingParams
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		return h.handleTextDocumentRangeFormatting(ctx, conn, req, params)
+
+	case "textDocument/onTypeFormatting":
+		if req.Params == nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		var params lsp.DocumentOnTypeFormattingParams
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		return h.handleTextDocumentOnTypeFormatting(ctx, conn, req, params)
+
+	case "textDocument/codeAction":
+		if req.Params == nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		var params CodeActionParams
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		return h.handleTextDocumentCodeAction(ctx, conn, req, params)
+
+	case "textDocument/content":
+		if req.Params == nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		var params lspext.ContentParams
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		return h.handleTextDocumentContent(ctx, conn, req, params)
+
 	case "workspace/symbol":
 		if req.Params == nil {
 			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
@@ -456,6 +598,17 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 			return nil, err
 		}
 		return h.handleRename(ctx, conn, req, params)
+
+	case "workspace/executeCommand":
+		if req.Params == nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		var params lsp.ExecuteCommandParams
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		return h.handleExecuteCommand(ctx, conn, req, params)
+
 	default:
 		if isFileSystemRequest(req.Method) {
 			uri, fileChanged, err := h.handleFileSystemRequest(ctx, req)
@@ -468,7 +621,14 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 				// (unless we're primarily using binary package cache .a
 				// files).
 				if !h.config.UseBinaryPkgCache || (h.config.DiagnosticsEnabled && req.Method == "textDocument/didSave") {
-					go h.typecheck(ctx, conn, uri, lsp.Position{})
+					go func() {
+						// Note: ctx is cancelled once we have handled
+						// the request, so we use a background context.
+						span := startSpanFollowsFromContext(ctx, "typecheck")
+						ctx := opentracing.ContextWithSpan(context.Background(), span)
+						defer span.Finish()
+						h.typecheck(ctx, conn, uri, lsp.Position{})
+					}()
 				}
 
 				if h.config.DiagnosticsEnabled && h.linter != nil && req.Method == "textDocument/didSave" {
@@ -481,6 +641,16 @@ func (h *LangHandler) Handle(ctx context.Context, conn jsonrpc2.JSONRPC2, req *j
 						}
 					}()
 				}
+
+				if h.config.DiagnosticsEnabled && req.Method == "textDocument/didSave" {
+					go func() {
+						ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+						defer cancel()
+						if err := h.checkImportRules(ctx, h.BuildContext(ctx), conn); err != nil {
+							log.Printf("warning: failed to check import rules: %s", err)
+						}
+					}()
+				}
 			}
 			return nil, err
 		}
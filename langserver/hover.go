@@ -19,16 +19,54 @@ import (
 	"github.com/sourcegraph/go-langserver/langserver/util"
 	"github.com/sourcegraph/go-lsp"
 	"github.com/sourcegraph/jsonrpc2"
+	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/packages"
 )
 
-func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.TextDocumentPositionParams) (*lsp.Hover, error) {
+// handleHover returns a *lsp.Hover, or a *MarkupHover if the client
+// supports Markdown hovers.
+func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.TextDocumentPositionParams) (interface{}, error) {
+	doc, r, err := h.hover(ctx, conn, req, params)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	if doc.links != nil && h.config.GoSrcURIsEnabled {
+		doc.links.clientURI = func(uri lsp.DocumentURI) lsp.DocumentURI {
+			return h.goSrcURIForFile(ctx, uri)
+		}
+	}
+	if h.markdownHoverSupported() {
+		return &MarkupHover{
+			Contents: MarkupContent{Kind: MarkupKindMarkdown, Value: doc.markdown(h.config.DocumentationURLTemplate)},
+			Range:    r,
+		}, nil
+	}
+	return &lsp.Hover{Contents: doc.markedStrings(), Range: r}, nil
+}
+
+// markdownHoverSupported reports whether the client prefers Markdown
+// hovers.
+func (h *LangHandler) markdownHoverSupported() bool {
+	hover := h.init.Capabilities.TextDocument.Hover
+	return hover != nil && len(hover.ContentFormat) > 0 && hover.ContentFormat[0] == MarkupKindMarkdown
+}
+
+// hover returns the documentation at the position and the range of the
+// identifier it is for. It returns a nil *hoverDoc if there is nothing to
+// show.
+func (h *LangHandler) hover(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.TextDocumentPositionParams) (*hoverDoc, *lsp.Range, error) {
 	if h.config.UseBinaryPkgCache {
-		return h.handleHoverGodef(ctx, conn, req, params)
+		doc, err := h.handleHoverGodef(ctx, conn, req, params)
+		if err == nil {
+			return doc, nil, nil
+		}
+		// Fall back to typechecking on any error, like
+		// handleDefinition. It works against our VFS.
+		params.TextDocument.URI = vfsURI(params.TextDocument.URI)
 	}
 
 	if !util.IsURI(params.TextDocument.URI) {
-		return nil, &jsonrpc2.Error{
+		return nil, nil, &jsonrpc2.Error{
 			Code:    jsonrpc2.CodeInvalidParams,
 			Message: fmt.Sprintf("textDocument/hover not yet supported for out-of-workspace URI (%q)", params.TextDocument.URI),
 		}
@@ -39,32 +77,47 @@ func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, r
 		// Invalid nodes means we tried to click on something which is
 		// not an ident (eg comment/string/etc). Return no information.
 		if _, ok := err.(*invalidNodeError); ok {
-			return nil, nil
+			return nil, nil, nil
 		}
 		// This is a common error we get in production when a user is
 		// browsing a go pkg which only contains files we can't
 		// analyse (usually due to build tags). To reduce signal of
 		// actual bad errors, we return no error in this case.
 		if _, ok := err.(*build.NoGoError); ok {
-			return nil, nil
+			return nil, nil, nil
 		}
-		return nil, err
+		return nil, nil, err
 	}
 
+	doc, err := h.identHoverDoc(ctx, fset, prog, pkg, node)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc == nil {
+		return nil, nil, fmt.Errorf("type/object not found at %+v", params.Position)
+	}
+	r := rangeForNode(fset, node)
+	return doc, &r, nil
+}
+
+// identHoverDoc returns the hover documentation of the identifier node in
+// the type-checked package pkg, or nil if it has no type or object.
+func (h *LangHandler) identHoverDoc(ctx context.Context, fset *token.FileSet, prog *loader.Program, pkg *loader.PackageInfo, node *ast.Ident) (*hoverDoc, error) {
 	o := pkg.ObjectOf(node)
 	t := pkg.TypeOf(node)
 	if o == nil && t == nil {
 		comments := packageDoc(pkg.Files, node.Name)
 
 		// Package statement idents don't have an object, so try that separately.
-		r := rangeForNode(fset, node)
 		if pkgName := packageStatementName(fset, pkg.Files, node); pkgName != "" {
-			return &lsp.Hover{
-				Contents: maybeAddComments(comments, []lsp.MarkedString{{Language: "go", Value: "package " + pkgName}}),
-				Range:    &r,
+			return &hoverDoc{
+				code:     "package " + pkgName,
+				comments: comments,
+				links:    typesDocLinks(fset, pkg.Pkg),
+				target:   packageDocTarget(pkg.Pkg),
 			}, nil
 		}
-		return nil, fmt.Errorf("type/object not found at %+v", params.Position)
+		return nil, nil
 	}
 
 	// Don't package-qualify the string output.
@@ -72,13 +125,14 @@ func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, r
 
 	// Handle builtin objects with invalid locations.
 	if o != nil && !o.Pos().IsValid() {
-		contents := builtinDoc(node.Name)
-
-		return &lsp.Hover{
-			Contents: contents,
-		}, nil
+		return builtinDoc(node.Name), nil
 	}
 
+	// inst is the instantiation if node refers to a generic function or
+	// type with type arguments, eg Map in Map[int](xs, f) or List in
+	// List[int].
+	inst, instantiated := pkg.Instances[node]
+
 	var s string
 	var extra string
 	if f, ok := o.(*types.Var); ok && f.IsField() {
@@ -86,16 +140,25 @@ func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, r
 		s = "struct " + o.String()
 	} else if o != nil {
 		if obj, ok := o.(*types.TypeName); ok {
-			typ := obj.Type().Underlying()
+			name, typ := obj.Name(), obj.Type().Underlying()
+			if instantiated {
+				name, typ = instanceString(obj, inst, qf), inst.Type.Underlying()
+			}
 			if _, ok := typ.(*types.Struct); ok {
-				s = "type " + obj.Name() + " struct"
+				s = "type " + name + " struct"
 				extra = prettyPrintTypesString(types.TypeString(typ, qf))
 			}
 			if _, ok := typ.(*types.Interface); ok {
-				s = "type " + obj.Name() + " interface"
+				s = "type " + name + " interface"
 				extra = prettyPrintTypesString(types.TypeString(typ, qf))
 			}
 		}
+		if _, ok := o.(*types.Func); ok && instantiated {
+			// Show the instantiated signature, followed by the
+			// generic declaration.
+			s = instanceString(o, inst, qf)
+			extra = objectString(o, qf)
+		}
 		if s == "" {
 			s = objectString(o, qf)
 		}
@@ -111,7 +174,10 @@ func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, r
 		// Package names must be resolved specially, so do this now to avoid
 		// additional overhead.
 		if v, ok := o.(*types.PkgName); ok {
-			pkg := prog.Package(v.Imported().Path())
+			pkg := programPackage(prog, v.Imported().Path())
+			if pkg == nil && h.config.UseExportData {
+				return exportDataPackageDoc(h.BuildContext(ctx), fset, v.Imported()), nil
+			}
 			if pkg == nil {
 				return "", fmt.Errorf("failed to import package %q", v.Imported().Path())
 			}
@@ -120,6 +186,9 @@ func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, r
 
 		// Resolve the object o into its respective ast.Node
 		_, path, _ := prog.PathEnclosingInterval(o.Pos(), o.Pos())
+		if path == nil && h.config.UseExportData {
+			path = exportDataObjectPath(h.BuildContext(ctx), fset, o)
+		}
 		if path == nil {
 			return "", nil
 		}
@@ -145,22 +214,32 @@ func (h *LangHandler) handleHover(ctx context.Context, conn jsonrpc2.JSONRPC2, r
 		return comments, nil
 	}
 
-	comments, err := findComments(o)
+	comments, err := findComments(originObject(o))
 	if err != nil {
 		return nil, err
 	}
-	contents := maybeAddComments(comments, []lsp.MarkedString{{Language: "go", Value: s}})
-	if extra != "" {
-		// If we have extra info, ensure it comes after the usually
-		// more useful documentation
-		contents = append(contents, lsp.MarkedString{Language: "go", Value: extra})
-	}
+	doc := &hoverDoc{code: s, comments: comments, extra: extra}
+	if o != nil {
+		typ := o.Type()
+		if instantiated {
+			typ = inst.Type
+		}
+		var tag string
+		if f, ok := o.(*types.Var); ok && f.IsField() {
+			tag = fieldTag(prog, f.Origin())
+		}
+		enrichTypesHover(doc, o, typ, qf, tag, h.hoverOptions(ctx))
 
-	r := rangeForNode(fset, node)
-	return &lsp.Hover{
-		Contents: contents,
-		Range:    &r,
-	}, nil
+		// Doc links are relative to the package the comment is in.
+		o := originObject(o)
+		if v, ok := o.(*types.PkgName); ok {
+			doc.links = typesDocLinks(fset, v.Imported())
+		} else if o.Pkg() != nil {
+			doc.links = typesDocLinks(fset, o.Pkg())
+		}
+		doc.target = typesDocTarget(o)
+	}
+	return doc, nil
 }
 
 // packageStatementName returns the package name ((*ast.Ident).Name)
@@ -214,38 +293,66 @@ func packageDoc(files []*ast.File, pkgName string) string {
 }
 
 // builtinDoc finds the documentation for a builtin node.
-func builtinDoc(ident string) []lsp.MarkedString {
-	// Grab files from builtin package
+func builtinDoc(ident string) *hoverDoc {
+	fs, asts, err := parseStdlibPackage("builtin")
+	if err != nil {
+		return &hoverDoc{}
+	}
+
+	// Extract documentation and declaration from the ASTs
+	docs := doc.New(asts, "builtin", doc.AllDecls)
+	node, pos := findDocIdent(docs, ident)
+	doc, _ := fmtDocObject(fs, node, fs.Position(pos))
+	doc.links = astDocLinks(fs, asts, "builtin")
+	// Builtins aren't exported, but they are documented.
+	doc.target = &docTarget{importPath: "builtin", pkgName: "builtin", symbol: ident}
+	return doc
+}
+
+// parseStdlibPackage parses the files of the standard library package
+// importPath, such as the builtin and unsafe packages which document the
+// builtins.
+func parseStdlibPackage(importPath string) (*token.FileSet, *ast.Package, error) {
+	// Grab files from the package
 	pkgs, err := packages.Load(
 		&packages.Config{
 			Mode: packages.LoadFiles,
 		},
-		"builtin",
+		importPath,
 	)
 	if err != nil {
-		return nil
+		return nil, nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, nil, fmt.Errorf("package %s not found", importPath)
+	}
+
+	filenames := pkgs[0].GoFiles
+	if len(filenames) == 0 {
+		// go/packages leaves out the files of the unsafe package.
+		bpkg, err := build.Import(importPath, "", 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range bpkg.GoFiles {
+			filenames = append(filenames, filepath.Join(bpkg.Dir, name))
+		}
 	}
 
 	// Parse the files into ASTs
-	pkg := pkgs[0]
 	fs := token.NewFileSet()
 	asts := &ast.Package{
-		Name:  "builtin",
+		Name:  pkgs[0].Name,
 		Files: make(map[string]*ast.File),
 	}
-	for _, filename := range pkg.GoFiles {
+	for _, filename := range filenames {
 		file, err := parser.ParseFile(fs, filename, nil, parser.ParseComments)
 		if err != nil {
 			fmt.Println(err.Error())
 		}
 		asts.Files[filename] = file
 	}
-
-	// Extract documentation and declaration from the ASTs
-	docs := doc.New(asts, "builtin", doc.AllDecls)
-	node, pos := findDocIdent(docs, ident)
-	contents, _ := fmtDocObject(fs, node, fs.Position(pos))
-	return contents
+	return fs, asts, nil
 }
 
 // findDocIdentt walks an input *doc.Package and locates the *doc.Value,
@@ -384,7 +491,7 @@ func prettyPrintTypesString(s string) string {
 	return b.String()
 }
 
-func (h *LangHandler) handleHoverGodef(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.TextDocumentPositionParams) (*lsp.Hover, error) {
+func (h *LangHandler) handleHoverGodef(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.TextDocumentPositionParams) (*hoverDoc, error) {
 	// First perform the equivalent of a textDocument/definition request in
 	// order to resolve the definition position.
 	fset, res, _, err := h.definitionGodef(ctx, params)
@@ -421,14 +528,19 @@ func (h *LangHandler) handleHoverGodef(ctx context.Context, conn jsonrpc2.JSONRP
 		}
 		comments := packageDoc(pkgFiles, bpkg.Name)
 
-		return &lsp.Hover{
-			Contents: maybeAddComments(comments, []lsp.MarkedString{{Language: "go", Value: fmt.Sprintf("package %s (%q)", bpkg.Name, bpkg.ImportPath)}}),
-
-			// TODO(slimsag): I think we can add Range here, but not exactly
-			// sure. res.Start and res.End are only present if it's a package
-			// selector, not an import statement. Since Range is optional,
-			// we're omitting it here.
-		}, nil
+		// TODO(slimsag): I think we can add Range here, but not exactly
+		// sure. res.Start and res.End are only present if it's a package
+		// selector, not an import statement. Since Range is optional,
+		// we're omitting it here.
+		doc := &hoverDoc{
+			code:     fmt.Sprintf("package %s (%q)", bpkg.Name, bpkg.ImportPath),
+			comments: comments,
+			links:    astDocLinks(fset, pkg, bpkg.ImportPath),
+		}
+		if bpkg.Name != "main" {
+			doc.target = &docTarget{importPath: bpkg.ImportPath, pkgName: bpkg.Name}
+		}
+		return doc, nil
 	}
 
 	loc := goRangeToLSPLocation(fset, res.Start, res.End)
@@ -451,10 +563,7 @@ func (h *LangHandler) handleHoverGodef(ctx context.Context, conn jsonrpc2.JSONRP
 			}
 			return nil, err
 		}
-		contents := builtinDoc(node.Name)
-		return &lsp.Hover{
-			Contents: contents,
-		}, nil
+		return builtinDoc(node.Name), nil
 	}
 
 	// convert the path into a real path because 3rd party tools
@@ -481,13 +590,25 @@ func (h *LangHandler) handleHoverGodef(ctx context.Context, conn jsonrpc2.JSONRP
 	docObject := findDocTarget(fset, target, docPkg)
 	if docObject == nil {
 		// probably a local variable, so just ignore.
-		return &lsp.Hover{}, nil
+		return &hoverDoc{}, nil
 	}
 
-	contents, _ := fmtDocObject(fset, docObject, target)
-	return &lsp.Hover{
-		Contents: contents,
-	}, nil
+	doc, node := fmtDocObject(fset, docObject, target)
+
+	// foundImportPath is the package name, so find the import path.
+	importPath := foundImportPath
+	if bpkg, err := build.Default.ImportDir(filepath.Dir(filename), build.FindOnly); err == nil {
+		importPath = bpkg.ImportPath
+		doc.links = astDocLinks(fset, foundPackage, bpkg.ImportPath)
+		if doc.target != nil && foundPackage.Name != "main" {
+			doc.target.importPath = bpkg.ImportPath
+			doc.target.pkgName = foundPackage.Name
+		} else {
+			doc.target = nil
+		}
+	}
+	enrichGodefHover(doc, fset, foundPackage, importPath, docObject, node, target, h.hoverOptions(ctx))
+	return doc, nil
 }
 
 // packageForFile returns the import path and pkg from pkgs that contains the
@@ -583,12 +704,14 @@ func findDocTarget(fset *token.FileSet, target token.Position, in interface{}) i
 // *doc.Type
 // *doc.Func
 //
-func fmtDocObject(fset *token.FileSet, x interface{}, target token.Position) ([]lsp.MarkedString, ast.Node) {
+func fmtDocObject(fset *token.FileSet, x interface{}, target token.Position) (*hoverDoc, ast.Node) {
 	switch v := x.(type) {
 	case *doc.Value: // Vars and Consts
 		// Sort the specs by distance to find the one nearest to target.
-		sort.Sort(byDistance{v.Decl.Specs, fset, target})
-		spec := v.Decl.Specs[0].(*ast.ValueSpec)
+		// Sort a copy, since the order of the specs matters to iota.
+		specs := append([]ast.Spec(nil), v.Decl.Specs...)
+		sort.Sort(byDistance{specs, fset, target})
+		spec := specs[0].(*ast.ValueSpec)
 
 		// Use the doc directly above the var inside a var() block, or if there
 		// is none, fall back to the doc directly above the var() block.
@@ -601,7 +724,7 @@ func fmtDocObject(fset *token.FileSet, x interface{}, target token.Position) ([]
 		cpy := *spec
 		cpy.Doc = nil
 		value := v.Decl.Tok.String() + " " + fmtNode(fset, &cpy)
-		return maybeAddComments(doc, []lsp.MarkedString{{Language: "go", Value: value}}), spec
+		return &hoverDoc{code: value, comments: doc, target: astDocTarget(spec.Names[0].Name, "")}, spec
 
 	case *doc.Type: // Type declarations
 		spec := v.Decl.Specs[0].(*ast.TypeSpec)
@@ -614,7 +737,7 @@ func fmtDocObject(fset *token.FileSet, x interface{}, target token.Position) ([]
 				if fset.Position(field.Pos()).Offset == target.Offset {
 					// An exact match.
 					value := fmt.Sprintf("func (%s).%s%s", spec.Name.Name, field.Names[0].Name, strings.TrimPrefix(fmtNode(fset, field.Type), "func"))
-					return maybeAddComments(field.Doc.Text(), []lsp.MarkedString{{Language: "go", Value: value}}), field
+					return &hoverDoc{code: value, comments: field.Doc.Text(), target: astDocTarget(field.Names[0].Name, spec.Name.Name)}, field
 				}
 			}
 
@@ -626,33 +749,49 @@ func fmtDocObject(fset *token.FileSet, x interface{}, target token.Position) ([]
 					value := fmt.Sprintf("struct field %s %s", field.Names[0], fmtNode(fset, field.Type))
 					// Concat associated documentation with any inline comments
 					comments := joinCommentGroups(field.Doc, field.Comment)
-					return maybeAddComments(comments, []lsp.MarkedString{{Language: "go", Value: value}}), field
+					return &hoverDoc{code: value, comments: comments}, field
 				}
 			}
 		}
 
 		// Formatting of all type declarations: structs, interfaces, integers, etc.
 		name := v.Decl.Tok.String() + " " + spec.Name.Name + " " + typeName(fset, spec.Type)
-		res := []lsp.MarkedString{{Language: "go", Value: name}}
+		res := &hoverDoc{code: name, target: astDocTarget(spec.Name.Name, "")}
 
-		doc := spec.Doc.Text()
-		if doc == "" {
-			doc = v.Doc
+		res.comments = spec.Doc.Text()
+		if res.comments == "" {
+			res.comments = v.Doc
 		}
-		res = maybeAddComments(doc, res)
 
 		if n := typeName(fset, spec.Type); n == "interface" || n == "struct" {
-			res = append(res, lsp.MarkedString{Language: "go", Value: fmtNode(fset, spec.Type)})
+			res.extra = fmtNode(fset, spec.Type)
 		}
 		return res, spec
 
 	case *doc.Func: // Functions
-		return maybeAddComments(v.Doc, []lsp.MarkedString{{Language: "go", Value: fmtNode(fset, v.Decl)}}), v.Decl
+		var recv string
+		if v.Decl.Recv != nil && len(v.Decl.Recv.List) == 1 {
+			recv = recvTypeName(v.Decl.Recv.List[0].Type)
+		}
+		return &hoverDoc{code: fmtNode(fset, v.Decl), comments: v.Doc, target: astDocTarget(v.Name, recv)}, v.Decl
 	default:
 		panic("unreachable")
 	}
 }
 
+// astDocTarget returns the documentation of the symbol name, which is a
+// method of recv if recv is not empty, or nil if it is not exported. The
+// package of the target is filled in by the caller.
+func astDocTarget(name, recv string) *docTarget {
+	if !ast.IsExported(name) || (recv != "" && !ast.IsExported(recv)) {
+		return nil
+	}
+	if recv != "" {
+		name = recv + "." + name
+	}
+	return &docTarget{symbol: name}
+}
+
 // typeName returns the name of typ, shortening interface and struct types to
 // just "interface" and "struct" rather than their full contents (incl. methods
 // and fields).
@@ -0,0 +1,397 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	doc "github.com/slimsag/godocmd"
+	"golang.org/x/tools/go/loader"
+)
+
+// hoverOptions selects the optional sections of hovers. See the Config
+// fields of the same names.
+type hoverOptions struct {
+	constantValues bool
+	structTags     bool
+	methodSets     bool
+
+	// sizes computes the layout of structs. It is nil if
+	// Config.HoverStructLayout is false or the GOARCH is unknown.
+	sizes types.Sizes
+}
+
+func (h *LangHandler) hoverOptions(ctx context.Context) hoverOptions {
+	opts := hoverOptions{
+		constantValues: h.config.HoverConstantValues,
+		structTags:     h.config.HoverStructTags,
+		methodSets:     h.config.HoverMethodSets,
+	}
+	if h.config.HoverStructLayout {
+		opts.sizes = types.SizesFor("gc", h.BuildContext(ctx).GOARCH)
+	}
+	return opts
+}
+
+// constantHex returns val in hex, eg "0xff", if it is an integer whose hex
+// form differs from its decimal form. Otherwise it returns "".
+func constantHex(val constant.Value) string {
+	if val.Kind() != constant.Int {
+		return ""
+	}
+	var x big.Int
+	switch v := constant.Val(val).(type) {
+	case int64:
+		x.SetInt64(v)
+	case *big.Int:
+		x.Set(v)
+	}
+	if x.CmpAbs(big.NewInt(10)) < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%#x", &x)
+}
+
+// constantComment returns the comment to append to the declaration of a
+// constant with value val, eg "// 0xff". If the declaration doesn't already
+// show the value, the comment starts with it, eg "// = 255 (0xff)".
+func constantComment(val constant.Value, declShowsValue bool) string {
+	if val.Kind() == constant.Unknown {
+		return ""
+	}
+	hex := constantHex(val)
+	switch {
+	case declShowsValue && hex == "":
+		return ""
+	case declShowsValue:
+		return "// " + hex
+	case hex == "":
+		return "// = " + val.String()
+	}
+	return fmt.Sprintf("// = %s (%s)", val, hex)
+}
+
+// structString formats st like prettyPrintTypesString formats its
+// types.TypeString, except that tags are in backquotes if possible, or
+// omitted if tags is false. It returns "" if st has no fields.
+func structString(st *types.Struct, qf types.Qualifier, tags bool) string {
+	if st.NumFields() == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	b.WriteString("struct {")
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		b.WriteString("\n    ")
+		if !f.Embedded() {
+			b.WriteString(f.Name())
+			b.WriteByte(' ')
+		}
+		typ := types.TypeString(f.Type(), qf)
+		if s := prettyPrintTypesString(typ); s != "" {
+			typ = s
+		}
+		b.WriteString(strings.Replace(typ, "\n", "\n    ", -1))
+		if tag := st.Tag(i); tags && tag != "" {
+			b.WriteByte(' ')
+			b.WriteString(quoteTag(tag))
+		}
+	}
+	b.WriteString("\n}")
+	return b.String()
+}
+
+// quoteTag quotes a struct tag the way it is usually written, in backquotes.
+func quoteTag(tag string) string {
+	if strconv.CanBackquote(tag) {
+		return "`" + tag + "`"
+	}
+	return strconv.Quote(tag)
+}
+
+// structLayout returns the size and alignment of st and the offset and size
+// of each of its fields, or "" if they can't be computed, eg because st has
+// fields of invalid types or type parameters.
+func structLayout(st *types.Struct, qf types.Qualifier, sizes types.Sizes) string {
+	if sizes == nil || !sizesKnown(st, map[types.Type]bool{}) {
+		return ""
+	}
+	fields := make([]*types.Var, st.NumFields())
+	for i := range fields {
+		fields[i] = st.Field(i)
+	}
+	offsets := sizes.Offsetsof(fields)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// size %d, align %d\n", sizes.Sizeof(st), sizes.Alignof(st))
+	w := tabwriter.NewWriter(&b, 0, 4, 1, ' ', 0)
+	for i, f := range fields {
+		name := types.TypeString(f.Type(), qf)
+		if !f.Embedded() {
+			name = f.Name() + " " + name
+		}
+		fmt.Fprintf(w, "%s\t// offset %d, size %d\n", name, offsets[i], sizes.Sizeof(f.Type()))
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sizesKnown reports whether the size of t can be computed. seen guards
+// against (invalid) recursive types.
+func sizesKnown(t types.Type, seen map[types.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	switch t := t.(type) {
+	case *types.Basic:
+		return t.Kind() != types.Invalid
+	case *types.TypeParam:
+		return false
+	case *types.Named:
+		return sizesKnown(t.Underlying(), seen)
+	case *types.Array:
+		return sizesKnown(t.Elem(), seen)
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if !sizesKnown(t.Field(i).Type(), seen) {
+				return false
+			}
+		}
+	}
+	// Pointers, slices, maps, channels, funcs and interfaces have the same
+	// size whatever they contain.
+	return true
+}
+
+// methodSetString returns the methods of the named type t, one per line
+// without their docs, eg "func (*T) M(x int) error". Methods which can only
+// be called on a pointer to t have a pointer receiver. It returns "" for
+// interfaces, whose methods are part of their declaration.
+func methodSetString(t *types.Named, qf types.Qualifier) string {
+	if types.IsInterface(t) {
+		return ""
+	}
+	recv := t.Obj().Name()
+	if t.TypeArgs().Len() > 0 {
+		recv = types.TypeString(t, qf)
+	} else if tparams := t.TypeParams(); tparams.Len() > 0 {
+		names := make([]string, tparams.Len())
+		for i := range names {
+			names[i] = tparams.At(i).Obj().Name()
+		}
+		recv += "[" + strings.Join(names, ", ") + "]"
+	}
+
+	value := types.NewMethodSet(t)
+	ptr := types.NewMethodSet(types.NewPointer(t))
+	var lines []string
+	for i := 0; i < ptr.Len(); i++ {
+		sel := ptr.At(i)
+		m := sel.Obj()
+		if !m.Exported() && m.Pkg() != t.Obj().Pkg() {
+			// Unexported methods promoted from embedded types of
+			// other packages can't be called.
+			continue
+		}
+		r := recv
+		if value.Lookup(m.Pkg(), m.Name()) == nil {
+			r = "*" + recv
+		}
+		sig := strings.TrimPrefix(types.TypeString(sel.Type(), qf), "func")
+		lines = append(lines, fmt.Sprintf("func (%s) %s%s", r, m.Name(), sig))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// enrichTypesHover adds the optional sections selected by opts to d, the
+// hover of obj, which was type checked. tag is the tag of obj if it is a
+// struct field.
+func enrichTypesHover(d *hoverDoc, obj types.Object, typ types.Type, qf types.Qualifier, tag string, opts hoverOptions) {
+	switch obj := obj.(type) {
+	case *types.Const:
+		if opts.constantValues {
+			if c := constantComment(obj.Val(), true); c != "" {
+				d.code += " " + c
+			}
+		}
+	case *types.Var:
+		if obj.IsField() && opts.structTags && tag != "" {
+			d.code += " " + quoteTag(tag)
+		}
+	case *types.TypeName:
+		st, ok := typ.Underlying().(*types.Struct)
+		if ok {
+			d.extra = structString(st, qf, opts.structTags)
+			d.layout = structLayout(st, qf, opts.sizes)
+		}
+		if named, ok := typ.(*types.Named); ok && opts.methodSets && !obj.IsAlias() {
+			d.methods = methodSetString(named, qf)
+		}
+	}
+}
+
+// enrichGodefHover adds the optional sections selected by opts to d, the
+// hover of node. x is the *doc.Value, *doc.Type or *doc.Func node belongs
+// to, and target the position of the hovered identifier.
+//
+// Unlike enrichTypesHover, it only has the syntax of pkg, which it type
+// checks on its own without its imports. So constants and structs which
+// depend on other packages aren't enriched, and the method sets only
+// contain the methods declared in pkg.
+func enrichGodefHover(d *hoverDoc, fset *token.FileSet, pkg *ast.Package, importPath string, x interface{}, node ast.Node, target token.Position, opts hoverOptions) {
+	switch node := node.(type) {
+	case *ast.ValueSpec:
+		if v, ok := x.(*doc.Value); !ok || v.Decl.Tok != token.CONST || !opts.constantValues {
+			return
+		}
+		for i, name := range node.Names {
+			if fset.Position(name.Pos()).Offset != target.Offset {
+				continue
+			}
+			_, info := checkASTPackage(fset, pkg, importPath, nil)
+			c, ok := info.Defs[name].(*types.Const)
+			if !ok {
+				return
+			}
+			// The declaration shows the value if it is a literal, eg
+			// 255, but not if it is eg 0xff or 1 << iota.
+			var showsValue bool
+			if i < len(node.Values) {
+				lit, ok := node.Values[i].(*ast.BasicLit)
+				showsValue = ok && lit.Value == c.Val().String()
+			}
+			if comment := constantComment(c.Val(), showsValue); comment != "" {
+				d.code += " " + comment
+			}
+		}
+
+	case *ast.Field:
+		if node.Tag != nil && opts.structTags && len(node.Names) > 0 && strings.HasPrefix(d.code, "struct field ") {
+			if tag, err := strconv.Unquote(node.Tag.Value); err == nil {
+				d.code += " " + quoteTag(tag)
+			}
+		}
+
+	case *ast.TypeSpec:
+		if st, ok := node.Type.(*ast.StructType); ok {
+			if !opts.structTags {
+				d.extra = fmtNode(fset, untaggedStruct(st))
+			}
+			if opts.sizes != nil {
+				_, info := checkASTPackage(fset, pkg, importPath, opts.sizes)
+				if tn, ok := info.Defs[node.Name].(*types.TypeName); ok {
+					if st, ok := tn.Type().Underlying().(*types.Struct); ok {
+						qf := func(*types.Package) string { return "" }
+						d.layout = structLayout(st, qf, opts.sizes)
+					}
+				}
+			}
+		}
+		if _, ok := node.Type.(*ast.InterfaceType); !ok && opts.methodSets {
+			d.methods = astMethodSetString(fset, pkg, node.Name.Name)
+		}
+	}
+}
+
+// fieldTag returns the tag of the struct field f, if prog has its syntax.
+func fieldTag(prog *loader.Program, f *types.Var) string {
+	_, path, _ := prog.PathEnclosingInterval(f.Pos(), f.Pos())
+	for _, n := range path {
+		if field, ok := n.(*ast.Field); ok {
+			if field.Tag == nil {
+				return ""
+			}
+			tag, _ := strconv.Unquote(field.Tag.Value)
+			return tag
+		}
+	}
+	return ""
+}
+
+// untaggedStruct returns a copy of st without the tags of its fields.
+func untaggedStruct(st *ast.StructType) *ast.StructType {
+	cpy := *st
+	fields := *st.Fields
+	fields.List = make([]*ast.Field, len(st.Fields.List))
+	for i, f := range st.Fields.List {
+		f := *f
+		f.Tag = nil
+		fields.List[i] = &f
+	}
+	cpy.Fields = &fields
+	return &cpy
+}
+
+// astMethodSetString is like methodSetString, but it returns the methods of
+// the type named typeName which are declared in pkg.
+func astMethodSetString(fset *token.FileSet, pkg *ast.Package, typeName string) string {
+	var lines []string
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+				continue
+			}
+			recv := fn.Recv.List[0].Type
+			if recvTypeName(recv) != typeName {
+				continue
+			}
+			sig := strings.TrimPrefix(fmtNode(fset, fn.Type), "func")
+			lines = append(lines, fmt.Sprintf("func (%s) %s%s", fmtNode(fset, recv), fn.Name.Name, sig))
+		}
+	}
+	// Sort like types.MethodSet, by name.
+	sort.Slice(lines, func(i, j int) bool {
+		return methodName(lines[i]) < methodName(lines[j])
+	})
+	return strings.Join(lines, "\n")
+}
+
+// methodName returns the name of the method of a line of
+// astMethodSetString.
+func methodName(line string) string {
+	name := line[strings.Index(line, ") ")+2:]
+	return name[:strings.IndexAny(name, "([")]
+}
+
+// checkASTPackage type checks pkg, ignoring errors. The imports of pkg
+// aren't available, so everything which depends on them is invalid.
+func checkASTPackage(fset *token.FileSet, pkg *ast.Package, importPath string, sizes types.Sizes) (*types.Package, *types.Info) {
+	files := make([]*ast.File, 0, len(pkg.Files))
+	for _, f := range pkg.Files {
+		files = append(files, f)
+	}
+	// Type check the files in a deterministic order.
+	sort.Slice(files, func(i, j int) bool {
+		return fset.File(files[i].Pos()).Name() < fset.File(files[j].Pos()).Name()
+	})
+	info := &types.Info{Defs: map[*ast.Ident]types.Object{}}
+	conf := types.Config{
+		Importer:    noImporter{},
+		FakeImportC: true,
+		Error:       func(error) {},
+		Sizes:       sizes,
+	}
+	tpkg, _ := conf.Check(importPath, fset, files, info)
+	return tpkg, info
+}
+
+// noImporter is a types.Importer which can't import any package.
+type noImporter struct{}
+
+func (noImporter) Import(path string) (*types.Package, error) {
+	return nil, errors.New("imports are not available")
+}
@@ -0,0 +1,137 @@
+package langserver
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	doc "github.com/slimsag/godocmd"
+	"github.com/sourcegraph/go-lsp"
+)
+
+const hoverEnrichSrc = `package p
+
+type Weekday int
+
+const (
+	Sunday Weekday = iota
+	Monday
+)
+
+const Mask = 1<<8 - 1
+
+const Answer = 42
+
+type Inner struct{ A, B int8 }
+
+type T struct {
+	Inner
+	Name string ` + "`json:\"name\"`" + `
+	ID   int64
+	Flag bool
+}
+
+func (T) Get() string { return "" }
+
+func (*T) Set(s string) {}
+`
+
+func TestHover_enrichments(t *testing.T) {
+	h, hover := newHoverTestHandler(t, hoverEnrichSrc)
+	h.config.HoverStructLayout = true
+
+	hoverString := func(line, character int) string {
+		var parts []string
+		for _, s := range hover(line, character).(*lsp.Hover).Contents {
+			parts = append(parts, s.Value)
+		}
+		return strings.Join(parts, "; ")
+	}
+
+	tests := []struct {
+		line, character int
+		want            string
+	}{
+		{6, 1, "const Monday Weekday = 1"},
+		{9, 6, "const Mask untyped int = 255 // 0xff"},
+		{17, 1, "struct field Name string `json:\"name\"`"},
+		{15, 5, "type T struct; struct {\n    Inner\n    Name string `json:\"name\"`\n    ID int64\n    Flag bool\n}; " +
+			"// size 40, align 8\nInner       // offset 0, size 2\nName string // offset 8, size 16\nID int64    // offset 24, size 8\nFlag bool   // offset 32, size 1; " +
+			"func (T) Get() string\nfunc (*T) Set(s string)"},
+		{2, 5, "type Weekday int"},
+	}
+	for _, test := range tests {
+		if got := hoverString(test.line, test.character); got != test.want {
+			t.Errorf("hover at %d:%d: got %q, want %q", test.line, test.character, got, test.want)
+		}
+	}
+
+	h.config.HoverConstantValues = false
+	h.config.HoverStructLayout = false
+	h.config.HoverStructTags = false
+	h.config.HoverMethodSets = false
+	tests = []struct {
+		line, character int
+		want            string
+	}{
+		{9, 6, "const Mask untyped int = 255"},
+		{17, 1, "struct field Name string"},
+		{15, 5, "type T struct; struct {\n    Inner\n    Name string\n    ID int64\n    Flag bool\n}"},
+	}
+	for _, test := range tests {
+		if got := hoverString(test.line, test.character); got != test.want {
+			t.Errorf("with enrichments disabled, hover at %d:%d: got %q, want %q", test.line, test.character, got, test.want)
+		}
+	}
+}
+
+func TestEnrichGodefHover(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/src/p/a.go", hoverEnrichSrc, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &ast.Package{Name: "p", Files: map[string]*ast.File{"/src/p/a.go": f}}
+	docPkg := doc.New(pkg, "p", doc.AllDecls)
+
+	// hover returns the hover of the identifier at the first occurrence
+	// of ident in the source, like handleHoverGodef.
+	hover := func(ident string, opts hoverOptions) *hoverDoc {
+		offset := strings.Index(hoverEnrichSrc, ident)
+		target := fset.Position(f.Package + token.Pos(offset))
+		d, node := fmtDocObject(fset, findDocTarget(fset, target, docPkg), target)
+		enrichGodefHover(d, fset, pkg, "p", findDocTarget(fset, target, docPkg), node, target, opts)
+		return d
+	}
+
+	opts := hoverOptions{constantValues: true, structTags: true, methodSets: true, sizes: types.SizesFor("gc", "386")}
+	tests := []struct {
+		ident string
+		want  hoverDoc
+	}{
+		{"Monday", hoverDoc{code: "const Monday // = 1"}},
+		{"Mask", hoverDoc{code: "const Mask = 1<<8 - 1 // = 255 (0xff)"}},
+		{"Answer", hoverDoc{code: "const Answer = 42 // 0x2a"}},
+		{"Name", hoverDoc{code: "struct field Name string `json:\"name\"`"}},
+		{"T struct", hoverDoc{
+			code:    "type T struct",
+			extra:   "struct {\n\tInner\n\tName string `json:\"name\"`\n\tID   int64\n\tFlag bool\n}",
+			layout:  "// size 24, align 4\nInner       // offset 0, size 2\nName string // offset 4, size 8\nID int64    // offset 12, size 8\nFlag bool   // offset 20, size 1",
+			methods: "func (T) Get() string\nfunc (*T) Set(s string)",
+		}},
+	}
+	for _, test := range tests {
+		got := hover(test.ident, opts)
+		if got.code != test.want.code || got.extra != test.want.extra || got.layout != test.want.layout || got.methods != test.want.methods {
+			t.Errorf("hover of %s: got %+v, want %+v", test.ident, *got, test.want)
+		}
+	}
+
+	got := hover("T struct", hoverOptions{})
+	if want := "struct {\n\tInner\n\tName string\n\tID   int64\n\tFlag bool\n}"; got.extra != want || got.layout != "" || got.methods != "" {
+		t.Errorf("with enrichments disabled, got %+v, want extra %q only", *got, want)
+	}
+}
@@ -0,0 +1,349 @@
+package langserver
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc/comment"
+	"go/token"
+	"go/types"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// hoverDoc is the documentation shown by textDocument/hover. It is rendered
+// as MarkedStrings or, if the client supports it, as Markdown.
+type hoverDoc struct {
+	// code is the declaration of the symbol, eg its signature.
+	code string
+
+	// comments is the text of the doc comment of the symbol.
+	comments string
+
+	// extra is code which is shown after comments, eg the fields of a
+	// struct.
+	extra string
+
+	// layout is the size and alignment of a struct and the offsets of
+	// its fields.
+	layout string
+
+	// methods is the method set of a named type, one method per line.
+	methods string
+
+	// links resolves the doc links in comments, eg [Name]. It may be nil.
+	links *docLinks
+
+	// target is the documentation of the symbol, which the Markdown
+	// links to. It is nil if the symbol has no documentation page, eg
+	// because it is not exported.
+	target *docTarget
+}
+
+// markedStrings renders d as MarkedStrings, with the comments converted to
+// Markdown by godocmd.
+func (d *hoverDoc) markedStrings() []lsp.MarkedString {
+	if d.code == "" {
+		return nil
+	}
+	contents := maybeAddComments(d.comments, []lsp.MarkedString{{Language: "go", Value: d.code}})
+	if d.extra != "" {
+		// If we have extra info, ensure it comes after the usually
+		// more useful documentation
+		contents = append(contents, lsp.MarkedString{Language: "go", Value: d.extra})
+	}
+	for _, section := range []string{d.layout, d.methods} {
+		if section != "" {
+			contents = append(contents, lsp.MarkedString{Language: "go", Value: section})
+		}
+	}
+	return contents
+}
+
+// markdown renders d as Markdown. The comments are interpreted with the doc
+// comment syntax of Go 1.19. urlTemplate is Config.DocumentationURLTemplate.
+func (d *hoverDoc) markdown(urlTemplate string) string {
+	if d.code == "" {
+		return ""
+	}
+	parts := []string{markdownCode(d.code)}
+	if d.comments != "" {
+		parts = append(parts, strings.TrimSpace(string(d.links.markdown(d.comments, urlTemplate))))
+	}
+	for _, section := range []string{d.extra, d.layout, d.methods} {
+		if section != "" {
+			parts = append(parts, markdownCode(section))
+		}
+	}
+	if link := d.target.markdownLink(urlTemplate); link != "" {
+		parts = append(parts, link)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func markdownCode(code string) string {
+	return "```go\n" + code + "\n```"
+}
+
+// docTarget is the documentation of a package or a symbol in it.
+type docTarget struct {
+	importPath, pkgName string
+
+	// symbol is the name of the symbol in the package, eg "Reader" or
+	// "Reader.Read". It is empty for the documentation of the package.
+	symbol string
+}
+
+// typesDocTarget returns the documentation of obj, or nil if it has none.
+// Only exported package level objects, methods of exported types and
+// packages other than main have documentation.
+func typesDocTarget(obj types.Object) *docTarget {
+	if pkgName, ok := obj.(*types.PkgName); ok {
+		return packageDocTarget(pkgName.Imported())
+	}
+	pkg := obj.Pkg()
+	if pkg == nil || pkg.Name() == "main" || !obj.Exported() {
+		return nil
+	}
+	if f, ok := obj.(*types.Func); ok {
+		if recv := f.Type().(*types.Signature).Recv(); recv != nil {
+			named, ok := derefType(recv.Type()).(*types.Named)
+			if !ok || !named.Obj().Exported() {
+				return nil
+			}
+			return &docTarget{importPath: pkg.Path(), pkgName: pkg.Name(), symbol: named.Obj().Name() + "." + f.Name()}
+		}
+	}
+	if obj.Parent() != pkg.Scope() {
+		// Fields and local declarations.
+		return nil
+	}
+	return &docTarget{importPath: pkg.Path(), pkgName: pkg.Name(), symbol: obj.Name()}
+}
+
+// packageDocTarget returns the documentation of pkg, or nil if it is a main
+// package.
+func packageDocTarget(pkg *types.Package) *docTarget {
+	if pkg.Name() == "main" {
+		return nil
+	}
+	return &docTarget{importPath: pkg.Path(), pkgName: pkg.Name()}
+}
+
+func derefType(t types.Type) types.Type {
+	if p, ok := t.(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return t
+}
+
+// markdownLink returns a Markdown link to t, eg "[`http.Server` on
+// pkg.go.dev](https://pkg.go.dev/net/http#Server)", or "" if t is nil or
+// urlTemplate is empty.
+func (t *docTarget) markdownLink(urlTemplate string) string {
+	if t == nil {
+		return ""
+	}
+	u := documentationURL(urlTemplate, t.importPath, t.symbol)
+	if u == "" {
+		return ""
+	}
+	name := t.importPath
+	if t.symbol != "" {
+		name = t.pkgName + "." + t.symbol
+	}
+	text := fmt.Sprintf("`%s`", name)
+	if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+		text += " on " + parsed.Host
+	}
+	return fmt.Sprintf("[%s](%s)", text, u)
+}
+
+// documentationURL expands urlTemplate, which is
+// Config.DocumentationURLTemplate, for the documentation of symbol in the
+// package importPath. It returns "" if urlTemplate is empty.
+func documentationURL(urlTemplate, importPath, symbol string) string {
+	if urlTemplate == "" {
+		return ""
+	}
+	u := strings.Replace(urlTemplate, "{importPath}", importPath, -1)
+	u = strings.Replace(u, "{symbol}", symbol, -1)
+	if symbol == "" {
+		u = strings.TrimSuffix(u, "#")
+	}
+	return u
+}
+
+// docLinks resolves the doc links in the doc comments of a package, eg
+// [Name], [Recv.Name] or [pkg.Name].
+type docLinks struct {
+	// importPath is the import path of the package.
+	importPath string
+
+	// imports maps the names of the packages the package imports to
+	// their import paths.
+	imports map[string]string
+
+	// lookup returns the location of the declaration of name, or of the
+	// method recv.name if recv is not empty, in the package importPath.
+	lookup func(importPath, recv, name string) (lsp.Location, bool)
+
+	// clientURI, if not nil, returns the URI the client knows a file by.
+	clientURI func(lsp.DocumentURI) lsp.DocumentURI
+}
+
+// markdown renders the doc comment text as Markdown. Doc links are
+// resolved to the locations of their declarations, or else to their
+// documentation. l may be nil, in which case only links to other packages
+// are resolved.
+func (l *docLinks) markdown(text, urlTemplate string) []byte {
+	var p comment.Parser
+	if l != nil {
+		p.LookupPackage = func(name string) (string, bool) {
+			importPath, ok := l.imports[name]
+			return importPath, ok
+		}
+		p.LookupSym = func(recv, name string) bool {
+			_, ok := l.lookup(l.importPath, recv, name)
+			return ok
+		}
+	}
+	pr := comment.Printer{
+		DocLinkURL: func(link *comment.DocLink) string {
+			return l.url(link, urlTemplate)
+		},
+		// Hovers can't be linked to, and clients would show the IDs.
+		HeadingID: func(*comment.Heading) string { return "" },
+	}
+	return pr.Markdown(p.Parse(text))
+}
+
+// url returns the URL of the declaration of the target of link, or of its
+// documentation if we don't know where it is declared.
+func (l *docLinks) url(link *comment.DocLink, urlTemplate string) string {
+	importPath := link.ImportPath
+	if l != nil {
+		if importPath == "" {
+			importPath = l.importPath
+		}
+		if link.Name != "" {
+			if loc, ok := l.lookup(importPath, link.Recv, link.Name); ok {
+				if l.clientURI != nil {
+					loc.URI = l.clientURI(loc.URI)
+				}
+				return fmt.Sprintf("%s#L%d", loc.URI, loc.Range.Start.Line+1)
+			}
+		}
+	}
+	symbol := link.Name
+	if link.Recv != "" {
+		symbol = link.Recv + "." + link.Name
+	}
+	return documentationURL(urlTemplate, importPath, symbol)
+}
+
+// typesDocLinks returns the doc links of pkg. The targets of the links are
+// looked up in pkg and the packages it imports.
+func typesDocLinks(fset *token.FileSet, pkg *types.Package) *docLinks {
+	pkgs := map[string]*types.Package{pkg.Path(): pkg}
+	imports := map[string]string{}
+	for _, imp := range pkg.Imports() {
+		pkgs[imp.Path()] = imp
+		imports[imp.Name()] = imp.Path()
+	}
+	return &docLinks{
+		importPath: pkg.Path(),
+		imports:    imports,
+		lookup: func(importPath, recv, name string) (lsp.Location, bool) {
+			pkg := pkgs[importPath]
+			if pkg == nil {
+				return lsp.Location{}, false
+			}
+			var obj types.Object
+			if recv == "" {
+				obj = pkg.Scope().Lookup(name)
+			} else if tn, ok := pkg.Scope().Lookup(recv).(*types.TypeName); ok {
+				obj, _, _ = types.LookupFieldOrMethod(tn.Type(), true, pkg, name)
+			}
+			return objectLocation(fset, obj)
+		},
+	}
+}
+
+// objectLocation returns the location of the declaration of obj, if it is
+// known.
+func objectLocation(fset *token.FileSet, obj types.Object) (lsp.Location, bool) {
+	if obj == nil || !obj.Pos().IsValid() {
+		return lsp.Location{}, false
+	}
+	return posLocation(fset, obj.Pos(), len(obj.Name()))
+}
+
+func posLocation(fset *token.FileSet, pos token.Pos, length int) (lsp.Location, bool) {
+	if fset.File(pos) == nil || fset.Position(pos).Filename == "" {
+		return lsp.Location{}, false
+	}
+	return goRangeToLSPLocation(fset, pos, pos+token.Pos(length)), true
+}
+
+// astDocLinks returns the doc links of the package pkg, which has the given
+// import path. Only the targets of links to pkg itself can be looked up.
+func astDocLinks(fset *token.FileSet, pkg *ast.Package, importPath string) *docLinks {
+	imports := map[string]string{}
+	decls := map[string]token.Pos{} // "Name" or "Recv.Name" -> position
+	for _, f := range pkg.Files {
+		for _, spec := range f.Imports {
+			p, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			name := path.Base(p)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports[name] = p
+		}
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				name := decl.Name.Name
+				if decl.Recv != nil && len(decl.Recv.List) == 1 {
+					name = recvTypeName(decl.Recv.List[0].Type) + "." + name
+				}
+				decls[name] = decl.Name.Pos()
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						decls[spec.Name.Name] = spec.Name.Pos()
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							decls[name.Name] = name.Pos()
+						}
+					}
+				}
+			}
+		}
+	}
+	return &docLinks{
+		importPath: importPath,
+		imports:    imports,
+		lookup: func(p, recv, name string) (lsp.Location, bool) {
+			if p != importPath {
+				return lsp.Location{}, false
+			}
+			key := name
+			if recv != "" {
+				key = recv + "." + name
+			}
+			pos, ok := decls[key]
+			if !ok {
+				return lsp.Location{}, false
+			}
+			return posLocation(fset, pos, len(name))
+		},
+	}
+}
@@ -0,0 +1,161 @@
+package langserver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestHoverDoc_markdown(t *testing.T) {
+	links := &docLinks{
+		importPath: "example.com/p",
+		imports:    map[string]string{"io": "io"},
+		lookup: func(importPath, recv, name string) (lsp.Location, bool) {
+			if importPath == "example.com/p" && recv == "" && name == "Writer" {
+				return lsp.Location{URI: "file:///src/example.com/p/p.go", Range: lsp.Range{Start: lsp.Position{Line: 9}}}, true
+			}
+			return lsp.Location{}, false
+		},
+	}
+	doc := &hoverDoc{
+		code: "func Copy(dst Writer, src io.Reader) error",
+		comments: `Copy copies src to dst, see [Writer] and [io.Reader].
+
+# Errors
+
+Copy fails if:
+  - dst fails
+  - src fails
+
+For example:
+
+	err := Copy(w, r)
+`,
+		extra:  "type Writer interface{}",
+		links:  links,
+		target: &docTarget{importPath: "example.com/p", pkgName: "p", symbol: "Copy"},
+	}
+
+	want := "```go\nfunc Copy(dst Writer, src io.Reader) error\n```\n\n" +
+		"Copy copies src to dst, see [Writer](file:///src/example.com/p/p.go#L10) and [io.Reader](https://pkg.go.dev/io#Reader).\n\n" +
+		"### Errors\n\n" +
+		"Copy fails if:\n\n  - dst fails\n  - src fails\n\n" +
+		"For example:\n\n\terr := Copy(w, r)\n\n" +
+		"```go\ntype Writer interface{}\n```\n\n" +
+		"[`p.Copy` on pkg.go.dev](https://pkg.go.dev/example.com/p#Copy)"
+	if got := doc.markdown("https://pkg.go.dev/{importPath}#{symbol}"); got != want {
+		t.Errorf("got Markdown\n%s\nwant\n%s", got, want)
+	}
+
+	// Without a template, only links to declarations remain.
+	want = "```go\nfunc Copy(dst Writer, src io.Reader) error\n```\n\n" +
+		"Copy copies src to dst, see [Writer](file:///src/example.com/p/p.go#L10) and io.Reader."
+	doc.comments = "Copy copies src to dst, see [Writer] and [io.Reader]."
+	doc.extra = ""
+	if got := doc.markdown(""); got != want {
+		t.Errorf("got Markdown\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDocumentationURL(t *testing.T) {
+	tests := []struct {
+		template, importPath, symbol, want string
+	}{
+		{"https://pkg.go.dev/{importPath}#{symbol}", "net/http", "Server", "https://pkg.go.dev/net/http#Server"},
+		{"https://pkg.go.dev/{importPath}#{symbol}", "net/http", "Server.Close", "https://pkg.go.dev/net/http#Server.Close"},
+		{"https://pkg.go.dev/{importPath}#{symbol}", "net/http", "", "https://pkg.go.dev/net/http"},
+		{"https://docs.example.com/pkg/{importPath}/?sym={symbol}", "example.com/p", "T", "https://docs.example.com/pkg/example.com/p/?sym=T"},
+		{"", "net/http", "Server", ""},
+	}
+	for _, test := range tests {
+		if got := documentationURL(test.template, test.importPath, test.symbol); got != test.want {
+			t.Errorf("documentationURL(%q, %q, %q) = %q, want %q", test.template, test.importPath, test.symbol, got, test.want)
+		}
+	}
+}
+
+func TestHover_markdown(t *testing.T) {
+	h, hover := newHoverTestHandler(t, `package p
+
+// T is a [U].
+type T struct{}
+
+// M returns [T.N].
+func (T) M() int { return 0 }
+
+func (T) N() {}
+
+type U int
+
+var v int
+`)
+
+	// Clients which don't advertise Markdown get MarkedStrings.
+	want := &lsp.Hover{
+		Contents: append(maybeAddComments("T is a [U].\n", []lsp.MarkedString{{Language: "go", Value: "type T struct"}}), lsp.MarkedString{Language: "go", Value: "func (T) M() int\nfunc (T) N()"}),
+		Range:    &lsp.Range{Start: lsp.Position{Line: 3, Character: 5}, End: lsp.Position{Line: 3, Character: 6}},
+	}
+	if got := hover(3, 5); !reflect.DeepEqual(got, want) {
+		t.Errorf("got hover %+v, want %+v", got, want)
+	}
+
+	h.init.Capabilities.TextDocument.Hover = &struct {
+		ContentFormat []string `json:"contentFormat,omitempty"`
+	}{ContentFormat: []string{"markdown", "plaintext"}}
+	tests := []struct {
+		line, character int
+		want            string
+	}{
+		{3, 5, "```go\ntype T struct\n```\n\nT is a [U](file:///src/p/a.go#L11).\n\n```go\nfunc (T) M() int\nfunc (T) N()\n```\n\n[`p.T` on pkg.go.dev](https://pkg.go.dev/p#T)"},
+		{6, 9, "```go\nfunc (T).M() int\n```\n\nM returns [T.N](file:///src/p/a.go#L9).\n\n[`p.T.M` on pkg.go.dev](https://pkg.go.dev/p#T.M)"},
+		{12, 4, "```go\nvar v int\n```"},
+	}
+	for _, test := range tests {
+		got, ok := hover(test.line, test.character).(*MarkupHover)
+		if !ok {
+			t.Fatalf("got %T, want *MarkupHover", got)
+		}
+		if got.Contents.Kind != "markdown" || got.Contents.Value != test.want {
+			t.Errorf("hover at %d:%d: got %s\n%s\nwant\n%s", test.line, test.character, got.Contents.Kind, got.Contents.Value, test.want)
+		}
+	}
+}
+
+// newHoverTestHandler returns a handler for a workspace with the package p
+// with the file /src/p/a.go, and a func which returns the hover at a
+// position in the file.
+func newHoverTestHandler(t *testing.T, src string) (*LangHandler, func(line, character int) interface{}) {
+	fs := NewAtomicFS()
+	fs.Bind("/src", ctxvfs.Map(map[string][]byte{
+		"p/a.go": []byte(src),
+	}), "/", ctxvfs.BindReplace)
+	cfg := NewDefaultConfig()
+	cfg.UseBinaryPkgCache = false
+	h := &LangHandler{
+		HandlerShared: &HandlerShared{FS: fs},
+		init: &InitializeParams{
+			InitializeParams: lsp.InitializeParams{RootURI: "file:///src/p"},
+			RootImportPath:   "p",
+			BuildContext:     &InitializeBuildContextParams{GOOS: "linux", GOARCH: "amd64", GOPATH: "/", GOROOT: "/goroot", Compiler: "gc"},
+		},
+		config: &cfg,
+	}
+	h.resetCaches(false)
+
+	_, ctx := opentracing.StartSpanFromContext(context.Background(), "hovertest")
+	return h, func(line, character int) interface{} {
+		res, err := h.handleHover(ctx, nil, &jsonrpc2.Request{Method: "textDocument/hover"}, lsp.TextDocumentPositionParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: "file:///src/p/a.go"},
+			Position:     lsp.Position{Line: line, Character: character},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+}
@@ -49,7 +49,7 @@ func (h *LangHandler) handleTextDocumentImplementation(ctx context.Context, conn
 		lconf.ImportWithTests(path)
 	}
 	// Type-check the program.
-	lprog, err := lconf.Load()
+	lprog, err := h.packageLoader().Load(ctx, &lconf)
 	if err != nil {
 		return nil, err
 	}
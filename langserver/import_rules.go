@@ -0,0 +1,404 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-langserver/pkg/tools"
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// importRulesFile is the file in the root of the workspace which configures
+// the import rules checked by checkImportRules.
+const importRulesFile = ".go-import-rules.json"
+
+// importRulesSource is the source of the diagnostics of import rule
+// violations and import cycles.
+const importRulesSource = "importrules"
+
+// importRules are the layering rules of the packages of a workspace, such as:
+//
+//	{"rules": [
+//		{"from": "./internal/storage/...", "forbid": ["./internal/http/..."]},
+//		{"from": "./internal/domain/...", "allow": ["std", "./internal/domain/..."]}
+//	]}
+type importRules struct {
+	Rules []*importRule `json:"rules"`
+}
+
+// importRule restricts the imports of the packages matching From. The
+// patterns are import paths in which "..." matches any string, like the
+// patterns of the go command. A "./" prefix is replaced by the root import
+// path of the workspace, and "std" matches the standard library.
+type importRule struct {
+	From string `json:"from"`
+
+	// Forbid are the patterns of the packages which the packages matching
+	// From must not depend on, directly or via other packages of the
+	// workspace.
+	Forbid []string `json:"forbid,omitempty"`
+
+	// Allow, if set, are the patterns of the only packages which the
+	// packages matching From may import directly.
+	Allow []string `json:"allow,omitempty"`
+}
+
+// parseImportRules parses the import rules in data, resolving relative
+// patterns against the root import path of the workspace.
+func parseImportRules(data []byte, rootImportPath string) (*importRules, error) {
+	var rules importRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", importRulesFile, err)
+	}
+	resolve := func(pattern string) string {
+		if pattern == "." || strings.HasPrefix(pattern, "./") {
+			return path.Join(rootImportPath, pattern)
+		}
+		return pattern
+	}
+	for _, r := range rules.Rules {
+		if r.From == "" {
+			return nil, fmt.Errorf("invalid %s: rule has no from pattern", importRulesFile)
+		}
+		r.From = resolve(r.From)
+		for i := range r.Forbid {
+			r.Forbid[i] = resolve(r.Forbid[i])
+		}
+		for i := range r.Allow {
+			r.Allow[i] = resolve(r.Allow[i])
+		}
+	}
+	return &rules, nil
+}
+
+// matchImportPattern reports whether the import path matches pattern, which
+// must not be "std".
+func matchImportPattern(pattern, importPath string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	// Like for the go command, "foo/..." also matches "foo".
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	matched, _ := regexp.MatchString("^"+re+"$", importPath)
+	return matched
+}
+
+// matchAny reports whether the import path matches any of patterns.
+func (g *importGraph) matchAny(patterns []string, importPath string) bool {
+	for _, p := range patterns {
+		if p == "std" {
+			if g.stdlib[importPath] {
+				return true
+			}
+		} else if matchImportPattern(p, importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// importGraph is the import graph of the packages of a workspace, with the
+// import specs of the imports.
+type importGraph struct {
+	// pkgs are the packages of the workspace by their import paths. The
+	// test files are separate, since their imports are not imports of the
+	// packages depending on the package they test. The internal test files
+	// have a testSuffix, and the external test packages, which are allowed
+	// to import the package they test, have a _test suffix.
+	pkgs map[string]*importGraphPackage
+
+	// stdlib are the packages of the standard library the packages of the
+	// workspace import.
+	stdlib map[string]bool
+}
+
+// testSuffix is the suffix of the keys of the internal test files of the
+// packages in importGraph.pkgs.
+const testSuffix = " [test]"
+
+type importGraphPackage struct {
+	importPath string // without the suffix of test files
+	test       bool   // the internal test files of the package
+	imports    map[string][]importSpec
+}
+
+// importSpec is where a file imports a package.
+type importSpec struct {
+	filename string
+	rng      lsp.Range
+}
+
+// sortedImports returns the import paths of the packages p imports.
+func (p *importGraphPackage) sortedImports() []string {
+	imports := make([]string, 0, len(p.imports))
+	for imp := range p.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+// buildImportGraph returns the import graph of the packages under rootPath.
+// The imports are resolved to the import paths of the packages they import,
+// e.g. of vendored packages.
+func buildImportGraph(ctx context.Context, bctx *build.Context, findPackage FindPackageFunc, rootPath string) *importGraph {
+	fset := token.NewFileSet()
+	g := &importGraph{pkgs: map[string]*importGraphPackage{}, stdlib: map[string]bool{}}
+	for _, pkgPath := range tools.ListPkgsUnderDir(bctx, rootPath) {
+		bpkg, err := findPackage(ctx, bctx, pkgPath, rootPath, rootPath, 0)
+		if err != nil {
+			continue
+		}
+
+		add := func(key string, files ...[]string) {
+			p := &importGraphPackage{importPath: bpkg.ImportPath, test: key == bpkg.ImportPath+testSuffix, imports: map[string][]importSpec{}}
+			for _, names := range files {
+				for _, name := range names {
+					f, err := buildutil.ParseFile(fset, bctx, nil, bpkg.Dir, name, parser.ImportsOnly)
+					if err != nil {
+						continue
+					}
+					for _, spec := range f.Imports {
+						imp, err := strconv.Unquote(spec.Path.Value)
+						if err != nil || imp == "C" {
+							continue
+						}
+						if dep, err := findPackage(ctx, bctx, imp, bpkg.Dir, rootPath, build.FindOnly); err == nil && dep.ImportPath != "" {
+							imp = dep.ImportPath
+							if dep.Goroot {
+								g.stdlib[imp] = true
+							}
+						}
+						start, end := fset.Position(spec.Path.Pos()), fset.Position(spec.Path.End())
+						p.imports[imp] = append(p.imports[imp], importSpec{
+							filename: path.Join(bpkg.Dir, name),
+							rng: lsp.Range{
+								Start: lsp.Position{Line: start.Line - 1, Character: start.Column - 1},
+								End:   lsp.Position{Line: end.Line - 1, Character: end.Column - 1},
+							},
+						})
+					}
+				}
+			}
+			g.pkgs[key] = p
+		}
+		add(bpkg.ImportPath, bpkg.GoFiles, bpkg.CgoFiles)
+		if len(bpkg.TestGoFiles) > 0 {
+			add(bpkg.ImportPath+testSuffix, bpkg.TestGoFiles)
+		}
+		if len(bpkg.XTestGoFiles) > 0 {
+			add(bpkg.ImportPath+"_test", bpkg.XTestGoFiles)
+		}
+	}
+	return g
+}
+
+// shortestPaths returns the shortest import path from the package at key to
+// each package it depends on, directly or via other packages of the
+// workspace. The paths start with the first import, and end with the
+// dependency. Only the first import may be from test files.
+func (g *importGraph) shortestPaths(key string) map[string][]string {
+	start := g.pkgs[key]
+	if start == nil {
+		return nil
+	}
+	paths := map[string][]string{}
+	queue := []string{}
+	for _, imp := range start.sortedImports() {
+		paths[imp] = []string{imp}
+		queue = append(queue, imp)
+	}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		p := g.pkgs[next] // nil for packages outside of the workspace
+		if p == nil {
+			continue
+		}
+		for _, imp := range p.sortedImports() {
+			if _, seen := paths[imp]; !seen {
+				paths[imp] = append(append([]string(nil), paths[next]...), imp)
+				queue = append(queue, imp)
+			}
+		}
+	}
+	return paths
+}
+
+// importPath returns the shortest path of imports from the package from to
+// the package to, starting with from, or nil if from doesn't depend on to.
+// The test files of from are also searched.
+func (g *importGraph) importPath(from, to string) []string {
+	for _, key := range []string{from, from + testSuffix, from + "_test"} {
+		if p := g.shortestPaths(key)[to]; p != nil {
+			return append([]string{from}, p...)
+		}
+	}
+	return nil
+}
+
+// check returns the diagnostics of the import cycles in the workspace, and
+// of the violations of rules, at the imports which cause them.
+func (g *importGraph) check(rules *importRules) diagnostics {
+	diags := diagnostics{}
+	report := func(p *importGraphPackage, imp, message string) {
+		for _, spec := range p.imports[imp] {
+			diags[spec.filename] = append(diags[spec.filename], &lsp.Diagnostic{
+				Range:    spec.rng,
+				Severity: lsp.Error,
+				Source:   importRulesSource,
+				Message:  message,
+			})
+		}
+	}
+
+	keys := make([]string, 0, len(g.pkgs))
+	for key := range g.pkgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		p := g.pkgs[key]
+		paths := g.shortestPaths(key)
+
+		if cycle := paths[key]; cycle != nil {
+			report(p, cycle[0], fmt.Sprintf("import cycle not allowed: %s", strings.Join(append([]string{p.importPath}, cycle...), " -> ")))
+		}
+		if cycle := paths[p.importPath]; p.test && cycle != nil {
+			report(p, cycle[0], fmt.Sprintf("import cycle not allowed in test: %s", strings.Join(append([]string{p.importPath}, cycle...), " -> ")))
+		}
+
+		for _, r := range rules.Rules {
+			if !matchImportPattern(r.From, p.importPath) {
+				continue
+			}
+			if r.Allow != nil {
+				for _, imp := range p.sortedImports() {
+					// External test packages may import the package
+					// they test.
+					if imp != p.importPath && !g.matchAny(r.Allow, imp) {
+						report(p, imp, fmt.Sprintf("import %q violates import rule: %q may only import %s", imp, p.importPath, quoteList(r.Allow)))
+					}
+				}
+			}
+			if r.Forbid != nil {
+				deps := make([]string, 0, len(paths))
+				for dep := range paths {
+					deps = append(deps, dep)
+				}
+				sort.Strings(deps)
+				for _, dep := range deps {
+					if dep == p.importPath || !g.matchAny(r.Forbid, dep) {
+						continue
+					}
+					via := paths[dep]
+					message := fmt.Sprintf("import %q violates import rule: %q must not depend on %q", via[0], p.importPath, dep)
+					if len(via) > 1 {
+						message += fmt.Sprintf(" (%s)", strings.Join(append([]string{p.importPath}, via...), " -> "))
+					}
+					report(p, via[0], message)
+				}
+			}
+		}
+	}
+	return diags
+}
+
+func quoteList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = strconv.Quote(s)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// readImportRules returns the import rules of the workspace, or nil if it
+// has no importRulesFile or the file is empty.
+func (h *LangHandler) readImportRules(bctx *build.Context) (*importRules, error) {
+	f, err := buildutil.OpenFile(bctx, path.Join(h.RootFSPath, importRulesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	return parseImportRules(data, h.rootImportPath(bctx))
+}
+
+// rootImportPath returns the import path of the root of the workspace.
+func (h *LangHandler) rootImportPath(bctx *build.Context) string {
+	if h.init.RootImportPath != "" {
+		return h.init.RootImportPath
+	}
+	for _, gopath := range filepath.SplitList(bctx.GOPATH) {
+		src := path.Join(gopath, "src")
+		if util.PathHasPrefix(h.RootFSPath, src) {
+			return util.PathTrimPrefix(h.RootFSPath, src)
+		}
+	}
+	return ""
+}
+
+// checkImportRules publishes the diagnostics of the import cycles in the
+// workspace and the violations of its import rules, if it has an
+// importRulesFile. Otherwise it clears them.
+func (h *LangHandler) checkImportRules(ctx context.Context, bctx *build.Context, conn jsonrpc2.JSONRPC2) error {
+	rules, err := h.readImportRules(bctx)
+	if err != nil {
+		return err
+	}
+
+	// Files which no longer violate any rules must be published too,
+	// so that their diagnostics are cleared.
+	files := h.diagnosticsCache.files(importRulesSource)
+	var diags diagnostics
+	if rules != nil {
+		g := buildImportGraph(ctx, bctx, h.getFindPackageFunc(), h.RootFSPath)
+		diags = g.check(rules)
+		for filename := range diags {
+			files = append(files, filename)
+		}
+	}
+	return h.publishDiagnostics(ctx, conn, diags, importRulesSource, files)
+}
+
+// handleImportPathCommand returns the shortest path of imports from the
+// package of the workspace at the import path from to the package at the
+// import path to, which is the path that causes an import rule violation.
+func (h *LangHandler) handleImportPathCommand(ctx context.Context, from, to string) []string {
+	bctx := h.BuildContext(ctx)
+	root := h.rootImportPath(bctx)
+	resolve := func(importPath string) string {
+		if importPath == "." || strings.HasPrefix(importPath, "./") {
+			return path.Join(root, importPath)
+		}
+		return importPath
+	}
+	g := buildImportGraph(ctx, bctx, h.getFindPackageFunc(), h.RootFSPath)
+	return g.importPath(resolve(from), resolve(to))
+}
@@ -0,0 +1,170 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"go/build"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-lsp"
+)
+
+func TestMatchImportPattern(t *testing.T) {
+	tests := []struct {
+		pattern, importPath string
+		want                bool
+	}{
+		{"test/a", "test/a", true},
+		{"test/a", "test/a/b", false},
+		{"test/a/...", "test/a", true},
+		{"test/a/...", "test/a/b/c", true},
+		{"test/a/...", "test/ab", false},
+		{"test/.../internal", "test/a/internal", true},
+		{"test/.../internal", "test/a/internals", false},
+	}
+	for _, test := range tests {
+		if got := matchImportPattern(test.pattern, test.importPath); got != test.want {
+			t.Errorf("matchImportPattern(%q, %q) = %v, want %v", test.pattern, test.importPath, got, test.want)
+		}
+	}
+}
+
+func TestImportRules(t *testing.T) {
+	// buildutil.FakeContext can't list the packages under a directory, so
+	// the files are in a VFS.
+	fs := ctxvfs.NameSpace{}
+	fs.Bind("/", mapFS(map[string]string{
+		"gopath/src/test/storage/storage.go":  "package storage\n\nimport (\n\t\"fmt\"\n\t\"test/util\"\n)\n",
+		"gopath/src/test/util/util.go":        "package util\n\nimport \"test/http\"\n",
+		"gopath/src/test/util/util_test.go":   "package util\n\nimport \"test/domain\"\n",
+		"gopath/src/test/http/http.go":        "package http\n",
+		"gopath/src/test/http/http_test.go":   "package http_test\n\nimport (\n\t\"test/http\"\n\t\"test/storage\"\n)\n",
+		"gopath/src/test/http/export_test.go": "package http\n\nimport \"test/storage\"\n",
+		"gopath/src/test/domain/domain.go":    "package domain\n\nimport (\n\t\"fmt\"\n\t\"other\"\n)\n",
+		"gopath/src/test/a/a.go":              "package a\n\nimport \"test/b\"\n",
+		"gopath/src/test/b/b.go":              "package b\n\nimport \"test/a\"\n",
+		"gopath/src/other/other.go":           "package other\n",
+		"goroot/src/fmt/fmt.go":               "package fmt\n",
+	}), "/", ctxvfs.BindReplace)
+	bctx := &build.Context{GOOS: "linux", GOARCH: "amd64", GOROOT: "/goroot", GOPATH: "/gopath", Compiler: "gc"}
+	util.PrepareContext(bctx, context.Background(), fs)
+
+	rules, err := parseImportRules([]byte(`{"rules": [
+		{"from": "./storage/...", "forbid": ["./http/...", "./domain"]},
+		{"from": "./domain", "allow": ["std", "./domain/..."]}
+	]}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"test/http/...", "test/domain"}; !reflect.DeepEqual(rules.Rules[0].Forbid, want) {
+		t.Errorf("got forbid %q, want %q", rules.Rules[0].Forbid, want)
+	}
+
+	g := buildImportGraph(context.Background(), bctx, defaultFindPackageFunc, "/gopath/src/test")
+	diags := g.check(rules)
+	got := map[string][]string{}
+	for filename, fileDiags := range diags {
+		for _, d := range fileDiags {
+			if d.Source != importRulesSource {
+				t.Errorf("got source %q, want %q", d.Source, importRulesSource)
+			}
+			got[filename] = append(got[filename], d.Message)
+		}
+		sort.Strings(got[filename])
+	}
+	want := map[string][]string{
+		"/gopath/src/test/storage/storage.go": {`import "test/util" violates import rule: "test/storage" must not depend on "test/http" (test/storage -> test/util -> test/http)`},
+		"/gopath/src/test/domain/domain.go":   {`import "other" violates import rule: "test/domain" may only import "std", "test/domain/..."`},
+		"/gopath/src/test/a/a.go":             {`import cycle not allowed: test/a -> test/b -> test/a`},
+		"/gopath/src/test/b/b.go":             {`import cycle not allowed: test/b -> test/a -> test/b`},
+		// The imports of test files are not imports of the packages
+		// depending on the package.
+		"/gopath/src/test/http/export_test.go": {`import cycle not allowed in test: test/http -> test/storage -> test/util -> test/http`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got diagnostics %q, want %q", got, want)
+	}
+
+	// The diagnostic is on the path of the import spec.
+	d := diags["/gopath/src/test/storage/storage.go"][0]
+	if d.Range.Start.Line != 4 || d.Range.Start.Character != 1 || d.Range.End.Character != 12 {
+		t.Errorf("got range %+v", d.Range)
+	}
+
+	if got, want := g.importPath("test/storage", "test/http"), []string{"test/storage", "test/util", "test/http"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got import path %q, want %q", got, want)
+	}
+	if got := g.importPath("test/http", "test/util"); !reflect.DeepEqual(got, []string{"test/http", "test/storage", "test/util"}) {
+		t.Errorf("got import path %q via the external test package", got)
+	}
+	if got := g.importPath("test/domain", "test/http"); got != nil {
+		t.Errorf("got import path %q, want none", got)
+	}
+}
+
+func TestCheckImportRules(t *testing.T) {
+	files := map[string]string{
+		"gopath/src/test/" + importRulesFile: `{"rules": [{"from": "./a", "forbid": ["./b"]}]}`,
+		"gopath/src/test/a/a.go":             "package a\n\nimport \"test/b\"\n",
+		"gopath/src/test/b/b.go":             "package b\n",
+	}
+	h := &LangHandler{
+		HandlerShared:    &HandlerShared{},
+		init:             &InitializeParams{RootImportPath: "test"},
+		config:           &Config{DiagnosticsEnabled: true},
+		diagnosticsCache: newDiagnosticsCache(),
+	}
+	h.RootFSPath = "/gopath/src/test"
+	check := func() map[lsp.DocumentURI]int {
+		t.Helper()
+		fs := ctxvfs.NameSpace{}
+		fs.Bind("/", mapFS(files), "/", ctxvfs.BindReplace)
+		bctx := &build.Context{GOOS: "linux", GOARCH: "amd64", GOROOT: "/goroot", GOPATH: "/gopath", Compiler: "gc"}
+		util.PrepareContext(bctx, context.Background(), fs)
+		conn := &recordingConn{}
+		if err := h.checkImportRules(context.Background(), bctx, conn); err != nil {
+			t.Fatal(err)
+		}
+		var published []lsp.PublishDiagnosticsParams
+		for _, msg := range conn.msgs {
+			var params lsp.PublishDiagnosticsParams
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(msg, "notify textDocument/publishDiagnostics ")), &params); err != nil {
+				t.Fatal(err)
+			}
+			published = append(published, params)
+		}
+		return publishedDiagnosticsToMap(published...)
+	}
+	const a = "file:///gopath/src/test/a/a.go"
+
+	if got, want := check(), map[lsp.DocumentURI]int{a: 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Removing the last import of a file clears its diagnostics.
+	files["gopath/src/test/a/a.go"] = "package a\n"
+	if got, want := check(), map[lsp.DocumentURI]int{a: 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v after removing the import, want %v", got, want)
+	}
+	files["gopath/src/test/a/a.go"] = "package a\n\nimport \"test/b\"\n"
+	if got, want := check(), map[lsp.DocumentURI]int{a: 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// So does emptying or removing the rules.
+	files["gopath/src/test/"+importRulesFile] = "\n"
+	if got, want := check(), map[lsp.DocumentURI]int{a: 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v after emptying the rules, want %v", got, want)
+	}
+	files["gopath/src/test/"+importRulesFile] = `{"rules": [{"from": "./a", "forbid": ["./b"]}]}`
+	if got, want := check(), map[lsp.DocumentURI]int{a: 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	delete(files, "gopath/src/test/"+importRulesFile)
+	if got, want := check(), map[lsp.DocumentURI]int{a: 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v after removing the rules, want %v", got, want)
+	}
+}
@@ -0,0 +1,456 @@
+package langserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/loader"
+
+	"github.com/sourcegraph/go-langserver/diskcache"
+)
+
+// IndexDir is the location on disk where the persistent index is stored. The
+// index keeps expensive to compute per package data, such as symbols and
+// export data, across restarts. If empty, the persistent index is disabled.
+var IndexDir = ""
+
+// MaxIndexSizeBytes is the maximum size of IndexDir after pruning
+// entries. Defaults to 1 GB.
+var MaxIndexSizeBytes = int64(1024 * 1024 * 1024)
+
+// indexVersion is stored in every index entry. It must be incremented
+// whenever the format of the data stored in the index changes, so that
+// entries written by older versions are ignored.
+const indexVersion = 1
+
+// indexPruneInterval is the number of entries written to the index after
+// which we prune it.
+const indexPruneInterval = 100
+
+const (
+	indexKindSymbols    = "symbols"
+	indexKindExportData = "exportdata"
+)
+
+var (
+	diskIndexOnce   sync.Once
+	sharedDiskIndex *diskIndex
+
+	indexTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "golangserver_index_request_total",
+		Help: "Count of requests to the persistent index.",
+	}, []string{"kind", "type"})
+)
+
+func init() {
+	prometheus.MustRegister(indexTotal)
+}
+
+// indexKey identifies an entry in the persistent index. Since it includes a
+// hash of everything the entry was computed from, entries never need to be
+// invalidated. Entries which are no longer used are eventually pruned.
+type indexKey struct {
+	Kind       string
+	ImportPath string
+	Dir        string
+
+	// Build describes the build configuration the entry was computed
+	// with. See buildConfigKey.
+	Build string
+
+	// Hash is the hash of the package contents. See hashPkgFiles and
+	// pkgHasher.
+	Hash string
+}
+
+func (k indexKey) String() string {
+	return strings.Join([]string{k.Kind, k.ImportPath, k.Dir, k.Build, k.Hash}, "\x00")
+}
+
+// indexEntryMeta is stored alongside the data of an entry, so we can validate
+// it when loading.
+type indexEntryMeta struct {
+	Version int
+	Key     string
+}
+
+// diskIndex is a persistent on disk cache of per package data. It is safe for
+// concurrent use by multiple LangHandlers. A nil *diskIndex is valid, and
+// always computes the data.
+type diskIndex struct {
+	store *diskcache.Store
+
+	mu     sync.Mutex
+	writes int // number of writes since the last prune
+}
+
+// getDiskIndex returns the process level disk index. It returns nil if the
+// persistent index is disabled.
+func getDiskIndex() *diskIndex {
+	diskIndexOnce.Do(func() {
+		if IndexDir == "" {
+			return
+		}
+		sharedDiskIndex = &diskIndex{
+			store: &diskcache.Store{
+				Dir:               IndexDir,
+				Component:         "langserver-index",
+				MaxCacheSizeBytes: MaxIndexSizeBytes,
+			},
+		}
+		// Prune once on startup, in case we were previously run with
+		// a larger MaxIndexSizeBytes.
+		go sharedDiskIndex.prune()
+	})
+	return sharedDiskIndex
+}
+
+// get returns the data stored in the index for key. If there is no valid
+// entry for key, fill is called to compute the data, which is then stored in
+// the index.
+func (ix *diskIndex) get(ctx context.Context, key indexKey, fill func() ([]byte, error)) ([]byte, error) {
+	if ix == nil {
+		return fill()
+	}
+
+	keyString := key.String()
+
+	// The fetcher keeps running in the background when ctx is
+	// cancelled, so its results are guarded by a mutex.
+	var res struct {
+		sync.Mutex
+		filled  bool
+		data    []byte
+		fillErr error
+	}
+	f, err := ix.store.Open(ctx, keyString, func(ctx context.Context) (io.ReadCloser, error) {
+		res.Lock()
+		defer res.Unlock()
+		res.filled = true
+		res.data, res.fillErr = fill()
+		if res.fillErr != nil {
+			return nil, res.fillErr
+		}
+		b, err := encodeIndexEntry(keyString, res.data)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	})
+	if err != nil && ctx.Err() != nil {
+		// Don't fill again while the cancelled fill may still be
+		// running.
+		return nil, ctx.Err()
+	}
+	res.Lock()
+	didFill, filled, fillErr := res.filled, res.data, res.fillErr
+	res.Unlock()
+	if didFill {
+		indexTotal.WithLabelValues(key.Kind, "miss").Inc()
+		if f != nil {
+			f.Close()
+			ix.wrote()
+		}
+		// Even if we failed to store the data, we can still use it.
+		return filled, fillErr
+	}
+	if err != nil {
+		// We did not fill since someone else was filling concurrently,
+		// but they failed.
+		return fill()
+	}
+	defer f.Close()
+
+	data, err := decodeIndexEntry(f.File, keyString)
+	if err != nil {
+		// The entry is corrupt or was written by a different
+		// version. Remove it so it is stored again the next time.
+		indexTotal.WithLabelValues(key.Kind, "invalid").Inc()
+		_ = os.Remove(f.Path)
+		return fill()
+	}
+	indexTotal.WithLabelValues(key.Kind, "hit").Inc()
+	return data, nil
+}
+
+func (ix *diskIndex) wrote() {
+	ix.mu.Lock()
+	ix.writes++
+	prune := ix.writes >= indexPruneInterval
+	if prune {
+		ix.writes = 0
+	}
+	ix.mu.Unlock()
+	if prune {
+		go ix.prune()
+	}
+}
+
+// prune removes the least recently used entries until the index is smaller
+// than MaxIndexSizeBytes.
+func (ix *diskIndex) prune() {
+	_, _ = ix.store.EvictMaxSize(ix.store.MaxCacheSizeBytes)
+}
+
+// encodeIndexEntry encodes data as an index entry. An entry is a zip archive,
+// which gives us a checksum of the data for free.
+func encodeIndexEntry(key string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("meta.json")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(w).Encode(&indexEntryMeta{Version: indexVersion, Key: key}); err != nil {
+		return nil, err
+	}
+	w, err = zw.Create("data")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeIndexEntry returns the data of the index entry stored in f. It
+// returns an error if the entry is corrupt or is not for key.
+func decodeIndexEntry(f *os.File, key string) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "meta.json" || zr.File[1].Name != "data" {
+		return nil, errors.New("unexpected index entry layout")
+	}
+
+	meta, err := readZipFile(zr.File[0])
+	if err != nil {
+		return nil, err
+	}
+	var m indexEntryMeta
+	if err := json.Unmarshal(meta, &m); err != nil {
+		return nil, err
+	}
+	if m.Version != indexVersion {
+		return nil, fmt.Errorf("index entry has version %d, want %d", m.Version, indexVersion)
+	}
+	if m.Key != key {
+		return nil, errors.New("index entry is for a different key")
+	}
+
+	// Reading the whole file verifies its checksum.
+	return readZipFile(zr.File[1])
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// buildConfigKey returns a string describing everything in bctx which affects
+// the results of parsing and typechecking packages.
+func buildConfigKey(bctx *build.Context) string {
+	tags := append([]string(nil), bctx.BuildTags...)
+	sort.Strings(tags)
+	return fmt.Sprintf("%s %s/%s cgo=%t compiler=%s tags=%s allfiles=%t",
+		runtime.Version(), bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled, bctx.Compiler, strings.Join(tags, ","), bctx.UseAllFiles)
+}
+
+// hashPkgFiles returns the hash of the names and contents of the files in
+// dir.
+func hashPkgFiles(bctx *build.Context, dir string, files []string) (string, error) {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+	h := sha256.New()
+	var buf bytes.Buffer
+	for _, name := range files {
+		buf.Reset()
+		contents, err := readFile(bctx, buildutil.JoinPath(bctx, dir, name), &buf)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", name, len(contents))
+		h.Write(contents)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// goFilesInDir returns the names of all Go files in dir, regardless of build
+// constraints.
+func goFilesInDir(bctx *build.Context, dir string) ([]string, error) {
+	list, err := buildutil.ReadDir(bctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range list {
+		if strings.HasSuffix(fi.Name(), ".go") && !fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// pkgHasher computes the content hashes of packages. The hash of a package
+// covers the contents of its files as well as the hashes of its imports, so
+// it changes whenever anything which could affect the result of typechecking
+// the package changes.
+type pkgHasher struct {
+	ctx         context.Context
+	bctx        *build.Context
+	findPackage FindPackageFunc
+	rootPath    string
+
+	mu     sync.Mutex
+	hashes map[string]string // package dir -> hash
+}
+
+func newPkgHasher(ctx context.Context, bctx *build.Context, findPackage FindPackageFunc, rootPath string) *pkgHasher {
+	return &pkgHasher{
+		ctx:         ctx,
+		bctx:        bctx,
+		findPackage: findPackage,
+		rootPath:    rootPath,
+		hashes:      map[string]string{},
+	}
+}
+
+// hash returns the hash of bpkg. Test files of bpkg are not included.
+func (p *pkgHasher) hash(bpkg *build.Package) (string, error) {
+	return p.hashStack(bpkg, nil)
+}
+
+func (p *pkgHasher) hashStack(bpkg *build.Package, stack []string) (string, error) {
+	if bpkg.Goroot && bpkg.ImportPath == "unsafe" {
+		return "unsafe", nil
+	}
+	for _, dir := range stack {
+		if dir == bpkg.Dir {
+			return "", fmt.Errorf("import cycle via %s", bpkg.ImportPath)
+		}
+	}
+	stack = append(stack, bpkg.Dir)
+
+	// Note: Concurrent calls may end up hashing the same package. This is
+	// fine, and avoids having to detect import cycles across goroutines.
+	p.mu.Lock()
+	hash, ok := p.hashes[bpkg.Dir]
+	p.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	var files []string
+	files = append(files, bpkg.GoFiles...)
+	files = append(files, bpkg.CgoFiles...)
+	filesHash, err := hashPkgFiles(p.bctx, bpkg.Dir, files)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s %s\n", bpkg.ImportPath, bpkg.Name, filesHash)
+	for _, imp := range bpkg.Imports {
+		if imp == "C" {
+			continue
+		}
+		dep, err := p.findPackage(p.ctx, p.bctx, imp, bpkg.Dir, p.rootPath, 0)
+		if err != nil && !isMultiplePackageError(err) {
+			return "", errors.Wrapf(err, "hashing %s", bpkg.ImportPath)
+		}
+		depHash, err := p.hashStack(dep, stack)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", path.Clean(dep.ImportPath), depHash)
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+
+	p.mu.Lock()
+	p.hashes[bpkg.Dir] = hash
+	p.mu.Unlock()
+	return hash, nil
+}
+
+// exportDataKey returns the index key for the export data of bpkg.
+func exportDataKey(bctx *build.Context, bpkg *build.Package, hasher *pkgHasher) (indexKey, error) {
+	hash, err := hasher.hash(bpkg)
+	if err != nil {
+		return indexKey{}, err
+	}
+	return indexKey{
+		Kind:       indexKindExportData,
+		ImportPath: bpkg.ImportPath,
+		Dir:        bpkg.Dir,
+		Build:      buildConfigKey(bctx),
+		Hash:       hash,
+	}, nil
+}
+
+// indexExportData stores the export data of the packages imported by prog in
+// the persistent index. Packages which were created from files (ie the
+// package we typechecked for the user) or contain errors are skipped, since
+// their export data is either not reused or incomplete.
+func indexExportData(ctx context.Context, ix *diskIndex, fset *token.FileSet, bctx *build.Context, prog *loader.Program, findPackage FindPackageFunc, rootPath string) {
+	if ix == nil || prog == nil {
+		return
+	}
+	hasher := newPkgHasher(ctx, bctx, findPackage, rootPath)
+	created := map[*loader.PackageInfo]bool{}
+	for _, info := range prog.Created {
+		created[info] = true
+	}
+	for _, info := range prog.AllPackages {
+		if created[info] || !info.TransitivelyErrorFree || info.Pkg.Path() == "unsafe" {
+			continue
+		}
+		bpkg, err := findPackage(ctx, bctx, info.Pkg.Path(), rootPath, rootPath, 0)
+		if err != nil && !isMultiplePackageError(err) {
+			continue
+		}
+		key, err := exportDataKey(bctx, bpkg, hasher)
+		if err != nil {
+			continue
+		}
+		pkg := info.Pkg
+		_, _ = ix.get(ctx, key, func() ([]byte, error) {
+			var buf bytes.Buffer
+			if err := gcexportdata.Write(&buf, fset, pkg); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/tools/go/gcexportdata"
+
+	"github.com/sourcegraph/go-langserver/diskcache"
+)
+
+func newTestDiskIndex(t *testing.T) (*diskIndex, func()) {
+	dir, err := ioutil.TempDir("", "langserver-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := &diskIndex{
+		store: &diskcache.Store{
+			Dir:               dir,
+			MaxCacheSizeBytes: MaxIndexSizeBytes,
+		},
+	}
+	return ix, func() { os.RemoveAll(dir) }
+}
+
+func TestDiskIndex(t *testing.T) {
+	ix, cleanup := newTestDiskIndex(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	key := indexKey{Kind: indexKindSymbols, ImportPath: "p", Dir: "/src/p", Build: "b", Hash: "h1"}
+	fills := 0
+	get := func(key indexKey) string {
+		t.Helper()
+		data, err := ix.get(ctx, key, func() ([]byte, error) {
+			fills++
+			return []byte(key.Hash), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := get(key); got != "h1" || fills != 1 {
+		t.Fatalf("got %q after %d fills, want %q after 1 fill", got, fills, "h1")
+	}
+	if got := get(key); got != "h1" || fills != 1 {
+		t.Fatalf("got %q after %d fills, want %q from the index", got, fills, "h1")
+	}
+
+	// Changing the contents changes the key, so we should refill.
+	key2 := key
+	key2.Hash = "h2"
+	if got := get(key2); got != "h2" || fills != 2 {
+		t.Fatalf("got %q after %d fills, want %q after 2 fills", got, fills, "h2")
+	}
+
+	// Corrupt entries are removed and refilled.
+	files, err := filepath.Glob(filepath.Join(ix.store.Dir, "*.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 entries on disk, got %d", len(files))
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(f, []byte("garbage"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := get(key); got != "h1" || fills != 3 {
+		t.Fatalf("got %q after %d fills, want %q after 3 fills", got, fills, "h1")
+	}
+	if got := get(key); got != "h1" || fills != 4 {
+		t.Fatalf("got %q after %d fills, want the invalid entry to be refilled", got, fills)
+	}
+	if got := get(key); got != "h1" || fills != 4 {
+		t.Fatalf("got %q after %d fills, want %q from the index", got, fills, "h1")
+	}
+
+	// Errors from fill are returned, and nothing is stored.
+	key3 := key
+	key3.Hash = "h3"
+	wantErr := errors.New("fill failed")
+	if _, err := ix.get(ctx, key3, func() ([]byte, error) { return nil, wantErr }); err != wantErr {
+		t.Fatalf("got error %v, want %v", err, wantErr)
+	}
+	if got := get(key3); got != "h3" {
+		t.Fatalf("got %q, want %q", got, "h3")
+	}
+
+	// Pruning to 0 bytes removes everything.
+	if _, err := ix.store.EvictMaxSize(0); err != nil {
+		t.Fatal(err)
+	}
+	fills = 0
+	if got := get(key); got != "h1" || fills != 1 {
+		t.Fatalf("got %q after %d fills, want the pruned entry to be refilled", got, fills)
+	}
+}
+
+func TestDiskIndex_cancel(t *testing.T) {
+	ix, cleanup := newTestDiskIndex(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	key := indexKey{Kind: indexKindSymbols, ImportPath: "p", Dir: "/src/p", Build: "b", Hash: "h1"}
+	var fills int32
+	started, unblock := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+		close(done)
+	}()
+	_, err := ix.get(ctx, key, func() ([]byte, error) {
+		if atomic.AddInt32(&fills, 1) == 1 {
+			close(started)
+			<-unblock
+		}
+		return []byte("h1"), nil
+	})
+	<-done
+	if err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	close(unblock)
+	if n := atomic.LoadInt32(&fills); n != 1 {
+		t.Fatalf("got %d fills, want the cancelled get not to fill again", n)
+	}
+}
+
+func TestDiskIndex_nil(t *testing.T) {
+	var ix *diskIndex
+	data, err := ix.get(context.Background(), indexKey{}, func() ([]byte, error) {
+		return []byte("data"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("got %q, want %q", data, "data")
+	}
+}
+
+func TestPkgHasher(t *testing.T) {
+	ctx := context.Background()
+	hash := func(fs map[string]string) string {
+		t.Helper()
+		_, bctx, _ := setUpLoaderTest(fs)
+		bctx.Compiler = "gc"
+		bpkg, err := defaultFindPackageFunc(ctx, bctx, "p", "/src/p", "/src/p", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := newPkgHasher(ctx, bctx, defaultFindPackageFunc, "/src/p").hash(bpkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := hash(map[string]string{
+		"/src/p/p.go": `package p; import "q"; var _ = q.Q`,
+		"/src/q/q.go": `package q; const Q = 1`,
+	})
+	same := hash(map[string]string{
+		"/src/p/p.go": `package p; import "q"; var _ = q.Q`,
+		"/src/q/q.go": `package q; const Q = 1`,
+	})
+	if base != same {
+		t.Error("expected hash to be the same for the same contents")
+	}
+	depChanged := hash(map[string]string{
+		"/src/p/p.go": `package p; import "q"; var _ = q.Q`,
+		"/src/q/q.go": `package q; const Q = 2`,
+	})
+	if base == depChanged {
+		t.Error("expected hash to change if an import changes")
+	}
+	testChanged := hash(map[string]string{
+		"/src/p/p.go":      `package p; import "q"; var _ = q.Q`,
+		"/src/p/p_test.go": `package p`,
+		"/src/q/q.go":      `package q; const Q = 1`,
+	})
+	if base != testChanged {
+		t.Error("expected hash to ignore test files")
+	}
+}
+
+func TestIndexExportData(t *testing.T) {
+	ix, cleanup := newTestDiskIndex(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	fset, bctx, _ := setUpLoaderTest(map[string]string{
+		"/src/p/p.go": `package p; import "q"; var _ = q.Q`,
+		"/src/q/q.go": `package q; const Q = 1`,
+	})
+	bctx.Compiler = "gc"
+	bpkg, err := defaultFindPackageFunc(ctx, bctx, "p", "/src/p", "/src/p", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, _, err := typecheck(ctx, fset, bctx, bpkg, defaultFindPackageFunc, "/src/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExportData(ctx, ix, fset, bctx, prog, defaultFindPackageFunc, "/src/p")
+
+	qpkg, err := defaultFindPackageFunc(ctx, bctx, "q", "/src/p", "/src/p", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := exportDataKey(bctx, qpkg, newPkgHasher(ctx, bctx, defaultFindPackageFunc, "/src/p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ix.get(ctx, key, func() ([]byte, error) {
+		return nil, errors.New("export data for q was not indexed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := gcexportdata.Read(bytes.NewReader(data), fset, map[string]*types.Package{}, "q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Scope().Lookup("Q") == nil {
+		t.Error("expected export data of q to contain Q")
+	}
+}
@@ -625,7 +625,7 @@ func (p *parser) parseFieldDecl() *ast.Field {
 	// optional tag
 	var tag *ast.BasicLit
 	if p.tok == token.STRING {
-		tag = &ast.BasicLit{p.pos, p.tok, p.lit}
+		tag = &ast.BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.lit}
 		p.next()
 	}
 
@@ -855,7 +855,7 @@ func (p *parser) parseFuncType() (*ast.FuncType, *ast.Scope) {
 	scope := p.newScope(p.topScope) // function scope
 	params, results := p.parseSignature(scope)
 
-	return &ast.FuncType{pos, params, results}, scope
+	return &ast.FuncType{Func: pos, Params: params, Results: results}, scope
 }
 
 func (p *parser) parseMethodSpec() *ast.Field {
@@ -874,7 +874,7 @@ func (p *parser) parseMethodSpec() *ast.Field {
 
 		scope := p.newScope(nil) // method scope
 		params, results := p.parseSignature(scope)
-		f.Type = &ast.FuncType{token.NoPos, params, results}
+		f.Type = &ast.FuncType{Params: params, Results: results}
 	} else {
 		// embedded interface
 		f.Type = x
@@ -1052,7 +1052,7 @@ func (p *parser) parseOperand() ast.Expr {
 		return ident
 
 	case token.INT, token.FLOAT, token.IMAG, token.CHAR, token.STRING:
-		x := &ast.BasicLit{p.pos, p.tok, p.lit}
+		x := &ast.BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.lit}
 		p.next()
 		return x
 
@@ -1886,7 +1886,7 @@ func (p *parser) parseForStmt() ast.Stmt {
 		if rhs, isUnary := as.Rhs[0].(*ast.UnaryExpr); isUnary && rhs.Op == token.RANGE {
 			// rhs is range expression
 			// (any short variable declaration was handled by parseSimpleStat above)
-			return &ast.RangeStmt{pos, key, value, as.TokPos, as.Tok, rhs.X, body}
+			return &ast.RangeStmt{For: pos, Key: key, Value: value, TokPos: as.TokPos, Tok: as.Tok, X: rhs.X, Body: body}
 		}
 		p.errorExpected(s2.Pos(), "range clause")
 		return &ast.BadStmt{pos, body.End()}
@@ -1974,7 +1974,7 @@ func parseImportSpec(p *parser, doc *ast.CommentGroup, decl *ast.GenDecl, _ int)
 	declIdent := ident
 	var path *ast.BasicLit
 	if p.tok == token.STRING {
-		path = &ast.BasicLit{p.pos, p.tok, p.lit}
+		path = &ast.BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.lit}
 		if declIdent == nil {
 			filename := p.fset.Position(path.Pos()).Filename
 			name, err := p.pathToName(litToString(path), filepath.Dir(filename))
@@ -2140,7 +2140,7 @@ func (p *parser) parseFuncDecl() *ast.FuncDecl {
 	}
 	p.expectSemi()
 
-	decl := &ast.FuncDecl{doc, recv, ident, &ast.FuncType{pos, params, results}, body}
+	decl := &ast.FuncDecl{Doc: doc, Recv: recv, Name: ident, Type: &ast.FuncType{Func: pos, Params: params, Results: results}, Body: body}
 	// Go spec: The scope of an identifier denoting a constant, type,
 	// variable, or function (but not method) declared at top level
 	// (outside any function) is the package block.
@@ -2244,5 +2244,5 @@ func (p *parser) parseFile() *ast.File {
 		panic("internal error: imbalanced scopes")
 	}
 
-	return &ast.File{doc, pos, ident, decls, p.fileScope, nil, nil, p.comments}
+	return &ast.File{Doc: doc, Package: pos, Name: ident, Decls: decls, Scope: p.fileScope, Comments: p.comments}
 }
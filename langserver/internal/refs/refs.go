@@ -215,13 +215,21 @@ func DefInfo(pkg *types.Package, info *types.Info, nodes []ast.Node, pos token.P
 	if obj, ok := obj.(*types.Var); ok && obj.IsField() {
 		// Struct literal
 		if lit, ok := nodes[2].(*ast.CompositeLit); ok {
-			if parent, ok := lit.Type.(*ast.SelectorExpr); ok {
+			litType := lit.Type
+			// Literals of instantiated types, eg List[int]{}
+			switch t := litType.(type) {
+			case *ast.IndexExpr:
+				litType = t.X
+			case *ast.IndexListExpr:
+				litType = t.X
+			}
+			if parent, ok := litType.(*ast.SelectorExpr); ok {
 				return &Def{
 					ImportPath:  obj.Pkg().Path(),
 					PackageName: obj.Pkg().Name(),
 					Path:        fmt.Sprintf("%v %v", parent.Sel, obj.Id()),
 				}, nil
-			} else if parent, ok := lit.Type.(*ast.Ident); ok {
+			} else if parent, ok := litType.(*ast.Ident); ok {
 				return &Def{
 					ImportPath:  obj.Pkg().Path(),
 					PackageName: obj.Pkg().Name(),
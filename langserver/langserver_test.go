@@ -1181,6 +1181,52 @@ func main() {
 			},
 		},
 	},
+	"generics": {
+		rootURI: "file:///src/test/pkg",
+		fs: map[string]string{
+			"a.go": `package p; type List[T any] struct { Next *List[T]; Val T }; func (l *List[T]) Push(v T) {}; func Map[T, U any](xs []T, f func(T) U) []U { return nil }`,
+			"b.go": `package p; func b() { var l List[int]; l.Push(1); _ = Map[int, string](nil, nil); _ = l.Val; _ = Map([]int{1}, func(int) bool { return true }) }`,
+			"c.go": `package p; func c() { var l List[int]; l.Push(1) }`,
+		},
+		cases: lspTestCases{
+			wantHover: map[string]string{
+				"a.go:1:99": "func Map[T, U any](xs []T, f func(T) U) []U",
+				"b.go:1:29": "type List[int] struct; struct {\n    Next *List[int]\n    Val int\n}; func (*List[int]) Push(v int)",
+				"b.go:1:42": "func (*List[int]).Push(v int)",
+				"b.go:1:55": "func Map[int, string](xs []int, f func(int) string) []string; func Map[T, U any](xs []T, f func(T) U) []U",
+				"b.go:1:98": "func Map[int, bool](xs []int, f func(int) bool) []bool; func Map[T, U any](xs []T, f func(T) U) []U",
+				"c.go:1:42": "func (*List[int]).Push(v int)",
+			},
+			wantDefinition: map[string]string{
+				"a.go:1:57": "/src/test/pkg/a.go:1:22-1:23",  // type parameter T
+				"b.go:1:29": "/src/test/pkg/a.go:1:17-1:21",  // List[int]
+				"b.go:1:42": "/src/test/pkg/a.go:1:80-1:84",  // (*List[int]).Push
+				"b.go:1:55": "/src/test/pkg/a.go:1:99-1:102", // Map[int, string]
+				"b.go:1:89": "/src/test/pkg/a.go:1:53-1:56",  // List[int].Val
+				"c.go:1:42": "/src/test/pkg/a.go:1:80-1:84",  // (*List[int]).Push, without generic syntax in c.go
+			},
+			wantTypeDefinition: map[string]string{
+				"a.go:1:53": "/src/test/pkg/a.go:1:22-1:23", // Val is of type parameter type T
+				"b.go:1:27": "/src/test/pkg/a.go:1:17-1:21", // l is of type List[int]
+			},
+			wantReferences: map[string][]string{
+				"a.go:1:80": {
+					"/src/test/pkg/a.go:1:80",
+					"/src/test/pkg/b.go:1:42",
+					"/src/test/pkg/c.go:1:42",
+				},
+				"a.go:1:53": {
+					"/src/test/pkg/a.go:1:53",
+					"/src/test/pkg/b.go:1:89",
+				},
+				"a.go:1:99": {
+					"/src/test/pkg/a.go:1:99",
+					"/src/test/pkg/b.go:1:55",
+					"/src/test/pkg/b.go:1:98",
+				},
+			},
+		},
+	},
 	"renaming": {
 		rootURI: "file:///src/test/pkg",
 		fs: map[string]string{
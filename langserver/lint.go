@@ -61,11 +61,22 @@ func (h *LangHandler) lintPackage(ctx context.Context, bctx *build.Context, conn
 
 // lintWorkspace runs LangHandler.lint for the entire workspace
 func (h *LangHandler) lintWorkspace(ctx context.Context, bctx *build.Context, conn jsonrpc2.JSONRPC2) error {
+	ctx, progress := h.startWorkDoneProgress(ctx, conn, "Linting workspace")
+	defer progress.End("")
+
 	var files []string
 	pkgs := tools.ListPkgsUnderDir(bctx, h.RootFSPath)
+	for _, pkg := range pkgs {
+		progress.Expect(pkg)
+	}
+	// The lint tool runs over the whole workspace at once, so account for
+	// it as one more package.
+	progress.Expect(h.config.LintTool)
+
 	find := h.getFindPackageFunc()
 	for _, pkg := range pkgs {
 		p, err := find(ctx, bctx, pkg, h.RootFSPath, h.RootFSPath, 0)
+		progress.Step(pkg)
 		if err != nil {
 			if _, ok := err.(*build.NoGoError); ok {
 				continue
@@ -80,7 +91,9 @@ func (h *LangHandler) lintWorkspace(ctx context.Context, bctx *build.Context, co
 			files = append(files, path.Join(p.Dir, f))
 		}
 	}
-	return h.lint(ctx, bctx, conn, []string{path.Join(h.RootFSPath, "/...")}, files)
+	err := h.lint(ctx, bctx, conn, []string{path.Join(h.RootFSPath, "/...")}, files)
+	progress.Step(h.config.LintTool)
+	return err
 }
 
 // golint is a wrapper around the golint command that implements the
@@ -67,7 +67,7 @@ func (h *LangHandler) typecheck(ctx context.Context, conn jsonrpc2.JSONRPC2, fil
 	}
 
 	// TODO(sqs): do all pkgs in workspace together?
-	fset, prog, diags, err := h.cachedTypecheck(ctx, bctx, bpkg)
+	fset, prog, diags, err := h.cachedTypecheck(ctx, conn, bctx, bpkg)
 	if err != nil {
 		return nil, nil, nil, nil, nil, nil, err
 	}
@@ -188,7 +188,9 @@ type typecheckKey struct {
 	// can be multiple packages (e.g., build-tag-disabled main.go
 	// files) with the same names
 
-	// TODO(sqs): include build context in key
+	// buildConfig is the buildConfigKey of the build context, since it
+	// decides which files are part of the package.
+	buildConfig string
 }
 
 type typecheckResult struct {
@@ -197,7 +199,7 @@ type typecheckResult struct {
 	err  error
 }
 
-func (h *LangHandler) cachedTypecheck(ctx context.Context, bctx *build.Context, bpkg *build.Package) (*token.FileSet, *loader.Program, diagnostics, error) {
+func (h *LangHandler) cachedTypecheck(ctx context.Context, conn jsonrpc2.JSONRPC2, bctx *build.Context, bpkg *build.Package) (*token.FileSet, *loader.Program, diagnostics, error) {
 	parentSpan := opentracing.SpanFromContext(ctx)
 	span := parentSpan.Tracer().StartSpan("langserver-go: typecheck",
 		opentracing.Tags{"pkg": bpkg.ImportPath},
@@ -206,12 +208,36 @@ func (h *LangHandler) cachedTypecheck(ctx context.Context, bctx *build.Context,
 	ctx = opentracing.ContextWithSpan(ctx, span)
 	defer span.Finish()
 
+	// The first typecheck has to load all dependencies from scratch, so
+	// let the user know what is going on. Creating the progress waits on
+	// the client, so do it before we hold up other requests for the same
+	// package.
+	h.mu.Lock()
+	first := !h.typecheckStarted
+	h.typecheckStarted = true
+	h.mu.Unlock()
+	fillCtx := ctx
+	if first {
+		var progress *workDoneProgress
+		fillCtx, progress = h.startWorkDoneProgress(ctx, conn, "Type-checking "+bpkg.ImportPath)
+		defer progress.End("")
+	}
+
 	var diags diagnostics
-	r := h.typecheckCache.Get(typecheckKey{bpkg.ImportPath, bpkg.Dir, bpkg.Name}, func() interface{} {
+	filled := false
+	key := typecheckKey{bpkg.ImportPath, bpkg.Dir, bpkg.Name, buildConfigKey(bctx)}
+	r := h.typecheckCache.Get(key, func() interface{} {
+		filled = true
 		res := &typecheckResult{
 			fset: token.NewFileSet(),
 		}
-		res.prog, diags, res.err = typecheck(ctx, res.fset, bctx, bpkg, h.getFindPackageFunc(), h.RootFSPath)
+		res.prog, diags, res.err = h.packageLoader().Typecheck(fillCtx, res.fset, bctx, bpkg)
+		if err := fillCtx.Err(); err != nil {
+			// The result is incomplete, so don't keep it for
+			// other requests.
+			h.typecheckCache.Remove(key)
+			res.prog, diags, res.err = nil, nil, err
+		}
 		return res
 	})
 	if r == nil {
@@ -219,11 +245,20 @@ func (h *LangHandler) cachedTypecheck(ctx context.Context, bctx *build.Context,
 		return nil, nil, diags, nil
 	}
 	res := r.(*typecheckResult)
+	if !filled && (res.err == context.Canceled || res.err == context.DeadlineExceeded) && ctx.Err() == nil {
+		// We waited on a typecheck which was cancelled by another
+		// request, so typecheck again. If our own typecheck was
+		// cancelled, e.g. via its work done progress, we return the
+		// cancellation instead.
+		return h.cachedTypecheck(ctx, conn, bctx, bpkg)
+	}
 	return res.fset, res.prog, diags, res.err
 }
 
 // TODO(sqs): allow typechecking just a specific file not in a package, too
 func typecheck(ctx context.Context, fset *token.FileSet, bctx *build.Context, bpkg *build.Package, findPackage FindPackageFunc, rootPath string) (*loader.Program, diagnostics, error) {
+	progress := workDoneProgressFromContext(ctx)
+	progress.Expect(bpkg.ImportPath)
 	var typeErrs []error
 	conf := loader.Config{
 		Fset: fset,
@@ -250,8 +285,12 @@ func typecheck(ctx context.Context, fset *token.FileSet, bctx *build.Context, bp
 			if err != nil && !isMultiplePackageError(err) {
 				return bpkg, err
 			}
+			progress.Expect(bpkg.ImportPath)
 			return bpkg, nil
 		},
+		AfterTypeCheck: func(info *loader.PackageInfo, files []*ast.File) {
+			progress.Step(info.Pkg.Path())
+		},
 	}
 
 	// Hover needs this info, otherwise we could zero out the unnecessary
@@ -270,6 +309,7 @@ func typecheck(ctx context.Context, fset *token.FileSet, bctx *build.Context, bp
 
 	var goFiles []string
 	goFiles = append(goFiles, bpkg.GoFiles...)
+	goFiles = append(goFiles, bpkg.CgoFiles...) // typechecked with FakeImportC, without running cgo
 	goFiles = append(goFiles, bpkg.TestGoFiles...)
 	if strings.HasSuffix(bpkg.Name, "_test") {
 		goFiles = append(goFiles, bpkg.XTestGoFiles...)
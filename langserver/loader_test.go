@@ -8,10 +8,12 @@ import (
 	"go/token"
 	"path"
 	"reflect"
+	"runtime"
 	"testing"
 
 	opentracing "github.com/opentracing/opentracing-go"
 
+	"github.com/sourcegraph/ctxvfs"
 	"github.com/sourcegraph/go-langserver/langserver/util"
 	"github.com/sourcegraph/go-lsp"
 	"github.com/sourcegraph/jsonrpc2"
@@ -254,3 +256,60 @@ func TestBuildPackageForNamedFileInMultiPackageDir(t *testing.T) {
 		})
 	}
 }
+
+func TestCachedTypecheck_cancelProgress(t *testing.T) {
+	h := &LangHandler{DefaultConfig: NewDefaultConfig(), HandlerShared: &HandlerShared{}}
+	if err := h.reset(&InitializeParams{
+		InitializeParams:     lsp.InitializeParams{RootURI: "file:///src/p"},
+		NoOSFileSystemAccess: true,
+		ExtraCapabilities: ClientCapabilities{
+			Window: WindowClientCapabilities{WorkDoneProgress: true},
+		},
+		BuildContext: &InitializeBuildContextParams{
+			GOOS:     runtime.GOOS,
+			GOARCH:   runtime.GOARCH,
+			GOPATH:   "/",
+			GOROOT:   "/goroot",
+			Compiler: runtime.Compiler,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h.FS.Bind("/", mapFS(map[string]string{
+		"src/p/p.go": `package p; import "q"; var _ = q.V`,
+		"src/q/q.go": `package q; const V = 1`,
+	}), "/", ctxvfs.BindReplace)
+	bctx := h.BuildContext(context.Background())
+	bpkg, err := bctx.Import("p", "/src/p", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The user cancels the progress of the first typecheck as soon as it
+	// begins.
+	conn := &cancellingConn{h: h}
+	_, ctx := opentracing.StartSpanFromContext(context.Background(), "loadertest")
+	if _, _, _, err := h.cachedTypecheck(ctx, conn, bctx, bpkg); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	// The cancelled result is not cached.
+	if _, _, _, err := h.cachedTypecheck(ctx, conn, bctx, bpkg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cancellingConn is a recordingConn which cancels every work done progress
+// of h once it begins.
+type cancellingConn struct {
+	recordingConn
+	h *LangHandler
+}
+
+func (c *cancellingConn) Notify(ctx context.Context, method string, params interface{}, opt ...jsonrpc2.CallOption) error {
+	if p, ok := params.(*progressParams); ok {
+		if _, ok := p.Value.(*workDoneProgressBegin); ok {
+			c.h.progress.Cancel(p.Token)
+		}
+	}
+	return c.recordingConn.Notify(ctx, method, params, opt...)
+}
@@ -0,0 +1,181 @@
+package langserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+)
+
+// lsifVersion is the version of the Language Server Index Format written
+// by writeLSIF.
+const lsifVersion = "0.4.3"
+
+// lsifWriter writes the vertices and edges of an LSIF graph as JSON lines.
+type lsifWriter struct {
+	enc *json.Encoder
+	id  int
+	err error
+}
+
+// vertex writes a vertex with the properties and returns its ID.
+func (w *lsifWriter) vertex(label string, props map[string]interface{}) int {
+	return w.element("vertex", label, props)
+}
+
+// edge writes a 1:1 edge from outV to inV.
+func (w *lsifWriter) edge(label string, outV, inV int) {
+	w.element("edge", label, map[string]interface{}{"outV": outV, "inV": inV})
+}
+
+// edges writes a 1:n edge from outV to every vertex of inVs.
+func (w *lsifWriter) edges(label string, outV int, inVs []int, props map[string]interface{}) {
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+	props["outV"] = outV
+	props["inVs"] = inVs
+	w.element("edge", label, props)
+}
+
+func (w *lsifWriter) element(typ, label string, props map[string]interface{}) int {
+	w.id++
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+	props["id"] = w.id
+	props["type"] = typ
+	props["label"] = label
+	if w.err == nil {
+		w.err = w.enc.Encode(props)
+	}
+	return w.id
+}
+
+// writeLSIF writes the index as an LSIF graph. Each symbol is a result set
+// with its hover, definition and reference results, and its moniker if it
+// can be referenced across repositories. The ranges of the occurrences of a
+// symbol point to its result set.
+func (ix *codeIndex) writeLSIF(w io.Writer, opts IndexOptions) error {
+	bw := bufio.NewWriter(w)
+	lw := &lsifWriter{enc: json.NewEncoder(bw)}
+
+	lw.vertex("metaData", map[string]interface{}{
+		"version":          lsifVersion,
+		"projectRoot":      util.PathToURI(ix.rootPath),
+		"positionEncoding": "utf-16",
+		"toolInfo": map[string]interface{}{
+			"name":    "go-langserver",
+			"version": opts.ToolVersion,
+			"args":    opts.ToolArguments,
+		},
+	})
+	project := lw.vertex("project", map[string]interface{}{"kind": "go"})
+
+	resultSets := make([]int, len(ix.symbols))
+	packageInfos := map[string]int{} // by package
+	for i, s := range ix.symbols {
+		resultSets[i] = lw.vertex("resultSet", nil)
+		if s.hover != "" {
+			hover := lw.vertex("hoverResult", map[string]interface{}{
+				"result": map[string]interface{}{
+					"contents": MarkupContent{Kind: MarkupKindMarkdown, Value: s.hover},
+				},
+			})
+			lw.edge("textDocument/hover", resultSets[i], hover)
+		}
+		if kind := s.monikerKind(); kind != "" {
+			moniker := lw.vertex("moniker", map[string]interface{}{
+				"scheme":     "go",
+				"identifier": s.desc.ID,
+				"kind":       kind,
+				"unique":     "scheme",
+			})
+			lw.edge("moniker", resultSets[i], moniker)
+			info, ok := packageInfos[s.desc.Package]
+			if !ok {
+				info = lw.vertex("packageInformation", map[string]interface{}{
+					"name":    s.desc.Package,
+					"manager": "go",
+				})
+				packageInfos[s.desc.Package] = info
+			}
+			lw.edge("packageInformation", moniker, info)
+		}
+	}
+
+	// The ranges of the definitions and references of each symbol, by
+	// document.
+	type symbolRanges struct {
+		docs       []int
+		defs, refs map[int][]int
+	}
+	ranges := make([]*symbolRanges, len(ix.symbols))
+	documents := make([]int, 0, len(ix.documents))
+	for _, doc := range ix.documents {
+		d := lw.vertex("document", map[string]interface{}{
+			"uri":        util.PathToURI(doc.path),
+			"languageId": "go",
+		})
+		documents = append(documents, d)
+		contains := make([]int, 0, len(doc.occurrences))
+		for _, o := range doc.occurrences {
+			r := lw.vertex("range", map[string]interface{}{"start": o.rng.Start, "end": o.rng.End})
+			lw.edge("next", r, resultSets[o.symbol.id])
+			contains = append(contains, r)
+
+			sr := ranges[o.symbol.id]
+			if sr == nil {
+				sr = &symbolRanges{defs: map[int][]int{}, refs: map[int][]int{}}
+				ranges[o.symbol.id] = sr
+			}
+			if _, ok := sr.defs[d]; !ok {
+				if _, ok := sr.refs[d]; !ok {
+					sr.docs = append(sr.docs, d)
+				}
+			}
+			if o.definition {
+				sr.defs[d] = append(sr.defs[d], r)
+			} else {
+				sr.refs[d] = append(sr.refs[d], r)
+			}
+		}
+		if len(contains) > 0 {
+			lw.edges("contains", d, contains, nil)
+		}
+	}
+
+	for i, sr := range ranges {
+		if sr == nil {
+			continue
+		}
+		if ix.symbols[i].defined {
+			def := lw.vertex("definitionResult", nil)
+			lw.edge("textDocument/definition", resultSets[i], def)
+			for _, d := range sr.docs {
+				if len(sr.defs[d]) > 0 {
+					lw.edges("item", def, sr.defs[d], map[string]interface{}{"document": d})
+				}
+			}
+		}
+		refs := lw.vertex("referenceResult", nil)
+		lw.edge("textDocument/references", resultSets[i], refs)
+		for _, d := range sr.docs {
+			if len(sr.defs[d]) > 0 {
+				lw.edges("item", refs, sr.defs[d], map[string]interface{}{"document": d, "property": "definitions"})
+			}
+			if len(sr.refs[d]) > 0 {
+				lw.edges("item", refs, sr.refs[d], map[string]interface{}{"document": d, "property": "references"})
+			}
+		}
+	}
+
+	if len(documents) > 0 {
+		lw.edges("contains", project, documents, nil)
+	}
+	if lw.err != nil {
+		return lw.err
+	}
+	return bw.Flush()
+}
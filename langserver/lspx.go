@@ -1,6 +1,10 @@
 package langserver
 
-import "github.com/sourcegraph/go-lsp"
+import (
+	"encoding/json"
+
+	"github.com/sourcegraph/go-lsp"
+)
 
 // This file contains Go-specific extensions to LSP types.
 //
@@ -30,6 +34,9 @@ type InitializationOptions struct {
 	// Config.GoimportsLocalPrefix
 	GoimportsLocalPrefix *string `json:"goimportsLocalPrefix"`
 
+	// ImportGroups is an optional version of Config.ImportGroups
+	ImportGroups *[]string `json:"importGroups"`
+
 	// DiagnosticsEnabled enables is an optional version of
 	// Config.DiagnosticsEnabled
 	DiagnosticsEnabled *bool `json:"diagnosticsEnabled"`
@@ -39,6 +46,55 @@ type InitializationOptions struct {
 
 	// UseBinaryPkgCache is an optional version of Config.UseBinaryPkgCache
 	UseBinaryPkgCache *bool `json:"useBinaryPkgCache"`
+
+	// UseExportData is an optional version of Config.UseExportData
+	UseExportData *bool `json:"useExportData"`
+
+	// BuildTags is an optional version of Config.BuildTags
+	BuildTags *[]string `json:"buildTags"`
+
+	// GOOS is an optional version of Config.GOOS
+	GOOS *string `json:"goos"`
+
+	// GOARCH is an optional version of Config.GOARCH
+	GOARCH *string `json:"goarch"`
+
+	// CgoEnabled is an optional version of Config.CgoEnabled
+	CgoEnabled *bool `json:"cgoEnabled"`
+
+	// WorkspaceSymbolScope is an optional version of
+	// Config.WorkspaceSymbolScope
+	WorkspaceSymbolScope *string `json:"workspaceSymbolScope"`
+
+	// DocumentationURLTemplate is an optional version of
+	// Config.DocumentationURLTemplate
+	DocumentationURLTemplate *string `json:"documentationURLTemplate"`
+
+	// HoverConstantValues is an optional version of
+	// Config.HoverConstantValues
+	HoverConstantValues *bool `json:"hoverConstantValues"`
+
+	// HoverStructLayout is an optional version of Config.HoverStructLayout
+	HoverStructLayout *bool `json:"hoverStructLayout"`
+
+	// HoverStructTags is an optional version of Config.HoverStructTags
+	HoverStructTags *bool `json:"hoverStructTags"`
+
+	// HoverMethodSets is an optional version of Config.HoverMethodSets
+	HoverMethodSets *bool `json:"hoverMethodSets"`
+
+	// GoSrcURIsEnabled is an optional version of Config.GoSrcURIsEnabled
+	GoSrcURIsEnabled *bool `json:"goSrcURIsEnabled"`
+}
+
+// BuildConfiguration selects which files are part of a package. It is the
+// argument and result of the go.setBuildConfiguration command. The fields
+// have the same meaning and defaults as the Config fields of the same name.
+type BuildConfiguration struct {
+	BuildTags  []string `json:"buildTags,omitempty"`
+	GOOS       string   `json:"goos,omitempty"`
+	GOARCH     string   `json:"goarch,omitempty"`
+	CgoEnabled *bool    `json:"cgoEnabled,omitempty"`
 }
 
 type InitializeParams struct {
@@ -62,6 +118,103 @@ type InitializeParams struct {
 	// "golang.org/x/tools" is the root import
 	// path for "github.com/golang/tools".
 	RootImportPath string
+
+	// ExtraCapabilities are the client capabilities which
+	// lsp.ClientCapabilities does not know about. They are decoded from
+	// the same "capabilities" object as InitializeParams.Capabilities.
+	ExtraCapabilities ClientCapabilities `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It additionally decodes
+// ExtraCapabilities.
+func (p *InitializeParams) UnmarshalJSON(data []byte) error {
+	type initializeParams InitializeParams // prevent recursing into UnmarshalJSON
+	if err := json.Unmarshal(data, (*initializeParams)(p)); err != nil {
+		return err
+	}
+	var extra struct {
+		Capabilities ClientCapabilities `json:"capabilities"`
+	}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	p.ExtraCapabilities = extra.Capabilities
+	return nil
+}
+
+// ClientCapabilities are client capabilities from newer versions of the LSP
+// spec which are not (yet) part of lsp.ClientCapabilities.
+type ClientCapabilities struct {
+	Window WindowClientCapabilities `json:"window,omitempty"`
+}
+
+type WindowClientCapabilities struct {
+	// WorkDoneProgress is whether the client supports server initiated
+	// progress via window/workDoneProgress/create.
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+}
+
+// DocumentSymbol is a symbol of a document, which forms a hierarchy with
+// its children. It is from a newer version of the LSP spec and is not (yet)
+// part of go-lsp. It is the result of textDocument/documentSymbol if the
+// client supports hierarchicalDocumentSymbolSupport.
+type DocumentSymbol struct {
+	Name string `json:"name"`
+
+	// Detail is eg the signature of a function or the type of a field.
+	Detail string         `json:"detail,omitempty"`
+	Kind   lsp.SymbolKind `json:"kind"`
+
+	// Range encloses the whole declaration of the symbol, including
+	// its children.
+	Range lsp.Range `json:"range"`
+
+	// SelectionRange is the range of the name of the symbol.
+	SelectionRange lsp.Range        `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// MarkupKindMarkdown is the MarkupContent kind of Markdown.
+const MarkupKindMarkdown = "markdown"
+
+// MarkupContent is text of a kind, eg Markdown, which the client renders. It
+// is from a newer version of the LSP spec and is not (yet) part of go-lsp.
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// MarkupHover is the result of textDocument/hover if the client supports
+// Markdown hovers. It is lsp.Hover with MarkupContent contents.
+type MarkupHover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *lsp.Range    `json:"range,omitempty"`
+}
+
+// CodeActionParams is lsp.CodeActionParams with the context of a newer
+// version of the LSP spec, which go-lsp doesn't have (yet).
+type CodeActionParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+	Range        lsp.Range                  `json:"range"`
+	Context      CodeActionContext          `json:"context"`
+}
+
+// CodeActionContext is lsp.CodeActionContext with the kinds of code actions
+// the client requests.
+type CodeActionContext struct {
+	Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+
+	// Only are the kinds of the code actions to return. If empty, code
+	// actions of all kinds are returned.
+	Only []lsp.CodeActionKind `json:"only,omitempty"`
+}
+
+// CodeAction is a change to a document the client offers to the user, the
+// result of textDocument/codeAction. It is not (yet) part of go-lsp.
+type CodeAction struct {
+	Title string             `json:"title"`
+	Kind  lsp.CodeActionKind `json:"kind,omitempty"`
+	Edit  *lsp.WorkspaceEdit `json:"edit,omitempty"`
 }
 
 type InitializeBuildContextParams struct {
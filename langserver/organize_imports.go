@@ -0,0 +1,255 @@
+package langserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// The import group rules with a special meaning. Any other rule is a
+// comma-separated list of import path prefixes.
+const (
+	importGroupStd     = "std"
+	importGroupLocal   = "local"
+	importGroupDefault = "default"
+)
+
+// importOrganizer is a Formatter which sorts the imports of a file into
+// groups, separated by blank lines, in the order of the group rules.
+type importOrganizer struct {
+	// groups are the group rules, as described by Config.ImportGroups.
+	groups []string
+
+	// localPrefix is the comma-separated list of import path prefixes of
+	// the local group.
+	localPrefix string
+}
+
+func (o importOrganizer) Format(ctx context.Context, filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the import declarations from the last one, so the offsets
+	// of the others stay valid.
+	organized := src
+	for i := len(file.Decls) - 1; i >= 0; i-- {
+		decl, ok := file.Decls[i].(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT || !decl.Lparen.IsValid() {
+			continue
+		}
+		specs, ok := o.organize(fset, file, src, decl)
+		if !ok {
+			continue
+		}
+		start, end := fset.Position(decl.Lparen).Offset+1, fset.Position(decl.Rparen).Offset
+		organized = append(append(append([]byte{}, organized[:start]...), specs...), organized[end:]...)
+	}
+	return format.Source(organized)
+}
+
+// organize returns the specs of the parenthesized import declaration decl,
+// sorted into groups. It reports false if the declaration can't be
+// organized without losing comments, or must not be for cgo.
+func (o importOrganizer) organize(fset *token.FileSet, file *ast.File, src []byte, decl *ast.GenDecl) ([]byte, bool) {
+	// The comments of the declaration must all belong to specs, or they
+	// would be lost.
+	attached := make(map[*ast.CommentGroup]bool)
+	for _, spec := range decl.Specs {
+		attached[spec.(*ast.ImportSpec).Doc] = true
+		attached[spec.(*ast.ImportSpec).Comment] = true
+	}
+	for _, c := range file.Comments {
+		if c.Pos() > decl.Lparen && c.End() < decl.Rparen && !attached[c] {
+			return nil, false
+		}
+	}
+
+	type importSpec struct {
+		path string
+		text []byte
+	}
+	groups := make([][]importSpec, len(o.groups)+1)
+	for _, spec := range decl.Specs {
+		spec := spec.(*ast.ImportSpec)
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path == "C" {
+			return nil, false
+		}
+		start, end := spec.Pos(), spec.End()
+		if spec.Doc != nil {
+			start = spec.Doc.Pos()
+		}
+		if spec.Comment != nil {
+			end = spec.Comment.End()
+		}
+		text := src[fset.Position(start).Offset:fset.Position(end).Offset]
+		g := o.group(path)
+		groups[g] = append(groups[g], importSpec{path: path, text: text})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\n")
+	for _, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteString("\n")
+		}
+		sort.SliceStable(group, func(i, j int) bool { return group[i].path < group[j].path })
+		for _, spec := range group {
+			fmt.Fprintf(&buf, "\t%s\n", spec.text)
+		}
+	}
+	return buf.Bytes(), true
+}
+
+// group returns the index of the group of the import path. The group with
+// the longest matching prefix wins, then the standard library group, then
+// the default group. Imports no group is for go last.
+func (o importOrganizer) group(path string) int {
+	group, longest := -1, 0
+	for i, rule := range o.groups {
+		prefixes := rule
+		switch rule {
+		case importGroupStd, importGroupDefault:
+			continue
+		case importGroupLocal:
+			prefixes = o.localPrefix
+		}
+		for _, prefix := range strings.Split(prefixes, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(path, prefix) && len(prefix) > longest {
+				group, longest = i, len(prefix)
+			}
+		}
+	}
+	if group >= 0 {
+		return group
+	}
+
+	// Like goimports, consider the import paths without a dot in their
+	// first element the standard library.
+	std := !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+	for i, rule := range o.groups {
+		if std && rule == importGroupStd {
+			return i
+		}
+	}
+	for i, rule := range o.groups {
+		if rule == importGroupDefault {
+			return i
+		}
+	}
+	return len(o.groups)
+}
+
+func (h *LangHandler) handleTextDocumentCodeAction(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params CodeActionParams) ([]CodeAction, error) {
+	if !util.IsURI(params.TextDocument.URI) {
+		return nil, &jsonrpc2.Error{
+			Code:    jsonrpc2.CodeInvalidParams,
+			Message: fmt.Sprintf("%s not yet supported for out-of-workspace URI (%q)", req.Method, params.TextDocument.URI),
+		}
+	}
+
+	if !codeActionKindRequested(params.Context.Only, lsp.CAKSourceOrganizeImports) {
+		return nil, nil
+	}
+	edits, err := h.organizeImports(ctx, params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if len(edits) == 0 {
+		return nil, nil
+	}
+	return []CodeAction{{
+		Title: "Organize imports",
+		Kind:  lsp.CAKSourceOrganizeImports,
+		Edit: &lsp.WorkspaceEdit{
+			Changes: map[string][]lsp.TextEdit{string(params.TextDocument.URI): edits},
+		},
+	}}, nil
+}
+
+// codeActionKindRequested reports whether code actions of kind are
+// requested by a code action request for the kinds only.
+func codeActionKindRequested(only []lsp.CodeActionKind, kind lsp.CodeActionKind) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, o := range only {
+		if kind == o || strings.HasPrefix(string(kind), string(o)+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// organizeImports returns the edits which add the missing imports of the
+// file at uri, remove its unused ones and sort them into groups, without
+// formatting the rest of the file.
+func (h *LangHandler) organizeImports(ctx context.Context, uri lsp.DocumentURI) ([]lsp.TextEdit, error) {
+	unorganized, err := h.readFile(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	filename := h.FilePath(uri)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, unorganized, parser.ParseComments)
+	if err != nil {
+		// Code actions are requested for documents while they are being
+		// edited, which often don't parse, so there is nothing to do.
+		return nil, nil
+	}
+
+	organizer := formatters{goimports{localPrefix: h.config.GoimportsLocalPrefix}}
+	if len(h.config.ImportGroups) > 0 {
+		organizer = append(organizer, importOrganizer{groups: h.config.ImportGroups, localPrefix: h.config.GoimportsLocalPrefix})
+	}
+	organized, err := organizer.Format(ctx, filename, unorganized)
+	if err != nil {
+		return nil, err
+	}
+
+	// Imports are only ever changed above the first declaration which
+	// isn't an import declaration, and added right before it.
+	declLine := bytes.Count(unorganized, []byte("\n")) + 1
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.IMPORT {
+			continue
+		}
+		pos := decl.Pos()
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			if decl.Doc != nil {
+				pos = decl.Doc.Pos()
+			}
+		case *ast.FuncDecl:
+			if decl.Doc != nil {
+				pos = decl.Doc.Pos()
+			}
+		}
+		declLine = fset.Position(pos).Line - 1
+		break
+	}
+	var edits []lsp.TextEdit
+	for _, edit := range ComputeTextEdits(string(unorganized), string(organized)) {
+		if edit.Range.End.Line <= declLine {
+			edits = append(edits, edit)
+		}
+	}
+	return edits, nil
+}
@@ -0,0 +1,218 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestImportOrganizer(t *testing.T) {
+	organizer := importOrganizer{
+		groups:      []string{"std", "default", "github.com/acme/", "local"},
+		localPrefix: "github.com/acme/proj",
+	}
+
+	tests := map[string]struct {
+		src, want string
+	}{
+		"groups": {
+			src: `package p
+
+import (
+	"github.com/acme/proj/b"
+	"github.com/acme/lib"
+	"fmt"
+
+	"golang.org/x/tools/imports"
+	"github.com/acme/proj/a"
+	"bytes"
+)
+`,
+			want: `package p
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/tools/imports"
+
+	"github.com/acme/lib"
+
+	"github.com/acme/proj/a"
+	"github.com/acme/proj/b"
+)
+`,
+		},
+		"comments": {
+			src: `package p
+
+import (
+	// lib is documented.
+	lib "github.com/acme/lib"
+	"fmt" // fmt has a comment.
+)
+`,
+			want: `package p
+
+import (
+	"fmt" // fmt has a comment.
+
+	// lib is documented.
+	lib "github.com/acme/lib"
+)
+`,
+		},
+		"floating comment": {
+			src: `package p
+
+import (
+	"github.com/acme/lib"
+
+	// Standard library.
+
+	"fmt"
+)
+`,
+			want: `package p
+
+import (
+	"github.com/acme/lib"
+
+	// Standard library.
+
+	"fmt"
+)
+`,
+		},
+		"cgo": {
+			src: `package p
+
+// #include <stdio.h>
+import "C"
+
+import (
+	"github.com/acme/lib"
+	"fmt"
+)
+`,
+			want: `package p
+
+// #include <stdio.h>
+import "C"
+
+import (
+	"fmt"
+
+	"github.com/acme/lib"
+)
+`,
+		},
+	}
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			got, err := organizer.Format(context.Background(), "a.go", []byte(test.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+
+	// Imports no group is for go last.
+	organizer = importOrganizer{groups: []string{"github.com/acme/", "std"}}
+	got, err := organizer.Format(context.Background(), "a.go", []byte("package p\n\nimport (\n\t\"example.com/x\"\n\t\"fmt\"\n\t\"github.com/acme/lib\"\n)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package p\n\nimport (\n\t\"github.com/acme/lib\"\n\n\t\"fmt\"\n\n\t\"example.com/x\"\n)\n"; string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatter(t *testing.T) {
+	src := "package p\n\nimport (\n\t\"github.com/acme/lib\"\n\t\"fmt\"\n)\n\nvar  _ = fmt.Sprint\n\nvar _ = lib.X\n\nfunc f() {\n\n\tprintln()\n}\n"
+	h, _ := newHoverTestHandler(t, src)
+	ctx := context.Background()
+
+	h.config.FormatTool = formatToolGofmt
+	h.config.ImportGroups = []string{"std", "default"}
+	_, formatted, err := h.format(ctx, "file:///src/p/a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package p\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/acme/lib\"\n)\n\nvar _ = fmt.Sprint\n\nvar _ = lib.X\n\nfunc f() {\n\n\tprintln()\n}\n"; string(formatted) != want {
+		t.Errorf("got\n%s\nwant\n%s", formatted, want)
+	}
+
+	// gofumpt also removes the empty line at the start of the block.
+	h.config.FormatTool = formatToolGofumpt
+	h.config.ImportGroups = nil
+	_, formatted, err = h.format(ctx, "file:///src/p/a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package p\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/acme/lib\"\n)\n\nvar _ = fmt.Sprint\n\nvar _ = lib.X\n\nfunc f() {\n\tprintln()\n}\n"; string(formatted) != want {
+		t.Errorf("got\n%s\nwant\n%s", formatted, want)
+	}
+}
+
+func TestCodeAction_organizeImports(t *testing.T) {
+	h, _ := newHoverTestHandler(t, `package p
+
+import (
+	"github.com/acme/lib"
+	"os"
+	"fmt"
+)
+
+var  _ = fmt.Sprint
+
+var _ = lib.X
+`)
+	h.config.ImportGroups = []string{"std", "default"}
+	ctx := context.Background()
+
+	codeActions := func(only ...lsp.CodeActionKind) []CodeAction {
+		actions, err := h.handleTextDocumentCodeAction(ctx, nil, &jsonrpc2.Request{Method: "textDocument/codeAction"}, CodeActionParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: "file:///src/p/a.go"},
+			Context:      CodeActionContext{Only: only},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return actions
+	}
+
+	// The unused import is removed and the others are grouped, but the
+	// rest of the file isn't formatted.
+	want := []CodeAction{{
+		Title: "Organize imports",
+		Kind:  lsp.CAKSourceOrganizeImports,
+		Edit: &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
+			"file:///src/p/a.go": {
+				toTextEdit(toRange(3, 0, 3, 0), "\t\"fmt\"\n\n"),
+				toTextEdit(toRange(4, 0, 6, 0), ""),
+			},
+		}},
+	}}
+	if got := codeActions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got code actions %s, want %s", codeActionsString(got), codeActionsString(want))
+	}
+	if got := codeActions(lsp.CAKSource); !reflect.DeepEqual(got, want) {
+		t.Errorf("got code actions %s for source, want %s", codeActionsString(got), codeActionsString(want))
+	}
+	if got := codeActions(lsp.CAKQuickFix); got != nil {
+		t.Errorf("got code actions %s for quick fixes, want none", codeActionsString(got))
+	}
+}
+
+func codeActionsString(actions []CodeAction) string {
+	b, _ := json.Marshal(actions)
+	return string(b)
+}
@@ -0,0 +1,86 @@
+package langserver
+
+import (
+	"context"
+	"go/build"
+	"go/token"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// packageLoader loads and typechecks packages. Handlers load packages via a
+// packageLoader, which returns them as a *loader.Program. This means every
+// handler works against the same package model, regardless of how the
+// packages were loaded.
+type packageLoader interface {
+	// Typecheck loads bpkg, including its test files, as well as its
+	// dependencies. Only the function bodies of bpkg are typechecked.
+	Typecheck(ctx context.Context, fset *token.FileSet, bctx *build.Context, bpkg *build.Package) (*loader.Program, diagnostics, error)
+
+	// Load loads the packages in conf.ImportPkgs, including their tests,
+	// as well as their dependencies. conf.AfterTypeCheck is called for
+	// every package loaded. conf.TypeCheckFuncBodies is only a hint, an
+	// implementation may typecheck more function bodies.
+	Load(ctx context.Context, conf *loader.Config) (*loader.Program, error)
+}
+
+// packageLoader returns the packageLoader to use for the workspace.
+//
+// We use go/packages if we are working against the OS file system, since it
+// understands modules and asks the go tool about the build. The build
+// server provides a virtual file system and resolves dependencies itself
+// (FindPackage), which only go/loader supports.
+func (h *LangHandler) packageLoader() packageLoader {
+	h.mu.Lock()
+	onOSFileSystem, fallbackOnce := h.onOSFileSystem, h.packagesFallbackOnce
+	h.mu.Unlock()
+
+	h.HandlerShared.Mu.Lock()
+	virtualFS := h.HandlerShared.Shared || h.HandlerShared.FindPackage != nil || !onOSFileSystem
+	overlay := h.HandlerShared.overlay
+	h.HandlerShared.Mu.Unlock()
+
+	goLoader := &goLoader{
+		findPackage: h.getFindPackageFunc(),
+		rootPath:    h.RootFSPath,
+		exportData:  h.config.UseExportData,
+	}
+	if virtualFS || h.config.UseExportData {
+		return goLoader
+	}
+	return &packagesLoader{
+		overlay:      overlay,
+		fallback:     goLoader,
+		fallbackOnce: fallbackOnce,
+	}
+}
+
+// goLoader loads packages with golang.org/x/tools/go/loader, which finds
+// packages via go/build. This works with any file system, so it is what we
+// use for the virtual file system of the build server.
+type goLoader struct {
+	findPackage FindPackageFunc
+	rootPath    string
+
+	// exportData, if true, makes Typecheck import dependencies from
+	// export data. See typecheckWithExportData.
+	exportData bool
+}
+
+func (l *goLoader) Typecheck(ctx context.Context, fset *token.FileSet, bctx *build.Context, bpkg *build.Package) (*loader.Program, diagnostics, error) {
+	if l.exportData {
+		return typecheckWithExportData(ctx, fset, bctx, bpkg, l.findPackage, l.rootPath, getDiskIndex())
+	}
+	prog, diags, err := typecheck(ctx, fset, bctx, bpkg, l.findPackage, l.rootPath)
+	if ix := getDiskIndex(); ix != nil && err == nil {
+		// Writing the export data is not needed to respond, so do it in
+		// the background. We can't use ctx since it is cancelled once
+		// the request is done.
+		go indexExportData(context.Background(), ix, fset, bctx, prog, l.findPackage, l.rootPath)
+	}
+	return prog, diags, err
+}
+
+func (l *goLoader) Load(ctx context.Context, conf *loader.Config) (*loader.Program, error) {
+	return conf.Load()
+}
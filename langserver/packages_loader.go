@@ -0,0 +1,351 @@
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+)
+
+// packagesLoader loads packages with golang.org/x/tools/go/packages, which
+// asks the go tool about packages. Unlike go/build it understands modules
+// and the exact files the go tool would build (eg for cgo). Unsaved
+// documents are passed to the go tool as an overlay.
+type packagesLoader struct {
+	overlay *overlay
+
+	// fallback is used if the go tool fails to list the packages, eg if
+	// it is not installed.
+	fallback packageLoader
+
+	// fallbackOnce, if not nil, limits logging that we fall back to the
+	// first time, since it is usually for the same reason every time.
+	fallbackOnce *sync.Once
+}
+
+const packagesLoadMode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+	packages.NeedImports | packages.NeedDeps | packages.NeedTypes | packages.NeedSyntax |
+	packages.NeedTypesInfo
+
+func (l *packagesLoader) Typecheck(ctx context.Context, fset *token.FileSet, bctx *build.Context, bpkg *build.Package) (*loader.Program, diagnostics, error) {
+	cfg := l.config(ctx, fset, bctx, bpkg.Dir)
+	// Like typecheck, we only typecheck the function bodies of the
+	// package we are interested in.
+	cfg.ParseFile = parseFileFunc(func(filename string) bool {
+		return util.PathEqual(filepath.Dir(filename), bpkg.Dir)
+	})
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		l.logFallback(bpkg.Dir, err)
+		return l.fallback.Typecheck(ctx, fset, bctx, bpkg)
+	}
+	root := packagesRoot(pkgs, bpkg)
+	if root == nil {
+		return nil, nil, fmt.Errorf("go/packages did not find package %s in %s", bpkg.Name, bpkg.Dir)
+	}
+	if root.Types == nil {
+		// The package could not be listed at all, so there is no
+		// point in typechecking it.
+		return nil, nil, packagesError(root)
+	}
+
+	prog := &loader.Program{
+		Fset:        fset,
+		Imported:    map[string]*loader.PackageInfo{},
+		AllPackages: map[*types.Package]*loader.PackageInfo{},
+	}
+	var typeErrs []error
+	progress := workDoneProgressFromContext(ctx)
+	packages.Visit([]*packages.Package{root}, func(p *packages.Package) bool {
+		progress.Expect(p.PkgPath)
+		return true
+	}, func(p *packages.Package) {
+		typeErrs = append(typeErrs, packagesErrors(p)...)
+		if info := packageInfo(p); info != nil {
+			prog.AllPackages[info.Pkg] = info
+			if p == root {
+				prog.Created = append(prog.Created, info)
+			}
+		}
+		progress.Step(p.PkgPath)
+	})
+
+	diags, err := errsToDiagnostics(typeErrs, prog)
+	if err != nil {
+		return nil, nil, err
+	}
+	return prog, diags, nil
+}
+
+// logFallback logs that go/packages failed to load what and we fall back to
+// go/loader.
+func (l *packagesLoader) logFallback(what interface{}, err error) {
+	logf := func() {
+		log.Printf("warning: falling back to go/loader since go/packages failed to load %v: %s", what, err)
+	}
+	if l.fallbackOnce == nil {
+		logf()
+		return
+	}
+	l.fallbackOnce.Do(logf)
+}
+
+func (l *packagesLoader) Load(ctx context.Context, conf *loader.Config) (*loader.Program, error) {
+	fset := conf.Fset
+	if fset == nil {
+		fset = token.NewFileSet()
+		conf.Fset = fset
+	}
+	bctx := conf.Build
+	if bctx == nil {
+		bctx = &build.Default
+	}
+	var patterns []string
+	for path := range conf.ImportPkgs {
+		patterns = append(patterns, path)
+	}
+	cfg := l.config(ctx, fset, bctx, conf.Cwd)
+	var pkgs []*packages.Package
+	withBodies, err := funcBodyFiles(cfg, conf, patterns)
+	if err == nil {
+		cfg.ParseFile = parseFileFunc(func(filename string) bool {
+			return withBodies[filename]
+		})
+		pkgs, err = packages.Load(cfg, patterns...)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		l.logFallback(patterns, err)
+		return l.fallback.Load(ctx, conf)
+	}
+
+	prog := &loader.Program{
+		Fset:        fset,
+		Imported:    map[string]*loader.PackageInfo{},
+		AllPackages: map[*types.Package]*loader.PackageInfo{},
+	}
+	var firstErr error
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		if strings.HasSuffix(p.ID, ".test") {
+			// The generated main package of a test binary.
+			return
+		}
+		_, imported := conf.ImportPkgs[p.PkgPath]
+		info := packageInfo(p)
+		if info == nil {
+			if firstErr == nil && imported {
+				firstErr = packagesError(p)
+			}
+			return
+		}
+		prog.AllPackages[info.Pkg] = info
+		if imported && p.ID == p.PkgPath {
+			prog.Imported[p.PkgPath] = info
+		}
+		if conf.AfterTypeCheck != nil {
+			conf.AfterTypeCheck(info, info.Files)
+		}
+	})
+	if len(prog.AllPackages) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return prog, nil
+}
+
+// funcBodyFiles returns the files of the packages matching patterns whose
+// function bodies conf wants typechecked. go/packages parses files before
+// it knows which package they belong to, so we list the packages first.
+func funcBodyFiles(cfg *packages.Config, conf *loader.Config, patterns []string) (map[string]bool, error) {
+	listCfg := *cfg
+	listCfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles
+	pkgs, err := packages.Load(&listCfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, p := range pkgs {
+		if conf.TypeCheckFuncBodies != nil && !conf.TypeCheckFuncBodies(p.PkgPath) {
+			continue
+		}
+		for _, f := range p.GoFiles {
+			files[f] = true
+		}
+		for _, f := range p.CompiledGoFiles {
+			files[f] = true
+		}
+	}
+	return files, nil
+}
+
+// parseFileFunc returns a go/packages ParseFile function which removes the
+// function bodies of the files for which withBodies returns false, so
+// that they are not typechecked.
+func parseFileFunc(withBodies func(filename string) bool) func(*token.FileSet, string, []byte) (*ast.File, error) {
+	return func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+		f, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments)
+		if f != nil && !withBodies(filename) {
+			for _, decl := range f.Decls {
+				if fd, ok := decl.(*ast.FuncDecl); ok {
+					fd.Body = nil
+				}
+			}
+		}
+		return f, err
+	}
+}
+
+// config returns the go/packages configuration for loading packages in dir.
+func (l *packagesLoader) config(ctx context.Context, fset *token.FileSet, bctx *build.Context, dir string) *packages.Config {
+	cgo := "0"
+	if bctx.CgoEnabled {
+		cgo = "1"
+	}
+	env := append(os.Environ(),
+		"GOPATH="+bctx.GOPATH,
+		"GOOS="+bctx.GOOS,
+		"GOARCH="+bctx.GOARCH,
+		"CGO_ENABLED="+cgo,
+	)
+	if os.Getenv("GO111MODULE") == "" && !inModule(dir) {
+		// The workspace is a GOPATH workspace, which the go tool no
+		// longer understands by default.
+		env = append(env, "GO111MODULE=off")
+	}
+	var buildFlags []string
+	if len(bctx.BuildTags) > 0 {
+		buildFlags = append(buildFlags, "-tags="+strings.Join(bctx.BuildTags, ","))
+	}
+	return &packages.Config{
+		Context:    ctx,
+		Mode:       packagesLoadMode,
+		Dir:        dir,
+		Env:        env,
+		BuildFlags: buildFlags,
+		Fset:       fset,
+		Tests:      true,
+		Overlay:    l.overlay.files(),
+	}
+}
+
+// inModule reports whether dir is inside a Go module, ie dir or one of its
+// parents contains a go.mod file.
+func inModule(dir string) bool {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// packagesRoot returns the package in pkgs which corresponds to bpkg. If
+// bpkg has test files, we return the test variant of the package, since it
+// contains all the files of bpkg.
+func packagesRoot(pkgs []*packages.Package, bpkg *build.Package) *packages.Package {
+	xtest := strings.HasSuffix(bpkg.Name, "_test")
+	var root *packages.Package
+	for _, p := range pkgs {
+		if strings.HasSuffix(p.ID, ".test") {
+			// The generated main package of the test binary.
+			continue
+		}
+		if strings.HasSuffix(p.PkgPath, "_test") != xtest {
+			continue
+		}
+		if root == nil || strings.Contains(p.ID, " [") {
+			root = p
+		}
+	}
+	return root
+}
+
+// packageInfo converts p into the package model used by handlers. It
+// returns nil if p was not typechecked.
+func packageInfo(p *packages.Package) *loader.PackageInfo {
+	if p.Types == nil || p.TypesInfo == nil {
+		return nil
+	}
+	info := &loader.PackageInfo{
+		Pkg:                   p.Types,
+		Info:                  *p.TypesInfo,
+		Files:                 p.Syntax,
+		TransitivelyErrorFree: !p.IllTyped,
+	}
+	for _, err := range p.Errors {
+		info.Errors = append(info.Errors, err)
+	}
+	return info
+}
+
+// packagesErrors returns the errors of p which have a position, in a form
+// understood by errsToDiagnostics.
+func packagesErrors(p *packages.Package) []error {
+	var errs []error
+	for _, err := range p.Errors {
+		pos, ok := parsePackagesErrorPos(err.Pos)
+		if !ok {
+			continue
+		}
+		errs = append(errs, scanner.Error{Pos: pos, Msg: err.Msg})
+	}
+	return errs
+}
+
+// packagesError returns the first error of p, or a generic error if p has
+// none.
+func packagesError(p *packages.Package) error {
+	if len(p.Errors) > 0 {
+		return p.Errors[0]
+	}
+	return fmt.Errorf("failed to load package %s", p.ID)
+}
+
+// parsePackagesErrorPos parses the position of a packages.Error, which is
+// of the form "file:line:col" or "file:line".
+func parsePackagesErrorPos(s string) (token.Position, bool) {
+	var nums []int
+	for i := 0; i < 2; i++ {
+		idx := strings.LastIndex(s, ":")
+		if idx < 0 {
+			break
+		}
+		n, err := strconv.Atoi(s[idx+1:])
+		if err != nil {
+			break
+		}
+		nums = append([]int{n}, nums...)
+		s = s[:idx]
+	}
+	if len(nums) == 0 || s == "" || s == "-" {
+		return token.Position{}, false
+	}
+	pos := token.Position{Filename: s, Line: nums[0], Column: 1}
+	if len(nums) > 1 {
+		pos.Column = nums[1]
+	}
+	return pos, true
+}
@@ -0,0 +1,294 @@
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+	"github.com/sourcegraph/go-lsp"
+)
+
+func TestPackagesLoader(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not found")
+	}
+	dir, err := ioutil.TempDir("", "packages-loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"go.mod":         "module example.com/m\n",
+		"p/p.go":         "package p\n\nimport \"example.com/m/q\"\n\nvar X = q.Q\n",
+		"p/p_test.go":    "package p\n\nvar Y = X\n",
+		"p/x_test.go":    "package p_test\n\nimport \"example.com/m/p\"\n\nvar Z = p.X\n",
+		"q/q.go":         "package q\n\nconst Q = 1\n",
+		"q/ignored.go":   "// +build ignore\n\npackage main\n",
+		"q/unrelated.go": "package q\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The overlay changes the type of Q, which is only visible if it is
+	// used by go/packages.
+	ov := newOverlay()
+	ov.m[filepath.ToSlash(filepath.Join(dir, "q/q.go"))[1:]] = []byte("package q\n\nconst Q = \"overlay\"\n")
+	l := &packagesLoader{overlay: ov}
+
+	bctx := build.Default
+	bctx.CgoEnabled = false
+	pdir := filepath.Join(dir, "p")
+
+	for _, tc := range []struct {
+		name    string
+		pkgPath string
+		objs    []string
+	}{
+		{name: "p", pkgPath: "example.com/m/p", objs: []string{"X", "Y"}},
+		{name: "p_test", pkgPath: "example.com/m/p_test", objs: []string{"Z"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			bpkg := &build.Package{Name: tc.name, ImportPath: "example.com/m/p", Dir: pdir}
+			prog, diags, err := l.Typecheck(context.Background(), fset, &bctx, bpkg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diags) != 0 {
+				t.Fatalf("unexpected diagnostics: %v", diags)
+			}
+			if len(prog.Created) != 1 {
+				t.Fatalf("got %d created packages, want 1", len(prog.Created))
+			}
+			pkg := prog.Created[0].Pkg
+			if pkg.Path() != tc.pkgPath {
+				t.Errorf("got package %q, want %q", pkg.Path(), tc.pkgPath)
+			}
+			for _, name := range tc.objs {
+				obj := pkg.Scope().Lookup(name)
+				if obj == nil {
+					t.Fatalf("%s not found in %s", name, pkg.Path())
+				}
+				if got := obj.Type().String(); got != "string" {
+					t.Errorf("got type %s for %s, want string from the overlay", got, name)
+				}
+			}
+			if info := programPackage(prog, "example.com/m/q"); info == nil || len(info.Files) == 0 {
+				t.Error("expected dependency q to be loaded with syntax")
+			}
+		})
+	}
+}
+
+func TestPackagesLoader_Load(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not found")
+	}
+	dir, err := ioutil.TempDir("", "packages-loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"go.mod":      "module example.com/m\n",
+		"p/p.go":      "package p\n\nimport \"example.com/m/q\"\n\nvar X = q.Q\n\nfunc F() { _ = X }\n",
+		"p/p_test.go": "package p\n\nimport \"example.com/m/q\"\n\nvar Y = q.Q\n",
+		"q/q.go":      "package q\n\nconst Q = 1\n\nfunc F() { _ = Q }\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bctx := build.Default
+	l := &packagesLoader{}
+	conf := &loader.Config{Build: &bctx, Cwd: dir}
+	conf.Import("example.com/m/p")
+	seen := map[string]int{}
+	conf.AfterTypeCheck = func(info *loader.PackageInfo, files []*ast.File) {
+		if strings.HasPrefix(info.Pkg.Path(), "example.com/") {
+			seen[info.Pkg.Path()]++
+		}
+	}
+	prog, err := l.Load(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.Imported["example.com/m/p"] == nil {
+		t.Error("expected example.com/m/p to be an imported package")
+	}
+	// We see p twice, once with and once without its test files.
+	if want := map[string]int{"example.com/m/p": 2, "example.com/m/q": 1}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("got AfterTypeCheck calls %v, want %v", seen, want)
+	}
+	// Only the function bodies of the imported packages are
+	// typechecked.
+	for path, wantBody := range map[string]bool{"example.com/m/p": true, "example.com/m/q": false} {
+		info := programPackage(prog, path)
+		if info == nil || len(info.Files) == 0 {
+			t.Fatalf("expected %s to be loaded with syntax", path)
+		}
+		for _, decl := range info.Files[0].Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && (fd.Body != nil) != wantBody {
+				t.Errorf("got body %v for %s.%s, want body %t", fd.Body, path, fd.Name.Name, wantBody)
+			}
+		}
+	}
+}
+
+func TestPackagesLoader_findReferences(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not found")
+	}
+	dir, err := ioutil.TempDir("", "packages-loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"go.mod":      "module example.com/m\n",
+		"p/p.go":      "package p\n\nvar X = 1\n\nfunc F() int { return X }\n",
+		"p/p_test.go": "package p\n\nvar Y = X\n",
+		"p/x_test.go": "package p_test\n\nimport \"example.com/m/p\"\n\nvar Z = p.X\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	bctx := build.Default
+	l := &packagesLoader{}
+	fset := token.NewFileSet()
+	bpkg := &build.Package{Name: "p", ImportPath: "example.com/m/p", Dir: filepath.Join(dir, "p")}
+	prog, _, err := l.Typecheck(ctx, fset, &bctx, bpkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := prog.Created[0].Pkg.Scope().Lookup("X")
+	if obj == nil {
+		t.Fatal("X not found")
+	}
+
+	lconf := loader.Config{Fset: fset, Build: &bctx, Cwd: dir}
+	lconf.ImportWithTests("example.com/m/p")
+	refs := make(chan *ast.Ident, 100)
+	if err := findReferences(ctx, l, lconf, func(string) bool { return true }, obj, refs); err != nil {
+		t.Fatal(err)
+	}
+	close(refs)
+	var got []string
+	for id := range refs {
+		posn := fset.Position(id.Pos())
+		got = append(got, fmt.Sprintf("%s:%d:%d", filepath.Base(posn.Filename), posn.Line, posn.Column))
+	}
+	sort.Strings(got)
+	// The references in test files are found, and the ones in p.go
+	// are only reported once although it is in two variants of p.
+	want := []string{"p.go:5:23", "p_test.go:3:9", "x_test.go:5:11"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got references %v, want %v", got, want)
+	}
+}
+
+func TestPackageLoader_notOnOSFileSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "package-loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	packageLoaderFor := func(root string) packageLoader {
+		h := &LangHandler{DefaultConfig: NewDefaultConfig(), HandlerShared: &HandlerShared{}}
+		if err := h.reset(&InitializeParams{InitializeParams: lsp.InitializeParams{RootURI: util.PathToURI(filepath.ToSlash(root))}}); err != nil {
+			t.Fatal(err)
+		}
+		return h.packageLoader()
+	}
+	if l, ok := packageLoaderFor(dir).(*packagesLoader); !ok {
+		t.Errorf("got %T for a workspace on the file system, want go/packages", l)
+	}
+	// The workspace is only in the VFS, so go/packages can't load it.
+	if l, ok := packageLoaderFor(filepath.Join(dir, "missing")).(*goLoader); !ok {
+		t.Errorf("got %T for a workspace which is not on the file system, want go/loader", l)
+	}
+}
+
+func TestPackagesRoot(t *testing.T) {
+	pkgs := []*packages.Package{
+		{ID: "p", PkgPath: "p"},
+		{ID: "p [p.test]", PkgPath: "p"},
+		{ID: "p_test [p.test]", PkgPath: "p_test"},
+		{ID: "p.test", PkgPath: "p.test"},
+	}
+	if got := packagesRoot(pkgs, &build.Package{Name: "p"}); got != pkgs[1] {
+		t.Errorf("got %v, want the test variant of p", got)
+	}
+	if got := packagesRoot(pkgs, &build.Package{Name: "p_test"}); got != pkgs[2] {
+		t.Errorf("got %v, want the external test package", got)
+	}
+	if got := packagesRoot(pkgs[:1], &build.Package{Name: "p"}); got != pkgs[0] {
+		t.Errorf("got %v, want p if there are no tests", got)
+	}
+}
+
+func TestParsePackagesErrorPos(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want token.Position
+		ok   bool
+	}{
+		{in: "/src/p/f.go:1:2", want: token.Position{Filename: "/src/p/f.go", Line: 1, Column: 2}, ok: true},
+		{in: "/src/p/f.go:3", want: token.Position{Filename: "/src/p/f.go", Line: 3, Column: 1}, ok: true},
+		{in: `C:\src\p\f.go:1:2`, want: token.Position{Filename: `C:\src\p\f.go`, Line: 1, Column: 2}, ok: true},
+		{in: "-"},
+		{in: ""},
+	} {
+		got, ok := parsePackagesErrorPos(tc.in)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("parsePackagesErrorPos(%q) = %v, %t, want %v, %t", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
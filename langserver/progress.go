@@ -0,0 +1,232 @@
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// progressToken identifies a work done progress. LSP also allows integer
+// tokens, but we only ever create string tokens.
+type progressToken string
+
+type workDoneProgressCreateParams struct {
+	Token progressToken `json:"token"`
+}
+
+type workDoneProgressCancelParams struct {
+	Token progressToken `json:"token"`
+}
+
+type progressParams struct {
+	Token progressToken `json:"token"`
+	Value interface{}   `json:"value"`
+}
+
+type workDoneProgressBegin struct {
+	Kind        string `json:"kind"` // always "begin"
+	Title       string `json:"title"`
+	Cancellable bool   `json:"cancellable,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Percentage  int    `json:"percentage"`
+}
+
+type workDoneProgressReport struct {
+	Kind       string `json:"kind"` // always "report"
+	Message    string `json:"message,omitempty"`
+	Percentage int    `json:"percentage"`
+}
+
+type workDoneProgressEnd struct {
+	Kind    string `json:"kind"` // always "end"
+	Message string `json:"message,omitempty"`
+}
+
+// progress manages window/workDoneProgress/cancel by keeping track of the
+// work done progresses we have created.
+type progress struct {
+	mu   sync.Mutex
+	next int
+	m    map[progressToken]func()
+}
+
+// newToken returns a token which has not been used before by p.
+func (p *progress) newToken() progressToken {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next++
+	return progressToken(fmt.Sprintf("langserver-go-%d", p.next))
+}
+
+// WithCancel is like context.WithCancel, except you can also cancel via
+// calling p.Cancel with the same token.
+func (p *progress) WithCancel(ctx context.Context, token progressToken) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	p.mu.Lock()
+	if p.m == nil {
+		p.m = make(map[progressToken]func())
+	}
+	p.m[token] = cancel
+	p.mu.Unlock()
+	return ctx, func() {
+		p.mu.Lock()
+		delete(p.m, token)
+		p.mu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel will cancel the work associated with token. If the work has already
+// ended or token is unknown, Cancel is a noop.
+func (p *progress) Cancel(token progressToken) {
+	var cancel func()
+	p.mu.Lock()
+	if p.m != nil {
+		cancel = p.m[token]
+		delete(p.m, token)
+	}
+	p.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// workDoneProgress reports the progress of a long running operation to the
+// client via $/progress. Progress is measured in packages: the percentage
+// reported is the number of packages done out of the packages expected.
+//
+// A nil *workDoneProgress is valid, all its methods are noops. This is what
+// is used if the client does not support server initiated progress.
+type workDoneProgress struct {
+	conn  jsonrpc2.JSONRPC2
+	token progressToken
+	done  func() // releases the token
+
+	mu         sync.Mutex
+	expected   map[string]bool // package -> done
+	ndone      int
+	percentage int
+	ended      bool
+}
+
+// startWorkDoneProgress creates a work done progress with title on the
+// client, if the client supports it. The returned context is cancelled when
+// the client cancels the progress. The caller must call End once the work
+// is done.
+func (h *LangHandler) startWorkDoneProgress(ctx context.Context, conn jsonrpc2.JSONRPC2, title string) (context.Context, *workDoneProgress) {
+	h.mu.Lock()
+	init, manager := h.init, h.progress
+	h.mu.Unlock()
+	if init == nil || manager == nil || !init.ExtraCapabilities.Window.WorkDoneProgress {
+		return ctx, nil
+	}
+
+	token := manager.newToken()
+	if err := conn.Call(ctx, "window/workDoneProgress/create", &workDoneProgressCreateParams{Token: token}, nil); err != nil {
+		log.Printf("warning: failed to create work done progress %q: %s", title, err)
+		return ctx, nil
+	}
+
+	ctx, done := manager.WithCancel(ctx, token)
+	p := &workDoneProgress{
+		conn:     conn,
+		token:    token,
+		done:     done,
+		expected: map[string]bool{},
+	}
+	p.notify(&workDoneProgressBegin{
+		Kind:        "begin",
+		Title:       title,
+		Cancellable: true,
+	})
+	return withWorkDoneProgress(ctx, p), p
+}
+
+// Expect adds pkg to the packages which need to be done before the work is
+// complete. Expecting the same package more than once has no effect.
+func (p *workDoneProgress) Expect(pkg string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if _, ok := p.expected[pkg]; !ok {
+		p.expected[pkg] = false
+	}
+	p.mu.Unlock()
+}
+
+// Step marks pkg as done and reports the new percentage to the client.
+func (p *workDoneProgress) Step(pkg string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.ended || p.expected[pkg] {
+		p.mu.Unlock()
+		return
+	}
+	p.expected[pkg] = true
+	p.ndone++
+	percentage := 100 * p.ndone / len(p.expected)
+	if percentage <= p.percentage {
+		// Don't flood the client with notifications which do not
+		// change what it displays.
+		p.mu.Unlock()
+		return
+	}
+	p.percentage = percentage
+	p.mu.Unlock()
+
+	p.notify(&workDoneProgressReport{
+		Kind:       "report",
+		Message:    pkg,
+		Percentage: percentage,
+	})
+}
+
+// End reports that the work is done, with an optional message describing
+// the result.
+func (p *workDoneProgress) End(message string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	ended := p.ended
+	p.ended = true
+	p.mu.Unlock()
+	if ended {
+		return
+	}
+	p.notify(&workDoneProgressEnd{
+		Kind:    "end",
+		Message: message,
+	})
+	p.done()
+}
+
+func (p *workDoneProgress) notify(value interface{}) {
+	// We intentionally do not use the context of the work, since we still
+	// need to send the end notification if it is cancelled.
+	if err := p.conn.Notify(context.Background(), "$/progress", &progressParams{Token: p.token, Value: value}); err != nil {
+		log.Printf("warning: failed to send progress %q: %s", p.token, err)
+	}
+}
+
+type workDoneProgressKey struct{}
+
+// withWorkDoneProgress returns a copy of ctx which carries p. This allows
+// work which is shared between requests to report progress if it is run on
+// behalf of an operation which reports progress.
+func withWorkDoneProgress(ctx context.Context, p *workDoneProgress) context.Context {
+	return context.WithValue(ctx, workDoneProgressKey{}, p)
+}
+
+// workDoneProgressFromContext returns the *workDoneProgress associated with
+// ctx. It returns nil if ctx does not carry one.
+func workDoneProgressFromContext(ctx context.Context) *workDoneProgress {
+	p, _ := ctx.Value(workDoneProgressKey{}).(*workDoneProgress)
+	return p
+}
@@ -0,0 +1,156 @@
+package langserver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestProgressCancel(t *testing.T) {
+	p := &progress{}
+	token1 := p.newToken()
+	token2 := p.newToken()
+	if token1 == token2 {
+		t.Fatalf("tokens should be unique, got %q twice", token1)
+	}
+	ctx1, done1 := p.WithCancel(context.Background(), token1)
+	ctx2, done2 := p.WithCancel(context.Background(), token2)
+
+	p.Cancel(token1)
+	if ctx1.Err() == nil {
+		t.Fatal("ctx1 should be canceled")
+	}
+	if ctx2.Err() != nil {
+		t.Fatal("ctx2 should not be canceled yet")
+	}
+	done1()
+
+	done2()
+	if ctx2.Err() == nil {
+		t.Fatal("ctx2 should be canceled")
+	}
+	// Cancelling a progress which has ended should be a noop.
+	p.Cancel(token2)
+	p.Cancel("unknown")
+}
+
+func TestWorkDoneProgress(t *testing.T) {
+	conn := &recordingConn{}
+	h := &LangHandler{
+		init: &InitializeParams{
+			ExtraCapabilities: ClientCapabilities{
+				Window: WindowClientCapabilities{WorkDoneProgress: true},
+			},
+		},
+		progress: &progress{},
+	}
+
+	ctx, p := h.startWorkDoneProgress(context.Background(), conn, "test")
+	if p == nil {
+		t.Fatal("expected progress to be created")
+	}
+	if got := workDoneProgressFromContext(ctx); got != p {
+		t.Fatal("expected progress to be stored in context")
+	}
+
+	for _, pkg := range []string{"a", "b", "c", "d", "a"} {
+		p.Expect(pkg)
+	}
+	p.Step("a")
+	p.Step("a") // already done, should not be reported
+	p.Step("b")
+	p.Step("x") // not expected, but should still count as done
+	p.End("finished")
+	p.Step("c") // after end, should not be reported
+	p.End("finished")
+
+	if ctx.Err() == nil {
+		t.Error("ctx should be released after End")
+	}
+
+	want := []string{
+		`call window/workDoneProgress/create {"token":"langserver-go-1"}`,
+		`notify $/progress {"token":"langserver-go-1","value":{"kind":"begin","title":"test","cancellable":true,"percentage":0}}`,
+		`notify $/progress {"token":"langserver-go-1","value":{"kind":"report","message":"a","percentage":25}}`,
+		`notify $/progress {"token":"langserver-go-1","value":{"kind":"report","message":"b","percentage":50}}`,
+		`notify $/progress {"token":"langserver-go-1","value":{"kind":"report","message":"x","percentage":60}}`,
+		`notify $/progress {"token":"langserver-go-1","value":{"kind":"end","message":"finished"}}`,
+	}
+	if !reflect.DeepEqual(conn.msgs, want) {
+		t.Errorf("got messages\n%v\nwant\n%v", conn.msgs, want)
+	}
+}
+
+func TestWorkDoneProgress_unsupported(t *testing.T) {
+	conn := &recordingConn{}
+	h := &LangHandler{
+		init:     &InitializeParams{},
+		progress: &progress{},
+	}
+	ctx := context.Background()
+	gotCtx, p := h.startWorkDoneProgress(ctx, conn, "test")
+	if p != nil {
+		t.Fatal("expected no progress if the client does not support it")
+	}
+	if gotCtx != ctx {
+		t.Fatal("expected context to be unchanged")
+	}
+	// All methods should be safe to call on a nil progress
+	p.Expect("a")
+	p.Step("a")
+	p.End("")
+	if len(conn.msgs) != 0 {
+		t.Errorf("expected no messages, got %v", conn.msgs)
+	}
+}
+
+func TestInitializeParams_ExtraCapabilities(t *testing.T) {
+	var params InitializeParams
+	err := json.Unmarshal([]byte(`{"rootUri":"file:///src","capabilities":{"window":{"workDoneProgress":true},"xcacheProvider":true}}`), &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.RootURI != "file:///src" {
+		t.Errorf("got rootUri %q", params.RootURI)
+	}
+	if !params.Capabilities.XCacheProvider {
+		t.Error("expected capabilities from lsp.ClientCapabilities to be decoded")
+	}
+	if !params.ExtraCapabilities.Window.WorkDoneProgress {
+		t.Error("expected window.workDoneProgress to be decoded")
+	}
+}
+
+// recordingConn is a jsonrpc2.JSONRPC2 which records all calls and
+// notifications sent to it.
+type recordingConn struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (c *recordingConn) record(kind, method string, params interface{}) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.msgs = append(c.msgs, kind+" "+method+" "+string(b))
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *recordingConn) Call(ctx context.Context, method string, params, result interface{}, opt ...jsonrpc2.CallOption) error {
+	return c.record("call", method, params)
+}
+
+func (c *recordingConn) Notify(ctx context.Context, method string, params interface{}, opt ...jsonrpc2.CallOption) error {
+	return c.record("notify", method, params)
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
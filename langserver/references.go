@@ -60,6 +60,9 @@ func (h *LangHandler) handleTextDocumentReferences(ctx context.Context, conn jso
 	if obj == nil {
 		return nil, errors.New("references object not found")
 	}
+	// References to the methods and fields of instantiated types are
+	// references to the generic declaration.
+	obj = originObject(obj)
 
 	// TODO(sqs): golang.org/x/tools/cmd/guru/referrers.go has some
 	// other handling of obj == nil cases: type-switches, package
@@ -180,7 +183,7 @@ func (h *LangHandler) handleTextDocumentReferences(ctx context.Context, conn jso
 				lconf.ImportWithTests(path)
 			}
 
-			findRefErr = findReferences(findRefCtx, lconf, pkgInWorkspace, obj, refs)
+			findRefErr = findReferences(findRefCtx, h.packageLoader(), lconf, pkgInWorkspace, obj, refs)
 		}
 		if findRefCtx.Err() != nil {
 			// If we are canceled, cancel loop early
@@ -221,8 +224,10 @@ func (h *LangHandler) reverseImportGraph(ctx context.Context, conn jsonrpc2.JSON
 		// this repo. For sourcegraph.com this means we share the
 		// import graph across commits. We want this behaviour since
 		// we assume that they don't change drastically across
-		// commits.
-		cacheKey := "importgraph:" + string(h.init.Root())
+		// commits. The files of the packages, and so their imports,
+		// depend on the build target.
+		bctx := h.BuildContext(ctx)
+		cacheKey := fmt.Sprintf("importgraph:%s:%s/%s:cgo=%t", h.init.Root(), bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled)
 
 		h.mu.Lock()
 		tryCache := h.importGraph == nil
@@ -337,8 +342,8 @@ func refStreamAndCollect(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonr
 }
 
 // findReferences will find all references to obj. It will only return
-// references from packages in lconf.ImportPkgs.
-func findReferences(ctx context.Context, lconf loader.Config, pkgInWorkspace func(string) bool, obj types.Object, refs chan<- *ast.Ident) error {
+// references from packages in lconf.ImportPkgs, which are loaded with pl.
+func findReferences(ctx context.Context, pl packageLoader, lconf loader.Config, pkgInWorkspace func(string) bool, obj types.Object, refs chan<- *ast.Ident) error {
 	// Bail out early if the context is canceled
 	if ctx.Err() != nil {
 		return ctx.Err()
@@ -358,6 +363,11 @@ func findReferences(ctx context.Context, lconf loader.Config, pkgInWorkspace fun
 		mu                sync.Mutex
 		qobj              types.Object
 		afterTypeCheckErr error
+
+		// reported are the references we already sent. go/packages
+		// loads the files of a package once for each of its
+		// variants (eg p and p [p.test]), so we see them twice.
+		reported = map[token.Position]bool{}
 	)
 
 	collectPkg := pkgInWorkspace
@@ -422,10 +432,21 @@ func findReferences(ctx context.Context, lconf loader.Config, pkgInWorkspace fun
 		mu.Unlock()
 
 		// Look for references to the query object. Only collect
-		// those that are in this workspace.
+		// those that are in this workspace. The variants go/packages
+		// loads for tests each have their own objects, so we also
+		// match objects declared at the position of the query
+		// object.
 		if queryObj != nil && collectPkg(pkg) {
 			for id, obj := range info.Uses {
-				if sameObj(queryObj, obj) {
+				if !sameObj(queryObj, obj) && !declaredAt(lconf.Fset, obj, queryObj.Name(), objposn) {
+					continue
+				}
+				posn := lconf.Fset.Position(id.Pos())
+				mu.Lock()
+				dup := reported[posn]
+				reported[posn] = true
+				mu.Unlock()
+				if !dup {
 					refs <- id
 				}
 			}
@@ -442,7 +463,7 @@ func findReferences(ctx context.Context, lconf loader.Config, pkgInWorkspace fun
 			_ = util.Panicf(recover(), "findReferences")
 		}()
 
-		lconf.Load() // ignore error
+		pl.Load(ctx, &lconf) // ignore error
 	}()
 
 	select {
@@ -789,10 +810,10 @@ func usesOf(queryObj types.Object, info *loader.PackageInfo) []*ast.Ident {
 	return refs
 }
 
-// same reports whether x and y are identical, or both are PkgNames
-// that import the same Package.
+// same reports whether x and y are identical, y belongs to an
+// instantiation of x, or both are PkgNames that import the same Package.
 func sameObj(x, y types.Object) bool {
-	if x == y {
+	if x == y || x == originObject(y) {
 		return true
 	}
 	if x, ok := x.(*types.PkgName); ok {
@@ -803,6 +824,17 @@ func sameObj(x, y types.Object) bool {
 	return false
 }
 
+// declaredAt reports whether obj, or the generic object it was
+// instantiated from, is named name and declared at posn.
+func declaredAt(fset *token.FileSet, obj types.Object, name string, posn token.Position) bool {
+	obj = originObject(obj)
+	if obj.Name() != name || !obj.Pos().IsValid() {
+		return false
+	}
+	p := fset.Position(obj.Pos())
+	return p.Filename == posn.Filename && p.Offset == posn.Offset
+}
+
 // readFile is like ioutil.ReadFile, but
 // it goes through the virtualized build.Context.
 // If non-nil, buf must have been reset.
@@ -0,0 +1,219 @@
+package langserver
+
+import (
+	"bufio"
+	"go/types"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/go-langserver/langserver/util"
+)
+
+// The field numbers and values of the SCIP protobuf messages written by
+// writeSCIP, from scip.proto.
+const (
+	scipIndexMetadata        = 1
+	scipIndexDocuments       = 2
+	scipIndexExternalSymbols = 3
+
+	scipMetadataToolInfo             = 2
+	scipMetadataProjectRoot          = 3
+	scipMetadataTextDocumentEncoding = 4
+	scipTextEncodingUTF8             = 1
+
+	scipToolInfoName      = 1
+	scipToolInfoVersion   = 2
+	scipToolInfoArguments = 3
+
+	scipDocumentRelativePath = 1
+	scipDocumentOccurrences  = 2
+	scipDocumentSymbols      = 3
+	scipDocumentLanguage     = 4
+
+	scipDocumentPositionEncoding = 6
+	scipPositionEncodingUTF16    = 2
+
+	scipOccurrenceRange       = 1
+	scipOccurrenceSymbol      = 2
+	scipOccurrenceSymbolRoles = 3
+	scipSymbolRoleDefinition  = 1
+
+	scipSymbolInformationSymbol        = 1
+	scipSymbolInformationDocumentation = 3
+)
+
+// writeSCIP writes the index as a SCIP index. The index is written as a
+// sequence of Index messages with one field each, which protobuf decoders
+// merge into a single one, so the documents don't need to be buffered.
+func (ix *codeIndex) writeSCIP(w io.Writer, opts IndexOptions) error {
+	bw := bufio.NewWriter(w)
+
+	var toolInfo []byte
+	toolInfo = protoAppendString(toolInfo, scipToolInfoName, "go-langserver")
+	toolInfo = protoAppendString(toolInfo, scipToolInfoVersion, opts.ToolVersion)
+	for _, arg := range opts.ToolArguments {
+		toolInfo = protoAppendString(toolInfo, scipToolInfoArguments, arg)
+	}
+	var metadata []byte
+	metadata = protoAppendBytes(metadata, scipMetadataToolInfo, toolInfo)
+	metadata = protoAppendString(metadata, scipMetadataProjectRoot, string(util.PathToURI(ix.rootPath)))
+	metadata = protoAppendVarint(metadata, scipMetadataTextDocumentEncoding, scipTextEncodingUTF8)
+	if _, err := bw.Write(protoAppendBytes(nil, scipIndexMetadata, metadata)); err != nil {
+		return err
+	}
+
+	symbols := make([]string, len(ix.symbols))
+	for i, s := range ix.symbols {
+		symbols[i] = s.scipSymbol()
+	}
+	// The information of a symbol is written in the first document
+	// defining it.
+	written := make([]bool, len(ix.symbols))
+	for _, doc := range ix.documents {
+		var d []byte
+		d = protoAppendString(d, scipDocumentRelativePath, ix.relativePath(doc))
+		d = protoAppendString(d, scipDocumentLanguage, "go")
+		d = protoAppendVarint(d, scipDocumentPositionEncoding, scipPositionEncodingUTF16)
+		for _, o := range doc.occurrences {
+			r := []int32{int32(o.rng.Start.Line), int32(o.rng.Start.Character), int32(o.rng.End.Line), int32(o.rng.End.Character)}
+			if o.rng.Start.Line == o.rng.End.Line {
+				r = []int32{r[0], r[1], r[3]}
+			}
+			var occ []byte
+			occ = protoAppendPacked(occ, scipOccurrenceRange, r)
+			occ = protoAppendString(occ, scipOccurrenceSymbol, symbols[o.symbol.id])
+			if o.definition {
+				occ = protoAppendVarint(occ, scipOccurrenceSymbolRoles, scipSymbolRoleDefinition)
+			}
+			d = protoAppendBytes(d, scipDocumentOccurrences, occ)
+		}
+		for _, o := range doc.occurrences {
+			if o.definition && !written[o.symbol.id] {
+				written[o.symbol.id] = true
+				d = protoAppendBytes(d, scipDocumentSymbols, scipSymbolInformation(symbols[o.symbol.id], o.symbol.hover))
+			}
+		}
+		if _, err := bw.Write(protoAppendBytes(nil, scipIndexDocuments, d)); err != nil {
+			return err
+		}
+	}
+
+	for i, s := range ix.symbols {
+		if !s.defined && s.desc != nil && s.hover != "" {
+			if _, err := bw.Write(protoAppendBytes(nil, scipIndexExternalSymbols, scipSymbolInformation(symbols[i], s.hover))); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func scipSymbolInformation(symbol, hover string) []byte {
+	var info []byte
+	info = protoAppendString(info, scipSymbolInformationSymbol, symbol)
+	if hover != "" {
+		info = protoAppendString(info, scipSymbolInformationDocumentation, hover)
+	}
+	return info
+}
+
+// scipSymbol returns the SCIP symbol of s, eg
+//
+//	go go github.com/foo/bar . bar/Router#Route().
+//
+// Its package is the import path of the package which declares it, so it
+// identifies the same symbols as the monikers of LSIF indexes. The symbols
+// without a symbolDescriptor are local.
+func (s *indexSymbol) scipSymbol() string {
+	if s.desc == nil {
+		return "local " + strconv.Itoa(s.id)
+	}
+	var b strings.Builder
+	b.WriteString("go go ")
+	b.WriteString(scipEscapePackage(s.desc.Package))
+	b.WriteString(" . ")
+	b.WriteString(scipEscapeName(s.desc.PackageName))
+	b.WriteString("/")
+	if s.desc.Recv != "" {
+		b.WriteString(scipEscapeName(s.desc.Recv))
+		b.WriteString("#")
+	}
+	if s.desc.Name != "" {
+		b.WriteString(scipEscapeName(s.desc.Name))
+		switch s.obj.(type) {
+		case *types.TypeName:
+			b.WriteString("#")
+		case *types.Func:
+			b.WriteString("().")
+		default:
+			b.WriteString(".")
+		}
+	}
+	return b.String()
+}
+
+// scipEscapeName escapes a name of a SCIP descriptor with backticks, unless
+// it is a simple identifier.
+func scipEscapeName(name string) string {
+	for _, r := range name {
+		if !(r == '_' || r == '+' || r == '-' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "`" + strings.Replace(name, "`", "``", -1) + "`"
+		}
+	}
+	return name
+}
+
+// scipEscapePackage escapes the spaces of a field of the package of a SCIP
+// symbol, and replaces an empty one with ".".
+func scipEscapePackage(s string) string {
+	if s == "" {
+		return "."
+	}
+	return strings.Replace(s, " ", "  ", -1)
+}
+
+// The protobuf wire types used by SCIP messages.
+const (
+	protoWireVarint = 0
+	protoWireBytes  = 2
+)
+
+func protoAppendTag(b []byte, field, wireType int) []byte {
+	return protoAppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func protoAppendUvarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func protoAppendVarint(b []byte, field int, v int32) []byte {
+	b = protoAppendTag(b, field, protoWireVarint)
+	// Negative int32s are sign extended to 64 bits.
+	return protoAppendUvarint(b, uint64(int64(v)))
+}
+
+func protoAppendBytes(b []byte, field int, v []byte) []byte {
+	b = protoAppendTag(b, field, protoWireBytes)
+	b = protoAppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoAppendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return protoAppendBytes(b, field, []byte(v))
+}
+
+func protoAppendPacked(b []byte, field int, vs []int32) []byte {
+	var packed []byte
+	for _, v := range vs {
+		packed = protoAppendUvarint(packed, uint64(int64(v)))
+	}
+	return protoAppendBytes(b, field, packed)
+}
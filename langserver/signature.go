@@ -6,10 +6,14 @@ import (
 	"go/ast"
 	"go/token"
 	"go/types"
+	"regexp"
+	"strings"
 
 	"github.com/sourcegraph/go-langserver/langserver/util"
 	"github.com/sourcegraph/go-lsp"
 	"github.com/sourcegraph/jsonrpc2"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/loader"
 )
 
 func (h *LangHandler) handleTextDocumentSignatureHelp(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lsp.TextDocumentPositionParams) (*lsp.SignatureHelp, error) {
@@ -31,17 +35,67 @@ func (h *LangHandler) handleTextDocumentSignatureHelp(ctx context.Context, conn
 	if call == nil {
 		return nil, nil
 	}
-	t := pkg.TypeOf(call.Fun)
-	signature, ok := t.(*types.Signature)
-	if !ok {
-		return nil, nil
+	fun := astutil.Unparen(call.Fun)
+
+	var (
+		info     lsp.SignatureInformation
+		variadic bool
+		ok       bool
+	)
+	var funcObj types.Object
+	if funcIdent := calledIdent(fun); funcIdent != nil {
+		funcObj = pkg.ObjectOf(funcIdent)
 	}
-	info := lsp.SignatureInformation{Label: shortType(signature)}
-	sParams := signature.Params()
-	info.Parameters = make([]lsp.ParameterInformation, sParams.Len())
-	for i := 0; i < sParams.Len(); i++ {
-		info.Parameters[i] = lsp.ParameterInformation{Label: shortParam(sParams.At(i))}
+	if tv, isType := pkg.Types[fun]; isType && tv.IsType() {
+		info, ok = conversionSignature(tv.Type), true
+		if named, isNamed := tv.Type.(*types.Named); isNamed {
+			info.Documentation = declDoc(h.objectPath(ctx, fset, prog, named.Obj()))
+		}
+	} else if b, isBuiltin := funcObj.(*types.Builtin); isBuiltin {
+		info, variadic, ok = builtinSignature(b)
 	}
+	if !ok {
+		// The type of a generic function is only instantiated once its
+		// type arguments are known, which they may not be yet while the
+		// call is being written.
+		t := pkg.TypeOf(call.Fun)
+		if _, isSignature := t.(*types.Signature); !isSignature {
+			if f, isFunc := funcObj.(*types.Func); isFunc {
+				t = f.Type()
+			}
+		}
+		signature, isSignature := t.(*types.Signature)
+		if !isSignature {
+			return nil, nil
+		}
+		info = lsp.SignatureInformation{Label: shortType(signature)}
+		sParams := signature.Params()
+		info.Parameters = make([]lsp.ParameterInformation, sParams.Len())
+		variadic = signature.Variadic()
+		for i := 0; i < sParams.Len(); i++ {
+			label := shortParam(sParams.At(i))
+			if variadic && i == sParams.Len()-1 {
+				label = shortVariadicParam(sParams.At(i))
+			}
+			info.Parameters[i] = lsp.ParameterInformation{Label: label}
+		}
+
+		if f, isFunc := funcObj.(*types.Func); isFunc {
+			for _, node := range h.objectPath(ctx, fset, prog, f) {
+				if decl, isDecl := node.(*ast.FuncDecl); isDecl {
+					if decl.Doc != nil {
+						info.Documentation = decl.Doc.Text()
+					}
+					docs := paramDocs(decl)
+					for i := 0; i < sParams.Len(); i++ {
+						info.Parameters[i].Documentation = docs[sParams.At(i).Name()]
+					}
+					break
+				}
+			}
+		}
+	}
+
 	activeParameter := len(call.Args)
 	for index, arg := range call.Args {
 		if arg.End() >= *start {
@@ -49,28 +103,152 @@ func (h *LangHandler) handleTextDocumentSignatureHelp(ctx context.Context, conn
 			break
 		}
 	}
+	// All the arguments from the variadic parameter on are for it.
+	if variadic && activeParameter >= len(info.Parameters) {
+		activeParameter = len(info.Parameters) - 1
+	}
+
+	return &lsp.SignatureHelp{Signatures: []lsp.SignatureInformation{info}, ActiveSignature: 0, ActiveParameter: activeParameter}, nil
+}
+
+// calledIdent returns the identifier of the function or type called by a
+// call of fun, such as F in F(x), p.F[int](x) and T.M(t, x), or nil if fun
+// is not a (possibly qualified or instantiated) identifier.
+func calledIdent(fun ast.Expr) *ast.Ident {
+	switch fun := astutil.Unparen(fun).(type) {
+	case *ast.Ident:
+		return fun
+	case *ast.SelectorExpr:
+		return fun.Sel
+	case *ast.IndexExpr:
+		return calledIdent(fun.X)
+	case *ast.IndexListExpr:
+		return calledIdent(fun.X)
+	}
+	return nil
+}
+
+// objectPath returns the path to the declaration of obj from the root of
+// its file, innermost node first, or nil if it is not found.
+func (h *LangHandler) objectPath(ctx context.Context, fset *token.FileSet, prog *loader.Program, obj types.Object) []ast.Node {
+	_, path, _ := prog.PathEnclosingInterval(obj.Pos(), obj.Pos())
+	if path == nil && h.config.UseExportData {
+		path = exportDataObjectPath(h.BuildContext(ctx), fset, obj)
+	}
+	return path
+}
 
-	funcIdent, funcOk := call.Fun.(*ast.Ident)
-	if !funcOk {
-		selExpr, selOk := call.Fun.(*ast.SelectorExpr)
-		if selOk {
-			funcIdent = selExpr.Sel
-			funcOk = true
+// declDoc returns the doc comment of the declaration of the type at the end
+// of path.
+func declDoc(path []ast.Node) string {
+	for _, node := range path {
+		switch node := node.(type) {
+		case *ast.TypeSpec:
+			if node.Doc != nil {
+				return node.Doc.Text()
+			}
+		case *ast.GenDecl:
+			return node.Doc.Text()
 		}
 	}
-	if funcIdent != nil && funcOk {
-		funcObj := pkg.ObjectOf(funcIdent)
-		_, path, _ := prog.PathEnclosingInterval(funcObj.Pos(), funcObj.Pos())
-		for i := 0; i < len(path); i++ {
-			a, b := path[i].(*ast.FuncDecl)
-			if b && a.Doc != nil {
-				info.Documentation = a.Doc.Text()
-				break
+	return ""
+}
+
+// conversionSignature returns the signature of a conversion to t, which
+// takes a single value of any type convertible to t.
+func conversionSignature(t types.Type) lsp.SignatureInformation {
+	return lsp.SignatureInformation{
+		Label:      shortType(t) + "(x)",
+		Parameters: []lsp.ParameterInformation{{Label: "x"}},
+	}
+}
+
+// builtinSignature returns the signature of the builtin function b as
+// declared in the documentation of the builtin or unsafe package. It
+// reports whether the declaration was found.
+func builtinSignature(b *types.Builtin) (info lsp.SignatureInformation, variadic, ok bool) {
+	importPath := "builtin"
+	if b.Pkg() != nil {
+		importPath = b.Pkg().Path()
+	}
+	fset, pkg, err := parseStdlibPackage(importPath)
+	if err != nil {
+		return info, false, false
+	}
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			decl, isFunc := decl.(*ast.FuncDecl)
+			if !isFunc || decl.Recv != nil || decl.Name.Name != b.Name() {
+				continue
+			}
+			info = lsp.SignatureInformation{
+				Label:         fmtNode(fset, decl.Type),
+				Documentation: decl.Doc.Text(),
 			}
+			docs := paramDocs(decl)
+			for _, field := range decl.Type.Params.List {
+				typ := fmtNode(fset, field.Type)
+				if len(field.Names) == 0 {
+					info.Parameters = append(info.Parameters, lsp.ParameterInformation{Label: typ})
+				}
+				for _, name := range field.Names {
+					info.Parameters = append(info.Parameters, lsp.ParameterInformation{
+						Label:         name.Name + " " + typ,
+						Documentation: docs[name.Name],
+					})
+				}
+				_, variadic = field.Type.(*ast.Ellipsis)
+			}
+			return info, variadic, true
 		}
 	}
+	return info, false, false
+}
 
-	return &lsp.SignatureHelp{Signatures: []lsp.SignatureInformation{info}, ActiveSignature: 0, ActiveParameter: activeParameter}, nil
+// paramDocs returns the documentation of the parameters of the function
+// declared by decl, including its receiver, by their names. Go doc comments
+// describe parameters in prose, so the documentation of a parameter is the
+// sentences of the doc comment which mention it.
+func paramDocs(decl *ast.FuncDecl) map[string]string {
+	sentences := docSentences(decl.Doc.Text())
+	docs := make(map[string]string)
+	var fields []*ast.Field
+	if decl.Recv != nil {
+		fields = append(fields, decl.Recv.List...)
+	}
+	fields = append(fields, decl.Type.Params.List...)
+	for _, field := range fields {
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				continue
+			}
+			mention := regexp.MustCompile(`\b` + regexp.QuoteMeta(name.Name) + `\b`)
+			var mentions []string
+			for _, s := range sentences {
+				if mention.MatchString(s) {
+					mentions = append(mentions, s)
+				}
+			}
+			if len(mentions) > 0 {
+				docs[name.Name] = strings.Join(mentions, " ")
+			}
+		}
+	}
+	return docs
+}
+
+// docSentences splits the doc comment text into its sentences.
+func docSentences(text string) []string {
+	var sentences []string
+	words := strings.Fields(text)
+	start := 0
+	for i, word := range words {
+		if i == len(words)-1 || strings.HasSuffix(word, ".") || strings.HasSuffix(word, "?") || strings.HasSuffix(word, "!") {
+			sentences = append(sentences, strings.Join(words[start:i+1], " "))
+			start = i + 1
+		}
+	}
+	return sentences
 }
 
 // callExpr climbs AST tree up until call expression
@@ -99,3 +277,16 @@ func shortParam(param *types.Var) string {
 	}
 	return ret + shortType(param.Type())
 }
+
+// shortVariadicParam returns shorthand variadic parameter notation in form
+// "name ...type" without specifying type's import path
+func shortVariadicParam(param *types.Var) string {
+	ret := param.Name()
+	if ret != "" {
+		ret += " "
+	}
+	if slice, ok := param.Type().(*types.Slice); ok {
+		return ret + "..." + shortType(slice.Elem())
+	}
+	return ret + shortType(param.Type())
+}
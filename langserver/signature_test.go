@@ -0,0 +1,105 @@
+package langserver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-lsp"
+)
+
+func TestSignatureHelp(t *testing.T) {
+	h, _ := newHoverTestHandler(t, `package p
+
+import "unsafe"
+
+// T is a number.
+type T int
+
+// M multiplies t by n.
+func (t T) M(n int) T { return t * T(n) }
+
+// V returns the sum of base and the values in vs. The
+// values are added in order.
+func V(base int, vs ...int) int { return base }
+
+// G returns x.
+func G[E any](x E) E { return x }
+
+func f() {
+	var s []int
+	s = append(s, 1, 2)
+	_ = T(1)
+	_ = T.M(1, 2)
+	_ = V(1, 2, 3)
+	_ = G[int](1)
+	_ = G()
+	_ = unsafe.Sizeof(s)
+	_ = []byte("")
+}
+`)
+	h.FS.Bind("/goroot/src/unsafe", ctxvfs.Map(map[string][]byte{
+		"unsafe.go": []byte("package unsafe"),
+	}), "/", ctxvfs.BindReplace)
+	_, ctx := opentracing.StartSpanFromContext(context.Background(), "signaturetest")
+
+	tests := []struct {
+		line, character int
+		label           string
+		params          []string
+		paramDocs       []string
+		active          int
+	}{
+		// Builtins, with the variadic parameter staying active.
+		{19, 18, "func(slice []Type, elems ...Type) []Type", []string{"slice []Type", "elems ...Type"}, nil, 1},
+		{25, 19, "func(x ArbitraryType) uintptr", []string{"x ArbitraryType"}, nil, 0},
+
+		// Conversions.
+		{20, 7, "T(x)", []string{"x"}, []string{""}, 0},
+		{26, 12, "[]byte(x)", []string{"x"}, []string{""}, 0},
+
+		// Method expressions take the receiver as the first argument.
+		{21, 12, "func(t T, n int) T", []string{"t T", "n int"}, []string{"M multiplies t by n.", "M multiplies t by n."}, 1},
+
+		{22, 13, "func(base int, vs ...int) int", []string{"base int", "vs ...int"}, []string{"V returns the sum of base and the values in vs.", "V returns the sum of base and the values in vs."}, 1},
+
+		// Generic functions, instantiated or not.
+		{23, 12, "func(x int) int", []string{"x int"}, []string{"G returns x."}, 0},
+		{24, 7, "func[E any](x E) E", []string{"x E"}, []string{"G returns x."}, 0},
+	}
+	for _, test := range tests {
+		res, err := h.handleTextDocumentSignatureHelp(ctx, nil, nil, lsp.TextDocumentPositionParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: "file:///src/p/a.go"},
+			Position:     lsp.Position{Line: test.line, Character: test.character},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res == nil || len(res.Signatures) != 1 {
+			t.Errorf("signature help at %d:%d: got %+v, want a signature", test.line, test.character, res)
+			continue
+		}
+		info := res.Signatures[0]
+		var params, paramDocs []string
+		for _, p := range info.Parameters {
+			params = append(params, p.Label)
+			paramDocs = append(paramDocs, p.Documentation)
+		}
+		if info.Label != test.label || !reflect.DeepEqual(params, test.params) || res.ActiveParameter != test.active {
+			t.Errorf("signature help at %d:%d: got %q %q active %d, want %q %q active %d", test.line, test.character, info.Label, params, res.ActiveParameter, test.label, test.params, test.active)
+		}
+		if test.paramDocs != nil && !reflect.DeepEqual(paramDocs, test.paramDocs) {
+			t.Errorf("signature help at %d:%d: got parameter docs %q, want %q", test.line, test.character, paramDocs, test.paramDocs)
+		}
+	}
+}
+
+func TestDocSentences(t *testing.T) {
+	got := docSentences("Copy copies src to dst. It returns\nthe number of bytes copied, or an error?\n\n\tCopy(w, r)\n")
+	want := []string{"Copy copies src to dst.", "It returns the number of bytes copied, or an error?", "Copy(w, r)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package langserver
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"go/ast"
 	"go/build"
@@ -33,6 +34,13 @@ type Query struct {
 	File, Dir string
 	Tokens    []string
 
+	// Scope is where to search for symbols. If it is zero, the scope
+	// is decided by Config.WorkspaceSymbolScope.
+	Scope SymbolScope
+
+	// scopeErr is the error of a `scope:` filter with an unknown scope.
+	scopeErr error
+
 	Symbol lspext.SymbolDescriptor
 }
 
@@ -49,6 +57,9 @@ func (q Query) String() string {
 	default:
 		// no filter.
 	}
+	for _, name := range q.Scope.names() {
+		s = queryJoin(s, "scope:"+name)
+	}
 	if q.Kind != 0 {
 		for kwd, kind := range keywords {
 			if kind == q.Kind {
@@ -86,6 +97,14 @@ func ParseQuery(q string) (qu Query) {
 			qu.Filter = FilterExported
 			continue
 		}
+		if strings.HasPrefix(field, "scope:") {
+			scope, err := ParseSymbolScope(strings.TrimPrefix(field, "scope:"))
+			if err != nil && qu.scopeErr == nil {
+				qu.scopeErr = err
+			}
+			qu.Scope |= scope
+			continue
+		}
 
 		// Each field is split into tokens, delimited by periods or slashes.
 		tokens := strings.FieldsFunc(field, func(c rune) bool {
@@ -109,6 +128,62 @@ const (
 	FilterDir      FilterType = "dir"
 )
 
+// SymbolScope is a set of packages to search for symbols. Symbols outside
+// of the workspace rank below the ones in it.
+type SymbolScope uint
+
+const (
+	// ScopeWorkspace is the packages in the workspace.
+	ScopeWorkspace SymbolScope = 1 << iota
+
+	// ScopeStd is the packages in GOROOT.
+	ScopeStd
+
+	// ScopeDeps is the packages outside of the workspace and GOROOT
+	// which the workspace imports, directly or indirectly.
+	ScopeDeps
+
+	// ScopeAll is all of the above.
+	ScopeAll = ScopeWorkspace | ScopeStd | ScopeDeps
+)
+
+// symbolScopeNames are the names of scopes in `scope:` query filters and
+// Config.WorkspaceSymbolScope.
+var symbolScopeNames = []struct {
+	name  string
+	scope SymbolScope
+}{
+	{"workspace", ScopeWorkspace},
+	{"std", ScopeStd},
+	{"deps", ScopeDeps},
+	{"all", ScopeAll},
+}
+
+// ParseSymbolScope returns the scope with the given name, eg "std". The
+// scope always includes the workspace.
+func ParseSymbolScope(name string) (SymbolScope, error) {
+	for _, n := range symbolScopeNames {
+		if n.name == name {
+			return ScopeWorkspace | n.scope, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown symbol scope %q", name)
+}
+
+// names returns the names of the scopes in s, which s is the union of.
+func (s SymbolScope) names() []string {
+	var names []string
+	for _, n := range symbolScopeNames {
+		if n.scope != ScopeWorkspace && n.scope != ScopeAll && s&n.scope != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 && s != 0 {
+		names = append(names, "workspace")
+	}
+	return names
+}
+
 // keywords are keyword tokens that will be interpreted as symbol kind
 // filters in the search query.
 var keywords = map[string]lsp.SymbolKind{
@@ -132,12 +207,25 @@ type resultSorter struct {
 	Query
 	results   []scoredSymbol
 	resultsMu sync.Mutex
+
+	// filenames memoizes util.UriToPath for score, since parsing the
+	// URI of every symbol is expensive and most files have many symbols.
+	filenames map[lsp.DocumentURI]string
 }
 
 // scoredSymbol is a symbol with an attached search relevancy score.
 // It is used internally by resultSorter.
 type scoredSymbol struct {
 	score int
+
+	// fuzzy is the sum of the fuzzyMatch scores of the query tokens. It
+	// ranks symbols with the same score.
+	fuzzy int
+
+	// external is true for symbols outside of the workspace, which rank
+	// below the ones in it.
+	external bool
+
 	symbolPair
 }
 
@@ -146,8 +234,14 @@ type scoredSymbol struct {
  */
 func (s *resultSorter) Len() int { return len(s.results) }
 func (s *resultSorter) Less(i, j int) bool {
+	if s.results[i].external != s.results[j].external {
+		return !s.results[i].external
+	}
 	iscore, jscore := s.results[i].score, s.results[j].score
 	if iscore == jscore {
+		if s.results[i].fuzzy != s.results[j].fuzzy {
+			return s.results[i].fuzzy > s.results[j].fuzzy
+		}
 		if s.results[i].ContainerName == s.results[j].ContainerName {
 			if s.results[i].Name == s.results[j].Name {
 				return s.results[i].Location.URI < s.results[j].Location.URI
@@ -165,15 +259,35 @@ func (s *resultSorter) Swap(i, j int) {
 // Collect is a thread-safe method that will record the passed-in
 // symbol in the list of results if its score > 0.
 func (s *resultSorter) Collect(si symbolPair) {
+	s.collect(si, false)
+}
+
+// collect is Collect for symbols which are either in the workspace or
+// outside of it.
+func (s *resultSorter) collect(si symbolPair, external bool) {
 	s.resultsMu.Lock()
-	score := score(s.Query, si)
+	score, fuzzy := score(s.Query, si, s.filename(si.Location.URI))
 	if score > 0 {
-		sc := scoredSymbol{score, si}
+		sc := scoredSymbol{score, fuzzy, external, si}
 		s.results = append(s.results, sc)
 	}
 	s.resultsMu.Unlock()
 }
 
+// filename returns the path of the file uri refers to. s.resultsMu must be
+// held.
+func (s *resultSorter) filename(uri lsp.DocumentURI) string {
+	if filename, ok := s.filenames[uri]; ok {
+		return filename
+	}
+	if s.filenames == nil {
+		s.filenames = map[lsp.DocumentURI]string{}
+	}
+	filename := util.UriToPath(uri)
+	s.filenames[uri] = filename
+	return filename
+}
+
 // Results returns the ranked list of SymbolInformation values.
 func (s *resultSorter) Results() []lsp.SymbolInformation {
 	res := make([]lsp.SymbolInformation, len(s.results))
@@ -184,46 +298,57 @@ func (s *resultSorter) Results() []lsp.SymbolInformation {
 }
 
 // score returns 0 for results that aren't matches. Results that are matches are assigned
-// a positive score, which should be used for ranking purposes.
-func score(q Query, s symbolPair) (scor int) {
+// a positive score, which should be used for ranking purposes. Query tokens
+// of at least 2 characters which aren't a prefix of the name or container
+// name of s may still match them fuzzily, eg "nwrdr" matches NewReader. Such
+// matches score less than prefix matches. fuzzy is the sum of the fuzzyMatch
+// scores of the tokens, which ranks results with the same score. filename
+// is the path of the file s is defined in.
+func score(q Query, s symbolPair, filename string) (scor, fuzzy int) {
 	if q.Kind != 0 {
 		if q.Kind != s.Kind {
-			return 0
+			return 0, 0
 		}
 	}
 	if q.Symbol != nil && !s.desc.Contains(q.Symbol) {
-		return -1
+		return -1, 0
 	}
 	name, container := strings.ToLower(s.Name), strings.ToLower(s.ContainerName)
 	if !util.IsURI(s.Location.URI) {
 		log.Printf("unexpectedly saw symbol defined at a non-file URI: %q", s.Location.URI)
-		return 0
+		return 0, 0
 	}
-	filename := util.UriToPath(s.Location.URI)
 	isVendor := strings.HasPrefix(filename, "vendor/") || strings.Contains(filename, "/vendor/")
 	if q.Filter == FilterExported && isVendor {
 		// is:exported excludes vendor symbols always.
-		return 0
+		return 0, 0
 	}
 	if q.File != "" && filename != q.File {
 		// We're restricting results to a single file, and this isn't it.
-		return 0
+		return 0, 0
 	}
 	if len(q.Tokens) == 0 { // early return if empty query
 		if isVendor {
-			return 1 // lower score for vendor symbols
+			return 1, 0 // lower score for vendor symbols
 		} else {
-			return 2
+			return 2, 0
 		}
 	}
 	for i, tok := range q.Tokens {
 		tok := strings.ToLower(tok)
+		containerFuzzy := fuzzyTokenMatch(tok, s.ContainerName)
 		if strings.HasPrefix(container, tok) {
 			scor += 2
+		} else if containerFuzzy > 0 {
+			scor++
 		}
+		nameFuzzy := fuzzyTokenMatch(tok, s.Name)
 		if strings.HasPrefix(name, tok) {
 			scor += 3
+		} else if nameFuzzy > 0 {
+			scor++
 		}
+		fuzzy += containerFuzzy + nameFuzzy
 		if strings.Contains(filename, tok) && len(tok) >= 3 {
 			scor++
 		}
@@ -249,7 +374,18 @@ func score(q Query, s symbolPair) (scor int) {
 		// boost for exported symbols
 		scor++
 	}
-	return scor
+	return scor, fuzzy
+}
+
+// fuzzyTokenMatch returns the fuzzyMatch score of the query token tok for
+// name, or 0 if it doesn't match. Single characters match too many names to
+// be useful, so they never match.
+func fuzzyTokenMatch(tok, name string) int {
+	if len(tok) < 2 {
+		return 0
+	}
+	f, _ := fuzzyMatch(tok, name)
+	return f
 }
 
 // toSym returns a SymbolInformation value derived from values we get
@@ -316,6 +452,9 @@ func (h *LangHandler) handleTextDocumentSymbol(ctx context.Context, conn jsonrpc
 // language server.
 func (h *LangHandler) handleWorkspaceSymbol(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request, params lspext.WorkspaceSymbolParams) ([]lsp.SymbolInformation, error) {
 	q := ParseQuery(params.Query)
+	if q.scopeErr != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: q.scopeErr.Error()}
+	}
 	q.Symbol = params.Symbol
 	if q.Filter == FilterDir {
 		q.Dir = path.Join(h.init.RootImportPath, q.Dir)
@@ -326,6 +465,11 @@ func (h *LangHandler) handleWorkspaceSymbol(ctx context.Context, conn jsonrpc2.J
 		q.Dir = strings.SplitN(id.(string), "/-/", 2)[0]
 		q.Filter = FilterDir
 	}
+	if q.Scope == 0 && q.Symbol == nil {
+		// Symbol descriptor queries look for the definition of a
+		// symbol in this workspace, so they never use the default.
+		q.Scope = h.config.workspaceSymbolScope()
+	}
 	if params.Limit == 0 {
 		// If no limit is specified, default to a reasonable number
 		// for a user to look at. If they want more, they should
@@ -341,8 +485,14 @@ func (h *LangHandler) handleSymbol(ctx context.Context, conn jsonrpc2.JSONRPC2,
 		rootPath := h.FilePath(h.init.Root())
 		bctx := h.BuildContext(ctx)
 
+		progress := workDoneProgressFromContext(ctx)
+		pkgs := tools.ListPkgsUnderDir(bctx, rootPath)
+		for _, pkg := range pkgs {
+			progress.Expect(pkg)
+		}
+
 		par := parallel.NewRun(h.config.MaxParallelism)
-		for _, pkg := range tools.ListPkgsUnderDir(bctx, rootPath) {
+		for _, pkg := range pkgs {
 			// If we're restricting results to a single file or dir, ensure the
 			// package dir matches to avoid doing unnecessary work.
 			if results.Query.File != "" {
@@ -354,10 +504,12 @@ func (h *LangHandler) handleSymbol(ctx context.Context, conn jsonrpc2.JSONRPC2,
 				}
 				filePkgPath = util.PathTrimPrefix(filePkgPath, "src")
 				if !util.PathEqual(pkg, filePkgPath) {
+					progress.Step(pkg)
 					continue
 				}
 			}
 			if results.Query.Filter == FilterDir && !util.PathEqual(pkg, results.Query.Dir) {
+				progress.Step(pkg)
 				continue
 			}
 
@@ -377,6 +529,7 @@ func (h *LangHandler) handleSymbol(ctx context.Context, conn jsonrpc2.JSONRPC2,
 				// https://github.com/golang/go/issues/17788
 				defer func() {
 					par.Release()
+					progress.Step(pkg)
 					_ = util.Panicf(recover(), "%v for pkg %v", req.Method, pkg)
 				}()
 				h.collectFromPkg(ctx, bctx, pkg, rootPath, &results)
@@ -384,6 +537,9 @@ func (h *LangHandler) handleSymbol(ctx context.Context, conn jsonrpc2.JSONRPC2,
 		}
 		_ = par.Wait()
 	}
+	if results.Query.File == "" && results.Query.Filter != FilterDir {
+		h.collectExternalSymbols(&results)
+	}
 	sort.Sort(&results)
 	if len(results.results) > limit && limit > 0 {
 		results.results = results.results[:limit]
@@ -397,11 +553,19 @@ type pkgSymResult struct {
 	symbols []lsp.SymbolInformation
 }
 
+type symbolCacheKey struct {
+	importPath string
+
+	// buildConfig is the buildConfigKey of the build context, since it
+	// decides which files are part of the package.
+	buildConfig string
+}
+
 // collectFromPkg collects all the symbols from the specified package
 // into the results. It uses LangHandler's package symbol cache to
 // speed up repeated calls.
 func (h *LangHandler) collectFromPkg(ctx context.Context, bctx *build.Context, pkg string, rootPath string, results *resultSorter) {
-	symbols := h.symbolCache.Get(pkg, func() interface{} {
+	symbols := h.symbolCache.Get(symbolCacheKey{pkg, buildConfigKey(bctx)}, func() interface{} {
 		findPackage := h.getFindPackageFunc()
 		buildPkg, err := findPackage(ctx, bctx, pkg, rootPath, rootPath, 0)
 		if err != nil {
@@ -409,18 +573,15 @@ func (h *LangHandler) collectFromPkg(ctx context.Context, bctx *build.Context, p
 			return nil
 		}
 
-		fs := token.NewFileSet()
-		astPkgs, err := parseDir(fs, bctx, buildPkg.Dir, nil, 0)
+		symbols, err := pkgSymbols(ctx, bctx, buildPkg)
 		if err != nil {
-			log.Printf("failed to parse directory %s: %s", buildPkg.Dir, err)
+			log.Printf("failed to collect symbols of %s: %s", buildPkg.Dir, err)
 			return nil
 		}
-		astPkg := astPkgs[buildPkg.Name]
-		if astPkg == nil {
+		if symbols == nil {
 			return nil
 		}
-
-		return astPkgToSymbols(fs, astPkg, buildPkg)
+		return symbols
 	})
 
 	if symbols == nil {
@@ -435,6 +596,81 @@ func (h *LangHandler) collectFromPkg(ctx context.Context, bctx *build.Context, p
 	}
 }
 
+// pkgSymbols returns the symbols of buildPkg. If the persistent index is
+// enabled, the symbols are stored in it, keyed by the contents of all Go
+// files in the package directory.
+func pkgSymbols(ctx context.Context, bctx *build.Context, buildPkg *build.Package) ([]symbolPair, error) {
+	ix := getDiskIndex()
+	if ix == nil {
+		return parsePkgSymbols(bctx, buildPkg)
+	}
+
+	// parsePkgSymbols parses all Go files in the directory, so they all
+	// need to be part of the key.
+	files, err := goFilesInDir(bctx, buildPkg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPkgFiles(bctx, buildPkg.Dir, files)
+	if err != nil {
+		return nil, err
+	}
+	key := indexKey{
+		Kind:       indexKindSymbols,
+		ImportPath: buildPkg.ImportPath,
+		Dir:        buildPkg.Dir,
+		Build:      buildConfigKey(bctx),
+		Hash:       hash,
+	}
+	data, err := ix.get(ctx, key, func() ([]byte, error) {
+		symbols, err := parsePkgSymbols(bctx, buildPkg)
+		if err != nil {
+			return nil, err
+		}
+		indexed := make([]indexedSymbol, len(symbols))
+		for i, sym := range symbols {
+			indexed[i] = indexedSymbol{Symbol: sym.SymbolInformation, Desc: sym.desc}
+		}
+		return json.Marshal(indexed)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var indexed []indexedSymbol
+	if err := json.Unmarshal(data, &indexed); err != nil {
+		return nil, err
+	}
+	if len(indexed) == 0 {
+		return nil, nil
+	}
+	symbols := make([]symbolPair, len(indexed))
+	for i, sym := range indexed {
+		symbols[i] = symbolPair{SymbolInformation: sym.Symbol, desc: sym.Desc}
+	}
+	return symbols, nil
+}
+
+// indexedSymbol is how a symbolPair is stored in the persistent index.
+type indexedSymbol struct {
+	Symbol lsp.SymbolInformation
+	Desc   symbolDescriptor
+}
+
+// parsePkgSymbols parses the files of buildPkg and returns its symbols.
+func parsePkgSymbols(bctx *build.Context, buildPkg *build.Package) ([]symbolPair, error) {
+	fs := token.NewFileSet()
+	astPkgs, err := parseDir(fs, bctx, buildPkg.Dir, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	astPkg := astPkgs[buildPkg.Name]
+	if astPkg == nil {
+		return nil, nil
+	}
+	return astPkgToSymbols(fs, astPkg, buildPkg), nil
+}
+
 // SymbolCollector stores symbol information for an AST
 type SymbolCollector struct {
 	pkgSyms  []symbolPair
@@ -1,11 +1,16 @@
 package langserver
 
 import (
+	"context"
+	"fmt"
 	"reflect"
 	"sort"
 	"testing"
 
+	"github.com/sourcegraph/ctxvfs"
 	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/go-lsp/lspext"
+	"github.com/sourcegraph/jsonrpc2"
 )
 
 func Test_resultSorter(t *testing.T) {
@@ -100,6 +105,57 @@ func Test_resultSorter(t *testing.T) {
 			Location: lsp.Location{URI: "file:///file.go"},
 			Kind:     lsp.SKFunction,
 		}},
+	}, {
+		// Prefix matches rank above fuzzy matches, which are ranked by
+		// how well they match.
+		rawQuery: "hsrv",
+		allSymbols: []lsp.SymbolInformation{{
+			Name:     "HandlerSetReverse",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKFunction,
+		}, {
+			Name:     "HTTPServer",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKClass,
+		}, {
+			Name:     "hsrvConfig",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKVariable,
+		}, {
+			Name:     "Handler",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKClass,
+		}},
+		expResults: []lsp.SymbolInformation{{
+			Name:     "hsrvConfig",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKVariable,
+		}, {
+			Name:     "HTTPServer",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKClass,
+		}, {
+			Name:     "HandlerSetReverse",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKFunction,
+		}},
+	}, {
+		// Filters still apply to fuzzy matches.
+		rawQuery: "func nwrdr",
+		allSymbols: []lsp.SymbolInformation{{
+			Name:     "NewReader",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKFunction,
+		}, {
+			ContainerName: "T", Name: "NewReader",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKMethod,
+		}},
+		expResults: []lsp.SymbolInformation{{
+			Name:     "NewReader",
+			Location: lsp.Location{URI: "file:///file.go"},
+			Kind:     lsp.SKFunction,
+		}},
 	}, {
 		rawQuery: "",
 		allSymbols: []lsp.SymbolInformation{{
@@ -145,6 +201,12 @@ func TestQueryString(t *testing.T) {
 		{input: "bar baz is:exported", expect: "is:exported bar baz"},
 		{input: "bar baz dir:foo", expect: "dir:foo bar baz"},
 		{input: "func baz dir:foo", expect: "dir:foo func baz"},
+
+		// Scopes.
+		{input: "scope:std bar", expect: "scope:std bar"},
+		{input: "bar scope:deps scope:std", expect: "scope:std scope:deps bar"},
+		{input: "scope:workspace bar", expect: "scope:workspace bar"},
+		{input: "scope:all", expect: "scope:std scope:deps"},
 	}
 	for _, test := range tests {
 		t.Run(test.input, func(t *testing.T) {
@@ -155,3 +217,73 @@ func TestQueryString(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkspaceSymbolScope(t *testing.T) {
+	fs := NewAtomicFS()
+	fs.Bind("/goroot/src", ctxvfs.Map(map[string][]byte{
+		"net/http/server.go": []byte("package http; func ListenAndServe() {}"),
+	}), "/", ctxvfs.BindReplace)
+	fs.Bind("/src", ctxvfs.Map(map[string][]byte{
+		"d/d.go": []byte("package d; func ListenD() {}"),
+		"p/a.go": []byte(`package p; import "d"; func Listen() { d.ListenD() }`),
+	}), "/", ctxvfs.BindReplace)
+	cfg := NewDefaultConfig()
+	h := &LangHandler{
+		HandlerShared: &HandlerShared{FS: fs},
+		init: &InitializeParams{
+			InitializeParams: lsp.InitializeParams{RootURI: "file:///src/p"},
+			RootImportPath:   "p",
+			BuildContext:     &InitializeBuildContextParams{GOOS: "linux", GOARCH: "amd64", GOPATH: "/", GOROOT: "/goroot", Compiler: "gc"},
+		},
+		config: &cfg,
+	}
+	h.resetCaches(false)
+
+	symbols := func(query string) []string {
+		// Wait for the indexes the query needs, which the first query
+		// starts building.
+		q := ParseQuery(query)
+		if q.Scope == 0 {
+			q.Scope = cfg.workspaceSymbolScope()
+		}
+		for _, scope := range []SymbolScope{ScopeStd, ScopeDeps} {
+			if q.Scope&scope != 0 {
+				<-h.externalSymbolIndex(scope).ready
+			}
+		}
+
+		res, err := h.handleWorkspaceSymbol(context.Background(), nil, &jsonrpc2.Request{Method: "workspace/symbol"}, lspext.WorkspaceSymbolParams{Query: query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(res))
+		for i, sym := range res {
+			names[i] = fmt.Sprintf("%s:%s", sym.Location.URI, sym.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		scope, query string
+		want         []string
+	}{
+		{"", "listen", []string{"file:///src/p/a.go:Listen"}},
+		{"", "scope:workspace listen", []string{"file:///src/p/a.go:Listen"}},
+		{"", "scope:std listen", []string{"file:///src/p/a.go:Listen", "file:///goroot/src/net/http/server.go:ListenAndServe"}},
+		{"", "scope:deps listen", []string{"file:///src/p/a.go:Listen", "file:///src/d/d.go:ListenD"}},
+		{"all", "listen", []string{"file:///src/p/a.go:Listen", "file:///goroot/src/net/http/server.go:ListenAndServe", "file:///src/d/d.go:ListenD"}},
+		{"all", "scope:workspace listen", []string{"file:///src/p/a.go:Listen"}},
+		{"all", "dir:/ listen", []string{"file:///src/p/a.go:Listen"}},
+	}
+	for _, test := range tests {
+		cfg.WorkspaceSymbolScope = test.scope
+		if got := symbols(test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("with scope %q, got symbols %v for %q, want %v", test.scope, got, test.query, test.want)
+		}
+	}
+
+	_, err := h.handleWorkspaceSymbol(context.Background(), nil, &jsonrpc2.Request{Method: "workspace/symbol"}, lspext.WorkspaceSymbolParams{Query: "scope:nope listen"})
+	if e, ok := err.(*jsonrpc2.Error); !ok || e.Code != jsonrpc2.CodeInvalidParams {
+		t.Errorf("got error %v for an unknown scope, want invalid params", err)
+	}
+}
@@ -38,6 +38,8 @@ func (h *LangHandler) handleWorkspaceReferences(ctx context.Context, conn jsonrp
 	// See: https://github.com/Microsoft/language-server-protocol/blob/master/protocol.md#cancelRequest
 	ctx, cancel := context.WithTimeout(ctx, workspaceReferencesTimeout)
 	defer cancel()
+	ctx, progress := h.startWorkDoneProgress(ctx, conn, "Finding workspace references")
+	defer progress.End("")
 	rootPath := h.FilePath(h.init.Root())
 	bctx := h.BuildContext(ctx)
 
@@ -215,6 +217,7 @@ func (h *LangHandler) workspaceRefsTypecheck(ctx context.Context, bctx *build.Co
 
 	// Configure the loader.
 	findPackage := h.getFindPackageFunc()
+	progress := workDoneProgressFromContext(ctx)
 	var typeErrs []error
 	conf := loader.Config{
 		Fset: fset,
@@ -237,9 +240,11 @@ func (h *LangHandler) workspaceRefsTypecheck(ctx context.Context, bctx *build.Co
 			if err != nil && !isMultiplePackageError(err) {
 				return bpkg, err
 			}
+			progress.Expect(bpkg.ImportPath)
 			return bpkg, nil
 		},
 		AfterTypeCheck: func(pkg *loader.PackageInfo, files []*ast.File) {
+			progress.Step(pkg.Pkg.Path())
 			if err := ctx.Err(); err != nil {
 				return
 			}
@@ -16,10 +16,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/die-net/lrucache"
+	"github.com/gregjones/httpcache"
 	"github.com/keegancsmith/tmpfriend"
 	"github.com/pkg/errors"
 
 	"github.com/sourcegraph/go-langserver/debugserver"
+	"github.com/sourcegraph/go-langserver/diskcache"
 	"github.com/sourcegraph/go-langserver/tracer"
 	"github.com/sourcegraph/go-langserver/vfsutil"
 
@@ -27,6 +30,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/sourcegraph/go-langserver/buildserver"
+	"github.com/sourcegraph/go-langserver/gosrc"
 	"github.com/sourcegraph/go-langserver/langserver"
 	"github.com/sourcegraph/jsonrpc2"
 	wsjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
@@ -44,15 +48,25 @@ var (
 	freeosmemory      = flag.Bool("freeosmemory", true, "aggressively free memory back to the OS")
 	useBuildServer    = flag.Bool("usebuildserver", false, "use a build server to fetch dependencies, fetch files via Zip URL, etc.")
 	cacheDir          = flag.String("cachedir", "/tmp", "directory to store cached archives")
+	offline           = flag.Bool("offline", false, "never access the network to fetch dependencies, only read them from vendor directories, -modcachedir and -mirrordir (build server only)")
+	modCacheDir       = flag.String("modcachedir", "", "module cache directory, such as $GOPATH/pkg/mod, to read go.mod dependencies from in offline mode")
+	mirrorDir         = flag.String("mirrordir", "", "directory of zip archives of dependencies, named <import path>@<rev>.zip, to read from in offline mode")
+	importPathRules   = flag.String("importpathrules", "", "JSON file of rules which resolve the import paths matching regular expressions to clone URLs (build server only)")
+	importPathTTL     = flag.Duration("importpathcachettl", 24*time.Hour, "how long import paths resolved from their go-import meta tags are cached in -cachedir (disabled if zero)")
+	importPathNegTTL  = flag.Duration("importpathcachenegativettl", 5*time.Minute, "how long import paths which failed to resolve are cached in -cachedir")
+	purgeImportPaths  = flag.Bool("purgeimportpathcache", false, "purge the cached import paths on startup")
 	maxCacheSizeBytes = flag.Int64("maxCacheSizeBytes", 50*1024*1024*1024, "the maximum size of the cache directory after evicting entries")
+	indexDir          = flag.String("indexdir", "", "directory to persist symbols and export data across restarts (disabled if empty)")
+	maxIndexSizeBytes = flag.Int64("maxIndexSizeBytes", 1024*1024*1024, "the maximum size of the index directory after pruning entries")
 
 	// Default Config, can be overridden by InitializationOptions
 	usebinarypkgcache  = flag.Bool("usebinarypkgcache", true, "use $GOPATH/pkg binary .a files (improves performance). Can be overridden by InitializationOptions.")
+	useexportdata      = flag.Bool("useexportdata", false, "typecheck dependencies from export data cached by the server instead of from source. Can be overridden by InitializationOptions.")
 	maxparallelism     = flag.Int("maxparallelism", 0, "use at max N parallel goroutines to fulfill requests. Can be overridden by InitializationOptions.")
 	gocodecompletion   = flag.Bool("gocodecompletion", false, "enable completion (extra memory burden). Can be overridden by InitializationOptions.")
 	diagnostics        = flag.Bool("diagnostics", false, "enable diagnostics (extra memory burden). Can be overridden by InitializationOptions.")
 	funcSnippetEnabled = flag.Bool("func-snippet-enabled", true, "enable argument snippets on func completion. Can be overridden by InitializationOptions.")
-	formatTool         = flag.String("format-tool", "goimports", "which tool is used to format documents. Supported: goimports and gofmt. Can be overridden by InitializationOptions.")
+	formatTool         = flag.String("format-tool", "goimports", "which tool is used to format documents. Supported: goimports, gofmt and gofumpt. Can be overridden by InitializationOptions.")
 	lintTool           = flag.String("lint-tool", "none", "which tool is used to linting. Supported: none and golint. Can be overridden by InitializationOptions.")
 
 	openGauge = prometheus.NewGauge(prometheus.GaugeOpts{
@@ -78,6 +92,14 @@ func main() {
 
 	vfsutil.ArchiveCacheDir = filepath.Join(*cacheDir, "lang-go-archive-cache")
 	vfsutil.MaxCacheSizeBytes = *maxCacheSizeBytes
+	langserver.IndexDir = *indexDir
+	langserver.MaxIndexSizeBytes = *maxIndexSizeBytes
+	if *offline {
+		buildserver.Offline = &buildserver.OfflineConfig{
+			ModCacheDir: *modCacheDir,
+			MirrorDir:   *mirrorDir,
+		}
+	}
 
 	// Start pprof server, if desired.
 	if *pprof != "" {
@@ -95,6 +117,7 @@ func main() {
 	cfg.GocodeCompletionEnabled = *gocodecompletion
 	cfg.DiagnosticsEnabled = *diagnostics
 	cfg.UseBinaryPkgCache = *usebinarypkgcache
+	cfg.UseExportData = *useexportdata
 	cfg.FormatTool = *formatTool
 	cfg.LintTool = *lintTool
 
@@ -102,6 +125,14 @@ func main() {
 		cfg.MaxParallelism = *maxparallelism
 	}
 
+	if flag.Arg(0) == "index" {
+		if err := runIndex(cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(cfg); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -168,9 +199,19 @@ func run(cfg langserver.Config) error {
 		connOpt = append(connOpt, jsonrpc2.LogMessages(log.New(logW, "", 0)))
 	}
 
+	var resolver gosrc.Resolver
+	if *useBuildServer {
+		var err error
+		resolver, err = newImportPathResolver()
+		if err != nil {
+			return err
+		}
+	}
+
 	newHandler := func() (jsonrpc2.Handler, io.Closer) {
 		if *useBuildServer {
 			handler := buildserver.NewHandler(cfg)
+			handler.Resolver = resolver
 			return jsonrpc2.AsyncHandler(jsonrpc2.HandlerWithError(handler.Handle)), handler
 		}
 		return langserver.NewHandler(cfg), ioutil.NopCloser(strings.NewReader(""))
@@ -287,6 +328,63 @@ func (stdrwc) Close() error {
 	return os.Stdout.Close()
 }
 
+// newImportPathResolver returns the resolver of the import paths of the
+// dependencies fetched by the build server, or nil to use the default one.
+// The resolved import paths are shared by all connections.
+func newImportPathResolver() (gosrc.Resolver, error) {
+	var resolver gosrc.Chain
+	if *importPathRules != "" {
+		data, err := ioutil.ReadFile(*importPathRules)
+		if err != nil {
+			return nil, err
+		}
+		rules, err := gosrc.ParseRewriteRules(data)
+		if err != nil {
+			return nil, err
+		}
+		resolver = append(resolver, rules)
+	}
+
+	// Like the build server's own client, the meta tags are fetched with
+	// a client which caches responses in memory.
+	client := &http.Client{Transport: httpcache.NewTransport(lrucache.New(100*1024*1024, 0))}
+	memTTL := 10 * time.Minute
+	if *importPathTTL <= 0 {
+		if resolver == nil {
+			return nil, nil
+		}
+		return &gosrc.CachingResolver{
+			Resolver: append(resolver, gosrc.DefaultResolver(client)...),
+			TTL:      memTTL,
+		}, nil
+	}
+
+	// Import paths resolved from their meta tags are also cached on disk,
+	// so they survive restarts. The in-memory cache in front of it saves
+	// reading the disk cache for every import.
+	cache := &gosrc.DiskCache{
+		Resolver: gosrc.MetaResolver{Client: client},
+		Store: &diskcache.Store{
+			Dir:       filepath.Join(*cacheDir, "lang-go-import-path-cache"),
+			Component: "importpathcache",
+		},
+		TTL:         *importPathTTL,
+		NegativeTTL: *importPathNegTTL,
+	}
+	if *purgeImportPaths {
+		if err := cache.Purge(); err != nil {
+			return nil, err
+		}
+	}
+	if *importPathTTL < memTTL {
+		memTTL = *importPathTTL
+	}
+	return &gosrc.CachingResolver{
+		Resolver: append(resolver, gosrc.StdlibResolver{}, gosrc.StaticResolverFromEnv(), cache),
+		TTL:      memTTL,
+	}, nil
+}
+
 // freeOSMemory should be called in a goroutine, it invokes
 // runtime/debug.FreeOSMemory() more aggressively than the runtime default of
 // 5 minutes after GC.
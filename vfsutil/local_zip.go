@@ -0,0 +1,64 @@
+package vfsutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NewLocalZipVFS returns a new VFS backed by the zip archive at path on the
+// local disk. The files of the archive are in the directory prefix, such as
+// module@version for module zip archives. If prefix is empty or not in the
+// archive, a single top-level directory is stripped instead, like for the
+// archives of NewZipVFS.
+//
+// It returns an error satisfying os.IsNotExist if there is no archive at
+// path.
+func NewLocalZipVFS(path, prefix string) (*ArchiveFS, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	fetch := func(ctx context.Context) (*archiveReader, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zipNewFileReader(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to read zip archive %s", path)
+		}
+
+		ar := &archiveReader{Reader: zr, Closer: f}
+		if prefix != "" && len(zr.File) > 0 && strings.HasPrefix(zr.File[0].Name, prefix+"/") {
+			ar.prefix = prefix
+		} else {
+			ar.StripTopLevelDir = true
+		}
+		return ar, nil
+	}
+
+	return &ArchiveFS{fetch: fetch}, nil
+}
+
+// NewModuleCacheVFS returns a new VFS for accessing the files of the version
+// of a module in the module cache directory dir, such as $GOPATH/pkg/mod.
+// The version must be canonical, like the ones in go.mod files. The files
+// are read from the zip archive the go command downloaded, which it has
+// verified already.
+//
+// It returns an error satisfying os.IsNotExist if the module cache doesn't
+// have the version of the module.
+func NewModuleCacheVFS(dir, module, version string) (*ArchiveFS, error) {
+	return NewLocalZipVFS(ModuleCacheZipPath(dir, module, version), module+"@"+version)
+}
+
+// ModuleCacheZipPath returns the path of the zip archive of the version of
+// module in the module cache directory dir.
+func ModuleCacheZipPath(dir, module, version string) string {
+	return filepath.Join(dir, "cache", "download", filepath.FromSlash(escapeModulePath(module)), "@v", escapeModulePath(version)+".zip")
+}
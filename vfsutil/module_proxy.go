@@ -0,0 +1,369 @@
+package vfsutil
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sourcegraph/go-langserver/diskcache"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// ErrModuleProxyDirect is returned by NewModuleProxyVFS for the modules
+// which must be fetched directly from their repos instead, because GOPROXY
+// falls back to "direct" or GONOPROXY matches them.
+var ErrModuleProxyDirect = errors.New("module must be fetched directly from its repo")
+
+// defaultGOPROXY is the GOPROXY used by the go command if it's not set.
+const defaultGOPROXY = "https://proxy.golang.org,direct"
+
+// ModuleProxyConfig configures how NewModuleProxyVFS fetches modules. Its
+// fields are the settings of the go command's environment variables with the
+// same names, see https://golang.org/ref/mod#environment-variables.
+type ModuleProxyConfig struct {
+	GOPROXY   string
+	GONOPROXY string
+	GOPRIVATE string
+	GOSUMDB   string
+	GONOSUMDB string
+
+	// GoSum is the contents of the go.sum files the fetched modules are
+	// verified against.
+	GoSum []byte
+}
+
+// ModuleProxyConfigFromEnv returns the ModuleProxyConfig of the
+// environment variables of the process, without any go.sum.
+func ModuleProxyConfigFromEnv() *ModuleProxyConfig {
+	return &ModuleProxyConfig{
+		GOPROXY:   os.Getenv("GOPROXY"),
+		GONOPROXY: os.Getenv("GONOPROXY"),
+		GOPRIVATE: os.Getenv("GOPRIVATE"),
+		GOSUMDB:   os.Getenv("GOSUMDB"),
+		GONOSUMDB: os.Getenv("GONOSUMDB"),
+	}
+}
+
+// NewModuleProxyVFS returns a VFS of the files of the version of module,
+// fetched from the first of the module proxies of GOPROXY which has it. Like
+// the go command, it falls back to the next proxy if a proxy doesn't have
+// the module, or on any error if the proxy is followed by a "|" rather than
+// a ",". The module zip archive is fetched lazily, or from the local cache on
+// disk, and it is verified against the go.sum of the config unless GOSUMDB
+// or GONOSUMDB exclude the module from verification.
+func NewModuleProxyVFS(ctx context.Context, cfg *ModuleProxyConfig, module, version string) (*ArchiveFS, error) {
+	noProxy := cfg.GONOPROXY
+	if noProxy == "" {
+		noProxy = cfg.GOPRIVATE
+	}
+	if matchModulePrefixPatterns(noProxy, module) {
+		return nil, ErrModuleProxyDirect
+	}
+
+	goproxy := cfg.GOPROXY
+	if goproxy == "" {
+		goproxy = defaultGOPROXY
+	}
+	var err error
+	for _, proxy := range parseGOPROXY(goproxy) {
+		switch proxy.url {
+		case "direct":
+			return nil, ErrModuleProxyDirect
+		case "off":
+			if err == nil {
+				err = errors.Errorf("module lookup of %s disabled by GOPROXY=off", module)
+			}
+			return nil, err
+		}
+
+		var info *moduleInfo
+		info, err = fetchModuleInfo(ctx, proxy.url, module, version)
+		if err == nil {
+			return newModuleZipVFS(cfg, proxy.url, module, info.Version), nil
+		}
+		if _, notFound := err.(*moduleNotFoundError); !notFound && !proxy.fallBackOnError {
+			break
+		}
+	}
+	if err == nil {
+		err = errors.Errorf("GOPROXY=%s lists no module proxies", goproxy)
+	}
+	return nil, err
+}
+
+// moduleProxy is a proxy of a GOPROXY list.
+type moduleProxy struct {
+	url string // the base URL, "direct" or "off"
+
+	// fallBackOnError is whether to fall back to the next proxy on any
+	// error, rather than only if this proxy doesn't have the module.
+	fallBackOnError bool
+}
+
+// parseGOPROXY parses the list of proxies of GOPROXY.
+func parseGOPROXY(goproxy string) []moduleProxy {
+	var proxies []moduleProxy
+	for goproxy != "" {
+		url := goproxy
+		fallBackOnError := false
+		if i := strings.IndexAny(goproxy, ",|"); i >= 0 {
+			url, fallBackOnError, goproxy = goproxy[:i], goproxy[i] == '|', goproxy[i+1:]
+		} else {
+			goproxy = ""
+		}
+		if url = strings.TrimSpace(url); url != "" {
+			proxies = append(proxies, moduleProxy{url: strings.TrimSuffix(url, "/"), fallBackOnError: fallBackOnError})
+		}
+	}
+	return proxies
+}
+
+// matchModulePrefixPatterns reports whether any of the comma-separated glob
+// patterns matches the module path or one of its prefixes, as in GONOPROXY.
+func matchModulePrefixPatterns(globs, module string) bool {
+	for _, glob := range strings.Split(globs, ",") {
+		glob = strings.TrimSuffix(strings.TrimSpace(glob), "/")
+		if glob == "" {
+			continue
+		}
+		// Match the prefix of the module with as many path elements as
+		// the pattern.
+		elems := strings.Count(glob, "/") + 1
+		parts := strings.SplitN(module, "/", elems+1)
+		if len(parts) < elems {
+			continue
+		}
+		if matched, _ := path.Match(glob, strings.Join(parts[:elems], "/")); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleInfo is the JSON of the .info endpoint of a module version.
+type moduleInfo struct {
+	Version string // the canonical version
+}
+
+// moduleNotFoundError is returned if a proxy doesn't have a module version.
+type moduleNotFoundError struct {
+	url        string
+	statusCode int
+}
+
+func (e *moduleNotFoundError) Error() string {
+	return fmt.Sprintf("module proxy URL %s returned HTTP %d", e.url, e.statusCode)
+}
+
+func fetchModuleInfo(ctx context.Context, proxyURL, module, version string) (*moduleInfo, error) {
+	url := proxyURL + "/" + escapeModulePath(module) + "/@v/" + escapeModulePath(version) + ".info"
+	resp, err := getFromModuleProxy(ctx, url, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	var info moduleInfo
+	if err := json.NewDecoder(resp).Decode(&info); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode module info from %s", url)
+	}
+	if info.Version == "" {
+		info.Version = version
+	}
+	return &info, nil
+}
+
+// getFromModuleProxy returns the body of the response to a GET request of
+// url from a module proxy.
+func getFromModuleProxy(ctx context.Context, url, accept string) (io.ReadCloser, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to construct a new request with URL %s", url)
+	}
+	request.Header.Add("Accept", accept)
+	setAuthFromNetrc(request)
+	resp, err := ctxhttp.Do(ctx, nil, request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch from module proxy URL %s", withoutAuth(url))
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound, http.StatusGone:
+		resp.Body.Close()
+		return nil, &moduleNotFoundError{url: withoutAuth(url), statusCode: resp.StatusCode}
+	}
+	resp.Body.Close()
+	return nil, errors.Errorf("module proxy URL %s returned HTTP %d", withoutAuth(url), resp.StatusCode)
+}
+
+func newModuleZipVFS(cfg *ModuleProxyConfig, proxyURL, module, version string) *ArchiveFS {
+	url := proxyURL + "/" + escapeModulePath(module) + "/@v/" + escapeModulePath(version) + ".zip"
+	fetch := func(ctx context.Context) (ar *archiveReader, err error) {
+		span, ctx := opentracing.StartSpanFromContext(ctx, "module proxy Fetch")
+		ext.Component.Set(span, "modproxyvfs")
+		span.SetTag("module", module)
+		span.SetTag("version", version)
+		defer func() {
+			if err != nil {
+				ext.Error.Set(span, true)
+				span.SetTag("err", err)
+			}
+			span.Finish()
+		}()
+
+		store := &diskcache.Store{
+			Dir:               filepath.Join(ArchiveCacheDir, "modproxyvfs"),
+			Component:         "modproxyvfs",
+			MaxCacheSizeBytes: MaxCacheSizeBytes,
+		}
+
+		ff, err := cachedFetch(ctx, withoutAuth(url), store, func(ctx context.Context) (io.ReadCloser, error) {
+			moduleProxyFetch.Inc()
+			return getFromModuleProxy(ctx, url, "application/zip")
+		})
+		if err != nil {
+			moduleProxyFetchFailed.Inc()
+			return nil, errors.Wrapf(err, "failed to fetch/write/open module zip archive of %s@%s", module, version)
+		}
+		f := ff.File
+
+		zr, err := zipNewFileReader(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to read module zip archive of %s@%s", module, version)
+		}
+
+		if err := verifyModuleZip(cfg, module, version, zr); err != nil {
+			// Don't keep the archive around, a later fetch may be from
+			// a proxy which serves the right one.
+			f.Close()
+			ff.Evict()
+			return nil, err
+		}
+
+		// The files of module zip archives are in the directory
+		// module@version.
+		return &archiveReader{
+			Reader:  zr,
+			Closer:  f,
+			Evicter: store,
+			prefix:  module + "@" + version,
+		}, nil
+	}
+
+	return &ArchiveFS{fetch: fetch}
+}
+
+// verifyModuleZip verifies the module zip archive zr of the version of
+// module against the go.sum of cfg.
+func verifyModuleZip(cfg *ModuleProxyConfig, module, version string, zr *zip.Reader) error {
+	hashes := goSumHashes(cfg.GoSum, module, version)
+	if len(hashes) == 0 {
+		noSumDB := cfg.GONOSUMDB
+		if noSumDB == "" {
+			noSumDB = cfg.GOPRIVATE
+		}
+		if cfg.GOSUMDB == "off" || matchModulePrefixPatterns(noSumDB, module) {
+			return nil
+		}
+		return errors.Errorf("missing go.sum entry for module %s@%s", module, version)
+	}
+
+	hash, err := hashModuleZip(zr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to hash module zip archive of %s@%s", module, version)
+	}
+	for _, h := range hashes {
+		if h == hash {
+			return nil
+		}
+	}
+	return errors.Errorf("checksum mismatch for module %s@%s: downloaded %s, go.sum has %s", module, version, hash, strings.Join(hashes, ", "))
+}
+
+// goSumHashes returns the hashes of the module zip archive of the version
+// of module in go.sum.
+func goSumHashes(goSum []byte, module, version string) []string {
+	var hashes []string
+	for _, line := range strings.Split(string(goSum), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == module && fields[1] == version && strings.HasPrefix(fields[2], "h1:") {
+			hashes = append(hashes, fields[2])
+		}
+	}
+	return hashes
+}
+
+// hashModuleZip returns the "h1:" hash of a module zip archive, as recorded
+// in go.sum: the base64 of the SHA-256 of a summary of the SHA-256 hashes of
+// its files.
+func hashModuleZip(zr *zip.Reader) (string, error) {
+	files := make([]*zip.File, len(zr.File))
+	copy(files, zr.File)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	summary := sha256.New()
+	for _, f := range files {
+		if strings.Contains(f.Name, "\n") {
+			return "", errors.Errorf("file name %q contains a newline", f.Name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", h.Sum(nil), f.Name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+// escapeModulePath escapes a module path or version for module proxy URLs,
+// which replace the upper-case letters by "!" followed by the lower-case
+// letter, so they are safe on case-insensitive file systems.
+func escapeModulePath(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var moduleProxyFetch = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "golangserver_vfs_module_proxy_fetch_total",
+	Help: "Total number of module zip fetches by NewModuleProxyVFS.",
+})
+var moduleProxyFetchFailed = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "golangserver_vfs_module_proxy_fetch_failed_total",
+	Help: "Total number of module zip fetches by NewModuleProxyVFS that failed.",
+})
+
+func init() {
+	prometheus.MustRegister(moduleProxyFetch)
+	prometheus.MustRegister(moduleProxyFetchFailed)
+}
@@ -0,0 +1,223 @@
+package vfsutil
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestModuleProxyVFS(t *testing.T) {
+	cleanup := useEmptyArchiveCacheDir()
+	defer cleanup()
+
+	archive := moduleZip(t, "example.com/Foo/bar@v1.0.0", map[string]string{
+		"go.mod":     "module example.com/Foo/bar\n",
+		"bar.go":     "package bar\n",
+		"baz/baz.go": "package baz\n",
+	})
+	hash, err := hashModuleZip(zipReader(t, archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goSum := []byte("example.com/Foo/bar v1.0.0 " + hash + "\nexample.com/Foo/bar v1.0.0/go.mod h1:AAAA\n")
+
+	// good serves the module, missing has no modules and broken fails.
+	var mu sync.Mutex
+	var requests []string
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/example.com/!foo/bar/@v/list":
+			fmt.Fprintln(w, "v1.0.0")
+		case "/example.com/!foo/bar/@v/v1.0.0.info", "/example.com/!foo/bar/@v/master.info":
+			fmt.Fprint(w, `{"Version":"v1.0.0","Time":"2020-01-01T00:00:00Z"}`)
+		case "/example.com/!foo/bar/@v/v1.0.0.zip":
+			w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer good.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	ctx := context.Background()
+	want := map[string]string{
+		"/go.mod":     "module example.com/Foo/bar\n",
+		"/bar.go":     "package bar\n",
+		"/baz/baz.go": "package baz\n",
+	}
+
+	for _, goproxy := range []string{
+		good.URL,
+		missing.URL + "," + good.URL,
+		broken.URL + "|" + good.URL,
+	} {
+		fs, err := NewModuleProxyVFS(ctx, &ModuleProxyConfig{GOPROXY: goproxy, GoSum: goSum}, "example.com/Foo/bar", "v1.0.0")
+		if err != nil {
+			t.Fatalf("GOPROXY=%s: %s", goproxy, err)
+		}
+		testVFS(t, fs, want)
+		fs.Close()
+	}
+
+	// The archive is only fetched once, and then read from the cache.
+	zipFetches := 0
+	for _, r := range requests {
+		if strings.HasSuffix(r, ".zip") {
+			zipFetches++
+		}
+	}
+	if zipFetches != 1 {
+		t.Errorf("got %d fetches of the module zip archive, want 1", zipFetches)
+	}
+
+	// Versions are resolved to the canonical ones.
+	fs, err := NewModuleProxyVFS(ctx, &ModuleProxyConfig{GOPROXY: good.URL, GoSum: goSum}, "example.com/Foo/bar", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testVFS(t, fs, want)
+	fs.Close()
+
+	for _, test := range []struct {
+		cfg  ModuleProxyConfig
+		want string
+	}{
+		{ModuleProxyConfig{GOPROXY: missing.URL + ",direct"}, ErrModuleProxyDirect.Error()},
+		{ModuleProxyConfig{GOPROXY: good.URL, GONOPROXY: "example.com/*/bar"}, ErrModuleProxyDirect.Error()},
+		{ModuleProxyConfig{GOPROXY: good.URL, GOPRIVATE: "example.com"}, ErrModuleProxyDirect.Error()},
+		{ModuleProxyConfig{GOPROXY: "off"}, "module lookup of example.com/Foo/bar disabled by GOPROXY=off"},
+		{ModuleProxyConfig{GOPROXY: missing.URL + ",off"}, "returned HTTP 404"},
+		{ModuleProxyConfig{GOPROXY: broken.URL + "," + good.URL}, "returned HTTP 500"},
+	} {
+		if _, err := NewModuleProxyVFS(ctx, &test.cfg, "example.com/Foo/bar", "v1.0.0"); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%+v: got error %v, want %q", test.cfg, err, test.want)
+		}
+	}
+}
+
+func TestModuleProxyVFS_verify(t *testing.T) {
+	cleanup := useEmptyArchiveCacheDir()
+	defer cleanup()
+
+	archive := moduleZip(t, "example.com/m@v1.0.0", map[string]string{"m.go": "package m\n"})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/example.com/m/@v/v1.0.0.info":
+			fmt.Fprint(w, `{"Version":"v1.0.0"}`)
+		case "/example.com/m/@v/v1.0.0.zip":
+			w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer proxy.Close()
+
+	for _, test := range []struct {
+		cfg  ModuleProxyConfig
+		want string // the error, if any
+	}{
+		{ModuleProxyConfig{GoSum: []byte("example.com/m v1.0.0 h1:2vRB8nC3pNHjqoa2bHoyRc/6IW3r8TQL3sbN8mwxtyQ=\n")}, "checksum mismatch for module example.com/m@v1.0.0"},
+		{ModuleProxyConfig{GoSum: []byte("example.com/other v1.0.0 h1:2vRB8nC3pNHjqoa2bHoyRc/6IW3r8TQL3sbN8mwxtyQ=\n")}, "missing go.sum entry for module example.com/m@v1.0.0"},
+		{ModuleProxyConfig{GONOSUMDB: "example.com"}, ""},
+		{ModuleProxyConfig{GOPRIVATE: "example.com/m", GONOPROXY: "none"}, ""},
+		{ModuleProxyConfig{GOSUMDB: "off"}, ""},
+	} {
+		test.cfg.GOPROXY = proxy.URL
+		fs, err := NewModuleProxyVFS(context.Background(), &test.cfg, "example.com/m", "v1.0.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fs.Stat(context.Background(), "/m.go")
+		if test.want == "" && err != nil {
+			t.Errorf("%+v: got error %s, want none", test.cfg, err)
+		} else if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%+v: got error %v, want %q", test.cfg, err, test.want)
+		}
+		fs.Close()
+	}
+}
+
+func TestHashModuleZip(t *testing.T) {
+	archive := moduleZip(t, "example.com/m@v1.0.0", map[string]string{
+		"go.mod": "module example.com/m\n",
+		"m.go":   "package m\n",
+	})
+	// The hash of the go command for the same archive.
+	want := "h1:fCHMqo5ggHEQvwcrsN81zr5orRk5lClR36KRHpfUjKg="
+	if got, err := hashModuleZip(zipReader(t, archive)); err != nil {
+		t.Fatal(err)
+	} else if got != want {
+		t.Errorf("got hash %s, want %s", got, want)
+	}
+}
+
+func TestMatchModulePrefixPatterns(t *testing.T) {
+	tests := []struct {
+		globs, module string
+		want          bool
+	}{
+		{"example.com", "example.com/m", true},
+		{"example.com/", "example.com/m", true},
+		{"example.com/m", "example.com/m/v2", true},
+		{"*.corp.example.com", "git.corp.example.com/team/m", true},
+		{"github.com/acme/*", "github.com/acme/m/sub", true},
+		{"github.com/other, github.com/acme", "github.com/acme/m", true},
+		{"example.com/m/sub", "example.com/m", false},
+		{"example.com", "example.com.evil/m", false},
+		{"github.com/acme/*", "github.com/other/m", false},
+		{"", "example.com/m", false},
+	}
+	for _, test := range tests {
+		if got := matchModulePrefixPatterns(test.globs, test.module); got != test.want {
+			t.Errorf("matchModulePrefixPatterns(%q, %q) = %v, want %v", test.globs, test.module, got, test.want)
+		}
+	}
+}
+
+func TestEscapeModulePath(t *testing.T) {
+	if got, want := escapeModulePath("github.com/Azure/azure-sdk-for-go"), "github.com/!azure/azure-sdk-for-go"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// moduleZip returns a module zip archive of files in the directory dir, of
+// the form module@version.
+func moduleZip(t *testing.T, dir string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, contents := range files {
+		w, err := zw.Create(dir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipReader(t *testing.T, archive []byte) *zip.Reader {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
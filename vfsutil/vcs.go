@@ -0,0 +1,366 @@
+package vfsutil
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/ctxvfs"
+	"github.com/sourcegraph/go-langserver/diskcache"
+)
+
+// VCSRepoVFS is a VFS of a revision of a Mercurial, Subversion or Fossil
+// repo. Like GitRepoVFS, Mercurial and Fossil repos are mirrored on disk, and
+// only updated if they don't have the revision yet. The files of the
+// revision are exported into a zip archive, which is cached in
+// ArchiveCacheDir under the clone URL and the ID the revision resolved to.
+type VCSRepoVFS struct {
+	VCS      string // "hg", "svn" or "fossil"
+	CloneURL string // clone URL (e.g., "https://hg.example.com/foo")
+	Rev      string // revision, or "" for the default branch
+
+	once sync.Once
+	err  error // the error encountered during the fetch
+	fs   *ArchiveFS
+}
+
+// vcsCloneBasePath is where the Mercurial and Fossil repos are mirrored.
+var vcsCloneBasePath = "/tmp/go-langserver-vcs-clone-cache"
+
+// SupportedVCS reports whether VCSRepoVFS supports the version control system
+// vcs.
+func SupportedVCS(vcs string) bool {
+	_, ok := vcsCmds[vcs]
+	return ok
+}
+
+// fetchOrWait initiates the fetch if it has not yet
+// started. Otherwise it waits for it to finish.
+func (fs *VCSRepoVFS) fetchOrWait(ctx context.Context) error {
+	fs.once.Do(func() {
+		fs.err = fs.fetch(ctx)
+	})
+	return fs.err
+}
+
+func (fs *VCSRepoVFS) fetch(ctx context.Context) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "VCSRepoVFS fetch")
+	span.SetTag("vcs", fs.VCS)
+	defer func() {
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogFields(otlog.Error(err))
+		}
+		span.Finish()
+	}()
+
+	cmd, ok := vcsCmds[fs.VCS]
+	if !ok {
+		return fmt.Errorf("unsupported VCS %q (clone URL is %q)", fs.VCS, fs.CloneURL)
+	}
+	rev := fs.Rev
+	if rev == "" {
+		rev = cmd.defaultRev
+	}
+	// Make sure the arguments can't be misinterpreted as command-line
+	// flags.
+	for _, arg := range []string{fs.CloneURL, rev} {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("invalid %s argument (can't start with '-'): %q", cmd.name, arg)
+		}
+	}
+
+	urlMu := urlMu(fs.CloneURL)
+	urlMu.Lock()
+	defer urlMu.Unlock()
+	span.LogFields(otlog.String("event", "urlMu acquired"))
+
+	h := sha256.Sum256([]byte(fs.CloneURL))
+	repoDir := filepath.Join(vcsCloneBasePath, fs.VCS, hex.EncodeToString(h[:]))
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	id, err := cmd.resolve(ctx, fs.CloneURL, repoDir, rev)
+	if err != nil {
+		return err
+	}
+	if !cmd.idRx.MatchString(id) {
+		return fmt.Errorf("%s rev %q from %s resolved to suspicious revision ID %q", cmd.name, rev, fs.CloneURL, id)
+	}
+
+	store := &diskcache.Store{
+		Dir:               filepath.Join(ArchiveCacheDir, "vcsvfs"),
+		Component:         "vcsvfs",
+		MaxCacheSizeBytes: MaxCacheSizeBytes,
+	}
+	ff, err := cachedFetch(ctx, fs.VCS+":"+withoutAuth(fs.CloneURL)+"@"+id, store, func(ctx context.Context) (io.ReadCloser, error) {
+		tmpDir, err := ioutil.TempDir("", "vcsvfs")
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Join(tmpDir, "archive.zip")
+		if err := cmd.export(ctx, fs.CloneURL, repoDir, id, name); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, err
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, err
+		}
+		return &removeDirOnClose{File: f, dir: tmpDir}, nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to export %s rev %q from %s", cmd.name, rev, fs.CloneURL)
+	}
+	zr, err := zipNewFileReader(ff.File)
+	if err != nil {
+		ff.File.Close()
+		return err
+	}
+
+	// The files are in a single top-level directory named by the VCS.
+	ar := &archiveReader{Reader: zr, Closer: ff.File, StripTopLevelDir: true, Evicter: ff}
+	fs.fs = &ArchiveFS{fetch: func(context.Context) (*archiveReader, error) { return ar, nil }}
+	return nil
+}
+
+func (fs *VCSRepoVFS) Open(ctx context.Context, path string) (ctxvfs.ReadSeekCloser, error) {
+	if err := fs.fetchOrWait(ctx); err != nil {
+		return nil, err
+	}
+	return fs.fs.Open(ctx, path)
+}
+
+func (fs *VCSRepoVFS) Lstat(ctx context.Context, path string) (os.FileInfo, error) {
+	if err := fs.fetchOrWait(ctx); err != nil {
+		return nil, err
+	}
+	return fs.fs.Lstat(ctx, path)
+}
+
+func (fs *VCSRepoVFS) Stat(ctx context.Context, path string) (os.FileInfo, error) {
+	if err := fs.fetchOrWait(ctx); err != nil {
+		return nil, err
+	}
+	return fs.fs.Stat(ctx, path)
+}
+
+func (fs *VCSRepoVFS) ReadDir(ctx context.Context, path string) ([]os.FileInfo, error) {
+	if err := fs.fetchOrWait(ctx); err != nil {
+		return nil, err
+	}
+	return fs.fs.ReadDir(ctx, path)
+}
+
+func (fs *VCSRepoVFS) String() string {
+	return fmt.Sprintf("VCSRepoVFS{VCS: %q, CloneURL: %q, Rev: %q}", fs.VCS, fs.CloneURL, fs.Rev)
+}
+
+// vcsCmd describes how to fetch the revisions of the repos of a version
+// control system with its command.
+type vcsCmd struct {
+	name       string
+	defaultRev string         // the revision of the default branch
+	idRx       *regexp.Regexp // matches the IDs revisions resolve to
+
+	// resolve returns the ID of the revision rev of the repo at cloneURL,
+	// after cloning or updating the mirror of the repo at dir if needed.
+	resolve func(ctx context.Context, cloneURL, dir, rev string) (string, error)
+
+	// export writes a zip archive of the files of the revision id, in a
+	// single top-level directory, to the file name.
+	export func(ctx context.Context, cloneURL, dir, id, name string) error
+}
+
+var vcsCmds = map[string]*vcsCmd{
+	"hg": {
+		name:       "Mercurial",
+		defaultRev: "default",
+		idRx:       regexp.MustCompile(`^[0-9a-f]{40}$`),
+		resolve: func(ctx context.Context, cloneURL, dir, rev string) (string, error) {
+			id := func() (string, error) {
+				out, err := runVCSCmd(ctx, "", "hg", "log", "-R", dir, "-r", rev, "--limit", "1", "--template", "{node}")
+				return string(bytes.TrimSpace(out)), err
+			}
+			return mirrorAndResolve(ctx, dir, id,
+				[]string{"hg", "clone", "--noupdate", "--", cloneURL, dir},
+				[]string{"hg", "pull", "-R", dir},
+			)
+		},
+		export: func(ctx context.Context, cloneURL, dir, id, name string) error {
+			// Don't add the .hg_archival.txt file.
+			_, err := runVCSCmd(ctx, "", "hg", "archive", "-R", dir, "--config", "ui.archivemeta=false", "--type", "zip", "--prefix", "hg", "-r", id, "--", name)
+			return err
+		},
+	},
+
+	"svn": {
+		name:       "Subversion",
+		defaultRev: "HEAD",
+		idRx:       regexp.MustCompile(`^[0-9]+$`),
+		resolve: func(ctx context.Context, cloneURL, dir, rev string) (string, error) {
+			// Revisions are exported directly from the server, so the
+			// repo isn't mirrored.
+			out, err := runVCSCmd(ctx, "", "svn", "info", "--non-interactive", "--show-item", "last-changed-revision", "--", cloneURL+"@"+rev)
+			return string(bytes.TrimSpace(out)), err
+		},
+		export: func(ctx context.Context, cloneURL, dir, id, name string) error {
+			exportDir, err := ioutil.TempDir("", "svnexport")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(exportDir)
+			if _, err := runVCSCmd(ctx, "", "svn", "export", "--non-interactive", "--quiet", "--", cloneURL+"@"+id, filepath.Join(exportDir, "svn")); err != nil {
+				return err
+			}
+			return zipDir(filepath.Join(exportDir, "svn"), "svn", name)
+		},
+	},
+
+	"fossil": {
+		name:       "Fossil",
+		defaultRev: "tip",
+		idRx:       regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`),
+		resolve: func(ctx context.Context, cloneURL, dir, rev string) (string, error) {
+			repo := filepath.Join(dir, "repo.fossil")
+			id := func() (string, error) {
+				out, err := runVCSCmd(ctx, "", "fossil", "info", rev, "-R", repo)
+				if err != nil {
+					return "", err
+				}
+				// Older versions of Fossil call the hash of a
+				// check-in its UUID.
+				s := bufio.NewScanner(bytes.NewReader(out))
+				for s.Scan() {
+					if f := strings.Fields(s.Text()); len(f) >= 2 && (f[0] == "hash:" || f[0] == "uuid:") {
+						return f[1], nil
+					}
+				}
+				return "", fmt.Errorf("no check-in hash in the output of fossil info %s:\n%s", rev, out)
+			}
+			return mirrorAndResolve(ctx, dir, id,
+				[]string{"fossil", "clone", cloneURL, repo},
+				[]string{"fossil", "pull", "-R", repo},
+			)
+		},
+		export: func(ctx context.Context, cloneURL, dir, id, name string) error {
+			_, err := runVCSCmd(ctx, "", "fossil", "zip", id, name, "-R", filepath.Join(dir, "repo.fossil"), "--name", "fossil")
+			return err
+		},
+	},
+}
+
+// mirrorAndResolve returns the revision ID returned by id. If id fails,
+// the mirror at dir is updated by the command pull, or created by the
+// command clone if it doesn't exist yet, before calling id again.
+func mirrorAndResolve(ctx context.Context, dir string, id func() (string, error), clone, pull []string) (string, error) {
+	if _, err := os.Stat(dir); err == nil {
+		if rev, err := id(); err == nil {
+			return rev, nil
+		}
+		if _, err := runVCSCmd(ctx, "", pull[0], pull[1:]...); err != nil {
+			return "", err
+		}
+	} else if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+			return "", err
+		}
+		if clone[0] == "fossil" {
+			// Fossil clones into a file in dir.
+			if err := os.Mkdir(dir, 0700); err != nil {
+				return "", err
+			}
+		}
+		if _, err := runVCSCmd(ctx, "", clone[0], clone[1:]...); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	} else {
+		return "", err
+	}
+	return id()
+}
+
+// runVCSCmd runs the command name in dir and returns its standard output.
+func runVCSCmd(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	var buf bytes.Buffer
+	cmd.Stderr = &buf
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("command %v failed: %s (stderr follows)\n%s", cmd.Args, err, buf.Bytes())
+	}
+	return out, nil
+}
+
+// zipDir writes a zip archive of the files in dir, in the top-level
+// directory prefix, to the file name.
+func zipDir(dir, prefix, name string) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	zw := zip.NewWriter(f)
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(prefix + "/" + filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// removeDirOnClose is a file in the temporary directory dir, which is
+// removed when the file is closed.
+type removeDirOnClose struct {
+	*os.File
+	dir string
+}
+
+func (f *removeDirOnClose) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.dir)
+	return err
+}
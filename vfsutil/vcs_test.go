@@ -0,0 +1,135 @@
+package vfsutil
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVCSRepoVFS(t *testing.T) {
+	tests := map[string]struct {
+		cmd string // the command which must be installed
+
+		// create creates a repo in the empty directory dir and returns
+		// its clone URL and the working copy the cmds commit in.
+		create func(run func(dir, cmd string) string, dir string) (cloneURL, wc string)
+
+		// commit commits the file and returns the ID of the revision.
+		commit []string
+	}{
+		"hg": {
+			cmd: "hg",
+			create: func(run func(dir, cmd string) string, dir string) (string, string) {
+				run(dir, "hg init repo")
+				return filepath.Join(dir, "repo"), filepath.Join(dir, "repo")
+			},
+			commit: []string{
+				"hg commit --addremove -u a -m msg",
+				"hg log -r . --template '{node}'",
+			},
+		},
+		"svn": {
+			cmd: "svn",
+			create: func(run func(dir, cmd string) string, dir string) (string, string) {
+				run(dir, "svnadmin create repo")
+				cloneURL := "file://" + filepath.ToSlash(filepath.Join(dir, "repo"))
+				run(dir, "svn checkout --quiet "+cloneURL+" wc")
+				return cloneURL, filepath.Join(dir, "wc")
+			},
+			commit: []string{
+				"svn add --quiet --force file",
+				"svn commit --quiet -m msg",
+				"svn update --quiet",
+				"svn info --show-item last-changed-revision",
+			},
+		},
+		"fossil": {
+			cmd: "fossil",
+			create: func(run func(dir, cmd string) string, dir string) (string, string) {
+				run(dir, "fossil init --admin-user a repo.fossil && mkdir wc")
+				run(filepath.Join(dir, "wc"), "fossil open ../repo.fossil")
+				return filepath.Join(dir, "repo.fossil"), filepath.Join(dir, "wc")
+			},
+			commit: []string{
+				"fossil addremove",
+				"fossil commit --no-warnings -m msg",
+				"fossil info current | awk '$1 == \"hash:\" || $1 == \"uuid:\" { print $2 }'",
+			},
+		},
+	}
+	for vcs, test := range tests {
+		t.Run(vcs, func(t *testing.T) {
+			if _, err := exec.LookPath(test.cmd); err != nil {
+				t.Skipf("%s is not installed", test.cmd)
+			}
+
+			// We use a different vcsCloneBasePath and ArchiveCacheDir
+			// to ensure they are empty.
+			tmp, err := ioutil.TempDir("", "vfsutil_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			defer func(origClone, origArchive string) {
+				vcsCloneBasePath, ArchiveCacheDir = origClone, origArchive
+			}(vcsCloneBasePath, ArchiveCacheDir)
+			vcsCloneBasePath, ArchiveCacheDir = filepath.Join(tmp, "clone"), filepath.Join(tmp, "archive")
+			dir := filepath.Join(tmp, "src")
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			run := func(dir, cmd string) string {
+				c := exec.Command("bash", "-c", cmd)
+				c.Dir = dir
+				c.Env = append(os.Environ(), "USER=a", "HOME="+tmp)
+				out, err := c.CombinedOutput()
+				if err != nil {
+					t.Fatalf("Command %q failed. Output was:\n\n%s", cmd, out)
+				}
+				return strings.TrimSpace(string(out))
+			}
+			cloneURL, wc := test.create(run, dir)
+			commit := func(text string) string {
+				if err := ioutil.WriteFile(filepath.Join(wc, "file"), []byte(text), 0600); err != nil {
+					t.Fatal(err)
+				}
+				var out string
+				for _, cmd := range test.commit {
+					out = run(wc, cmd)
+				}
+				return out
+			}
+
+			rev1 := commit("text1")
+			rev2 := commit("text2")
+
+			// On first attempt there is no mirror, so this tests we
+			// can clone.
+			testVFS(t, &VCSRepoVFS{VCS: vcs, CloneURL: cloneURL, Rev: rev1}, map[string]string{"/file": "text1"})
+
+			// The second attempt should have the revision already.
+			// Without a revision, the default branch is used.
+			testVFS(t, &VCSRepoVFS{VCS: vcs, CloneURL: cloneURL, Rev: rev2}, map[string]string{"/file": "text2"})
+			testVFS(t, &VCSRepoVFS{VCS: vcs, CloneURL: cloneURL}, map[string]string{"/file": "text2"})
+
+			// Now we add a commit to test the update path.
+			rev3 := commit("text3")
+			testVFS(t, &VCSRepoVFS{VCS: vcs, CloneURL: cloneURL, Rev: rev3}, map[string]string{"/file": "text3"})
+		})
+	}
+}
+
+func TestVCSRepoVFS_unsupported(t *testing.T) {
+	fs := &VCSRepoVFS{VCS: "bzr", CloneURL: "https://example.com/repo"}
+	if _, err := fs.Stat(context.Background(), "/"); err == nil {
+		t.Fatal("got no error fetching a repo with an unsupported VCS")
+	}
+	if SupportedVCS("bzr") || !SupportedVCS("hg") {
+		t.Error("SupportedVCS returned unexpected results")
+	}
+}